@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for per-provider polling intervals that adapt to how often each feed changes
+type pollScheduler struct {
+	min, max time.Duration
+	initial  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*pollEntry
+}
+
+// Struct for the polling state of one provider
+type pollEntry struct {
+	interval time.Duration
+	next     time.Time
+	digest   [sha256.Size]byte
+	ok       bool
+}
+
+// Scheduler used with serve --adaptive-polling; nil polls every provider every --interval
+var adaptivePolling *pollScheduler
+
+// Gauge for the current polling interval of each provider
+var pollIntervalGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_poll_interval_seconds",
+		Help: "Current adaptive polling interval of the provider",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(pollIntervalGauge)
+}
+
+// Function to create a scheduler starting every provider at initial, clamped to [min, max]
+func newPollScheduler(initial, min, max time.Duration) *pollScheduler {
+	if initial < min {
+		initial = min
+	}
+	if initial > max {
+		initial = max
+	}
+	return &pollScheduler{min: min, max: max, initial: initial, entries: map[string]*pollEntry{}}
+}
+
+// Function to return the entry of a provider, creating it due immediately
+func (s *pollScheduler) entry(location string) *pollEntry {
+	e, ok := s.entries[location]
+	if !ok {
+		e = &pollEntry{interval: s.initial}
+		s.entries[location] = e
+	}
+	return e
+}
+
+// Function to keep the providers due for polling
+func (s *pollScheduler) dueProviders(providers []Provider, now time.Time) []Provider {
+	if s == nil {
+		return providers
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Provider
+	for _, provider := range providers {
+		if !now.Before(s.entry(provider.Location).next) {
+			due = append(due, provider)
+		}
+	}
+	return due
+}
+
+// Function to record a scrape and adapt the provider's interval: halve it when the
+// content changed, grow it by half when it did not. Failures keep the interval.
+func (s *pollScheduler) observe(provider Provider, result ScrapeResult, err error, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(provider.Location)
+	if err != nil {
+		e.ok = false
+	} else {
+		data, _ := json.Marshal(result)
+		digest := sha256.Sum256(data)
+		switch {
+		case !e.ok && e.digest == [sha256.Size]byte{}:
+			// First observation, nothing to compare with yet
+		case digest != e.digest:
+			e.interval /= 2
+		default:
+			e.interval += e.interval / 2
+		}
+		if e.interval < s.min {
+			e.interval = s.min
+		}
+		if e.interval > s.max {
+			e.interval = s.max
+		}
+		e.digest, e.ok = digest, true
+	}
+	e.next = now.Add(e.interval)
+	pollIntervalGauge.WithLabelValues(metricLocation(provider)).Set(e.interval.Seconds())
+}
+
+// Struct tracking when providers with a configured interval, or a ttl honoured with
+// serve --respect-ttl, were last polled
+type intervalSchedule struct {
+	mu    sync.Mutex
+	polls map[string]intervalPoll
+	// ttl of each provider's status feeds, from its last successful scrape
+	ttls map[string]time.Duration
+}
+
+// Whether providers are polled as their feeds' ttl allows instead of every --interval
+var respectTTL bool
+
+// Struct for the last poll of a provider and the interval it was configured with
+type intervalPoll struct {
+	at       time.Time
+	interval time.Duration
+}
+
+// Schedule of the providers whose config sets an interval
+var providerIntervals = &intervalSchedule{polls: map[string]intervalPoll{}, ttls: map[string]time.Duration{}}
+
+// Function to return the interval a provider is polled at; zero polls it every cycle.
+// A ttl is followed no closer than the proxy's minimum freshness.
+func (s *intervalSchedule) interval(provider Provider) time.Duration {
+	if provider.Interval > 0 {
+		return provider.Interval
+	}
+	if ttl := s.ttls[provider.Location]; respectTTL && ttl > 0 {
+		return max(ttl, proxyMinTTL)
+	}
+	return 0
+}
+
+// Function to remember the ttl published by a provider's status feeds scraped at now,
+// scheduling the next poll right away when it is the first interval the provider gets
+func (s *intervalSchedule) observeTTL(location string, ttl time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttls[location] = ttl
+	if _, ok := s.polls[location]; !ok && respectTTL && ttl > 0 {
+		s.polls[location] = intervalPoll{at: now, interval: max(ttl, proxyMinTTL)}
+	}
+}
+
+// Function to drop providers whose configured interval has not elapsed; due
+// providers are scheduled for their next poll
+func (s *intervalSchedule) dueProviders(providers []Provider, now time.Time) []Provider {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := providers[:0:0]
+	for _, provider := range providers {
+		interval := s.interval(provider)
+		if interval <= 0 {
+			delete(s.polls, provider.Location)
+			due = append(due, provider)
+			continue
+		}
+		// Comparing with the current interval lets a reloaded config or a new ttl shorten it right away
+		if poll, ok := s.polls[provider.Location]; ok && now.Sub(poll.at) < interval {
+			s.polls[provider.Location] = intervalPoll{at: poll.at, interval: interval}
+			continue
+		}
+		s.polls[provider.Location] = intervalPoll{at: now, interval: interval}
+		due = append(due, provider)
+	}
+	return due
+}
+
+// Function to shorten a wait so it ends when the next provider with a configured interval is due
+func (s *intervalSchedule) untilNext(wait time.Duration, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, poll := range s.polls {
+		if d := poll.at.Add(poll.interval).Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// Function to return how long to sleep until the next provider is due, at most the minimum interval
+func (s *pollScheduler) untilNext(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wait := s.min
+	for _, e := range s.entries {
+		if d := e.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	// Avoid spinning when a provider is already overdue
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct for the virtual system merging every provider into one GBFS v3 feed set
+type aggregateSystem struct {
+	name string
+	// feed_contact_email is required by GBFS v3; the feed is served once it is set
+	contactEmail string
+}
+
+// Aggregated system, named with serve --aggregate-name and enabled with --aggregate-contact-email
+var aggregate = &aggregateSystem{name: "GBFS exporter aggregate"}
+
+// GBFS v3 form factor and propulsion type of each canonical category
+var aggregateVehicleTypes = map[string][2]string{
+	categoryBike:    {"bicycle", "human"},
+	categoryEbike:   {"bicycle", "electric_assist"},
+	categoryScooter: {"scooter_standing", "electric"},
+	categoryCargo:   {"cargo_bicycle", "human"},
+	categoryOther:   {"other", "human"},
+}
+
+// Function to make an ID unique across the merged providers
+func aggregateID(location, id string) string {
+	return mqttSlug(location) + ":" + id
+}
+
+// Function to localize a text in the aggregate's only language
+func aggregateText(text string) []gin.H {
+	return []gin.H{{"text": text, "language": "en"}}
+}
+
+// Function to wrap feed data in a GBFS v3.0 envelope
+func aggregateEnvelope(updated time.Time, data any) gin.H {
+	return gin.H{
+		"last_updated": updated.UTC().Format(time.RFC3339),
+		"ttl":          reexportTTL,
+		"version":      "3.0",
+		"data":         data,
+	}
+}
+
+// Function to return the category a vehicle is published under
+func aggregateCategory(bike Bike) string {
+	if _, ok := aggregateVehicleTypes[bike.Category]; ok {
+		return bike.Category
+	}
+	return categoryOther
+}
+
+// Function to return the license fields of the aggregate's system_information: the
+// organizations to attribute, and the license when all the merged providers share one
+func aggregateLicense(states []ProviderState) gin.H {
+	fields := gin.H{}
+	var organizations []string
+	licenseURLs := map[string]bool{}
+	for _, state := range states {
+		license := state.Provider.License
+		if license == nil {
+			licenseURLs[""] = true
+			continue
+		}
+		licenseURLs[license.URL] = true
+		if license.Attribution != "" && !slices.Contains(organizations, license.Attribution) {
+			organizations = append(organizations, license.Attribution)
+		}
+	}
+	if len(organizations) > 0 {
+		fields["attribution_organization_name"] = aggregateText(strings.Join(organizations, ", "))
+	}
+	if len(licenseURLs) == 1 {
+		for licenseURL := range licenseURLs {
+			if licenseURL != "" {
+				fields["license_url"] = licenseURL
+			}
+		}
+	}
+	return fields
+}
+
+// Handler for GET /gbfs-v3/:feed, serving the latest state of every provider,
+// scoped to the deployment when given, as a single GBFS v3.0 system. Station and
+// vehicle IDs are prefixed with the provider, vehicle types are the canonical
+// categories, and docked vehicles count as bikes as station_status is not split
+// by type here.
+func aggregateFeedHandler(c *gin.Context) {
+	if aggregate.contactEmail == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the aggregated feed needs serve --aggregate-contact-email"})
+		return
+	}
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	states := configuredStates(deploymentProviders(c, providers))
+	sort.Slice(states, func(i, j int) bool { return states[i].Provider.Location < states[j].Provider.Location })
+	updated := clock.Now()
+	if len(states) > 0 {
+		updated = time.Time{}
+		for _, state := range states {
+			if state.UpdatedAt.After(updated) {
+				updated = state.UpdatedAt
+			}
+		}
+	}
+
+	c.Header("Cache-Control", "max-age=60")
+	switch c.Param("feed") {
+	case "gbfs.json":
+		base := requestBaseURL(c) + "/gbfs-v3/"
+		if deployment := c.Query(deploymentLabel); deployment != "" {
+			base = requestBaseURL(c) + "/deployments/" + url.PathEscape(deployment) + "/gbfs-v3/"
+		}
+		feeds := []GBFSFeed{}
+		for _, name := range []string{"system_information", "vehicle_types", "station_information", "station_status", "vehicle_status"} {
+			feeds = append(feeds, GBFSFeed{Name: name, URL: base + name + ".json"})
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, gin.H{"feeds": feeds}))
+
+	case "system_information.json":
+		info := gin.H{
+			"system_id":          mqttSlug(aggregate.name),
+			"languages":          []string{"en"},
+			"name":               aggregateText(aggregate.name),
+			"opening_hours":      "24/7",
+			"feed_contact_email": aggregate.contactEmail,
+			"timezone":           "Etc/UTC",
+		}
+		for key, value := range aggregateLicense(states) {
+			info[key] = value
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, info))
+
+	case "vehicle_types.json":
+		// Motorized types need a max_range_meters; the longest range reported stands in
+		ranges := map[string]float64{}
+		for _, state := range states {
+			for _, bike := range state.Bikes {
+				if bike.CurrentRangeMeters != nil {
+					category := aggregateCategory(bike)
+					ranges[category] = max(ranges[category], *bike.CurrentRangeMeters)
+				}
+			}
+		}
+		types := []gin.H{}
+		for _, category := range vehicleCategories {
+			kind := aggregateVehicleTypes[category]
+			vehicleType := gin.H{"vehicle_type_id": category, "form_factor": kind[0], "propulsion_type": kind[1], "name": aggregateText(category)}
+			if kind[1] != "human" {
+				vehicleType["max_range_meters"] = ranges[category]
+			}
+			types = append(types, vehicleType)
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, gin.H{"vehicle_types": types}))
+
+	case "station_information.json":
+		stations := []gin.H{}
+		for _, state := range states {
+			for _, s := range state.Stations {
+				station := gin.H{
+					"station_id": aggregateID(state.Provider.Location, s.StationID),
+					"name":       aggregateText(s.Name),
+					"lat":        s.Lat,
+					"lon":        s.Lon,
+				}
+				if s.IsVirtual {
+					station["is_virtual_station"] = true
+				}
+				if s.IsCharging {
+					station["is_charging_station"] = true
+				}
+				if s.ParkingType != "" {
+					station["parking_type"] = s.ParkingType
+				}
+				stations = append(stations, station)
+			}
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, gin.H{"stations": stations}))
+
+	case "station_status.json":
+		stations := []gin.H{}
+		for _, state := range states {
+			for _, s := range state.Stations {
+				station := gin.H{
+					"station_id":              aggregateID(state.Provider.Location, s.StationID),
+					"num_vehicles_available":  s.BikesAvailable,
+					"vehicle_types_available": []gin.H{{"vehicle_type_id": categoryBike, "count": s.BikesAvailable}},
+					"is_installed":            true,
+					"is_renting":              s.IsRenting,
+					"is_returning":            true,
+					"last_reported":           state.UpdatedAt.UTC().Format(time.RFC3339),
+				}
+				if !s.IsVirtual {
+					station["num_docks_available"] = s.DocksAvailable
+				}
+				stations = append(stations, station)
+			}
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, gin.H{"stations": stations}))
+
+	case "vehicle_status.json":
+		vehicles := []gin.H{}
+		for _, state := range states {
+			for _, bike := range vehiclePrivacy.bikes(state.Bikes) {
+				category := aggregateCategory(bike)
+				vehicle := gin.H{
+					"vehicle_id":      aggregateID(state.Provider.Location, bike.BikeID),
+					"lat":             bike.Lat,
+					"lon":             bike.Lon,
+					"is_reserved":     bool(bike.IsReserved),
+					"is_disabled":     bool(bike.IsDisabled),
+					"vehicle_type_id": category,
+					"last_reported":   state.UpdatedAt.UTC().Format(time.RFC3339),
+				}
+				if aggregateVehicleTypes[category][1] != "human" {
+					// Required for motorized vehicles; unknown ranges are published as 0
+					vehicle["current_range_meters"] = 0.0
+					if bike.CurrentRangeMeters != nil {
+						vehicle["current_range_meters"] = *bike.CurrentRangeMeters
+					}
+				}
+				if bike.CurrentFuelPercent != nil {
+					vehicle["current_fuel_percent"] = *bike.CurrentFuelPercent
+				}
+				vehicles = append(vehicles, vehicle)
+			}
+		}
+		c.JSON(http.StatusOK, aggregateEnvelope(updated, gin.H{"vehicles": vehicles}))
+
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown feed " + c.Param("feed")})
+	}
+}
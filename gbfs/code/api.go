@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct for a provider in GET /api/v1/providers
+type ProviderSummary struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Source     string `json:"source"`
+	Deployment string `json:"deployment,omitempty"`
+	// ActiveURL is the discovery URL scraped, for providers with fallback_urls
+	ActiveURL string `json:"active_url,omitempty"`
+	// Metadata is the administrative data of the provider's config entry
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Status is active, removed from the config or deactivated by an admin
+	Status string `json:"status"`
+	// UpdatedAt is when the provider was last ingested, LastUpdated when its feed says it last changed
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	LastUpdated    *time.Time `json:"last_updated,omitempty"`
+	AvailableBikes int        `json:"available_bikes"`
+	Bikes          int        `json:"bikes"`
+	Stations       int        `json:"stations"`
+	// Health is the latest gbfs_provider_health_score, once the provider was scraped
+	Health *HealthScore `json:"health,omitempty"`
+	// Prices are the ride prices per vehicle category, for providers publishing system_pricing_plans
+	Prices []CategoryPrice `json:"prices,omitempty"`
+	// License is the data license of the provider's system_information
+	License *DataLicense `json:"license,omitempty"`
+}
+
+// Struct for a lat/lon bounding box; a zero box matches everything
+type boundingBox struct {
+	minLat, maxLat, minLon, maxLon float64
+	set                            bool
+}
+
+// Function to parse the min_lat, max_lat, min_lon and max_lon query parameters, all or none
+func parseBoundingBox(c *gin.Context) (boundingBox, error) {
+	names := []string{"min_lat", "max_lat", "min_lon", "max_lon"}
+	values := make([]float64, len(names))
+	given := 0
+	for i, name := range names {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return boundingBox{}, fmt.Errorf("invalid %s %q", name, raw)
+		}
+		values[i] = value
+		given++
+	}
+	if given == 0 {
+		return boundingBox{}, nil
+	}
+	if given != len(names) {
+		return boundingBox{}, fmt.Errorf("a bounding box needs min_lat, max_lat, min_lon and max_lon")
+	}
+	box := boundingBox{minLat: values[0], maxLat: values[1], minLon: values[2], maxLon: values[3], set: true}
+	if box.minLat > box.maxLat || box.minLon > box.maxLon {
+		return boundingBox{}, fmt.Errorf("bounding box minimums must not exceed its maximums")
+	}
+	return box, nil
+}
+
+// Function to report whether a point lies within the box, edges included
+func (b boundingBox) contains(lat, lon float64) bool {
+	return !b.set || (lat >= b.minLat && lat <= b.maxLat && lon >= b.minLon && lon <= b.maxLon)
+}
+
+// Function to return a timestamp for JSON, omitted when zero
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Handler for GET /api/v1/providers, listing the configured providers with their latest
+// ingested totals, followed by the inactive ones still holding history
+func providersHandler(c *gin.Context) {
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, record := range registry.inactiveRecords() {
+		providers = append(providers, record.provider)
+	}
+	states := map[string]ProviderState{}
+	for _, state := range liveState.all() {
+		states[state.Provider.Location] = state
+	}
+
+	providers = deploymentProviders(c, providers)
+	summaries := make([]ProviderSummary, 0, len(providers))
+	for _, provider := range providers {
+		summary := ProviderSummary{
+			Name:       provider.Location,
+			URL:        redactURL(provider.URL),
+			Source:     provider.Source,
+			Deployment: provider.Deployment,
+			Metadata:   provider.Metadata,
+			Status:     registry.status(provider.Location),
+		}
+		if len(provider.FallbackURLs) > 0 {
+			summary.ActiveURL = redactURL(discoveryFailover.activeURL(provider))
+		}
+		if state, ok := states[provider.Location]; ok {
+			summary.UpdatedAt = optionalTime(state.UpdatedAt)
+			summary.LastUpdated = optionalTime(state.LastUpdated)
+			summary.AvailableBikes = ScrapeResult{Bikes: state.Bikes, Stations: state.Stations, BikeCount: state.BikeCount}.AvailableBikes()
+			summary.Bikes = len(state.Bikes) + state.BikeCount
+			summary.Stations = len(state.Stations)
+			summary.License = state.Provider.License
+		}
+		if score, ok := providerHealth.score(provider.Location); ok {
+			summary.Health = &score
+		}
+		summary.Prices = vehiclePrices.prices(provider.Location)
+		summaries = append(summaries, summary)
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": summaries})
+}
+
+// Handler for GET /api/v1/providers/:name/bikes, listing the free-floating bikes of
+// the latest ingestion, optionally within a min_lat/max_lat/min_lon/max_lon box
+func providerBikesHandler(c *gin.Context) {
+	box, err := parseBoundingBox(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	provider, ok, err := findProvider(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	state, ok := liveState.get(provider.Location)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "provider has not been ingested yet"})
+		return
+	}
+
+	// The box applies to the published positions, so it cannot narrow down the true ones
+	bikes := make([]Bike, 0, len(state.Bikes))
+	for _, bike := range vehiclePrivacy.bikes(state.Bikes) {
+		if box.contains(bike.Lat, bike.Lon) {
+			bikes = append(bikes, bike)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":     provider.Location,
+		"updated_at":   optionalTime(state.UpdatedAt),
+		"last_updated": optionalTime(state.LastUpdated),
+		"license":      state.Provider.License,
+		"bikes":        bikes,
+	})
+}
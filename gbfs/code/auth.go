@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role granted to an API caller; each role includes the permissions of the ones below it
+type role int
+
+const (
+	roleNone role = iota
+	// Read endpoints: metrics, feeds, dashboards and APIs
+	roleViewer
+	// Triggering ingestion and debug scrapes
+	roleOperator
+	// Provider management
+	roleAdmin
+)
+
+// Function to return the role's name as used in flags and JWT claims
+func (r role) String() string {
+	switch r {
+	case roleViewer:
+		return "viewer"
+	case roleOperator:
+		return "operator"
+	case roleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// Function to parse a role name
+func parseRole(name string) (role, error) {
+	for _, r := range []role{roleViewer, roleOperator, roleAdmin} {
+		if strings.EqualFold(name, r.String()) {
+			return r, nil
+		}
+	}
+	return roleNone, fmt.Errorf("unknown role %q (want viewer, operator or admin)", name)
+}
+
+// Struct for an API key and the role it grants; the key may be a secret reference
+type apiKey struct {
+	role role
+	key  string
+}
+
+// Struct holding the API access configuration; with no keys and no JWT secret the API is open
+type accessControl struct {
+	keys []apiKey
+	// HS256 secret for bearer JWTs, may be a secret reference
+	jwtSecret string
+	// Claim carrying the role name, or a list of role names
+	jwtRoleClaim string
+}
+
+// Access configuration for the HTTP API, set up by the serve command
+var apiAccess = &accessControl{jwtRoleClaim: "role"}
+
+// Function to parse --api-key flags in the form role=key
+func parseAPIKeys(values []string) ([]apiKey, error) {
+	var keys []apiKey
+	for _, value := range values {
+		name, key, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --api-key %q, expected role=key", value)
+		}
+		r, err := parseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, apiKey{role: r, key: key})
+	}
+	return keys, nil
+}
+
+// Function to report whether any authentication is configured
+func (a *accessControl) enabled() bool {
+	return len(a.keys) > 0 || a.jwtSecret != ""
+}
+
+// Function to return the role granted by a presented credential, or roleNone
+func (a *accessControl) authenticate(token string) role {
+	granted := roleNone
+	for _, k := range a.keys {
+		key, err := secrets.expand(k.key)
+		if err != nil || key == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 && k.role > granted {
+			granted = k.role
+		}
+	}
+	if granted == roleNone && a.jwtSecret != "" && strings.Count(token, ".") == 2 {
+		if r, err := a.verifyJWT(token); err == nil {
+			granted = r
+		}
+	}
+	return granted
+}
+
+// Function to verify an HS256 JWT and return the highest role in its role claim
+func (a *accessControl) verifyJWT(token string) (role, error) {
+	secret, err := secrets.expand(a.jwtSecret)
+	if err != nil {
+		return roleNone, err
+	}
+	parts := strings.Split(token, ".")
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return roleNone, fmt.Errorf("decoding JWT header: %w", err)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return roleNone, fmt.Errorf("parsing JWT header: %w", err)
+	}
+	if h.Alg != "HS256" {
+		return roleNone, fmt.Errorf("unsupported JWT algorithm %q", h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return roleNone, fmt.Errorf("decoding JWT signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return roleNone, fmt.Errorf("invalid JWT signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return roleNone, fmt.Errorf("decoding JWT payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return roleNone, fmt.Errorf("parsing JWT payload: %w", err)
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return roleNone, fmt.Errorf("JWT expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return roleNone, fmt.Errorf("JWT not yet valid")
+	}
+
+	// The role claim may be a single name or a list of names
+	var names []string
+	switch value := claims[a.jwtRoleClaim].(type) {
+	case string:
+		names = []string{value}
+	case []interface{}:
+		for _, v := range value {
+			if name, ok := v.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	granted := roleNone
+	for _, name := range names {
+		if r, err := parseRole(name); err == nil && r > granted {
+			granted = r
+		}
+	}
+	if granted == roleNone {
+		return roleNone, fmt.Errorf("JWT has no known role in claim %q", a.jwtRoleClaim)
+	}
+	return granted, nil
+}
+
+// Function to read the caller's credential from the Authorization or X-API-Key header
+func requestCredential(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+// Middleware rejecting requests whose credential does not grant at least the required role
+func requireRole(required role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !apiAccess.enabled() {
+			c.Next()
+			return
+		}
+		token := requestCredential(c)
+		if token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="gbfs"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing credentials"})
+			return
+		}
+		granted := apiAccess.authenticate(token)
+		if granted == roleNone {
+			c.Header("WWW-Authenticate", `Bearer realm="gbfs", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if granted < required {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("requires the %s role", required)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Struct for a provider as listed by the admin API
+type providerInfo struct {
+	Location string   `json:"location"`
+	URL      string   `json:"url"`
+	Source   string   `json:"source"`
+	Headers  []string `json:"headers,omitempty"`
+	Status   string   `json:"status"`
+}
+
+// Handler for listing the configured providers, without header values, then the removed and deactivated ones
+func adminProvidersHandler(c *gin.Context) {
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	infos := make([]providerInfo, 0, len(providers))
+	for _, provider := range providers {
+		info := providerInfo{Location: provider.Location, URL: redactURL(provider.URL), Source: provider.Source, Status: providerActive}
+		for name := range provider.Headers {
+			info.Headers = append(info.Headers, name)
+		}
+		infos = append(infos, info)
+	}
+	for _, record := range registry.inactiveRecords() {
+		infos = append(infos, providerInfo{Location: record.Name, URL: record.URL, Source: record.Source, Status: record.Status})
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": infos})
+}
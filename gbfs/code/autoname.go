@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a URL-only provider whose system_information could not be read waits before another attempt
+const autoNameRetry = time.Minute
+
+// Struct for the names system_information gave a URL-only provider
+type autoName struct {
+	name     string
+	operator string
+	// when the last attempt to read system_information failed, zero once named
+	failedAt time.Time
+}
+
+// Struct naming providers registered with only a URL after their system_information,
+// so their labels come from the operator instead of hand-typed config
+type autoNamer struct {
+	mu    sync.Mutex
+	byURL map[string]*autoName
+}
+
+// Names of URL-only providers, resolved during ingestion
+var autoNames = &autoNamer{byURL: map[string]*autoName{}}
+
+// Function to return the name a URL-only provider has until its system_information
+// is read: the host of its URL
+func provisionalLocation(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return rawURL
+}
+
+// Function to read the names of the URL-only providers that have none yet from their
+// system_information. Only ingestion calls this, so API requests never fetch feeds.
+func (a *autoNamer) resolve(providers []Provider) {
+	for _, provider := range providers {
+		// Only GBFS feeds publish system_information
+		if !provider.AutoName || (provider.Source != "" && provider.Source != sourceGBFS) {
+			continue
+		}
+		a.mu.Lock()
+		known, ok := a.byURL[provider.URL]
+		a.mu.Unlock()
+		if ok && (known.name != "" || time.Since(known.failedAt) < autoNameRetry) {
+			continue
+		}
+
+		feed, err := fetchSystemInformationFeed(provider)
+		name := strings.TrimSpace(string(feed.Data.Name))
+		resolved := &autoName{name: name, operator: strings.TrimSpace(string(feed.Data.Operator))}
+		switch {
+		case err != nil:
+			log.Printf("Error naming %s from system_information: %v", redactURL(provider.URL), err)
+			resolved = &autoName{failedAt: time.Now()}
+		case name == "":
+			log.Printf("Error naming %s: system_information has no name", redactURL(provider.URL))
+			resolved = &autoName{failedAt: time.Now()}
+		default:
+			log.Printf("Provider %s named %q from system_information", redactURL(provider.URL), name)
+			// Series and usage so far were accounted under the provisional name
+			retireProviderMetrics(provider)
+			budgets.rename(provider, name, time.Now())
+			registry.drop(provider.Location)
+		}
+		a.mu.Lock()
+		a.byURL[provider.URL] = resolved
+		a.mu.Unlock()
+	}
+}
+
+// Function to give URL-only providers their resolved name, or their provisional one,
+// and their operator as metadata. Names already in use get a numeric suffix.
+func (a *autoNamer) apply(providers []Provider) []Provider {
+	taken := map[string]bool{}
+	for _, provider := range providers {
+		if !provider.AutoName {
+			taken[provider.Location] = true
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	named := make([]Provider, len(providers))
+	for i, provider := range providers {
+		if provider.AutoName {
+			name := provisionalLocation(provider.URL)
+			if known, ok := a.byURL[provider.URL]; ok && known.name != "" {
+				name = known.name
+				if known.operator != "" && provider.Metadata["operator"] == "" {
+					metadata := map[string]string{"operator": known.operator}
+					for key, value := range provider.Metadata {
+						metadata[key] = value
+					}
+					provider.Metadata = metadata
+				}
+			}
+			location := name
+			for n := 2; taken[location]; n++ {
+				location = name + "-" + strconv.Itoa(n)
+			}
+			provider.Location = location
+			taken[location] = true
+		}
+		named[i] = provider
+	}
+	return named
+}
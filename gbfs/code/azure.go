@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespace under which the custom metrics appear in Azure Monitor
+const azureMetricNamespace = "GBFS"
+
+// Struct for a sink publishing availability and scrape health to Azure Monitor.
+// It either posts custom metrics against a resource using a managed identity
+// token, or, with an Application Insights connection string, tracks them as
+// App Insights metrics.
+type azureMonitorSink struct {
+	client *http.Client
+
+	// Custom metrics via managed identity
+	metricsURL string
+	clientID   string
+	mu         sync.Mutex
+	token      string
+	expires    time.Time
+
+	// Application Insights via connection string
+	ingestionURL       string
+	instrumentationKey string
+}
+
+// Function to create a sink posting custom metrics for resourceID in region with a managed identity
+func newAzureMonitorSink(resourceID, region, clientID string) (*azureMonitorSink, error) {
+	if resourceID == "" || region == "" {
+		return nil, fmt.Errorf("Azure Monitor needs both a resource ID and a region")
+	}
+	return &azureMonitorSink{
+		client:     &http.Client{Timeout: 30 * time.Second},
+		metricsURL: "https://" + region + ".monitoring.azure.com/" + strings.TrimPrefix(resourceID, "/") + "/metrics",
+		clientID:   clientID,
+	}, nil
+}
+
+// Function to create a sink tracking metrics in Application Insights from a connection string
+func newAppInsightsSink(connectionString string) (*azureMonitorSink, error) {
+	fields := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		if key, value, ok := strings.Cut(part, "="); ok {
+			fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if fields["instrumentationkey"] == "" {
+		return nil, fmt.Errorf("connection string has no InstrumentationKey")
+	}
+	endpoint := fields["ingestionendpoint"]
+	if endpoint == "" {
+		endpoint = "https://dc.services.visualstudio.com"
+	}
+	return &azureMonitorSink{
+		client:             &http.Client{Timeout: 30 * time.Second},
+		ingestionURL:       strings.TrimSuffix(endpoint, "/") + "/v2/track",
+		instrumentationKey: fields["instrumentationkey"],
+	}, nil
+}
+
+// Function to return the sink name used in logs
+func (s *azureMonitorSink) Name() string {
+	return "azure-monitor"
+}
+
+// Struct for one metric value with its location dimension
+type azureMetricValue struct {
+	metric   string
+	location string
+	value    float64
+}
+
+// Function to publish a cycle's availability and provider up/down metrics
+func (s *azureMonitorSink) Publish(snapshots []ProviderSnapshot) error {
+	var values []azureMetricValue
+	total := 0
+	for _, snapshot := range snapshots {
+		up := 1.0
+		if snapshot.Error != "" {
+			up = 0
+		} else {
+			values = append(values, azureMetricValue{"AvailableBikes", snapshot.Location, float64(snapshot.AvailableBikes)})
+			total += snapshot.AvailableBikes
+		}
+		values = append(values, azureMetricValue{"ProviderUp", snapshot.Location, up})
+	}
+	values = append(values, azureMetricValue{"TotalAvailableBikes", "", float64(total)})
+
+	if s.instrumentationKey != "" {
+		return s.trackAppInsights(values)
+	}
+	return s.postCustomMetrics(values)
+}
+
+// Function to post values to the Azure Monitor custom metrics API, one request per metric name
+func (s *azureMonitorSink) postCustomMetrics(values []azureMetricValue) error {
+	token, err := s.managedIdentityToken()
+	if err != nil {
+		return err
+	}
+
+	type series struct {
+		DimValues []string `json:"dimValues,omitempty"`
+		Min       float64  `json:"min"`
+		Max       float64  `json:"max"`
+		Sum       float64  `json:"sum"`
+		Count     int      `json:"count"`
+	}
+	byMetric := map[string][]series{}
+	var order []string
+	for _, v := range values {
+		if _, ok := byMetric[v.metric]; !ok {
+			order = append(order, v.metric)
+		}
+		point := series{Min: v.value, Max: v.value, Sum: v.value, Count: 1}
+		if v.location != "" {
+			point.DimValues = []string{v.location}
+		}
+		byMetric[v.metric] = append(byMetric[v.metric], point)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, metric := range order {
+		baseData := map[string]interface{}{
+			"metric":    metric,
+			"namespace": azureMetricNamespace,
+			"series":    byMetric[metric],
+		}
+		if len(byMetric[metric][0].DimValues) > 0 {
+			baseData["dimNames"] = []string{"location"}
+		}
+		body := map[string]interface{}{"time": now, "data": map[string]interface{}{"baseData": baseData}}
+		if err := s.post(s.metricsURL, "Bearer "+token, body); err != nil {
+			return fmt.Errorf("posting %s: %w", metric, err)
+		}
+	}
+	return nil
+}
+
+// Function to send values as Application Insights metric telemetry in one batch
+func (s *azureMonitorSink) trackAppInsights(values []azureMetricValue) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var buf bytes.Buffer
+	for _, v := range values {
+		properties := map[string]string{}
+		if v.location != "" {
+			properties["location"] = v.location
+		}
+		envelope := map[string]interface{}{
+			"name": "Microsoft.ApplicationInsights.Metric",
+			"time": now,
+			"iKey": s.instrumentationKey,
+			"data": map[string]interface{}{
+				"baseType": "MetricData",
+				"baseData": map[string]interface{}{
+					"ver":        2,
+					"metrics":    []map[string]interface{}{{"name": azureMetricNamespace + "." + v.metric, "value": v.value, "count": 1}},
+					"properties": properties,
+				},
+			},
+		}
+		line, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return s.postRaw(s.ingestionURL, "", "application/x-json-stream", buf.Bytes())
+}
+
+// Function to fetch (and cache) a token for Azure Monitor from the instance metadata service
+func (s *azureMonitorSink) managedIdentityToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expires.Add(-5*time.Minute)) {
+		return s.token, nil
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", "https://monitoring.azure.com/")
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting managed identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting managed identity token: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("parsing managed identity token: %w", err)
+	}
+	expiresOn, _ := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	s.token = token.AccessToken
+	s.expires = time.Unix(expiresOn, 0)
+	return s.token, nil
+}
+
+// Function to POST a JSON body
+func (s *azureMonitorSink) post(target, authorization string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.postRaw(target, authorization, "application/json", body)
+}
+
+// Function to POST a raw body and check the response status
+func (s *azureMonitorSink) postRaw(target, authorization, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for the daily upstream budget of a provider; zero fields are unlimited
+type ScrapeBudget struct {
+	DailyRequests int   `yaml:"daily_requests,omitempty" json:"daily_requests,omitempty"`
+	DailyBytes    int64 `yaml:"daily_bytes,omitempty" json:"daily_bytes,omitempty"`
+}
+
+// Function to check a budget's limits
+func (b ScrapeBudget) validate() error {
+	if b.DailyRequests < 0 || b.DailyBytes < 0 {
+		return fmt.Errorf("budget limits cannot be negative")
+	}
+	return nil
+}
+
+// Error returned for requests of a provider whose daily budget is used up
+var errBudgetExhausted = errors.New("daily scrape budget exhausted")
+
+// Struct for a provider's upstream usage during one UTC day
+type BudgetUsage struct {
+	Provider string       `json:"provider"`
+	Day      string       `json:"day"`
+	Requests int          `json:"requests"`
+	Bytes    int64        `json:"bytes"`
+	Budget   ScrapeBudget `json:"budget"`
+	Paused   bool         `json:"paused"`
+}
+
+// Struct accounting the requests and bytes fetched from each provider per UTC day
+type budgetTracker struct {
+	// defaults apply to providers without a budget of their own
+	defaults ScrapeBudget
+
+	mu    sync.Mutex
+	day   string
+	usage map[string]*BudgetUsage
+}
+
+// Upstream accounting; default budgets set with serve --daily-request-budget and --daily-byte-budget
+var budgets = &budgetTracker{usage: map[string]*BudgetUsage{}}
+
+// Create Prometheus gauges for each provider's upstream usage today
+var (
+	dailyRequestsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_upstream_requests_today",
+			Help: "Number of requests made to the provider's upstream since UTC midnight",
+		},
+		[]string{"location"},
+	)
+	dailyBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_upstream_bytes_today",
+			Help: "Number of body bytes downloaded from the provider's upstream since UTC midnight",
+		},
+		[]string{"location"},
+	)
+	budgetPausedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_scrape_paused",
+			Help: "Whether scraping of the provider is paused because its daily budget is used up (1) or not (0)",
+		},
+		[]string{"location"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(dailyRequestsGauge, dailyBytesGauge, budgetPausedGauge)
+}
+
+// Function to return the budget that applies to a provider
+func (t *budgetTracker) budget(provider Provider) ScrapeBudget {
+	if provider.Budget != nil {
+		return *provider.Budget
+	}
+	return t.defaults
+}
+
+// Function to return a provider's usage for today, starting a new day when the date changed; needs t.mu
+func (t *budgetTracker) entry(location string, now time.Time) *BudgetUsage {
+	day := now.UTC().Format(time.DateOnly)
+	if day != t.day {
+		t.day = day
+		t.usage = map[string]*BudgetUsage{}
+		dailyRequestsGauge.Reset()
+		dailyBytesGauge.Reset()
+	}
+	usage, ok := t.usage[location]
+	if !ok {
+		usage = &BudgetUsage{Provider: location, Day: day}
+		t.usage[location] = usage
+	}
+	return usage
+}
+
+// Function to report whether a provider's usage reached its budget
+func exhausted(usage *BudgetUsage, budget ScrapeBudget) bool {
+	return (budget.DailyRequests > 0 && usage.Requests >= budget.DailyRequests) ||
+		(budget.DailyBytes > 0 && usage.Bytes >= budget.DailyBytes)
+}
+
+// Function to refuse a request when the provider's budget is used up
+func (t *budgetTracker) allow(provider Provider, now time.Time) error {
+	if provider.Location == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if exhausted(t.entry(provider.Location, now), t.budget(provider)) {
+		return fmt.Errorf("%w for %s", errBudgetExhausted, provider.Location)
+	}
+	return nil
+}
+
+// Function to account requests sent to a provider's upstream and the body bytes they returned
+func (t *budgetTracker) record(provider Provider, requests, bytes int, now time.Time) {
+	if provider.Location == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.entry(provider.Location, now)
+	usage.Requests += requests
+	usage.Bytes += int64(bytes)
+	dailyRequestsGauge.WithLabelValues(metricLocation(provider)).Set(float64(usage.Requests))
+	dailyBytesGauge.WithLabelValues(metricLocation(provider)).Set(float64(usage.Bytes))
+}
+
+// Function to carry today's usage of a renamed provider over to its new name
+func (t *budgetTracker) rename(provider Provider, to string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	from := provider.Location
+	usage, ok := t.usage[from]
+	if !ok || from == to {
+		return
+	}
+	delete(t.usage, from)
+	dailyRequestsGauge.DeleteLabelValues(metricLocation(provider))
+	dailyBytesGauge.DeleteLabelValues(metricLocation(provider))
+	budgetPausedGauge.DeleteLabelValues(metricLocation(provider))
+	renamed := t.entry(to, now)
+	renamed.Requests += usage.Requests
+	renamed.Bytes += usage.Bytes
+	provider.Location = to
+	dailyRequestsGauge.WithLabelValues(metricLocation(provider)).Set(float64(renamed.Requests))
+	dailyBytesGauge.WithLabelValues(metricLocation(provider)).Set(float64(renamed.Bytes))
+}
+
+// Function to drop providers whose budget is used up from a cycle
+func (t *budgetTracker) activeProviders(providers []Provider, now time.Time) []Provider {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	active := providers[:0:0]
+	for _, provider := range providers {
+		usage := t.entry(provider.Location, now)
+		paused := exhausted(usage, t.budget(provider))
+		if paused && !usage.Paused {
+			log.Printf("Pausing scrapes of %s until UTC midnight: %d requests and %d bytes used today", provider.Location, usage.Requests, usage.Bytes)
+		}
+		usage.Paused = paused
+		if paused {
+			budgetPausedGauge.WithLabelValues(metricLocation(provider)).Set(1)
+			continue
+		}
+		budgetPausedGauge.WithLabelValues(metricLocation(provider)).Set(0)
+		active = append(active, provider)
+	}
+	return active
+}
+
+// Function to list today's usage of the given providers, sorted by name
+func (t *budgetTracker) report(providers []Provider, now time.Time) []BudgetUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := make([]BudgetUsage, 0, len(providers))
+	for _, provider := range providers {
+		usage := *t.entry(provider.Location, now)
+		usage.Budget = t.budget(provider)
+		usage.Paused = exhausted(&usage, usage.Budget)
+		report = append(report, usage)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Provider < report[j].Provider })
+	return report
+}
+
+// Handler for GET /api/v1/budgets, listing today's upstream usage and budget of each provider
+func budgetsHandler(c *gin.Context) {
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	providers = deploymentProviders(c, providers)
+	if name := c.Query("provider"); name != "" {
+		filtered := providers[:0:0]
+		for _, provider := range providers {
+			if provider.Location == name {
+				filtered = append(filtered, provider)
+			}
+		}
+		if len(filtered) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+			return
+		}
+		providers = filtered
+	}
+	c.JSON(http.StatusOK, gin.H{"budgets": budgets.report(providers, time.Now())})
+}
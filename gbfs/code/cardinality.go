@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label value that series beyond --max-label-values are summed into
+const overflowLabelValue = "other"
+
+// Counter for label values folded into the overflow series
+var labelValuesDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_label_values_dropped_total",
+		Help: "Number of label values, e.g. station IDs, whose series were folded into \"other\" because the provider exceeded --max-label-values",
+	},
+	[]string{"location", "label"},
+)
+
+func init() {
+	prometheus.MustRegister(labelValuesDropped)
+}
+
+// Struct capping the distinct values a provider exports for a label such as
+// station_id, so a feed publishing thousands of new IDs cannot blow up the series
+type labelLimiter struct {
+	// most distinct values per provider and label; 0 disables the cap
+	max int
+
+	mu sync.Mutex
+	// values exported as themselves and values folded into "other", by provider and label
+	kept     map[[2]string]map[string]bool
+	overflow map[[2]string]map[string]bool
+}
+
+// Label value caps, set with --max-label-values
+var labelLimits = &labelLimiter{max: 10000, kept: map[[2]string]map[string]bool{}, overflow: map[[2]string]map[string]bool{}}
+
+// Function to map each of a provider's current values of a label to itself, or to
+// "other" once the cap is reached. Values exported before keep their series while
+// they are present; the free slots go to new values in sorted order.
+func (l *labelLimiter) assign(provider Provider, label string, values []string) map[string]string {
+	assigned := make(map[string]string, len(values))
+	if l.max <= 0 || len(values) <= l.max {
+		for _, value := range values {
+			assigned[value] = value
+		}
+		l.mu.Lock()
+		delete(l.kept, [2]string{provider.Location, label})
+		delete(l.overflow, [2]string{provider.Location, label})
+		l.mu.Unlock()
+		return assigned
+	}
+
+	key := [2]string{provider.Location, label}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	previous, previousOverflow := l.kept[key], l.overflow[key]
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	kept := map[string]bool{}
+	for _, value := range sorted {
+		if previous[value] {
+			kept[value] = true
+		}
+	}
+	for _, value := range sorted {
+		if len(kept) >= l.max {
+			break
+		}
+		kept[value] = true
+	}
+	overflow := map[string]bool{}
+	for _, value := range sorted {
+		if kept[value] {
+			assigned[value] = value
+			continue
+		}
+		assigned[value] = overflowLabelValue
+		overflow[value] = true
+		if !previousOverflow[value] {
+			labelValuesDropped.WithLabelValues(metricLocation(provider), label).Inc()
+		}
+	}
+	l.kept[key], l.overflow[key] = kept, overflow
+	return assigned
+}
+
+// Function to forget a provider's label values
+func (l *labelLimiter) forget(location string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.kept {
+		if key[0] == location {
+			delete(l.kept, key)
+		}
+	}
+	for key := range l.overflow {
+		if key[0] == location {
+			delete(l.overflow, key)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of faults injected into a provider's fetches
+const (
+	// The request hangs until the provider's timeout expires
+	faultTimeout = "timeout"
+	// The upstream answers 500 Internal Server Error
+	faultServerError = "error"
+	// The body is cut off halfway, leaving invalid JSON
+	faultTruncate = "truncate"
+	// The body's last_updated is moved back by the fault's age
+	faultStale = "stale"
+)
+
+// Age given to stale feeds when a fault sets none
+const defaultFaultAge = time.Hour
+
+// Struct for a fault injected into a provider's fetches with serve --chaos, for
+// checking alerts and failure handling before a real outage
+type FaultRule struct {
+	Kind string `yaml:"kind"`
+	// Feed limits the fault to feed URLs containing this, e.g. station_status; empty matches every feed
+	Feed string `yaml:"feed,omitempty"`
+	// Probability of the fault per matching request, from 0 to 1; zero means always
+	Probability float64 `yaml:"probability,omitempty"`
+	// Age is how far back stale faults move last_updated
+	Age time.Duration `yaml:"age,omitempty"`
+}
+
+// Function to check a fault rule
+func (f FaultRule) validate() error {
+	switch f.Kind {
+	case faultTimeout, faultServerError, faultTruncate, faultStale:
+	default:
+		return fmt.Errorf("invalid fault kind %q, expected timeout, error, truncate or stale", f.Kind)
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("fault %s: probability must be between 0 and 1", f.Kind)
+	}
+	if f.Age < 0 {
+		return fmt.Errorf("fault %s: age must not be negative", f.Kind)
+	}
+	return nil
+}
+
+// Whether the faults in the config are injected, set with serve --chaos
+var chaosEnabled bool
+
+// Counter for the faults injected per provider and kind
+var injectedFaults = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_injected_faults_total",
+		Help: "Number of faults injected into the provider's requests by serve --chaos",
+	},
+	[]string{"location", "kind"},
+)
+
+func init() {
+	prometheus.MustRegister(injectedFaults)
+}
+
+// Function to list the provider's faults of the given kinds that apply to url
+func matchingFaults(provider Provider, url string, kinds ...string) []FaultRule {
+	if !chaosEnabled {
+		return nil
+	}
+	var faults []FaultRule
+	for _, fault := range provider.Faults {
+		if slices.Contains(kinds, fault.Kind) && (fault.Feed == "" || strings.Contains(url, fault.Feed)) {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+// Function to pick the fault of one of the given kinds to inject into a request for url,
+// if any. Faults injected into traced dry runs are not counted.
+func pickFault(provider Provider, url string, trace *ScrapeTrace, kinds ...string) (FaultRule, bool) {
+	for _, fault := range matchingFaults(provider, url, kinds...) {
+		if fault.Probability > 0 && rand.Float64() >= fault.Probability {
+			continue
+		}
+		if trace == nil {
+			injectedFaults.WithLabelValues(metricLocation(provider), fault.Kind).Inc()
+		}
+		return fault, true
+	}
+	return FaultRule{}, false
+}
+
+// Function to fail a request the way a timeout or error fault would
+func (f FaultRule) failRequest(ctx context.Context, url string) error {
+	if f.Kind == faultTimeout {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return transientError{error: fmt.Errorf("%s returned 500 Internal Server Error (injected)", url)}
+}
+
+// Function to corrupt a response body the way a truncate or stale fault would
+func (f FaultRule) corruptBody(body []byte) []byte {
+	if f.Kind == faultTruncate {
+		return body[:len(body)/2]
+	}
+	age := f.Age
+	if age == 0 {
+		age = defaultFaultAge
+	}
+	return staleBody(body, clock.Now().Add(-age))
+}
+
+// Function to set a feed's last_updated to at, keeping its format: a POSIX
+// timestamp before GBFS 3.0, an RFC 3339 string since
+func staleBody(body []byte, at time.Time) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	if _, ok := doc["last_updated"].(string); ok {
+		doc["last_updated"] = at.UTC().Format(time.RFC3339)
+	} else {
+		doc["last_updated"] = at.Unix()
+	}
+	stale, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return stale
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Distance a vehicle must have moved while absent for its return to count as a trip
+const tripMinDistanceMeters = 100
+
+// How long a vanished vehicle is remembered while waiting for it to reappear
+const vanishedRetention = 24 * time.Hour
+
+// Counters for vehicles appearing in and disappearing from free_bike_status between scrapes
+var (
+	vehiclesAppeared = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_vehicles_appeared_total",
+			Help: "Number of vehicles that appeared in the provider's free_bike_status since the previous scrape",
+		},
+		[]string{"location"},
+	)
+	vehiclesDisappeared = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_vehicles_disappeared_total",
+			Help: "Number of vehicles that disappeared from the provider's free_bike_status since the previous scrape",
+		},
+		[]string{"location"},
+	)
+	estimatedTrips = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_estimated_trips_total",
+			Help: "Trips estimated from vehicles that vanished and reappeared at least 100m away; undercounts feeds that rotate vehicle IDs",
+		},
+		[]string{"location"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(vehiclesAppeared, vehiclesDisappeared, estimatedTrips)
+}
+
+// Struct for where and when a vehicle was last seen
+type vehicleSighting struct {
+	lat, lon float64
+	at       time.Time
+}
+
+// Struct tracking the vehicles of each provider between scrapes
+type vehicleChurn struct {
+	mu sync.Mutex
+	// Vehicles in the previous scrape, by provider and vehicle ID
+	present map[string]map[string]vehicleSighting
+	// Vehicles that disappeared and may come back, by provider and vehicle ID
+	vanished map[string]map[string]vehicleSighting
+}
+
+// Churn tracker fed by ingestion
+var churn = &vehicleChurn{
+	present:  map[string]map[string]vehicleSighting{},
+	vanished: map[string]map[string]vehicleSighting{},
+}
+
+// Function to compare a scrape's vehicles with the previous scrape and count the differences,
+// returning the trips estimated since then. The first scrape of a provider only sets the baseline.
+func (c *vehicleChurn) observe(provider Provider, bikes []Bike, now time.Time) int {
+	location := provider.Location
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := make(map[string]vehicleSighting, len(bikes))
+	for _, bike := range bikes {
+		if bike.BikeID != "" {
+			current[bike.BikeID] = vehicleSighting{lat: bike.Lat, lon: bike.Lon, at: now}
+		}
+	}
+	previous, seen := c.present[location]
+	c.present[location] = current
+	if !seen {
+		return 0
+	}
+
+	vanished := c.vanished[location]
+	if vanished == nil {
+		vanished = map[string]vehicleSighting{}
+		c.vanished[location] = vanished
+	}
+	appeared, disappeared, trips := 0, 0, 0
+	for id, sighting := range current {
+		if _, ok := previous[id]; ok {
+			continue
+		}
+		appeared++
+		if last, ok := vanished[id]; ok {
+			if haversineMeters(last.lat, last.lon, sighting.lat, sighting.lon) >= tripMinDistanceMeters {
+				trips++
+			}
+			delete(vanished, id)
+		}
+	}
+	for id, sighting := range previous {
+		if _, ok := current[id]; !ok {
+			disappeared++
+			vanished[id] = sighting
+		}
+	}
+	for id, sighting := range vanished {
+		if now.Sub(sighting.at) > vanishedRetention {
+			delete(vanished, id)
+		}
+	}
+
+	vehiclesAppeared.WithLabelValues(metricLocation(provider)).Add(float64(appeared))
+	vehiclesDisappeared.WithLabelValues(metricLocation(provider)).Add(float64(disappeared))
+	estimatedTrips.WithLabelValues(metricLocation(provider)).Add(float64(trips))
+	return trips
+}
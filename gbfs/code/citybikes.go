@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Base URL of the CityBikes network API
+const cityBikesAPI = "https://api.citybik.es/v2/networks/"
+
+// Struct for the CityBikes /v2/networks/<id> response
+type cityBikesNetwork struct {
+	Network struct {
+		ID       string `json:"id"`
+		Stations []struct {
+			ID         string  `json:"id"`
+			Name       string  `json:"name"`
+			Latitude   float64 `json:"latitude"`
+			Longitude  float64 `json:"longitude"`
+			FreeBikes  *int    `json:"free_bikes"`
+			EmptySlots *int    `json:"empty_slots"`
+		} `json:"stations"`
+	} `json:"network"`
+}
+
+// Function to report whether a URL points at the CityBikes API
+func isCityBikesURL(rawURL string) bool {
+	if strings.HasPrefix(rawURL, "citybikes://") {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Host == "api.citybik.es"
+}
+
+// Function to expand the citybikes://<network> shorthand into the API URL
+func cityBikesURL(rawURL string) string {
+	if network, ok := strings.CutPrefix(rawURL, "citybikes://"); ok {
+		return cityBikesAPI + strings.Trim(network, "/")
+	}
+	return rawURL
+}
+
+// Function to scrape a CityBikes network into the normalized station model
+func scrapeCityBikes(provider Provider, trace *ScrapeTrace) (ScrapeResult, error) {
+	body, err := fetchBody(provider, provider.URL, trace)
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("fetching CityBikes network %s: %w", provider.URL, err)
+	}
+
+	var network cityBikesNetwork
+	if err := json.Unmarshal(body, &network); err != nil {
+		return ScrapeResult{}, failedBodies.keep(provider, provider.URL, body, fmt.Errorf("parsing CityBikes network %s: %w", provider.URL, err))
+	}
+	if network.Network.ID == "" {
+		return ScrapeResult{}, fmt.Errorf("no network in CityBikes response from %s", provider.URL)
+	}
+
+	stations := make([]Station, 0, len(network.Network.Stations))
+	for _, s := range network.Network.Stations {
+		station := Station{
+			StationID: s.ID,
+			Name:      s.Name,
+			Lat:       s.Latitude,
+			Lon:       s.Longitude,
+			IsRenting: true,
+		}
+		if s.FreeBikes != nil {
+			station.BikesAvailable = *s.FreeBikes
+		}
+		if s.EmptySlots != nil {
+			station.DocksAvailable = *s.EmptySlots
+		}
+		stations = append(stations, station)
+	}
+	trace.recordCount("stations", len(stations))
+	return ScrapeResult{Stations: stations}, nil
+}
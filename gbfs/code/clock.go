@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Interface for the source of the current time seen by analytics and alerting,
+// so a replay can run them at the recorded times instead of the wall clock
+type Clock interface {
+	Now() time.Time
+}
+
+// Struct for the wall clock
+type systemClock struct{}
+
+// Function to return the wall-clock time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Struct for a clock that stands still at the time it was last set to, e.g. the
+// recording time of the cycle being replayed
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Function to return the time the clock was last set to
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Function to move the clock to t
+func (c *manualClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Clock read by ingestion, analytics and alerting; serve --replay replaces it.
+// Transport concerns such as timeouts, caches of responses and budgets stay on the
+// wall clock, as they are about the requests actually made.
+var clock Clock = systemClock{}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Thresholds, as a share of the ingestion interval, above which the scrape pool
+// grows and below which it shrinks
+const (
+	concurrencyGrowRatio   = 0.5
+	concurrencyShrinkRatio = 0.1
+)
+
+// Struct for the worker pool scraping providers. With a fixed size it always
+// runs that many workers; otherwise the size follows how long cycles take
+// compared to the interval, between 1 and max.
+type scrapePool struct {
+	fixed int
+	max   int
+
+	mu     sync.Mutex
+	size   int
+	target time.Duration
+}
+
+// Pool used by ingestion, sized with --concurrency and --max-concurrency
+var scrapeWorkers = &scrapePool{max: 32, size: 4}
+
+// Gauge for the number of concurrent scrape workers
+var scrapeConcurrencyGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "gbfs_scrape_concurrency",
+		Help: "Number of providers scraped concurrently in an ingestion cycle",
+	},
+)
+
+// Gauge for the duration of the last ingestion cycle's scrapes
+var cycleDurationGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "gbfs_cycle_duration_seconds",
+		Help: "Time the last ingestion cycle spent scraping providers",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(scrapeConcurrencyGauge, cycleDurationGauge)
+}
+
+// Function to check the pool flags and start the tuned size within the cap
+func (p *scrapePool) validate() error {
+	if p.fixed < 0 {
+		return fmt.Errorf("--concurrency cannot be negative")
+	}
+	if p.max < 1 {
+		return fmt.Errorf("--max-concurrency must be at least 1")
+	}
+	p.size = min(p.size, p.max)
+	return nil
+}
+
+// Function to set the interval that cycles are tuned to fit in
+func (p *scrapePool) setTarget(interval time.Duration) {
+	p.mu.Lock()
+	p.target = interval
+	p.mu.Unlock()
+}
+
+// Function to return the number of workers to use for n providers
+func (p *scrapePool) workers(n int) int {
+	p.mu.Lock()
+	size := p.size
+	if p.fixed > 0 {
+		size = p.fixed
+	}
+	p.mu.Unlock()
+	return max(1, min(size, n))
+}
+
+// Function to call job for every index below n on the pool's workers, returning once all are done
+func (p *scrapePool) run(n int, job func(i int)) {
+	workers := p.workers(n)
+	scrapeConcurrencyGauge.Set(float64(workers))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				job(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Function to resize the pool after a cycle of n providers that took elapsed:
+// doubling when the cycle used over half the interval, shrinking by one when it
+// used under a tenth of it
+func (p *scrapePool) tune(n int, elapsed time.Duration) {
+	cycleDurationGauge.Set(elapsed.Seconds())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fixed > 0 || p.target <= 0 {
+		return
+	}
+
+	ratio := float64(elapsed) / float64(p.target)
+	size := p.size
+	switch {
+	case ratio > concurrencyGrowRatio && p.size < n:
+		size = min(p.size*2, p.max, n)
+	case ratio < concurrencyShrinkRatio:
+		size = max(p.size-1, 1)
+	}
+	if size != p.size {
+		log.Printf("Scrape concurrency %d -> %d: last cycle took %s of a %s interval", p.size, size, elapsed.Round(time.Millisecond), p.target)
+		p.size = size
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Path of the YAML or JSON config file given with --config
+var configPath string
+
+// Optional feeds a provider's config can enable; the discovery feed is always fetched
+var configurableFeeds = []string{
+	"free_bike_status", "vehicle_status", "station_status", "station_information", "system_information",
+	"vehicle_types", "system_hours", "system_pricing_plans",
+}
+
+// Struct for the YAML (or JSON) config file
+type Config struct {
+	Providers []ProviderConfig `yaml:"providers"`
+	// Deployments group further providers served from the same process under their own name and labels
+	Deployments []DeploymentConfig `yaml:"deployments,omitempty"`
+	// StationAlerts fire when stations stay empty, full or offline, see serve --station-alert-webhook
+	StationAlerts []StationAlertRule `yaml:"station_alerts,omitempty"`
+	// DerivedMetrics are per-provider gauges computed from an expression over each scrape
+	DerivedMetrics []DerivedMetric `yaml:"derived_metrics,omitempty"`
+}
+
+// Struct for a single provider entry in the config file
+type ProviderConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// FallbackURLs are discovery URLs tried in order when url fails, e.g. during a domain migration
+	FallbackURLs []string `yaml:"fallback_urls,omitempty"`
+	// Source is "gbfs" (default), "citybikes" or "nextbike-xml"; detected from the URL when empty
+	Source string `yaml:"source,omitempty"`
+	// Headers are sent with every request, e.g. API keys; values may reference
+	// secrets as ${vault:secret/data/gbfs#key}, ${env:NAME} or ${file:/path}
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Verify enables digest or signature checks for operators that sign their feeds
+	Verify *VerifyConfig `yaml:"verify,omitempty"`
+	// QuietHours are daily "HH:MM-HH:MM" windows during which the system is closed and polled less often
+	QuietHours []string `yaml:"quiet_hours,omitempty"`
+	// SystemHours derives closed hours from the provider's system_hours feed
+	SystemHours bool `yaml:"system_hours,omitempty"`
+	// Timezone of the system, e.g. Europe/Amsterdam; defaults to the server's local time
+	Timezone string `yaml:"timezone,omitempty"`
+	// SystemID overrides the system_id from system_information used by --location-label system_id
+	SystemID string `yaml:"system_id,omitempty"`
+	// Normalize fixes malformed feeds before parsing with renames, moves, number conversions or wrapping
+	Normalize []NormalizeStep `yaml:"normalize,omitempty"`
+	// VehicleTypes maps vehicle_type_id values to bike, ebike, scooter, cargo or other
+	VehicleTypes map[string]string `yaml:"vehicle_types,omitempty"`
+	// Tags group providers into gbfs_rollup_* metrics, e.g. {country: NL, operator: tier}
+	Tags map[string]string `yaml:"tags,omitempty"`
+	// Metadata is free-form administrative data, e.g. {permit_id: P-2024-17, contact: ops@example.com},
+	// listed by /api/v1/providers and exported with serve --metadata-info-label
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+	// Budget pauses scraping until UTC midnight once the day's requests or bytes reach it
+	Budget *ScrapeBudget `yaml:"budget,omitempty"`
+	// Language of the discovery feeds to use, e.g. fr; defaults to English, then the first published
+	Language string `yaml:"language,omitempty"`
+	// Interval polls the provider no more often than this, e.g. 15m for slow-changing feeds
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Timeout overrides --provider-timeout for all requests of one scrape
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Feeds limits the feeds fetched to these names, e.g. [station_status, station_information]
+	Feeds []string `yaml:"feeds,omitempty"`
+	// SkipFeeds never fetches these feeds, e.g. [free_bike_status] for a docked-only system
+	SkipFeeds []string `yaml:"skip_feeds,omitempty"`
+	// FleetCap is the number of vehicles the operator's permit allows it to deploy
+	FleetCap int `yaml:"fleet_cap,omitempty"`
+	// Faults are timeouts, 500s, truncated bodies or stale timestamps injected with serve --chaos
+	Faults []FaultRule `yaml:"faults,omitempty"`
+}
+
+// Scaffold written by `config init`; kept as text so the comments survive
+const configScaffold = `# GBFS exporter configuration.
+# Each provider needs the URL of its gbfs.json discovery feed and a unique name
+# (used as the "location" metric label). Providers without a name are named
+# after the name in their system_information, and their operator is added
+# as metadata.
+providers:
+  - name: Aalst
+    url: https://gbfs.api.ridedott.com/public/v2/aalst/gbfs.json
+    # Tags add the provider to gbfs_rollup_* metrics per tag value
+    tags:
+      country: BE
+      operator: dott
+  # - name: Switzerland
+  #   url: https://www.sharedmobility.ch/gbfs.json
+  # - url: https://gbfs.example.com/gbfs.json
+  # Systems without usable GBFS can use the CityBikes network API instead:
+  # - name: Paris
+  #   url: citybikes://velib
+  # Feeds that need an API key can send headers, resolving secrets from
+  # Vault (VAULT_ADDR + VAULT_TOKEN or VAULT_K8S_ROLE), the environment or files:
+  # - name: Oslo
+  #   url: https://gbfs.example.com/oslo/gbfs.json
+  #   headers:
+  #     Authorization: Bearer ${vault:secret/data/gbfs#oslo_token}
+  # Legacy Nextbike XML feeds are converted as well (nextbike://<city uid>):
+  # - name: Leipzig
+  #   url: nextbike://1
+  # Systems that close at night can be polled less often while closed, from
+  # fixed quiet hours or the feed's own system_hours:
+  # - name: Leuven
+  #   url: https://gbfs.example.com/leuven/gbfs.json
+  #   timezone: Europe/Brussels
+  #   quiet_hours: ["01:00-06:00"]
+  #   system_hours: true
+  # Operators that sign their feeds can be verified before ingestion:
+  # - name: Ghent
+  #   url: https://gbfs.example.com/ghent/gbfs.json
+  #   verify:
+  #     digest: true                 # require a matching Content-Digest/Digest header
+  #     checksum_suffix: .sha256     # or compare with a published sha256sum file
+  #     signature_header: X-GBFS-Signature
+  #     public_key: <base64 Ed25519 public key>
+  # Operators migrating domains can be scraped from whichever discovery URL works:
+  # - name: Leuven
+  #   url: https://gbfs.new-domain.example/leuven/gbfs.json
+  #   fallback_urls: [https://gbfs.old-domain.example/leuven/gbfs.json]
+  # Slightly malformed feeds can be normalized before parsing:
+  # - name: Bruges
+  #   url: https://gbfs.example.com/bruges/gbfs.json
+  #   normalize:
+  #     - feed: free_bike_status             # only for feed URLs containing this
+  #       move: {from: vehicles, to: data.bikes}
+  #     - rename: {from: data.bikes.*.id, to: bike_id}
+  #     - numbers: [data.bikes.*.lat, data.bikes.*.lon]
+  #   # Vehicle types are categorized from the vehicle_types feed; aliases override it
+  #   vehicle_types:
+  #     EB-2: ebike
+  #     kick: scooter
+  # Operators that charge per request can be held to a daily budget:
+  # - name: Antwerp
+  #   url: https://gbfs.example.com/antwerp/gbfs.json
+  #   budget:
+  #     daily_requests: 2000
+  #     daily_bytes: 500000000
+  # Each provider can pick its feed language, poll interval, scrape timeout
+  # and the feeds to fetch:
+  # - name: Brussels
+  #   url: https://gbfs.example.com/brussels/gbfs.json
+  #   language: fr
+  #   interval: 15m
+  #   timeout: 20s
+  #   feeds: [station_status, station_information]
+  # or skip the feeds a system does not need, here for a docked-only one:
+  #   skip_feeds: [free_bike_status, vehicle_types]
+  # and compare the vehicles an operator deploys with its city permit:
+  #   fleet_cap: 1500
+  # Administrative metadata is listed by /api/v1/providers and can be
+  # exported as labels of gbfs_provider_metadata_info:
+  #   metadata:
+  #     permit_id: P-2024-17
+  #     contract: city-pilot
+  #     contact: ops@example.com
+  # With serve --chaos, faults are injected into a provider's requests to
+  # check alerts before a real outage: timeout, error (a 500), truncate or stale
+  #   faults:
+  #     - kind: timeout
+  #       feed: station_status
+  #       probability: 0.2
+  #     - kind: stale
+  #       age: 2h
+# Edits take effect after SIGHUP or POST /reload; JSON files with the same
+# fields work too when the path ends in .json.
+# Station alerts are sent to serve --station-alert-webhook when a station stays
+# empty or full, or disappears from its feed:
+# station_alerts:
+#   - name: central-empty
+#     provider: Aalst
+#     stations: ["station-1"]
+#     condition: empty      # empty, full or offline
+#     for: 30m
+# Derived metrics are exported per provider as gbfs_derived_<name>, computed after
+# every scrape from available_bikes, free_bikes, docked_bikes, docks_available,
+# stations, stations_renting, stations_empty, stations_full, vehicles_reserved,
+# vehicles_disabled and vehicles_<category>; a division by zero skips the value:
+# derived_metrics:
+#   - name: ebike_share
+#     help: Share of available vehicles that are e-bikes
+#     expr: "available_bikes > 0 ? vehicles_ebike / available_bikes : 0"
+# Several cities can be served from one process as named deployments; their
+# metrics carry a deployment label and are served at /metrics?deployment=<name>,
+# their API at /deployments/<name>/api/v1/...
+# deployments:
+#   - name: benelux
+#     labels: {region: eu-west}
+#     webhooks:
+#       - url: https://hooks.example.com/benelux
+#     providers:
+#       - name: Rotterdam
+#         url: https://gbfs.example.com/rotterdam/gbfs.json
+`
+
+// Function to load and validate the config file at path
+func loadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return parseConfig(path, data)
+}
+
+// Function to parse and validate config data; path identifies its origin in errors
+// and selects JSON when it ends in .json
+func parseConfig(path string, data []byte) (Config, error) {
+	if strings.HasSuffix(path, ".json") {
+		// Round-trip through YAML so both formats share the yaml field names
+		var doc any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		converted, err := yaml.Marshal(doc)
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		data = converted
+	}
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := config.validate(path); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Function to validate the config's providers and deployments; path identifies its origin in errors
+func (c Config) validate(path string) error {
+	seen := make(map[string]bool, len(c.Providers))
+	for i, provider := range c.Providers {
+		if err := provider.validate(path, i, seen); err != nil {
+			return err
+		}
+	}
+	if err := c.validateDeployments(path, seen); err != nil {
+		return err
+	}
+	rules := map[string]bool{}
+	for _, rule := range c.StationAlerts {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if rules[rule.Name] {
+			return fmt.Errorf("%s: duplicate station alert name %q", path, rule.Name)
+		}
+		rules[rule.Name] = true
+	}
+	derived := map[string]bool{}
+	for _, metric := range c.DerivedMetrics {
+		if _, err := metric.compile(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if derived[metric.Name] {
+			return fmt.Errorf("%s: duplicate derived metric name %q", path, metric.Name)
+		}
+		derived[metric.Name] = true
+	}
+	return nil
+}
+
+// Function to validate one provider entry; seen holds names already in use, across deployments
+func (provider ProviderConfig) validate(path string, i int, seen map[string]bool) error {
+	if provider.URL == "" {
+		return fmt.Errorf("%s: provider %d needs a url", path, i+1)
+	}
+	if provider.Name != "" && seen[provider.Name] {
+		return fmt.Errorf("%s: duplicate provider name %q", path, provider.Name)
+	}
+	for _, url := range provider.FallbackURLs {
+		if url == "" || url == provider.URL {
+			return fmt.Errorf("%s: provider %q: fallback_urls must be non-empty and differ from url", path, provider.Name)
+		}
+	}
+	if !validSource(provider.Source) {
+		return fmt.Errorf("%s: provider %q has unknown source %q", path, provider.Name, provider.Source)
+	}
+	for _, window := range provider.QuietHours {
+		if _, err := parseClockWindow(window); err != nil {
+			return fmt.Errorf("%s: provider %q: %w", path, provider.Name, err)
+		}
+	}
+	if provider.Timezone != "" {
+		if _, err := time.LoadLocation(provider.Timezone); err != nil {
+			return fmt.Errorf("%s: provider %q: unknown timezone %q", path, provider.Name, provider.Timezone)
+		}
+	}
+	if provider.Verify != nil {
+		if err := provider.Verify.validate(); err != nil {
+			return fmt.Errorf("%s: provider %q: %w", path, provider.Name, err)
+		}
+	}
+	for id, category := range provider.VehicleTypes {
+		if err := validVehicleCategory(category); err != nil {
+			return fmt.Errorf("%s: provider %q: vehicle type %q: %w", path, provider.Name, id, err)
+		}
+	}
+	if provider.Budget != nil {
+		if err := provider.Budget.validate(); err != nil {
+			return fmt.Errorf("%s: provider %q: %w", path, provider.Name, err)
+		}
+	}
+	for _, step := range provider.Normalize {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("%s: provider %q: %w", path, provider.Name, err)
+		}
+	}
+	if provider.FleetCap < 0 {
+		return fmt.Errorf("%s: provider %q: fleet_cap must not be negative", path, provider.Name)
+	}
+	for _, fault := range provider.Faults {
+		if err := fault.validate(); err != nil {
+			return fmt.Errorf("%s: provider %q: %w", path, provider.Name, err)
+		}
+	}
+	if provider.Interval < 0 || provider.Timeout < 0 {
+		return fmt.Errorf("%s: provider %q: interval and timeout must not be negative", path, provider.Name)
+	}
+	if len(provider.Feeds) > 0 || len(provider.SkipFeeds) > 0 {
+		for _, feed := range append(slices.Clone(provider.Feeds), provider.SkipFeeds...) {
+			if !slices.Contains(configurableFeeds, feed) {
+				return fmt.Errorf("%s: provider %q: unknown feed %q, expected one of %s", path, provider.Name, feed, strings.Join(configurableFeeds, ", "))
+			}
+		}
+		enabled := Provider{Feeds: provider.Feeds, SkipFeeds: provider.SkipFeeds}
+		if !enabled.feedEnabled("free_bike_status") && !enabled.feedEnabled("station_status") {
+			return fmt.Errorf("%s: provider %q: feeds and skip_feeds must leave free_bike_status, vehicle_status or station_status enabled", path, provider.Name)
+		}
+	}
+	seen[provider.Name] = true
+	return nil
+}
+
+// Function to convert the config file's providers, including those of every deployment, into the internal representation
+func (c Config) providers() []Provider {
+	providers := make([]Provider, 0, len(c.Providers))
+	for _, provider := range c.Providers {
+		providers = append(providers, provider.provider())
+	}
+	for _, deployment := range c.Deployments {
+		for _, provider := range deployment.Providers {
+			p := provider.provider()
+			p.Deployment = deployment.Name
+			p.DeploymentLabels = deployment.Labels
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// Function to convert one provider entry into the internal representation
+func (provider ProviderConfig) provider() Provider {
+	p := newProvider(provider.Name, provider.URL, provider.Source, provider.Headers)
+	p.Verify = provider.Verify
+	p.FallbackURLs = provider.FallbackURLs
+	if provider.Name == "" {
+		p.Location = provisionalLocation(provider.URL)
+		p.AutoName = true
+	}
+	for _, value := range provider.QuietHours {
+		if window, err := parseClockWindow(value); err == nil {
+			p.QuietHours = append(p.QuietHours, window)
+		}
+	}
+	p.SystemHours = provider.SystemHours
+	p.Timezone = provider.Timezone
+	p.SystemID = provider.SystemID
+	p.Normalize = provider.Normalize
+	p.VehicleTypes = provider.VehicleTypes
+	p.Tags = provider.Tags
+	p.Metadata = provider.Metadata
+	p.Budget = provider.Budget
+	p.Language = provider.Language
+	p.Interval = provider.Interval
+	p.Timeout = provider.Timeout
+	p.Feeds = provider.Feeds
+	p.SkipFeeds = provider.SkipFeeds
+	p.FleetCap = provider.FleetCap
+	p.Faults = provider.Faults
+	return p
+}
+
+// Function to serialize a config as YAML
+func marshalConfig(config Config) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Function to write data to path, or stdout when path is "-", refusing to clobber existing files
+func writeConfigFile(stdout io.Writer, path string, data []byte, force bool) error {
+	if path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, use --force to overwrite", path)
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Function to build the config command with its init and migrate-env subcommands
+func newConfigCommand() *cobra.Command {
+	var out string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Generate or migrate the YAML config file",
+	}
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a commented example config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeConfigFile(cmd.OutOrStdout(), out, []byte(configScaffold), force)
+		},
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate-env",
+		Short: "Convert providerN_region/providerN_url environment variables into a config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			providers, err := getProvidersFromEnv()
+			if err != nil {
+				return err
+			}
+			var config Config
+			for _, provider := range providers {
+				config.Providers = append(config.Providers, ProviderConfig{
+					Name: provider.Location,
+					URL:  provider.URL,
+				})
+				if provider.Source != sourceGBFS {
+					config.Providers[len(config.Providers)-1].Source = provider.Source
+				}
+			}
+			data, err := marshalConfig(config)
+			if err != nil {
+				return err
+			}
+			return writeConfigFile(cmd.OutOrStdout(), out, data, force)
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&out, "out", "o", "gbfs.yaml", "file to write, or - for stdout")
+	cmd.PersistentFlags().BoolVar(&force, "force", false, "overwrite an existing file")
+	cmd.AddCommand(initCmd, migrateCmd)
+	return cmd
+}
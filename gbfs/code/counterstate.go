@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Counters whose values survive restarts when --counter-state is set, by metric name
+var persistentCounters = map[string]*prometheus.CounterVec{
+	"gbfs_vehicles_appeared_total":          vehiclesAppeared,
+	"gbfs_vehicles_disappeared_total":       vehiclesDisappeared,
+	"gbfs_estimated_trips_total":            estimatedTrips,
+	"gbfs_feed_verification_failures_total": feedVerificationFailures,
+	"gbfs_feed_timeouts_total":              feedTimeoutsTotal,
+	"gbfs_scrape_failures_total":            scrapeFailures,
+	"gbfs_pricing_changes_total":            pricingChanges,
+	"gbfs_fleet_days_over_cap_total":        fleetDaysOverCap,
+	"gbfs_throttle_events_total":            throttleEvents,
+	"gbfs_discovery_failovers_total":        discoveryFailovers,
+	"gbfs_label_values_dropped_total":       labelValuesDropped,
+}
+
+// Struct for one saved counter series
+type savedCounter struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// Interface for backends that can hold the saved counters
+type counterStateStore interface {
+	SaveCounters(data []byte) error
+	LoadCounters() ([]byte, error)
+}
+
+// Struct for counters saved as a JSON file, replaced atomically
+type fileCounterState struct {
+	path string
+}
+
+// Function to write the counters to a temporary file and rename it into place
+func (f fileCounterState) SaveCounters(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".counters-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Function to read the counters file; a missing file means nothing was saved yet
+func (f fileCounterState) LoadCounters() ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Function to pick the counter store: "state" uses the --state-backend, anything else is a file path
+func openCounterState(target string) (counterStateStore, error) {
+	if target != "state" {
+		return fileCounterState{path: target}, nil
+	}
+	store, ok := liveState.shared.(counterStateStore)
+	if !ok {
+		return nil, fmt.Errorf("--counter-state state needs a --state-backend")
+	}
+	return store, nil
+}
+
+// Function to collect the current value of every persistent counter series
+func collectCounters() []savedCounter {
+	var saved []savedCounter
+	for name, vec := range persistentCounters {
+		metrics := make(chan prometheus.Metric, 64)
+		go func() {
+			vec.Collect(metrics)
+			close(metrics)
+		}()
+		for metric := range metrics {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil || m.Counter == nil {
+				continue
+			}
+			labels := make(map[string]string, len(m.Label))
+			for _, pair := range m.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			saved = append(saved, savedCounter{Name: name, Labels: labels, Value: m.Counter.GetValue()})
+		}
+	}
+	return saved
+}
+
+// Function to save all persistent counters
+func saveCounters(store counterStateStore) error {
+	data, err := json.Marshal(collectCounters())
+	if err != nil {
+		return err
+	}
+	return store.SaveCounters(data)
+}
+
+// Function to restore saved counters at startup, before anything increments them
+func restoreCounters(store counterStateStore) error {
+	data, err := store.LoadCounters()
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	var saved []savedCounter
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing saved counters: %w", err)
+	}
+	restored := 0
+	for _, counter := range saved {
+		vec, ok := persistentCounters[counter.Name]
+		if !ok || counter.Value < 0 {
+			continue
+		}
+		c, err := vec.GetMetricWith(counter.Labels)
+		if err != nil {
+			// The label set changed between versions
+			continue
+		}
+		c.Add(counter.Value)
+		restored++
+	}
+	log.Printf("Restored %d counter series", restored)
+	return nil
+}
+
+// Function to restore the counters, then save them every interval and on shutdown
+func startCounterPersistence(store counterStateStore, interval time.Duration) error {
+	if err := restoreCounters(store); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := saveCounters(store); err != nil {
+				log.Printf("Error saving counters: %v", err)
+			}
+		}
+	}()
+	onShutdown(func() {
+		if err := saveCounters(store); err != nil {
+			log.Printf("Error saving counters: %v", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Count-only mode set with --count-only: free_bike_status feeds are scanned for
+// their vehicle count without decoding the vehicles, so per-vehicle features
+// (vehicle APIs, churn, geographic overlays) see no vehicles
+var countOnly bool
+
+// Function to count the entries of data.bikes in a free_bike_status document by
+// scanning its tokens, skipping every other value without building it. A null
+// data or vehicle list counts no vehicles, as when decoding the whole feed.
+func countFreeBikeStatus(body []byte) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, err
+		}
+		if key != "data" {
+			if err := skipValue(dec); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		present, err := expectDelimOrNull(dec, '{')
+		if err != nil {
+			return 0, fmt.Errorf("data: %w", err)
+		}
+		if !present {
+			return 0, nil
+		}
+		count := 0
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return 0, err
+			}
+			// GBFS 3.x vehicle_status lists vehicles instead of bikes
+			if key != "bikes" && key != "vehicles" {
+				if err := skipValue(dec); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			present, err := expectDelimOrNull(dec, '[')
+			if err != nil {
+				return 0, fmt.Errorf("data.%s: %w", key, err)
+			}
+			if !present {
+				continue
+			}
+			for dec.More() {
+				if err := skipValue(dec); err != nil {
+					return 0, err
+				}
+				count++
+			}
+			if _, err := dec.Token(); err != nil {
+				return 0, err
+			}
+		}
+		return count, nil
+	}
+	return 0, nil
+}
+
+// Function to read the next token and check it opens the expected object or array
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	present, err := expectDelimOrNull(dec, delim)
+	if err == nil && !present {
+		return fmt.Errorf("expected %q, found null", delim)
+	}
+	return err
+}
+
+// Function to read the next token and check it opens the expected object or array,
+// reporting false for a null in its place
+func expectDelimOrNull(dec *json.Decoder, delim json.Delim) (bool, error) {
+	token, err := dec.Token()
+	if err == io.EOF {
+		return false, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	if token == nil {
+		return false, nil
+	}
+	if token != delim {
+		return false, fmt.Errorf("expected %q, found %v", delim, token)
+	}
+	return true, nil
+}
+
+// Function to consume one value, however deeply nested, without decoding it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Datadog metric intake type for gauges
+const datadogGauge = 3
+
+// Datadog service check statuses
+const (
+	datadogCheckOK       = 0
+	datadogCheckCritical = 2
+)
+
+// Struct for a sink submitting availability gauges and provider up/down
+// service checks straight to the Datadog API, without an agent
+type datadogSink struct {
+	apiKey   string
+	baseURL  string
+	hostname string
+	tags     []string
+	client   *http.Client
+}
+
+// Function to create a Datadog sink for a site such as datadoghq.com or datadoghq.eu
+func newDatadogSink(apiKey, site string, tags []string) (*datadogSink, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("a Datadog API key is required")
+	}
+	hostname, _ := os.Hostname()
+	return &datadogSink{
+		apiKey:   apiKey,
+		baseURL:  "https://api." + site,
+		hostname: hostname,
+		tags:     tags,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Function to return the sink name used in logs
+func (s *datadogSink) Name() string {
+	return "datadog"
+}
+
+// Function to build the tags for a provider, including the configured static tags
+func (s *datadogSink) providerTags(snapshot ProviderSnapshot) []string {
+	return append([]string{"location:" + snapshot.Location}, s.tags...)
+}
+
+// Function to submit gauges and service checks for a cycle
+func (s *datadogSink) Publish(snapshots []ProviderSnapshot) error {
+	type point struct {
+		Timestamp int64   `json:"timestamp"`
+		Value     float64 `json:"value"`
+	}
+	type series struct {
+		Metric string   `json:"metric"`
+		Type   int      `json:"type"`
+		Points []point  `json:"points"`
+		Tags   []string `json:"tags"`
+	}
+	type checkRun struct {
+		Check     string   `json:"check"`
+		HostName  string   `json:"host_name"`
+		Status    int      `json:"status"`
+		Timestamp int64    `json:"timestamp"`
+		Message   string   `json:"message,omitempty"`
+		Tags      []string `json:"tags"`
+	}
+
+	now := time.Now().Unix()
+	var metrics []series
+	var checks []checkRun
+	total := 0
+	for _, snapshot := range snapshots {
+		tags := s.providerTags(snapshot)
+		check := checkRun{
+			Check:     "gbfs.provider.up",
+			HostName:  s.hostname,
+			Status:    datadogCheckOK,
+			Timestamp: now,
+			Tags:      tags,
+		}
+		if snapshot.Error != "" {
+			check.Status = datadogCheckCritical
+			check.Message = snapshot.Error
+			checks = append(checks, check)
+			continue
+		}
+		checks = append(checks, check)
+		metrics = append(metrics, series{
+			Metric: "gbfs.available_bikes",
+			Type:   datadogGauge,
+			Points: []point{{Timestamp: now, Value: float64(snapshot.AvailableBikes)}},
+			Tags:   tags,
+		})
+		total += snapshot.AvailableBikes
+	}
+	metrics = append(metrics, series{
+		Metric: "gbfs.total_available_bikes",
+		Type:   datadogGauge,
+		Points: []point{{Timestamp: now, Value: float64(total)}},
+		Tags:   s.tags,
+	})
+
+	if err := s.post("/api/v2/series", map[string]interface{}{"series": metrics}); err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if err := s.post("/api/v1/check_run", check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to POST a JSON payload to the Datadog API
+func (s *datadogSink) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
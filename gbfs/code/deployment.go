@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Struct for a named group of providers in the config file, served from the same
+// process with its own labels, sinks and metrics and API namespace
+type DeploymentConfig struct {
+	Name string `yaml:"name"`
+	// Labels are added to every metric series of the deployment's providers
+	Labels    map[string]string   `yaml:"labels,omitempty"`
+	Providers []ProviderConfig    `yaml:"providers"`
+	Webhooks  []DeploymentWebhook `yaml:"webhooks,omitempty"`
+}
+
+// Struct for a webhook receiving only the snapshots of one deployment
+type DeploymentWebhook struct {
+	URL string `yaml:"url"`
+	// Secret signs the payloads; may be a secret reference such as ${env:NAME}
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// Label added to the metrics of providers that belong to a deployment
+const deploymentLabel = "deployment"
+
+// Names allowed for deployments, usable in URL paths and label values
+var deploymentNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Names allowed for extra labels, as Prometheus requires
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Function to validate the config's deployments; provider names must be unique
+// across deployments, since they identify providers in metrics and the API
+func (c Config) validateDeployments(path string, seen map[string]bool) error {
+	names := map[string]bool{}
+	for i, deployment := range c.Deployments {
+		if !deploymentNamePattern.MatchString(deployment.Name) {
+			return fmt.Errorf("%s: deployment %d needs a name of letters, digits, - or _", path, i+1)
+		}
+		if names[deployment.Name] {
+			return fmt.Errorf("%s: duplicate deployment name %q", path, deployment.Name)
+		}
+		names[deployment.Name] = true
+		for name := range deployment.Labels {
+			if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("%s: deployment %q: invalid label name %q", path, deployment.Name, name)
+			}
+			if name == deploymentLabel || name == "location" || name == "url" {
+				return fmt.Errorf("%s: deployment %q: label %q is reserved", path, deployment.Name, name)
+			}
+		}
+		for j, webhook := range deployment.Webhooks {
+			if webhook.URL == "" {
+				return fmt.Errorf("%s: deployment %q: webhook %d needs a url", path, deployment.Name, j+1)
+			}
+		}
+		for j, provider := range deployment.Providers {
+			if err := provider.validate(path+": deployment "+deployment.Name, j, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sinks that receive only one deployment's snapshots, by deployment name; replaced on config reloads
+var deploymentSinks = map[string][]Sink{}
+var deploymentSinksMu sync.RWMutex
+
+// Function to start the webhooks of every deployment in the config, replacing those of a previous config
+func setupDeploymentSinks(config Config, retries int) error {
+	sinks := map[string][]Sink{}
+	for _, deployment := range config.Deployments {
+		for _, webhook := range deployment.Webhooks {
+			secret, err := secrets.expand(webhook.Secret)
+			if err != nil {
+				return fmt.Errorf("deployment %s: webhook secret: %w", deployment.Name, err)
+			}
+			registerSecretValue(secret)
+			sinks[deployment.Name] = append(sinks[deployment.Name], newWebhookSink(webhook.URL, secret, retries))
+		}
+	}
+	deploymentSinksMu.Lock()
+	deploymentSinks = sinks
+	deploymentSinksMu.Unlock()
+	return nil
+}
+
+// Function to hand each deployment's sinks the snapshots of its own providers
+func publishToDeploymentSinks(snapshots []ProviderSnapshot) {
+	deploymentSinksMu.RLock()
+	current := deploymentSinks
+	deploymentSinksMu.RUnlock()
+	for name, sinks := range current {
+		var own []ProviderSnapshot
+		for _, snapshot := range snapshots {
+			if snapshot.Deployment == name {
+				own = append(own, snapshot)
+			}
+		}
+		if len(own) == 0 {
+			continue
+		}
+		for _, sink := range sinks {
+			if err := sink.Publish(own); err != nil {
+				log.Printf("Error publishing to %s sink of deployment %s: %v", sink.Name(), name, err)
+			}
+		}
+	}
+}
+
+// Function to wrap a gatherer so series of deployment providers carry the deployment's
+// labels, keeping only one deployment's series when only is set
+func deploymentGatherer(gatherer prometheus.Gatherer, providers []Provider, only string) prometheus.Gatherer {
+	byLocation := make(map[string]Provider, len(providers))
+	for _, provider := range providers {
+		if provider.Deployment != "" {
+			byLocation[metricLocation(provider)] = provider
+		}
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+
+		var result []*dto.MetricFamily
+		for _, family := range families {
+			var metrics []*dto.Metric
+			for _, metric := range family.Metric {
+				provider, ok := byLocation[metricLabel(metric, "location")]
+				if only != "" && (!ok || provider.Deployment != only) {
+					continue
+				}
+				if ok {
+					addDeploymentLabels(metric, provider)
+				}
+				metrics = append(metrics, metric)
+			}
+			if len(metrics) > 0 {
+				family.Metric = metrics
+				result = append(result, family)
+			}
+		}
+		return result, nil
+	})
+}
+
+// Function to return the value of a series' label, or "" when it has none
+func metricLabel(metric *dto.Metric, name string) string {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// Function to add a provider's deployment labels to a series, keeping labels sorted by name
+func addDeploymentLabels(metric *dto.Metric, provider Provider) {
+	labels := map[string]string{deploymentLabel: provider.Deployment}
+	for name, value := range provider.DeploymentLabels {
+		labels[name] = value
+	}
+	for name, value := range labels {
+		if metricLabel(metric, name) != "" {
+			continue
+		}
+		name, value := name, value
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+}
+
+// Function to keep only the providers of the request's deployment, if one was selected
+func deploymentProviders(c *gin.Context, providers []Provider) []Provider {
+	name := c.Query(deploymentLabel)
+	if name == "" {
+		return providers
+	}
+	own := providers[:0:0]
+	for _, provider := range providers {
+		if provider.Deployment == name {
+			own = append(own, provider)
+		}
+	}
+	return own
+}
+
+// Function to report whether any provider belongs to the named deployment
+func deploymentExists(providers []Provider, name string) bool {
+	for _, provider := range providers {
+		if provider.Deployment == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler for /deployments/:deployment/*path, serving the rest of the API scoped to one deployment
+func deploymentRouteHandler(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		providers, err := getProviders()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		name := c.Param(deploymentLabel)
+		if !deploymentExists(providers, name) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+			return
+		}
+		query := c.Request.URL.Query()
+		query.Set(deploymentLabel, name)
+		c.Request.URL.Path = c.Param("path")
+		c.Request.URL.RawQuery = query.Encode()
+		router.HandleContext(c)
+		// The outer chain must not resume with the re-routed handlers
+		c.Abort()
+	}
+}
+
+// Middleware refusing providers outside the selected deployment, whether named
+// in the path or in the provider query parameter
+func deploymentScope(c *gin.Context) {
+	name := c.Query(deploymentLabel)
+	if name == "" {
+		c.Next()
+		return
+	}
+	for _, location := range []string{c.Param("name"), c.Param("provider"), c.Query("provider")} {
+		if location == "" {
+			continue
+		}
+		providers, err := getProviders()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		found := false
+		for _, provider := range deploymentProviders(c, providers) {
+			found = found || provider.Location == location
+		}
+		if !found {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "provider not found in deployment " + name})
+			return
+		}
+	}
+	c.Next()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prefix of the metrics defined by the config's derived_metrics
+const derivedMetricPrefix = "gbfs_derived_"
+
+// Struct for a gauge computed per provider from an expression over its scrape, in the config file
+type DerivedMetric struct {
+	// Name is exported as gbfs_derived_<name>
+	Name string `yaml:"name"`
+	Help string `yaml:"help,omitempty"`
+	// Expr is evaluated after every successful scrape, e.g. vehicles_ebike / max(available_bikes, 1)
+	Expr string `yaml:"expr"`
+}
+
+// Function to return the variables an expression can use, from a provider's scrape
+func derivedVariables(result ScrapeResult) map[string]float64 {
+	vars := map[string]float64{
+		"available_bikes": float64(result.AvailableBikes()),
+		"free_bikes":      float64(len(result.Bikes) + result.BikeCount),
+		"stations":        float64(len(result.Stations)),
+		// Sums over the stations and vehicles below, defined even when there are none
+		"docked_bikes": 0, "docks_available": 0, "stations_renting": 0, "stations_empty": 0, "stations_full": 0,
+		"vehicles_reserved": 0, "vehicles_disabled": 0,
+	}
+	for _, station := range result.Stations {
+		vars["docked_bikes"] += float64(station.BikesAvailable)
+		vars["docks_available"] += float64(station.DocksAvailable)
+		if station.IsRenting {
+			vars["stations_renting"]++
+		}
+		if station.BikesAvailable == 0 {
+			vars["stations_empty"]++
+		}
+		if station.DocksAvailable == 0 {
+			vars["stations_full"]++
+		}
+	}
+	for _, bike := range result.Bikes {
+		if bike.IsReserved {
+			vars["vehicles_reserved"]++
+		}
+		if bike.IsDisabled {
+			vars["vehicles_disabled"]++
+		}
+	}
+	for category, count := range countByCategory(result.Bikes) {
+		vars["vehicles_"+category] = float64(count)
+	}
+	return vars
+}
+
+// Function to return the names derivedVariables defines, for checking expressions
+func derivedVariableNames() map[string]bool {
+	names := map[string]bool{}
+	for name := range derivedVariables(ScrapeResult{}) {
+		names[name] = true
+	}
+	return names
+}
+
+// Function to check a derived metric and compile its expression
+func (m DerivedMetric) compile() (exprNode, error) {
+	if m.Name == "" || !labelNamePattern.MatchString(m.Name) {
+		return nil, fmt.Errorf("derived metric %q needs a name of letters, digits and underscores", m.Name)
+	}
+	if strings.TrimSpace(m.Expr) == "" {
+		return nil, fmt.Errorf("derived metric %q needs an expr", m.Name)
+	}
+	node, err := compileExpr(m.Expr, derivedVariableNames())
+	if err != nil {
+		return nil, fmt.Errorf("derived metric %q: %w", m.Name, err)
+	}
+	return node, nil
+}
+
+// Struct for a configured derived metric, ready to evaluate and export
+type derivedRule struct {
+	expr exprNode
+	desc *prometheus.Desc
+}
+
+// Struct evaluating the derived metrics after each scrape and exporting the latest
+// values. It collects unchecked, so a config reload can add and drop metrics.
+type derivedMetricSet struct {
+	mu    sync.Mutex
+	names []string
+	rules map[string]derivedRule
+	// latest values by provider and metric name, with the provider's metric location
+	values    map[string]map[string]float64
+	locations map[string]string
+}
+
+// Derived metrics, set up from the config's derived_metrics
+var derivedMetrics = &derivedMetricSet{values: map[string]map[string]float64{}, locations: map[string]string{}}
+
+func init() {
+	prometheus.MustRegister(derivedMetrics)
+}
+
+// Function to replace the derived metrics; the metrics are validated with the config
+func (s *derivedMetricSet) setRules(metrics []DerivedMetric) {
+	rules := map[string]derivedRule{}
+	var names []string
+	for _, metric := range metrics {
+		node, err := metric.compile()
+		if err != nil {
+			continue
+		}
+		help := metric.Help
+		if help == "" {
+			help = "Derived from " + metric.Expr
+		}
+		rules[metric.Name] = derivedRule{
+			expr: node,
+			desc: prometheus.NewDesc(derivedMetricPrefix+metric.Name, help, []string{"location"}, nil),
+		}
+		names = append(names, metric.Name)
+	}
+	sort.Strings(names)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names, s.rules = names, rules
+	for location, values := range s.values {
+		for name := range values {
+			if _, ok := rules[name]; !ok {
+				delete(values, name)
+			}
+		}
+		if len(values) == 0 {
+			delete(s.values, location)
+		}
+	}
+}
+
+// Function to evaluate the derived metrics against a provider's scrape. A metric
+// whose expression fails, e.g. dividing by zero, has no value for the provider
+// until it evaluates again.
+func (s *derivedMetricSet) observe(provider Provider, result ScrapeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.rules) == 0 {
+		return
+	}
+	vars := derivedVariables(result)
+	values := make(map[string]float64, len(s.rules))
+	for _, name := range s.names {
+		value, err := s.rules[name].expr.eval(vars)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		values[name] = value
+	}
+	s.values[provider.Location] = values
+	s.locations[provider.Location] = metricLocation(provider)
+}
+
+// Function to drop a provider's derived values
+func (s *derivedMetricSet) forget(location string) {
+	s.mu.Lock()
+	delete(s.values, location)
+	delete(s.locations, location)
+	s.mu.Unlock()
+}
+
+// Function to describe nothing, which makes the collector unchecked
+func (s *derivedMetricSet) Describe(chan<- *prometheus.Desc) {}
+
+// Function to export the latest derived values of every provider
+func (s *derivedMetricSet) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for location, values := range s.values {
+		for name, value := range values {
+			ch <- prometheus.MustNewConstMetric(s.rules[name].desc, prometheus.GaugeValue, value, s.locations[location])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// IP families accepted by --ip-family
+const (
+	familyAny        = "any"
+	familyIPv4       = "ipv4"
+	familyIPv6       = "ipv6"
+	familyPreferIPv4 = "prefer-ipv4"
+	familyPreferIPv6 = "prefer-ipv6"
+)
+
+// Struct for how feed connections are dialed
+type dialOptions struct {
+	family string
+	// fallbackDelay is how long happy eyeballs waits before racing the other family; negative disables it
+	fallbackDelay time.Duration
+	// sourceIP or sourceInterface select the local address connections originate from
+	sourceIP        string
+	sourceInterface string
+
+	// Resolved by configure
+	network   string
+	localAddr net.Addr
+}
+
+// Dial settings, set with --ip-family, --happy-eyeballs-delay, --source-ip and --source-interface
+var dialing = &dialOptions{family: familyAny, fallbackDelay: 300 * time.Millisecond}
+
+// Function to check the dial flags and resolve the network and local address
+func (o *dialOptions) configure() error {
+	o.network = "tcp"
+	switch o.family {
+	case familyAny, familyPreferIPv4, familyPreferIPv6:
+	case familyIPv4:
+		o.network = "tcp4"
+	case familyIPv6:
+		o.network = "tcp6"
+	default:
+		return fmt.Errorf("invalid --ip-family %q, expected any, ipv4, ipv6, prefer-ipv4 or prefer-ipv6", o.family)
+	}
+	if o.sourceIP != "" && o.sourceInterface != "" {
+		return fmt.Errorf("--source-ip and --source-interface cannot be combined")
+	}
+
+	var source net.IP
+	switch {
+	case o.sourceIP != "":
+		if source = net.ParseIP(o.sourceIP); source == nil {
+			return fmt.Errorf("invalid --source-ip %q", o.sourceIP)
+		}
+	case o.sourceInterface != "":
+		ip, err := interfaceAddress(o.sourceInterface, o.network)
+		if err != nil {
+			return err
+		}
+		source = ip
+	default:
+		o.localAddr = nil
+		return nil
+	}
+
+	// A local address of one family cannot reach the other one
+	if source.To4() != nil {
+		if o.network == "tcp6" {
+			return fmt.Errorf("source address %s is IPv4 but --ip-family is ipv6", source)
+		}
+		o.network = "tcp4"
+	} else {
+		if o.network == "tcp4" {
+			return fmt.Errorf("source address %s is IPv6 but --ip-family is ipv4", source)
+		}
+		o.network = "tcp6"
+	}
+	o.localAddr = &net.TCPAddr{IP: source}
+	return nil
+}
+
+// Function to pick the first usable address of a network interface for the network
+func interfaceAddress(name, network string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("--source-interface: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("--source-interface %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		isV4 := ipNet.IP.To4() != nil
+		if (network == "tcp4" && !isV4) || (network == "tcp6" && isV4) {
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	return nil, fmt.Errorf("--source-interface %s has no usable %s address", name, network)
+}
+
+// Function to report whether addresses must be resolved and ordered before dialing
+func (o *dialOptions) ordersAddresses() bool {
+	return o.family == familyPreferIPv4 || o.family == familyPreferIPv6
+}
+
+// Function to order resolved addresses with the preferred family first, keeping the resolver's order otherwise
+func (o *dialOptions) order(addrs []string) []string {
+	if !o.ordersAddresses() {
+		return addrs
+	}
+	wantV4 := o.family == familyPreferIPv4
+	ordered := append([]string(nil), addrs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		iV4 := net.ParseIP(ordered[i]).To4() != nil
+		jV4 := net.ParseIP(ordered[j]).To4() != nil
+		return iV4 == wantV4 && jV4 != wantV4
+	})
+	return ordered
+}
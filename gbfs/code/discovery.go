@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Discovery language preferred for providers whose config sets none, set with --language
+var preferredLanguage string
+
+// Gauge for the GBFS version each provider's discovery document declares
+var feedVersionInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_version_info",
+		Help: "GBFS version declared by the provider's discovery document, always 1",
+	},
+	[]string{"location", "version"},
+)
+
+// Version last exported per provider, so an upgrade replaces the old series
+var feedVersions = struct {
+	sync.Mutex
+	byLocation map[string]string
+}{byLocation: map[string]string{}}
+
+func init() {
+	prometheus.MustRegister(feedVersionInfo)
+}
+
+// Struct for a GBFS discovery (gbfs.json) document, independent of language layout.
+// In v1/v2 the feeds are nested under a language key (data.en.feeds); in v3 they
+// sit directly under data.feeds.
+type GBFSDiscovery struct {
+	TTL     int                        `json:"ttl"`
+	Version string                     `json:"version"`
+	Data    map[string]json.RawMessage `json:"data"`
+}
+
+// Struct for the feed list under one language (or the flat v3 data object)
+type gbfsFeedList struct {
+	Feeds []GBFSFeed `json:"feeds"`
+}
+
+// Function to parse a discovery document
+func parseDiscovery(body []byte) (GBFSDiscovery, error) {
+	var discovery GBFSDiscovery
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return GBFSDiscovery{}, err
+	}
+	if len(discovery.Data) == 0 {
+		return GBFSDiscovery{}, fmt.Errorf("discovery document has no data")
+	}
+	return discovery, nil
+}
+
+// Function to return the declared GBFS version; documents without one predate the
+// version field added in 1.1
+func (d GBFSDiscovery) detectedVersion() string {
+	if d.Version == "" {
+		return "1.0"
+	}
+	return d.Version
+}
+
+// Function to export the GBFS version a provider declares
+func recordFeedVersion(provider Provider, version string) {
+	location := metricLocation(provider)
+	feedVersions.Lock()
+	defer feedVersions.Unlock()
+	if previous, ok := feedVersions.byLocation[location]; ok && previous != version {
+		feedVersionInfo.DeleteLabelValues(location, previous)
+	}
+	feedVersions.byLocation[location] = version
+	feedVersionInfo.WithLabelValues(location, version).Set(1)
+}
+
+// Function to return the feeds keyed by language. The flat v3 layout uses the
+// empty string as its only key.
+func (d GBFSDiscovery) FeedsByLanguage() (map[string][]GBFSFeed, error) {
+	if raw, ok := d.Data["feeds"]; ok {
+		var feeds []GBFSFeed
+		if err := json.Unmarshal(raw, &feeds); err != nil {
+			return nil, fmt.Errorf("parsing feeds: %w", err)
+		}
+		return map[string][]GBFSFeed{"": feeds}, nil
+	}
+
+	byLanguage := make(map[string][]GBFSFeed, len(d.Data))
+	for language, raw := range d.Data {
+		var list gbfsFeedList
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("parsing feeds for language %s: %w", language, err)
+		}
+		byLanguage[language] = list.Feeds
+	}
+	return byLanguage, nil
+}
+
+// Function to return the feeds of one language, preferring the given one, then
+// English as the exporter always has
+func (d GBFSDiscovery) preferredFeeds(language string) ([]GBFSFeed, error) {
+	byLanguage, err := d.FeedsByLanguage()
+	if err != nil {
+		return nil, err
+	}
+	if feeds, ok := byLanguage[language]; ok && language != "" {
+		return feeds, nil
+	}
+	if feeds, ok := byLanguage["en"]; ok {
+		return feeds, nil
+	}
+	for _, language := range append(d.Languages(), "") {
+		if feeds, ok := byLanguage[language]; ok {
+			return feeds, nil
+		}
+	}
+	return nil, nil
+}
+
+// Function to return the languages published, sorted
+func (d GBFSDiscovery) Languages() []string {
+	if _, ok := d.Data["feeds"]; ok {
+		return nil
+	}
+	languages := make([]string, 0, len(d.Data))
+	for language := range d.Data {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Distance a vehicle may drift between scrapes, e.g. from GPS noise, and still
+// count as parked at the same spot
+const dwellRadiusMeters = 50
+
+// Quantiles of the current dwell times exported per provider
+var dwellQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Gauges for how long the vehicles in the latest scrape have been parked where they are
+var (
+	vehicleDwellQuantile = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_vehicle_dwell_seconds",
+			Help: "Quantiles of how long the provider's available vehicles have stayed within 50m of where they were first seen parked",
+		},
+		[]string{"location", "quantile"},
+	)
+	vehiclesDwellingLong = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_vehicles_dwelling_long",
+			Help: "Vehicles parked at the same spot for longer than --dwell-alert-after, likely abandoned or broken",
+		},
+		[]string{"location"},
+	)
+)
+
+// Histogram for the dwell times that ended, when the vehicle moved or left the feed
+var vehicleDwellCompleted = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "gbfs_vehicle_dwell_completed_seconds",
+		Help:    "How long vehicles stayed parked at one spot before moving or leaving the feed",
+		Buckets: []float64{600, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 3 * 86400, 7 * 86400, 14 * 86400},
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(vehicleDwellQuantile, vehiclesDwellingLong, vehicleDwellCompleted)
+}
+
+// Struct for where a vehicle was parked and since when
+type vehicleDwell struct {
+	lat, lon float64
+	since    time.Time
+}
+
+// Struct tracking how long each provider's vehicles stay parked between scrapes
+type dwellTracker struct {
+	// dwell above which a vehicle counts in gbfs_vehicles_dwelling_long
+	alertAfter time.Duration
+
+	mu sync.Mutex
+	// Parked vehicles of the previous scrape, by provider and vehicle ID
+	parked map[string]map[string]vehicleDwell
+}
+
+// Dwell tracker fed by ingestion, with the threshold set by --dwell-alert-after
+var dwells = &dwellTracker{alertAfter: 72 * time.Hour, parked: map[string]map[string]vehicleDwell{}}
+
+// Function to update the dwell of every vehicle in a scrape and export the
+// provider's dwell quantiles. A vehicle's dwell starts when it is first seen
+// at a spot and ends when it moves away or is missing from a scrape, e.g. rented.
+// Dwells already running at the provider's first scrape are counted from then.
+func (t *dwellTracker) observe(provider Provider, bikes []Bike, now time.Time) {
+	location := metricLocation(provider)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous := t.parked[provider.Location]
+	current := make(map[string]vehicleDwell, len(bikes))
+	for _, bike := range bikes {
+		if bike.BikeID == "" {
+			continue
+		}
+		dwell, ok := previous[bike.BikeID]
+		if ok && haversineMeters(dwell.lat, dwell.lon, bike.Lat, bike.Lon) > dwellRadiusMeters {
+			vehicleDwellCompleted.WithLabelValues(location).Observe(now.Sub(dwell.since).Seconds())
+			ok = false
+		}
+		if !ok {
+			dwell = vehicleDwell{lat: bike.Lat, lon: bike.Lon, since: now}
+		}
+		current[bike.BikeID] = dwell
+	}
+	for id, dwell := range previous {
+		if _, ok := current[id]; !ok {
+			vehicleDwellCompleted.WithLabelValues(location).Observe(now.Sub(dwell.since).Seconds())
+		}
+	}
+	t.parked[provider.Location] = current
+
+	// Feeds without stable vehicle IDs have no dwell to report
+	if len(current) == 0 {
+		retireDwellMetrics(provider)
+		return
+	}
+	durations := make([]float64, 0, len(current))
+	long := 0
+	for _, dwell := range current {
+		d := now.Sub(dwell.since)
+		durations = append(durations, d.Seconds())
+		if t.alertAfter > 0 && d > t.alertAfter {
+			long++
+		}
+	}
+	sort.Float64s(durations)
+	for _, q := range dwellQuantiles {
+		// Nearest-rank quantile of the sorted dwells
+		rank := int(math.Ceil(q*float64(len(durations)))) - 1
+		vehicleDwellQuantile.WithLabelValues(location, strconv.FormatFloat(q, 'g', -1, 64)).Set(durations[max(rank, 0)])
+	}
+	vehiclesDwellingLong.WithLabelValues(location).Set(float64(long))
+}
+
+// Function to forget a provider's parked vehicles
+func (t *dwellTracker) forget(location string) {
+	t.mu.Lock()
+	delete(t.parked, location)
+	t.mu.Unlock()
+}
+
+// Function to delete a provider's dwell series
+func retireDwellMetrics(provider Provider) {
+	vehicleDwellQuantile.DeletePartialMatch(prometheus.Labels{"location": metricLocation(provider)})
+	vehiclesDwellingLong.DeleteLabelValues(metricLocation(provider))
+}
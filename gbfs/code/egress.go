@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Struct for the rules deciding which provider URLs the exporter may fetch,
+// so URLs supplied through config or the API cannot turn it into an SSRF proxy
+type egressPolicy struct {
+	// Hosts are exact names or "*.example.com" suffix patterns
+	allowHosts []string
+	denyHosts  []string
+	allowCIDRs []*net.IPNet
+	denyCIDRs  []*net.IPNet
+	// allowPrivate permits loopback, private, link-local and other internal addresses
+	allowPrivate bool
+}
+
+// Policy applied to every provider fetch
+var egress = &egressPolicy{}
+
+// Address ranges that are never public, beyond what net.IP's helpers cover
+var internalCIDRs = mustParseCIDRs(
+	"0.0.0.0/8",     // "this network"
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"64:ff9b::/96",  // NAT64, can reach internal IPv4 addresses
+)
+
+// Function to parse CIDRs known to be valid
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// Function to parse a list of CIDRs
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Function to report whether a host matches an exact or "*.suffix" pattern
+func hostMatches(host, pattern string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// Function to check a URL's scheme and host before any connection is made
+func (p *egressPolicy) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("blocked URL %s: scheme %q is not allowed", rawURL, u.Scheme)
+	}
+	host := u.Hostname()
+	for _, pattern := range p.denyHosts {
+		if hostMatches(host, pattern) {
+			return fmt.Errorf("blocked URL %s: host %s is denied", rawURL, host)
+		}
+	}
+	if len(p.allowHosts) > 0 {
+		for _, pattern := range p.allowHosts {
+			if hostMatches(host, pattern) {
+				return nil
+			}
+		}
+		return fmt.Errorf("blocked URL %s: host %s is not in the allowlist", rawURL, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(ip)
+	}
+	return nil
+}
+
+// Function to check an address about to be dialed; runs after DNS resolution,
+// so hostnames resolving to internal addresses are caught too
+func (p *egressPolicy) checkIP(ip net.IP) error {
+	for _, ipNet := range p.denyCIDRs {
+		if ipNet.Contains(ip) {
+			return fmt.Errorf("blocked address %s: denied by %s", ip, ipNet)
+		}
+	}
+	for _, ipNet := range p.allowCIDRs {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	if p.allowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("blocked address %s: internal addresses are not allowed", ip)
+	}
+	for _, ipNet := range internalCIDRs {
+		if ipNet.Contains(ip) {
+			return fmt.Errorf("blocked address %s: internal addresses are not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// Function to build an HTTP client enforcing the policy on every dial and redirect,
+// pooling connections as configured in connPool
+func (p *egressPolicy) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     connPool.keepAlive,
+		FallbackDelay: dialing.fallbackDelay,
+		LocalAddr:     dialing.localAddr,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("blocked address %s: not an IP", host)
+			}
+			return p.checkIP(ip)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// The configured family or source address narrows tcp to tcp4 or tcp6
+		if network == "tcp" && dialing.network != "" {
+			network = dialing.network
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if connPool.dnsCacheTTL > 0 || dialing.ordersAddresses() {
+		transport.DialContext = newDNSCache(connPool.dnsCacheTTL).dialContext(transport.DialContext)
+	}
+	transport.MaxIdleConns = connPool.maxIdleConns
+	transport.MaxIdleConnsPerHost = connPool.maxIdleConnsPerHost
+	transport.MaxConnsPerHost = connPool.maxConnsPerHost
+	transport.IdleConnTimeout = connPool.idleConnTimeout
+	// A negative keep-alive disables connection reuse altogether
+	transport.DisableKeepAlives = connPool.keepAlive < 0
+	transport.ForceAttemptHTTP2 = connPool.http2
+	if !connPool.http2 {
+		// A non-nil empty map stops the transport from negotiating HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: redirects.check,
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Spacing in meters of the points sampled inside each district to estimate coverage
+const districtSampleMeters = 100
+
+// Most sample points per district; larger districts are sampled more coarsely
+const districtMaxSamples = 20000
+
+// Struct for a census district with its population and coverage sample points
+type District struct {
+	Name       string  `json:"name"`
+	Population float64 `json:"population"`
+
+	polygons [][][][2]float64
+	samples  [][2]float64
+}
+
+// Struct for the vehicles available in a district and how much of it they cover
+type DistrictAvailability struct {
+	Name                 string         `json:"name"`
+	Population           float64        `json:"population"`
+	AvailableBikes       int            `json:"available_bikes"`
+	ByProvider           map[string]int `json:"by_provider"`
+	BikesPer1000         float64        `json:"bikes_per_1000_residents"`
+	Coverage             float64        `json:"coverage_ratio"`
+	CoverageRadiusMeters float64        `json:"coverage_radius_meters"`
+}
+
+// Struct cross-referencing census districts with the latest ingested bikes and stations
+type districtIndex struct {
+	districts []District
+	radius    float64
+
+	mu           sync.RWMutex
+	availability []DistrictAvailability
+}
+
+// Loaded census districts; nil when no --districts file is configured
+var districts *districtIndex
+
+// Gauges for the equity metrics of each district
+var (
+	districtBikes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_district_available_bikes",
+			Help: "Number of bikes available inside a census district",
+		},
+		[]string{"district"},
+	)
+	districtBikesPerCapita = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_district_bikes_per_1000_residents",
+			Help: "Bikes available inside a census district per 1000 residents",
+		},
+		[]string{"district"},
+	)
+	districtCoverage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_district_coverage_ratio",
+			Help: "Share of a census district's area within the coverage radius of an available bike",
+		},
+		[]string{"district"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(districtBikes, districtBikesPerCapita, districtCoverage)
+}
+
+// Function to load districts from a GeoJSON FeatureCollection of Polygon or
+// MultiPolygon features carrying name and population properties
+func loadDistricts(path, nameProperty, populationProperty string, radius float64) (*districtIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var collection struct {
+		Features []struct {
+			Properties map[string]any `json:"properties"`
+			Geometry   struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	index := &districtIndex{radius: radius}
+	for i, feature := range collection.Features {
+		district := District{Name: fmt.Sprint(feature.Properties[nameProperty])}
+		if feature.Properties[nameProperty] == nil {
+			district.Name = "district-" + strconv.Itoa(i+1)
+		}
+		district.Population, err = propertyNumber(feature.Properties[populationProperty])
+		if err != nil {
+			return nil, fmt.Errorf("%s: district %q: %s property: %w", path, district.Name, populationProperty, err)
+		}
+
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+				return nil, fmt.Errorf("%s: district %q: %w", path, district.Name, err)
+			}
+			district.polygons = [][][][2]float64{polygon}
+		case "MultiPolygon":
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &district.polygons); err != nil {
+				return nil, fmt.Errorf("%s: district %q: %w", path, district.Name, err)
+			}
+		default:
+			// Points and lines have no area to cover
+			continue
+		}
+		district.samples = district.samplePoints()
+		index.districts = append(index.districts, district)
+	}
+	if len(index.districts) == 0 {
+		return nil, fmt.Errorf("no polygon districts in %s", path)
+	}
+	return index, nil
+}
+
+// Function to read a GeoJSON property as a number, accepting numeric strings
+func propertyNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case nil:
+		return 0, fmt.Errorf("missing")
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+// Function to report whether a [lon, lat] position lies inside a polygon, whose
+// first ring is the outline and further rings are holes
+func polygonContains(polygon [][][2]float64, lon, lat float64) bool {
+	for i, ring := range polygon {
+		if ringContains(ring, lon, lat) != (i == 0) {
+			return false
+		}
+	}
+	return len(polygon) > 0
+}
+
+// Function to test a position against a ring with the even-odd rule
+func ringContains(ring [][2]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Function to report whether a position lies inside the district
+func (d District) contains(lat, lon float64) bool {
+	for _, polygon := range d.polygons {
+		if polygonContains(polygon, lon, lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to lay a regular grid of [lat, lon] points over the district
+func (d District) samplePoints() [][2]float64 {
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	for _, polygon := range d.polygons {
+		for _, ring := range polygon {
+			for _, p := range ring {
+				minLon, maxLon = min(minLon, p[0]), max(maxLon, p[0])
+				minLat, maxLat = min(minLat, p[1]), max(maxLat, p[1])
+			}
+		}
+	}
+	if math.IsInf(minLat, 1) {
+		return nil
+	}
+
+	// Grow the spacing until the bounding box holds at most districtMaxSamples points
+	cosLat := max(math.Cos((minLat+maxLat)/2*math.Pi/180), 0.01)
+	step := float64(districtSampleMeters)
+	for {
+		rows := (maxLat - minLat) * 111320 / step
+		cols := (maxLon - minLon) * 111320 * cosLat / step
+		if rows*cols <= districtMaxSamples {
+			break
+		}
+		step *= 2
+	}
+
+	dLat := step / 111320
+	dLon := step / (111320 * cosLat)
+	var samples [][2]float64
+	for lat := minLat + dLat/2; lat < maxLat; lat += dLat {
+		for lon := minLon + dLon/2; lon < maxLon; lon += dLon {
+			if d.contains(lat, lon) {
+				samples = append(samples, [2]float64{lat, lon})
+			}
+		}
+	}
+	return samples
+}
+
+// Function to recompute per-district availability and coverage from the provider states and update the gauges
+func (d *districtIndex) update(states []ProviderState) {
+	grid := newGeoGrid(d.radius)
+	var points []geoPoint
+	for _, state := range states {
+		for _, bike := range state.Bikes {
+			points = append(points, geoPoint{Provider: state.Provider.Location, Lat: bike.Lat, Lon: bike.Lon, Bikes: 1})
+		}
+		for _, station := range state.Stations {
+			// Empty stations offer nothing to ride
+			if station.BikesAvailable > 0 {
+				points = append(points, geoPoint{Provider: state.Provider.Location, Lat: station.Lat, Lon: station.Lon, Bikes: station.BikesAvailable})
+			}
+		}
+	}
+	for _, p := range points {
+		grid.add(p)
+	}
+
+	availability := make([]DistrictAvailability, 0, len(d.districts))
+	for _, district := range d.districts {
+		a := DistrictAvailability{
+			Name:                 district.Name,
+			Population:           district.Population,
+			ByProvider:           map[string]int{},
+			CoverageRadiusMeters: d.radius,
+		}
+		for _, p := range points {
+			if district.contains(p.Lat, p.Lon) {
+				a.AvailableBikes += p.Bikes
+				a.ByProvider[p.Provider] += p.Bikes
+			}
+		}
+		if district.Population > 0 {
+			a.BikesPer1000 = float64(a.AvailableBikes) / district.Population * 1000
+		}
+		covered := 0
+		for _, sample := range district.samples {
+			if len(grid.within(sample[0], sample[1], d.radius)) > 0 {
+				covered++
+			}
+		}
+		if len(district.samples) > 0 {
+			a.Coverage = float64(covered) / float64(len(district.samples))
+		}
+
+		districtBikes.WithLabelValues(district.Name).Set(float64(a.AvailableBikes))
+		districtBikesPerCapita.WithLabelValues(district.Name).Set(a.BikesPer1000)
+		districtCoverage.WithLabelValues(district.Name).Set(a.Coverage)
+		availability = append(availability, a)
+	}
+
+	d.mu.Lock()
+	d.availability = availability
+	d.mu.Unlock()
+}
+
+// Handler for GET /api/v1/districts, ordered from the least to the best served per capita
+func districtsHandler(c *gin.Context) {
+	if districts == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no districts configured"})
+		return
+	}
+	districts.mu.RLock()
+	result := append([]DistrictAvailability{}, districts.availability...)
+	districts.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].BikesPer1000 < result[j].BikesPer1000 })
+	c.JSON(http.StatusOK, gin.H{"coverage_radius_meters": districts.radius, "districts": result})
+}
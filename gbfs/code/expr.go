@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Interface for a compiled node of a derived metric expression
+type exprNode interface {
+	eval(vars map[string]float64) (float64, error)
+}
+
+// Node types of the expression tree
+type (
+	exprNumber   float64
+	exprVariable string
+	exprUnary    struct {
+		op      string
+		operand exprNode
+	}
+	exprBinary struct {
+		op          string
+		left, right exprNode
+	}
+	exprConditional struct {
+		cond, then, otherwise exprNode
+	}
+	exprCall struct {
+		name string
+		args []exprNode
+	}
+)
+
+// Functions expressions may call, with their number of arguments; min and max take one or more
+var exprFunctions = map[string]int{"min": -1, "max": -1, "abs": 1, "round": 1, "floor": 1, "ceil": 1}
+
+// Function to compile an expression over the given variables. It supports numbers,
+// variables, + - * / %, comparisons and && || ! yielding 1 or 0, cond ? a : b,
+// parentheses and the functions in exprFunctions.
+func compileExpr(source string, variables map[string]bool) (exprNode, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, variables: variables}
+	node, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// Function to split an expression into numbers, identifiers and operators
+func tokenizeExpr(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.' || source[i] == 'e' ||
+				((source[i] == '-' || source[i] == '+') && (source[i-1] == 'e'))) {
+				i++
+			}
+			tokens = append(tokens, source[start:i])
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, source[start:i])
+		default:
+			if i+1 < len(source) {
+				if op := source[i : i+2]; op == "<=" || op == ">=" || op == "==" || op == "!=" || op == "&&" || op == "||" {
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%<>!?:(),", c) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// Struct for a recursive descent parser over the tokens of one expression
+type exprParser struct {
+	tokens    []string
+	pos       int
+	variables map[string]bool
+}
+
+// Function to return the next token without consuming it, empty at the end
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// Function to consume the next token if it is one of ops
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	next := p.peek()
+	for _, op := range ops {
+		if next == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// Function to parse cond ? a : b, the lowest precedence
+func (p *exprParser) conditional() (exprNode, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept(":"); !ok {
+		return nil, fmt.Errorf("expected : after ?")
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return exprConditional{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// Binary operators from the lowest precedence level to the highest
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// Function to parse the left-associative binary operators of a precedence level and above
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(exprPrecedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+}
+
+// Function to parse negation and logical not
+func (p *exprParser) unary() (exprNode, error) {
+	if op, ok := p.accept("-", "!"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+// Function to parse a number, variable, function call or parenthesized expression
+func (p *exprParser) primary() (exprNode, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	switch {
+	case token == "(":
+		node, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return node, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return exprNumber(value), nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if _, ok := p.accept("("); ok {
+			return p.call(token)
+		}
+		if !p.variables[token] {
+			return nil, fmt.Errorf("unknown variable %q", token)
+		}
+		return exprVariable(token), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+// Function to parse the arguments of a function call after its (
+func (p *exprParser) call(name string) (exprNode, error) {
+	arity, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	var args []exprNode
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if _, ok := p.accept(","); !ok {
+				return nil, fmt.Errorf("expected , or ) in %s()", name)
+			}
+		}
+	}
+	if (arity < 0 && len(args) == 0) || (arity >= 0 && len(args) != arity) {
+		return nil, fmt.Errorf("wrong number of arguments to %s()", name)
+	}
+	return exprCall{name: name, args: args}, nil
+}
+
+func (n exprNumber) eval(map[string]float64) (float64, error) {
+	return float64(n), nil
+}
+
+func (n exprVariable) eval(vars map[string]float64) (float64, error) {
+	return vars[string(n)], nil
+}
+
+func (n exprUnary) eval(vars map[string]float64) (float64, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return exprBool(value == 0), nil
+	}
+	return -value, nil
+}
+
+func (n exprBinary) eval(vars map[string]float64) (float64, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	// Logical operators short-circuit, so a guard such as x > 0 && y / x > 1 is safe
+	switch {
+	case n.op == "&&" && left == 0:
+		return 0, nil
+	case n.op == "||" && left != 0:
+		return 1, nil
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if n.op == "%" {
+			return math.Mod(left, right), nil
+		}
+		return left / right, nil
+	case "==":
+		return exprBool(left == right), nil
+	case "!=":
+		return exprBool(left != right), nil
+	case "<":
+		return exprBool(left < right), nil
+	case "<=":
+		return exprBool(left <= right), nil
+	case ">":
+		return exprBool(left > right), nil
+	case ">=":
+		return exprBool(left >= right), nil
+	default:
+		// && and || whose left side did not decide the result
+		return exprBool(right != 0), nil
+	}
+}
+
+func (n exprConditional) eval(vars map[string]float64) (float64, error) {
+	cond, err := n.cond.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+func (n exprCall) eval(vars map[string]float64) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	switch n.name {
+	case "min":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	case "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "round":
+		return math.Round(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	default:
+		return math.Ceil(args[0]), nil
+	}
+}
+
+// Function to turn a condition into 1 or 0
+func exprBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
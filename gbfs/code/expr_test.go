@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileExprEval(t *testing.T) {
+	vars := map[string]float64{"bikes": 6, "docks": 4, "zero": 0}
+	known := map[string]bool{"bikes": true, "docks": true, "zero": true}
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		// Precedence and associativity
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"24 / 4 / 2", 3},
+		{"7 % 4 * 2", 6},
+		{"-2 * 3", -6},
+		{"--2", 2},
+		{"1 + 2 < 4", 1},
+		{"1 < 2 == 1", 1},
+		{"1 || 0 && 0", 1},
+		{"!0 && 1", 1},
+		{"!1 || 0", 0},
+		{"0 ? 1 : 0 ? 2 : 3", 3},
+		{"1 ? 0 ? 4 : 5 : 6", 5},
+		{"bikes > docks ? bikes - docks : 0", 2},
+		// Numbers and functions
+		{".5 + 1e-1", 0.6},
+		{"2.5e1", 25},
+		{"min(bikes, docks, 5)", 4},
+		{"max(bikes)", 6},
+		{"abs(docks - bikes)", 2},
+		{"round(2.5) + floor(1.9) + ceil(1.1)", 6},
+		{"bikes / (bikes + docks)", 0.6},
+		// Short-circuiting guards keep divisions by zero from being evaluated
+		{"zero > 0 && bikes / zero > 1", 0},
+		{"zero == 0 || bikes / zero > 1", 1},
+		{"zero ? bikes / zero : -1", -1},
+	}
+	for _, tt := range tests {
+		node, err := compileExpr(tt.source, known)
+		if err != nil {
+			t.Errorf("compileExpr(%q): %v", tt.source, err)
+			continue
+		}
+		got, err := node.eval(vars)
+		if err != nil {
+			t.Errorf("eval(%q): %v", tt.source, err)
+			continue
+		}
+		if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("eval(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	known := map[string]bool{"bikes": true}
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"", "unexpected end of expression"},
+		{"   ", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "missing )"},
+		{"1 + 2)", `unexpected ")"`},
+		{"1 2", `unexpected "2"`},
+		{"* 2", `unexpected "*"`},
+		{"1 ? 2", "expected : after ?"},
+		{"docks + 1", `unknown variable "docks"`},
+		{"sqrt(4)", `unknown function "sqrt"`},
+		{"abs()", "wrong number of arguments to abs()"},
+		{"abs(1, 2)", "wrong number of arguments to abs()"},
+		{"min()", "wrong number of arguments to min()"},
+		{"min(1 2)", "expected , or ) in min()"},
+		{"1.2.3", `invalid number "1.2.3"`},
+		{"bikes # 2", `unexpected character '#'`},
+		{"bikes = 2", `unexpected character '='`},
+	}
+	for _, tt := range tests {
+		_, err := compileExpr(tt.source, known)
+		if err == nil {
+			t.Errorf("compileExpr(%q) succeeded, want error %q", tt.source, tt.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("compileExpr(%q) error = %q, want %q", tt.source, err, tt.err)
+		}
+	}
+}
+
+func TestExprDivisionByZero(t *testing.T) {
+	for _, source := range []string{"1 / 0", "1 % 0", "bikes / (bikes - bikes)"} {
+		node, err := compileExpr(source, map[string]bool{"bikes": true})
+		if err != nil {
+			t.Fatalf("compileExpr(%q): %v", source, err)
+		}
+		if _, err := node.eval(map[string]float64{"bikes": 3}); err == nil || err.Error() != "division by zero" {
+			t.Errorf("eval(%q) error = %v, want division by zero", source, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interface for places a failed response can be kept, returning a reference to it
+type failedBodyStore interface {
+	put(name string, body []byte) (string, error)
+}
+
+// Struct for failed responses kept as files under a directory
+type fileFailedBodies struct {
+	dir string
+}
+
+func (f fileFailedBodies) put(name string, body []byte) (string, error) {
+	path := filepath.Join(f.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Struct for failed responses kept as objects under an S3 prefix
+type s3FailedBodies struct {
+	archive *s3Archive
+}
+
+func (s s3FailedBodies) put(name string, body []byte) (string, error) {
+	key := s.archive.prefix + name
+	if err := s.archive.put(key, body); err != nil {
+		return "", err
+	}
+	return "s3://" + s.archive.bucket + "/" + key, nil
+}
+
+// Struct keeping the raw responses of feeds that failed to parse, so operator
+// bugs can be reported with evidence
+type failedBodyRecorder struct {
+	store    failedBodyStore
+	maxBytes int
+
+	mu sync.Mutex
+	// last kept digest and reference per URL, so a feed that stays broken is kept once
+	last map[string][2]string
+}
+
+// Recorder of failed responses, set with --keep-failed-responses
+var failedBodies *failedBodyRecorder
+
+// Function to open a recorder for a local directory or s3://bucket/prefix URL
+func newFailedBodyRecorder(location string, maxBytes int) (*failedBodyRecorder, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("--keep-failed-responses-max-bytes must be positive")
+	}
+	var store failedBodyStore
+	if strings.HasPrefix(location, "s3://") {
+		archive, err := newS3Archive(location)
+		if err != nil {
+			return nil, err
+		}
+		store = s3FailedBodies{archive: archive}
+	} else {
+		if err := os.MkdirAll(location, 0o755); err != nil {
+			return nil, err
+		}
+		store = fileFailedBodies{dir: location}
+	}
+	return &failedBodyRecorder{store: store, maxBytes: maxBytes, last: map[string][2]string{}}, nil
+}
+
+// Function to keep the body of a response that failed to parse, capped and with
+// secrets redacted, returning err annotated with where the body was kept
+func (r *failedBodyRecorder) keep(provider Provider, url string, body []byte, err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	kept := []byte(redactText(string(body)))
+	if len(kept) > r.maxBytes {
+		kept = append(kept[:r.maxBytes:r.maxBytes], fmt.Sprintf("\n[truncated %d of %d bytes]\n", len(kept)-r.maxBytes, len(kept))...)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(kept))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ref := ""
+	if last, ok := r.last[url]; ok && last[0] == digest {
+		ref = last[1]
+	} else {
+		// Files are grouped per provider; the redacted URL keeps tokens out of the name
+		location := strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == '.' {
+				return '_'
+			}
+			return r
+		}, provider.Location)
+		if location == "" {
+			location = "unnamed"
+		}
+		name := location + "/" + time.Now().UTC().Format(recordCycleLayout) + "-" + recordFileName(redactURL(url))
+		stored, putErr := r.store.put(name, kept)
+		if putErr != nil {
+			log.Printf("Error keeping failed response of %s: %v", redactURL(url), putErr)
+			return err
+		}
+		ref = stored
+		r.last[url] = [2]string{digest, ref}
+	}
+	return fmt.Errorf("%w (response kept at %s)", err, ref)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How long a provider stays on a fallback discovery URL before the primary is tried again
+const failoverPrimaryRetry = 10 * time.Minute
+
+// Metrics for providers whose discovery URL failed over to a fallback
+var (
+	discoveryFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_discovery_failovers_total",
+			Help: "Number of times a provider's scrapes switched to another of its configured discovery URLs",
+		},
+		[]string{"location"},
+	)
+	discoveryURLInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_discovery_url_info",
+			Help: "Discovery URL a provider with fallback_urls is currently scraped from, 1 for the active URL",
+		},
+		[]string{"location", "url", "role"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(discoveryFailovers, discoveryURLInfo)
+}
+
+// Struct for the discovery URL a provider is scraped from
+type activeDiscovery struct {
+	url string
+	// when the primary URL was last tried while on a fallback
+	primaryTried time.Time
+}
+
+// Struct switching providers between their primary and fallback discovery URLs,
+// e.g. while an operator migrates domains
+type failoverTracker struct {
+	mu        sync.Mutex
+	providers map[string]*activeDiscovery
+}
+
+// Discovery failover of every provider with fallback_urls
+var discoveryFailover = &failoverTracker{providers: map[string]*activeDiscovery{}}
+
+// Function to return the discovery URL a provider is currently scraped from
+func (t *failoverTracker) activeURL(provider Provider) string {
+	if len(provider.FallbackURLs) == 0 {
+		return provider.URL
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if active, ok := t.providers[provider.Location]; ok && containsString(provider.discoveryURLs(), active.url) {
+		return active.url
+	}
+	return provider.URL
+}
+
+// Function to return the order in which to try a provider's discovery URLs: the
+// active one first, unless it is a fallback and the primary is due another try
+func (t *failoverTracker) candidates(provider Provider, now time.Time) []string {
+	active := t.activeURL(provider)
+	order := []string{active}
+	if active != provider.URL {
+		t.mu.Lock()
+		state := t.providers[provider.Location]
+		if now.Sub(state.primaryTried) >= failoverPrimaryRetry {
+			state.primaryTried = now
+			order = []string{provider.URL, active}
+		}
+		t.mu.Unlock()
+	}
+	for _, url := range provider.discoveryURLs() {
+		if !containsString(order, url) {
+			order = append(order, url)
+		}
+	}
+	return order
+}
+
+// Function to fetch a provider's discovery feed from the first of its discovery URLs
+// that responds, switching the active URL when another one had to be used.
+// Throttling and exhausted budgets apply to every URL, so they are not failed over.
+func (t *failoverTracker) fetch(provider Provider, trace *ScrapeTrace) ([]byte, error) {
+	var firstErr error
+	for _, url := range t.candidates(provider, time.Now()) {
+		body, err := fetchRaw(provider, url, trace)
+		if err == nil {
+			// A dry run does not switch the URL later scrapes use
+			if trace == nil {
+				t.activate(provider, url)
+			}
+			return body, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if errors.Is(err, errThrottled) || errors.Is(err, errBudgetExhausted) {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// Function to make url the provider's active discovery URL
+func (t *failoverTracker) activate(provider Provider, url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous := provider.URL
+	if active, ok := t.providers[provider.Location]; ok {
+		if active.url == url {
+			return
+		}
+		previous = active.url
+	}
+	t.providers[provider.Location] = &activeDiscovery{url: url, primaryTried: time.Now()}
+	if url != previous {
+		log.Printf("Warning: %s failed over from %s to %s", provider.Location, redactURL(previous), redactURL(url))
+		discoveryFailovers.WithLabelValues(metricLocation(provider)).Inc()
+	}
+	discoveryURLInfo.DeletePartialMatch(prometheus.Labels{"location": metricLocation(provider)})
+	role := "fallback"
+	if url == provider.URL {
+		role = "primary"
+	}
+	discoveryURLInfo.WithLabelValues(metricLocation(provider), redactURL(url), role).Set(1)
+}
+
+// Function to forget a provider's active discovery URL
+func (t *failoverTracker) forget(provider Provider) {
+	t.mu.Lock()
+	delete(t.providers, provider.Location)
+	t.mu.Unlock()
+	discoveryURLInfo.DeletePartialMatch(prometheus.Labels{"location": metricLocation(provider)})
+}
+
+// Function to list the primary discovery URL followed by the fallbacks
+func (p Provider) discoveryURLs() []string {
+	return append([]string{p.URL}, p.FallbackURLs...)
+}
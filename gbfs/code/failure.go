@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// What happens to a provider's metrics when its scrape fails
+const (
+	// Keep exporting the last successful value
+	failureKeep = "keep"
+	// Export NaN, so queries see a gap while the series stays present
+	failureNaN = "nan"
+	// Export zero availability
+	failureZero = "zero"
+	// Remove the series until the next successful scrape
+	failureDelete = "delete"
+)
+
+// Failure policy chosen with serve --on-failure
+var failurePolicy = failureKeep
+
+// Function to check a --on-failure value
+func validFailurePolicy(policy string) error {
+	switch policy {
+	case failureKeep, failureNaN, failureZero, failureDelete:
+		return nil
+	}
+	return fmt.Errorf("invalid --on-failure %q, expected keep, nan, zero or delete", policy)
+}
+
+// Function to apply the failure policy to the metrics of a provider whose scrape failed
+func applyFailurePolicy(provider Provider) {
+	switch failurePolicy {
+	case failureNaN:
+		for _, update := range providerMetricUpdates(provider, 0) {
+			update.Value = math.NaN()
+			update.apply()
+		}
+	case failureZero:
+		for _, update := range providerMetricUpdates(provider, 0) {
+			update.apply()
+		}
+	case failureDelete:
+		for _, update := range providerMetricUpdates(provider, 0) {
+			update.gauge.Delete(update.Labels)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metrics served by /federate unless --federate-metric is given: totals, per-provider
+// availability and health, without per-station or per-vehicle-type series
+var defaultFederateMetrics = []string{
+	totalBikesMetric,
+	availableBikesMetric,
+	"gbfs_rollup_.*",
+	"gbfs_provider_health_score",
+	"gbfs_last_success_timestamp_seconds",
+	"gbfs_scrape_failures_total",
+	"gbfs_system_open",
+	"gbfs_estimated_fleet_size",
+	"gbfs_version_info",
+	providerMetadataMetric,
+}
+
+// Struct for the subset of series regional exporters expose to a central Prometheus
+type federationConfig struct {
+	metrics []*regexp.Regexp
+	// labels added to every federated series, e.g. region=eu-west
+	labels map[string]string
+}
+
+// Federation subset, set with --federate-metric and --federate-label
+var federation federationConfig
+
+// Function to compile the metric name patterns, anchored as in PromQL, and parse the
+// name=value labels
+func (f *federationConfig) configure(patterns, labels []string) error {
+	f.metrics = nil
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("--federate-metric %q: %w", pattern, err)
+		}
+		f.metrics = append(f.metrics, re)
+	}
+	f.labels = map[string]string{}
+	for _, label := range labels {
+		name, value, ok := strings.Cut(label, "=")
+		if !ok || !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("--federate-label %q must be name=value with a valid label name", label)
+		}
+		f.labels[name] = value
+	}
+	return nil
+}
+
+// Function to report whether a metric family is federated
+func (f federationConfig) includes(name string) bool {
+	for _, re := range f.metrics {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to wrap a gatherer so only the federated families remain, carrying the
+// federation labels; labels a series already has are kept
+func federateGatherer(gatherer prometheus.Gatherer, config federationConfig) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+
+		var federated []*dto.MetricFamily
+		for _, family := range families {
+			if !config.includes(family.GetName()) {
+				continue
+			}
+			for _, metric := range family.Metric {
+				for name, value := range config.labels {
+					if metricLabel(metric, name) != "" {
+						continue
+					}
+					name, value := name, value
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+				sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+			}
+			federated = append(federated, family)
+		}
+		return federated, nil
+	})
+}
+
+// Handler for GET /federate, the --federate-metric subset of /metrics for a central
+// Prometheus federating many exporters; ?location= and ?deployment= narrow it as on /metrics
+func federateHandler(c *gin.Context) {
+	gatherer := federateGatherer(requestGatherer(c), federation)
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
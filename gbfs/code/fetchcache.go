@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// Struct for a short-lived cache of feed responses, shared with identical
+// concurrent fetches through singleflight
+type responseCache struct {
+	ttl   time.Duration
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+// Struct for a cached feed body
+type cachedResponse struct {
+	body      []byte
+	fetchedAt time.Time
+}
+
+// Response cache for upstream feeds; the TTL is set with --response-cache-ttl
+var fetchCache = &responseCache{ttl: 5 * time.Second, entries: map[string]cachedResponse{}}
+
+// Counter for fetches answered without a request of their own
+var fetchDeduplicated = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_fetch_deduplicated_total",
+		Help: "Number of feed fetches served by an identical in-flight request or the response cache",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(fetchDeduplicated)
+}
+
+// Function to build the cache key of a fetch. Providers sharing a URL only share
+// responses when they send the same headers and verify them the same way.
+func fetchCacheKey(provider Provider, url string) string {
+	return fmt.Sprintf("%s\x00%v\x00%+v", url, provider.Headers, provider.Verify)
+}
+
+// Function to fetch a URL through the cache: a fresh cached body is returned as
+// is, and concurrent fetches of the same key wait for a single upstream request
+func (c *responseCache) fetch(provider Provider, url string, fetch func() ([]byte, error)) ([]byte, error) {
+	key := fetchCacheKey(provider, url)
+	if body, ok := c.get(key); ok {
+		fetchDeduplicated.WithLabelValues("cache").Inc()
+		return body, nil
+	}
+
+	body, err, shared := c.group.Do(key, func() (any, error) {
+		body, err := fetch()
+		if err == nil {
+			c.put(key, body)
+		}
+		return body, err
+	})
+	if shared {
+		fetchDeduplicated.WithLabelValues("inflight").Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body.([]byte), nil
+}
+
+// Function to return a cached body younger than the TTL
+func (c *responseCache) get(key string) ([]byte, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.fetchedAt) >= c.ttl {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+// Function to cache a body, dropping expired entries on the way
+func (c *responseCache) put(key string, body []byte) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResponse{body: body, fetchedAt: now}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fields identifying a vehicle or station, hashed in fixtures. Type and plan IDs stay
+// readable, since hints such as "ebike_v2" are among the quirks worth testing.
+var fixtureIDFields = map[string]bool{
+	"bike_id":    true,
+	"vehicle_id": true,
+	"station_id": true,
+}
+
+// Metres per degree of latitude
+const metresPerDegree = 111_320.0
+
+// Struct turning recorded feeds into anonymized golden fixtures: IDs are hashed,
+// coordinates jittered and secrets in URLs redacted, deterministically for a salt so
+// joins between feeds and positions across cycles stay consistent
+type fixtureAnonymizer struct {
+	salt   []byte
+	jitter float64
+}
+
+// Function to return an HMAC of value under the salt, as hex truncated to n characters
+func (a fixtureAnonymizer) hash(value string, n int) string {
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:n]
+}
+
+// Function to anonymize one recorded body, returning the feed URLs it rewrote
+func (a fixtureAnonymizer) anonymize(body []byte) ([]byte, map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept as written, so quirks such as 1 for true survive
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+	urls := map[string]string{}
+	doc = a.walk(doc, urls)
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(out, '\n'), urls, nil
+}
+
+// Function to anonymize a decoded JSON value in place
+func (a fixtureAnonymizer) walk(value any, urls map[string]string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		a.jitterPosition(value)
+		for key, field := range value {
+			if id, ok := field.(string); ok && fixtureIDFields[key] {
+				value[key] = a.hash(id, 12)
+				continue
+			}
+			value[key] = a.walk(field, urls)
+		}
+		return value
+	case []any:
+		for i, item := range value {
+			value[i] = a.walk(item, urls)
+		}
+		return value
+	case string:
+		if redactedURL := redactURL(value); redactedURL != value {
+			urls[value] = redactedURL
+			return redactedURL
+		}
+	}
+	return value
+}
+
+// Function to move an object's lat/lon by up to the jitter radius. The offset is
+// derived from the original position, so a station keeps its jittered position
+// in every feed and cycle.
+func (a fixtureAnonymizer) jitterPosition(object map[string]any) {
+	lat, latOK := object["lat"].(json.Number)
+	lon, lonOK := object["lon"].(json.Number)
+	if !latOK || !lonOK || a.jitter <= 0 {
+		return
+	}
+	latValue, err1 := lat.Float64()
+	lonValue, err2 := lon.Float64()
+	if err1 != nil || err2 != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(lat.String() + "," + lon.String()))
+	sum := mac.Sum(nil)
+	distance := a.jitter * math.Sqrt(float64(binary.BigEndian.Uint32(sum[0:4]))/math.MaxUint32)
+	bearing := 2 * math.Pi * float64(binary.BigEndian.Uint32(sum[4:8])) / math.MaxUint32
+
+	latValue += distance * math.Cos(bearing) / metresPerDegree
+	lonValue += distance * math.Sin(bearing) / (metresPerDegree * math.Cos(latValue*math.Pi/180))
+	// Six decimals is about 10 cm, as precise as any feed publishes
+	object["lat"] = json.Number(strconv.FormatFloat(latValue, 'f', 6, 64))
+	object["lon"] = json.Number(strconv.FormatFloat(lonValue, 'f', 6, 64))
+}
+
+// Function to convert the cycles recorded with --record in from into fixtures in out,
+// laid out the same way so they can be replayed with serve --replay. Files whose URL
+// was redacted are renamed to match the redacted URL; bodies that are not JSON are
+// skipped, as they cannot be anonymized. Returns the cycles and files written.
+func writeFixtures(from, out string, anonymizer fixtureAnonymizer, maxCycles int) (int, int, error) {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	var cycles []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			cycles = append(cycles, entry.Name())
+		}
+	}
+	sort.Strings(cycles)
+	if maxCycles > 0 && len(cycles) > maxCycles {
+		cycles = cycles[len(cycles)-maxCycles:]
+	}
+
+	files := 0
+	reader := newRecordingReader(localArchive{dir: from})
+	for _, cycle := range cycles {
+		written, err := writeFixtureCycle(reader, from, cycle, filepath.Join(out, cycle), anonymizer)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cycle %s: %w", cycle, err)
+		}
+		files += written
+	}
+	return len(cycles), files, nil
+}
+
+// Function to anonymize the files of one recorded cycle; those recorded as diffs
+// are rebuilt and written in full
+func writeFixtureCycle(reader *recordingReader, from, cycle, out string, anonymizer fixtureAnonymizer) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(from, cycle))
+	if err != nil {
+		return 0, err
+	}
+	bodies := map[string][]byte{}
+	renamed := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), recordDiffSuffix)
+		body, err := reader.read(cycle, name)
+		if err != nil {
+			return 0, err
+		}
+		anonymized, urls, err := anonymizer.anonymize(body)
+		if err != nil {
+			log.Printf("Skipping %s: not JSON (%v)", name, err)
+			continue
+		}
+		bodies[name] = anonymized
+		for original, redactedURL := range urls {
+			renamed[recordFileName(original)] = recordFileName(redactedURL)
+			renamed[legacyRecordFileName(original)] = recordFileName(redactedURL)
+		}
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return 0, err
+	}
+	for name, body := range bodies {
+		if target, ok := renamed[name]; ok {
+			name = target
+		}
+		if err := os.WriteFile(filepath.Join(out, name), body, 0o644); err != nil {
+			return 0, err
+		}
+	}
+	return len(bodies), nil
+}
+
+// Function to check a fixture salt, which must be set so hashes cannot be reversed
+// by hashing known IDs
+func validFixtureSalt(salt string) error {
+	if strings.TrimSpace(salt) == "" {
+		return fmt.Errorf("--salt (or $GBFS_FIXTURE_SALT) is required so hashed IDs cannot be recomputed")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Width of the buckets in which fleet maxima are kept
+const fleetBucket = time.Hour
+
+// Gauge for the estimated deployed fleet of each provider
+var estimatedFleetGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_estimated_fleet_size",
+		Help: "Most distinct vehicles seen in a single scrape over the fleet window, an estimate of the deployed fleet",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(estimatedFleetGauge)
+}
+
+// Struct tracking the hourly maximum of distinct vehicles per provider. Maxima of
+// single scrapes are used rather than IDs seen over time, as feeds that rotate
+// vehicle IDs would otherwise inflate the fleet with every trip.
+type fleetEstimator struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	maxima map[string]map[time.Time]int
+}
+
+// Fleet estimator fed by ingestion; the window is set with --fleet-window
+var fleet = &fleetEstimator{window: 7 * 24 * time.Hour, maxima: map[string]map[time.Time]int{}}
+
+// Function to count the distinct vehicles of a scrape: free-floating vehicles by
+// ID, plus those counted without decoding, plus bikes docked at stations
+func distinctVehicles(result ScrapeResult) int {
+	ids := make(map[string]bool, len(result.Bikes))
+	anonymous := 0
+	for _, bike := range result.Bikes {
+		if bike.BikeID == "" {
+			anonymous++
+			continue
+		}
+		ids[bike.BikeID] = true
+	}
+	total := len(ids) + anonymous + result.BikeCount
+	for _, station := range result.Stations {
+		total += station.BikesAvailable
+	}
+	return total
+}
+
+// Function to record a scrape and return the provider's estimated fleet
+func (f *fleetEstimator) observe(location string, result ScrapeResult, now time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	buckets, ok := f.maxima[location]
+	if !ok {
+		buckets = map[time.Time]int{}
+		f.maxima[location] = buckets
+	}
+	bucket := now.Truncate(fleetBucket)
+	buckets[bucket] = max(buckets[bucket], distinctVehicles(result))
+
+	estimate := 0
+	for at, count := range buckets {
+		if now.Sub(at) > f.window {
+			delete(buckets, at)
+			continue
+		}
+		estimate = max(estimate, count)
+	}
+	return estimate
+}
+
+// Function to return the provider's estimated fleet without recording a scrape
+func (f *fleetEstimator) estimate(location string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	estimate := 0
+	for _, count := range f.maxima[location] {
+		estimate = max(estimate, count)
+	}
+	return estimate
+}
+
+// Function to record a scrape and export the provider's estimated fleet
+func updateFleetMetric(provider Provider, result ScrapeResult, now time.Time) {
+	estimatedFleetGauge.WithLabelValues(metricLocation(provider)).Set(float64(fleet.observe(provider.Location, result, now)))
+}
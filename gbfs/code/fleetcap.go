@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Days over cap listed by the compliance API, per provider
+const fleetCapHistoryDays = 90
+
+// Gauges comparing each capped provider's deployed vehicles with its permit
+var (
+	fleetCapGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_fleet_cap",
+			Help: "Vehicles the provider is permitted to deploy, from fleet_cap in the config",
+		},
+		[]string{"location"},
+	)
+	fleetDeployedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_fleet_deployed_vehicles",
+			Help: "Distinct vehicles in the latest scrape of a provider with a fleet cap",
+		},
+		[]string{"location"},
+	)
+	fleetCapUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_fleet_cap_utilization_ratio",
+			Help: "Deployed vehicles divided by the fleet cap; above 1 the provider is over its permit",
+		},
+		[]string{"location"},
+	)
+	fleetOverCap = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_fleet_over_cap",
+			Help: "1 while the latest scrape shows more vehicles than the fleet cap, 0 otherwise",
+		},
+		[]string{"location"},
+	)
+)
+
+// Counter for the local days on which a provider exceeded its fleet cap at least once
+var fleetDaysOverCap = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_fleet_days_over_cap_total",
+		Help: "Days, in the system's time zone, on which a scrape showed more vehicles than the fleet cap",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(fleetCapGauge, fleetDeployedGauge, fleetCapUtilization, fleetOverCap, fleetDaysOverCap)
+}
+
+// Struct for a provider's compliance with its fleet cap in GET /api/v1/compliance/fleet
+type FleetCapStatus struct {
+	Provider    string     `json:"provider"`
+	Cap         int        `json:"fleet_cap"`
+	Deployed    int        `json:"deployed"`
+	Utilization float64    `json:"utilization"`
+	OverCap     bool       `json:"over_cap"`
+	OverSince   *time.Time `json:"over_since,omitempty"`
+	// Peak is the most vehicles seen in one scrape of the current local day
+	Peak int `json:"peak_today"`
+	// DaysOverCap lists the recent local days with at least one scrape over the cap
+	DaysOverCap []string  `json:"days_over_cap"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Struct for the events POSTed to --fleet-cap-webhook when a provider goes over
+// its cap and when it is back within it
+type fleetCapPayload struct {
+	Event  string         `json:"event"`
+	Status FleetCapStatus `json:"status"`
+}
+
+// Struct comparing every scrape of a capped provider with its fleet cap
+type fleetCapTracker struct {
+	notifiers []*webhookSink
+
+	mu       sync.Mutex
+	statuses map[string]*FleetCapStatus
+	// local day of each provider's peak
+	days map[string]string
+}
+
+// Fleet cap compliance fed by ingestion
+var fleetCaps = &fleetCapTracker{statuses: map[string]*FleetCapStatus{}, days: map[string]string{}}
+
+// Function to compare a scrape with the provider's fleet cap, exporting the compliance
+// metrics and notifying the webhooks when the provider crosses its cap
+func (t *fleetCapTracker) observe(provider Provider, result ScrapeResult, now time.Time) {
+	location := metricLocation(provider)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, known := t.statuses[provider.Location]
+	if provider.FleetCap <= 0 {
+		// The cap was removed from the config
+		if known {
+			delete(t.statuses, provider.Location)
+			retireFleetCapMetrics(provider)
+		}
+		return
+	}
+	if !known {
+		status = &FleetCapStatus{Provider: provider.Location, DaysOverCap: []string{}}
+		t.statuses[provider.Location] = status
+	}
+
+	day := now.In(providerLocation(provider)).Format("2006-01-02")
+	if t.days[provider.Location] != day {
+		t.days[provider.Location] = day
+		status.Peak = 0
+	}
+	deployed := distinctVehicles(result)
+	status.Cap, status.Deployed, status.UpdatedAt = provider.FleetCap, deployed, now
+	status.Utilization = float64(deployed) / float64(provider.FleetCap)
+	status.Peak = max(status.Peak, deployed)
+
+	over := deployed > provider.FleetCap
+	if over {
+		if n := len(status.DaysOverCap); n == 0 || status.DaysOverCap[n-1] != day {
+			status.DaysOverCap = append(status.DaysOverCap, day)
+			if len(status.DaysOverCap) > fleetCapHistoryDays {
+				status.DaysOverCap = status.DaysOverCap[1:]
+			}
+			fleetDaysOverCap.WithLabelValues(location).Inc()
+		}
+	}
+	event := ""
+	switch {
+	case over && !status.OverCap:
+		since := now
+		status.OverSince = &since
+		event = "fleet_cap_exceeded"
+		log.Printf("Warning: %s deploys %d vehicles, over its fleet cap of %d", provider.Location, deployed, provider.FleetCap)
+	case !over && status.OverCap:
+		status.OverSince = nil
+		event = "fleet_cap_resolved"
+		log.Printf("%s is back within its fleet cap of %d with %d vehicles", provider.Location, provider.FleetCap, deployed)
+	}
+	status.OverCap = over
+
+	fleetCapGauge.WithLabelValues(location).Set(float64(provider.FleetCap))
+	fleetDeployedGauge.WithLabelValues(location).Set(float64(deployed))
+	fleetCapUtilization.WithLabelValues(location).Set(status.Utilization)
+	if over {
+		fleetOverCap.WithLabelValues(location).Set(1)
+	} else {
+		fleetOverCap.WithLabelValues(location).Set(0)
+	}
+	if event != "" && len(t.notifiers) > 0 {
+		go t.notify(event, status.copy())
+	}
+}
+
+// Function to copy a status, so it can be used outside the tracker's lock
+func (s *FleetCapStatus) copy() FleetCapStatus {
+	c := *s
+	c.DaysOverCap = append([]string{}, s.DaysOverCap...)
+	return c
+}
+
+// Function to POST a fleet cap event to every fleet cap webhook
+func (t *fleetCapTracker) notify(event string, status FleetCapStatus) {
+	body, err := json.Marshal(fleetCapPayload{Event: event, Status: status})
+	if err != nil {
+		log.Printf("Error encoding fleet cap event: %v", err)
+		return
+	}
+	for _, notifier := range t.notifiers {
+		if err := notifier.send(body); err != nil {
+			log.Printf("Error notifying %s of fleet cap event: %v", notifier.Name(), err)
+		}
+	}
+}
+
+// Function to list the capped providers' compliance, optionally of one provider, by name
+func (t *fleetCapTracker) list(location string) []FleetCapStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := []FleetCapStatus{}
+	for _, status := range t.statuses {
+		if location == "" || status.Provider == location {
+			statuses = append(statuses, status.copy())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Provider < statuses[j].Provider })
+	return statuses
+}
+
+// Function to delete a provider's fleet cap series
+func retireFleetCapMetrics(provider Provider) {
+	for _, gauge := range []*prometheus.GaugeVec{fleetCapGauge, fleetDeployedGauge, fleetCapUtilization, fleetOverCap} {
+		gauge.DeleteLabelValues(metricLocation(provider))
+	}
+}
+
+// Handler for GET /api/v1/compliance/fleet, comparing each capped provider's deployed
+// vehicles with its permit; ?provider= limits it to one provider, and the deployment
+// to its own providers
+func fleetComplianceHandler(c *gin.Context) {
+	statuses := fleetCaps.list(c.Query("provider"))
+	if c.Query(deploymentLabel) != "" {
+		providers, err := getProviders()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		own := map[string]bool{}
+		for _, provider := range deploymentProviders(c, providers) {
+			own[provider.Location] = true
+		}
+		scoped := statuses[:0:0]
+		for _, status := range statuses {
+			if own[status.Provider] {
+				scoped = append(scoped, status)
+			}
+		}
+		statuses = scoped
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": statuses})
+}
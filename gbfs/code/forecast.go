@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Seasons averaged for each forecast point
+const forecastSeasons = 4
+
+// Longest forecast horizon accepted
+const maxForecastHorizon = 7 * 24 * time.Hour
+
+// Struct for one predicted availability
+type ForecastPoint struct {
+	At             time.Time `json:"at"`
+	AvailableBikes float64   `json:"available_bikes"`
+	// Seasons is how many past seasons contributed; 0 means the last observed value was carried forward
+	Seasons int `json:"seasons"`
+}
+
+// Function to pick the season of a history: weekly once a full week plus the horizon
+// is stored, since weekday and weekend patterns differ, else daily
+func forecastSeason(history []ProviderSnapshot, now time.Time, horizon time.Duration) (string, time.Duration) {
+	if len(history) > 0 && now.Sub(history[0].ScrapedAt) >= 7*24*time.Hour+horizon {
+		return "weekly", 7 * 24 * time.Hour
+	}
+	return "daily", 24 * time.Hour
+}
+
+// Function to predict availability at each step up to the horizon as the mean of
+// the same moment in the previous seasons, each the mean of the successful scrapes
+// within half a step of it
+func forecastAvailability(history []ProviderSnapshot, now time.Time, horizon, step, period time.Duration) []ForecastPoint {
+	last := math.NaN()
+	for _, snapshot := range history {
+		if snapshot.Error == "" && !snapshot.Closed {
+			last = float64(snapshot.AvailableBikes)
+		}
+	}
+
+	var points []ForecastPoint
+	for at := now.Add(step); !at.After(now.Add(horizon)); at = at.Add(step) {
+		point := ForecastPoint{At: at}
+		total := 0.0
+		for season := 1; season <= forecastSeasons; season++ {
+			centre := at.Add(-time.Duration(season) * period)
+			// Points past now in a short history have no season yet
+			if centre.After(now) {
+				continue
+			}
+			from, to := centre.Add(-step/2), centre.Add(step/2)
+			sum, n := 0, 0
+			for _, snapshot := range history {
+				if snapshot.Error != "" || snapshot.Closed || snapshot.ScrapedAt.Before(from) || !snapshot.ScrapedAt.Before(to) {
+					continue
+				}
+				sum += snapshot.AvailableBikes
+				n++
+			}
+			if n > 0 {
+				total += float64(sum) / float64(n)
+				point.Seasons++
+			}
+		}
+		switch {
+		case point.Seasons > 0:
+			point.AvailableBikes = math.Round(total/float64(point.Seasons)*10) / 10
+		case !math.IsNaN(last):
+			point.AvailableBikes = last
+		default:
+			continue
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// Handler for GET /api/v1/forecast?provider=&horizon=2h&step=15m, predicting a
+// provider's availability from the snapshot store with a seasonal moving average
+func forecastHandler(c *gin.Context) {
+	reader, ok := snapshotStore.(SnapshotReader)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot storage is not enabled"})
+		return
+	}
+	name := c.Query("provider")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider is required"})
+		return
+	}
+	horizon, err := parseSLAWindow(c.DefaultQuery("horizon", "2h"))
+	if err != nil || horizon > maxForecastHorizon {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "horizon must be a duration of at most 7d"})
+		return
+	}
+	step, err := time.ParseDuration(c.DefaultQuery("step", "15m"))
+	if err != nil || step < time.Minute || step > horizon {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "step must be a duration between 1m and the horizon"})
+		return
+	}
+
+	now := clock.Now().UTC()
+	lookback := forecastSeasons*7*24*time.Hour + step
+	snapshots, err := reader.QuerySnapshots(SnapshotQuery{Providers: []string{name}, From: now.Add(-lookback), To: now})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(snapshots) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no stored scrapes for provider " + name})
+		return
+	}
+
+	season, period := forecastSeason(snapshots, now, horizon)
+	c.JSON(http.StatusOK, gin.H{
+		"provider":     name,
+		"generated_at": now,
+		"horizon":      horizon.String(),
+		"step":         step.String(),
+		"season":       season,
+		"forecast":     forecastAvailability(snapshots, now, horizon, step, period),
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import "math"
+
+// Mean Earth radius in meters
+const earthRadiusMeters = 6371000
+
+// Function to compute the great-circle distance in meters between two points
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// Struct for a point carrying a bike count, as indexed by geoGrid
+type geoPoint struct {
+	Provider string
+	Lat, Lon float64
+	Bikes    int
+}
+
+// Struct bucketing points into cells of roughly cellMeters, so radius
+// queries only inspect neighbouring cells
+type geoGrid struct {
+	cellDeg float64
+	cells   map[[2]int][]geoPoint
+}
+
+// Function to create a grid sized for queries of the given radius
+func newGeoGrid(cellMeters float64) *geoGrid {
+	return &geoGrid{
+		cellDeg: cellMeters / 111320,
+		cells:   map[[2]int][]geoPoint{},
+	}
+}
+
+// Function to return the cell of a coordinate
+func (g *geoGrid) cell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / g.cellDeg)), int(math.Floor(lon / g.cellDeg))}
+}
+
+// Function to add a point to the grid
+func (g *geoGrid) add(p geoPoint) {
+	key := g.cell(p.Lat, p.Lon)
+	g.cells[key] = append(g.cells[key], p)
+}
+
+// Function to return every point within radius meters of lat/lon
+func (g *geoGrid) within(lat, lon, radius float64) []geoPoint {
+	// Longitude degrees shrink towards the poles, so widen the search there
+	span := int(math.Ceil(radius / (g.cellDeg * 111320)))
+	lonSpan := span
+	if c := math.Cos(lat * math.Pi / 180); c > 0.01 {
+		lonSpan = int(math.Ceil(float64(span) / c))
+	}
+
+	center := g.cell(lat, lon)
+	var found []geoPoint
+	for dy := -span; dy <= span; dy++ {
+		for dx := -lonSpan; dx <= lonSpan; dx++ {
+			for _, p := range g.cells[[2]int{center[0] + dy, center[1] + dx}] {
+				if haversineMeters(lat, lon, p.Lat, p.Lon) <= radius {
+					found = append(found, p)
+				}
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Function to build a Grafana panel running the given Prometheus queries
+func grafanaPanel(id int, title, kind string, x, y, w, h int, targets ...gin.H) gin.H {
+	return gin.H{
+		"id":         id,
+		"title":      title,
+		"type":       kind,
+		"datasource": gin.H{"type": "prometheus", "uid": "${datasource}"},
+		"gridPos":    gin.H{"x": x, "y": y, "w": w, "h": h},
+		"targets":    targets,
+	}
+}
+
+// Function to build a Prometheus query target for a panel
+func grafanaTarget(refID, expr, legend string) gin.H {
+	return gin.H{
+		"refId":        refID,
+		"expr":         expr,
+		"legendFormat": legend,
+		"datasource":   gin.H{"type": "prometheus", "uid": "${datasource}"},
+	}
+}
+
+// Function to generate an importable dashboard for the exporter's metrics,
+// with a location variable pre-populated from the configured providers
+func grafanaDashboard(providers []Provider) gin.H {
+	locations := make([]string, 0, len(providers))
+	options := []gin.H{{"text": "All", "value": "$__all", "selected": true}}
+	for _, provider := range providers {
+		locations = append(locations, provider.Location)
+		options = append(options, gin.H{"text": provider.Location, "value": provider.Location, "selected": false})
+	}
+
+	selected := availableBikesMetric + `{location=~"$location"}`
+	return gin.H{
+		"title":         "GBFS bike availability",
+		"uid":           "gbfs-exporter",
+		"schemaVersion": 39,
+		"editable":      true,
+		"time":          gin.H{"from": "now-7d", "to": "now"},
+		"refresh":       "1m",
+		"tags":          []string{"gbfs"},
+		"templating": gin.H{
+			"list": []gin.H{
+				{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				{
+					"name":       "location",
+					"label":      "Provider",
+					"type":       "custom",
+					"query":      strings.Join(locations, ","),
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+					"current":    gin.H{"text": "All", "value": "$__all"},
+					"options":    options,
+				},
+			},
+		},
+		"panels": []gin.H{
+			grafanaPanel(1, "Total available bikes", "stat", 0, 0, 6, 6,
+				grafanaTarget("A", totalBikesMetric, "total")),
+			grafanaPanel(2, "Current availability by provider", "bargauge", 6, 0, 18, 6,
+				grafanaTarget("A", "sort_desc("+selected+")", "{{location}}")),
+			grafanaPanel(3, "Available bikes over time", "timeseries", 0, 6, 24, 9,
+				grafanaTarget("A", selected, "{{location}}")),
+			grafanaPanel(4, "Share of total availability", "timeseries", 0, 15, 12, 8,
+				grafanaTarget("A", selected+" / ignoring(location, url) group_left "+totalBikesMetric, "{{location}}")),
+			grafanaPanel(5, "Daily average by provider", "timeseries", 12, 15, 12, 8,
+				grafanaTarget("A", "avg_over_time("+selected+"[1d])", "{{location}}")),
+		},
+	}
+}
+
+// Handler for GET /grafana/dashboard.json
+func grafanaDashboardHandler(c *gin.Context) {
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, grafanaDashboard(providers))
+}
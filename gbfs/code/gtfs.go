@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for a GTFS stop from stops.txt
+type TransitStop struct {
+	StopID   string  `json:"stop_id"`
+	StopName string  `json:"stop_name"`
+	Lat      float64 `json:"stop_lat"`
+	Lon      float64 `json:"stop_lon"`
+}
+
+// Struct for the bikes available near a transit stop
+type TransitStopAvailability struct {
+	TransitStop
+	RadiusMeters   float64        `json:"radius_meters"`
+	AvailableBikes int            `json:"available_bikes"`
+	ByProvider     map[string]int `json:"by_provider"`
+}
+
+// Struct cross-referencing transit stops with the latest ingested bikes and stations
+type transitStopIndex struct {
+	stops  []TransitStop
+	radius float64
+
+	mu           sync.RWMutex
+	availability []TransitStopAvailability
+}
+
+// Loaded transit stops; nil when no stops.txt is configured
+var transitStops *transitStopIndex
+
+// Gauge for bikes available within the configured radius of each transit stop
+var transitStopBikes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "transit_stop_available_bikes",
+		Help: "Number of bikes available within the configured radius of a GTFS transit stop",
+	},
+	[]string{"stop_id", "stop_name"},
+)
+
+func init() {
+	prometheus.MustRegister(transitStopBikes)
+}
+
+// Function to load stops from a GTFS stops.txt file
+func loadTransitStops(path string, radius float64) (*transitStopIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s header: %w", path, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, required := range []string{"stop_id", "stop_lat", "stop_lon"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%s is missing the %s column", path, required)
+		}
+	}
+
+	index := &transitStopIndex{radius: radius}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		// Only physical stops and stations carry usable coordinates
+		if lt := field("location_type"); lt != "" && lt != "0" && lt != "1" {
+			continue
+		}
+		lat, errLat := strconv.ParseFloat(field("stop_lat"), 64)
+		lon, errLon := strconv.ParseFloat(field("stop_lon"), 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+		index.stops = append(index.stops, TransitStop{
+			StopID:   field("stop_id"),
+			StopName: field("stop_name"),
+			Lat:      lat,
+			Lon:      lon,
+		})
+	}
+	if len(index.stops) == 0 {
+		return nil, fmt.Errorf("no usable stops in %s", path)
+	}
+	return index, nil
+}
+
+// Function to recompute per-stop availability from the provider states and update the gauges
+func (t *transitStopIndex) update(states []ProviderState) {
+	grid := newGeoGrid(t.radius)
+	for _, state := range states {
+		for _, bike := range state.Bikes {
+			grid.add(geoPoint{Provider: state.Provider.Location, Lat: bike.Lat, Lon: bike.Lon, Bikes: 1})
+		}
+		for _, station := range state.Stations {
+			grid.add(geoPoint{Provider: state.Provider.Location, Lat: station.Lat, Lon: station.Lon, Bikes: station.BikesAvailable})
+		}
+	}
+
+	availability := make([]TransitStopAvailability, 0, len(t.stops))
+	for _, stop := range t.stops {
+		a := TransitStopAvailability{TransitStop: stop, RadiusMeters: t.radius, ByProvider: map[string]int{}}
+		for _, p := range grid.within(stop.Lat, stop.Lon, t.radius) {
+			a.AvailableBikes += p.Bikes
+			a.ByProvider[p.Provider] += p.Bikes
+		}
+		transitStopBikes.With(prometheus.Labels{"stop_id": stop.StopID, "stop_name": stop.StopName}).Set(float64(a.AvailableBikes))
+		availability = append(availability, a)
+	}
+
+	t.mu.Lock()
+	t.availability = availability
+	t.mu.Unlock()
+}
+
+// Handler for GET /api/v1/transit-stops, optionally filtered with ?stop_id= and ?min_bikes=
+func transitStopsHandler(c *gin.Context) {
+	if transitStops == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no GTFS stops configured"})
+		return
+	}
+	minBikes, _ := strconv.Atoi(c.Query("min_bikes"))
+	stopID := c.Query("stop_id")
+
+	transitStops.mu.RLock()
+	defer transitStops.mu.RUnlock()
+	result := []TransitStopAvailability{}
+	for _, a := range transitStops.availability {
+		if stopID != "" && a.StopID != stopID {
+			continue
+		}
+		if a.AvailableBikes < minBikes {
+			continue
+		}
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AvailableBikes > result[j].AvailableBikes })
+	c.JSON(http.StatusOK, gin.H{"radius_meters": transitStops.radius, "stops": result})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scrapes the success part of the health score is computed over
+const healthScrapeWindow = 20
+
+// Share of the estimated fleet available for rent that earns the full availability
+// part; vehicles on a trip are not a sign of poor health
+const healthAvailabilityTarget = 0.5
+
+// Weights of the health score parts, adding up to 100
+var healthWeights = map[string]float64{
+	"success":      40,
+	"freshness":    30,
+	"availability": 30,
+}
+
+// Gauge for the combined health of each provider
+var providerHealthScore = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_provider_health_score",
+		Help: "Provider health from 0 to 100: 40 for recent scrape success, 30 for data freshness and 30 for vehicle availability",
+	},
+	[]string{"location"},
+)
+
+// Gauge for each part of the health score, from 0 to 1
+var providerHealthComponent = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_provider_health_component",
+		Help: "Part of the provider health score from 0 to 1: success, freshness or availability",
+	},
+	[]string{"location", "component"},
+)
+
+func init() {
+	prometheus.MustRegister(providerHealthScore, providerHealthComponent)
+}
+
+// Struct for a provider's health score and its parts
+type HealthScore struct {
+	Score      float64            `json:"score"`
+	Components map[string]float64 `json:"components"`
+}
+
+// Struct tracking the latest scrape outcomes of each provider and its latest score
+type healthTracker struct {
+	mu       sync.Mutex
+	outcomes map[string][]bool
+	scores   map[string]HealthScore
+}
+
+// Health scores computed by ingestion
+var providerHealth = &healthTracker{outcomes: map[string][]bool{}, scores: map[string]HealthScore{}}
+
+// Function to record a scrape outcome and export the provider's score, computed from
+// recent successes, the age of its latest data and how much of its fleet is available
+func (h *healthTracker) observe(provider Provider, ok bool, now time.Time) HealthScore {
+	h.mu.Lock()
+	outcomes := append(h.outcomes[provider.Location], ok)
+	if len(outcomes) > healthScrapeWindow {
+		outcomes = outcomes[len(outcomes)-healthScrapeWindow:]
+	}
+	h.outcomes[provider.Location] = outcomes
+	h.mu.Unlock()
+
+	succeeded := 0
+	for _, outcome := range outcomes {
+		if outcome {
+			succeeded++
+		}
+	}
+	components := map[string]float64{
+		"success":      float64(succeeded) / float64(len(outcomes)),
+		"freshness":    0,
+		"availability": 0,
+	}
+	if state, found := liveState.get(provider.Location); found {
+		components["freshness"] = dataFreshness(state, now)
+		if fleetSize := fleet.estimate(provider.Location); fleetSize > 0 {
+			available := ScrapeResult{Bikes: state.Bikes, Stations: state.Stations, BikeCount: state.BikeCount}.AvailableBikes()
+			components["availability"] = math.Min(1, float64(available)/float64(fleetSize)/healthAvailabilityTarget)
+		}
+	}
+
+	score := HealthScore{Components: components}
+	location := metricLocation(provider)
+	for name, value := range components {
+		score.Score += healthWeights[name] * value
+		providerHealthComponent.WithLabelValues(location, name).Set(value)
+	}
+	score.Score = math.Round(score.Score*10) / 10
+	providerHealthScore.WithLabelValues(location).Set(score.Score)
+
+	h.mu.Lock()
+	h.scores[provider.Location] = score
+	h.mu.Unlock()
+	return score
+}
+
+// Function to return a provider's latest score
+func (h *healthTracker) score(location string) (HealthScore, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	score, ok := h.scores[location]
+	return score, ok
+}
+
+// Function to rate the age of a provider's data from 1, while within --stale-after,
+// down to 0 at twice that age. The feed's last_updated is used when published, the
+// time of the last successful scrape otherwise.
+func dataFreshness(state ProviderState, now time.Time) float64 {
+	at := state.UpdatedAt
+	if !state.LastUpdated.IsZero() && state.LastUpdated.Before(at) {
+		at = state.LastUpdated
+	}
+	staleAfter := incidents.staleAfter
+	if staleAfter <= 0 {
+		staleAfter = 10 * time.Minute
+	}
+	age := now.Sub(at)
+	if age <= staleAfter {
+		return 1
+	}
+	return math.Max(0, 1-float64(age-staleAfter)/float64(staleAfter))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct for one ingestion cycle of a provider in GET /api/v1/history
+type HistoryPoint struct {
+	ScrapedAt      time.Time `json:"scraped_at"`
+	AvailableBikes int       `json:"available_bikes"`
+	Error          string    `json:"error,omitempty"`
+	Closed         bool      `json:"closed,omitempty"`
+}
+
+// Handler for GET /api/v1/history?provider=X, returning a provider's availability at
+// every cycle from the --store storage. from/to are RFC 3339 (default the last 7 days);
+// stations=true adds the per-station counts from --station-history.
+func historyHandler(c *gin.Context) {
+	reader, ok := snapshotStore.(SnapshotReader)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot storage is not enabled"})
+		return
+	}
+	provider := c.Query("provider")
+	if provider == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider is required"})
+		return
+	}
+	query, err := parseSnapshotQuery([]string{provider}, c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.To.IsZero() {
+		query.To = clock.Now().UTC()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-7 * 24 * time.Hour)
+	}
+	if !query.From.Before(query.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	snapshots, err := reader.QuerySnapshots(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	points := make([]HistoryPoint, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		points = append(points, HistoryPoint{
+			ScrapedAt:      snapshot.ScrapedAt,
+			AvailableBikes: snapshot.AvailableBikes,
+			Error:          snapshot.Error,
+			Closed:         snapshot.Closed,
+		})
+	}
+	response := gin.H{"provider": provider, "from": query.From, "to": query.To, "points": points}
+
+	if c.Query("stations") == "true" {
+		if stationHistory == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "station history is not enabled"})
+			return
+		}
+		samples, err := stationHistory.QueryStationSamples("", []string{provider}, query.From, query.To)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if samples == nil {
+			samples = []StationSample{}
+		}
+		response["stations"] = samples
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of provider incidents
+const (
+	// The provider's scrapes are failing
+	incidentDown = "down"
+	// The provider's feed reports a last_updated older than --stale-after
+	incidentStale = "stale"
+)
+
+// Struct for a period during which a provider was down or stale; End is nil while it lasts
+type Incident struct {
+	ID       string     `json:"id"`
+	Provider string     `json:"provider"`
+	Kind     string     `json:"kind"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+// Struct tracking incidents from ingestion outcomes, optionally persisted as
+// JSON lines where each open and close appends the incident's latest version
+type incidentLog struct {
+	staleAfter time.Duration
+
+	mu        sync.Mutex
+	incidents []Incident
+	// index of the open incident by provider and kind
+	open map[[2]string]int
+	enc  *json.Encoder
+	f    *os.File
+}
+
+// Incident log fed by ingestion; persisted with serve --incidents
+var incidents = &incidentLog{staleAfter: 10 * time.Minute, open: map[[2]string]int{}}
+
+// Function to load the incidents recorded in a JSON lines file and append new transitions to it
+func (l *incidentLog) persistTo(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	byID := map[string]int{}
+	dec := json.NewDecoder(f)
+	for {
+		var incident Incident
+		if err := dec.Decode(&incident); err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		// Later lines carry the latest version of an incident
+		if i, ok := byID[incident.ID]; ok {
+			l.incidents[i] = incident
+			continue
+		}
+		byID[incident.ID] = len(l.incidents)
+		l.incidents = append(l.incidents, incident)
+	}
+	for i, incident := range l.incidents {
+		if incident.End == nil {
+			l.open[[2]string{incident.Provider, incident.Kind}] = i
+		}
+	}
+	l.f, l.enc = f, json.NewEncoder(f)
+	return nil
+}
+
+// Function to update a provider's incidents with the outcome of a scrape. Closed
+// systems are not checked for staleness, as their feeds may pause overnight.
+func (l *incidentLog) observe(location string, err error, lastUpdated time.Time, closed bool, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.start(location, incidentDown, err.Error(), now)
+		return
+	}
+	l.end(location, incidentDown, now)
+
+	if closed || l.staleAfter <= 0 || lastUpdated.IsZero() {
+		l.end(location, incidentStale, now)
+		return
+	}
+	if age := now.Sub(lastUpdated); age > l.staleAfter {
+		l.start(location, incidentStale, fmt.Sprintf("last_updated %s ago", age.Round(time.Second)), now)
+	} else {
+		l.end(location, incidentStale, now)
+	}
+}
+
+// Function to open an incident unless one of the kind is already open
+func (l *incidentLog) start(location, kind, reason string, now time.Time) {
+	key := [2]string{location, kind}
+	if _, ok := l.open[key]; ok {
+		return
+	}
+	incident := Incident{
+		ID:       fmt.Sprintf("%s-%s-%d", location, kind, now.Unix()),
+		Provider: location,
+		Kind:     kind,
+		Start:    now.UTC(),
+		Reason:   reason,
+	}
+	l.open[key] = len(l.incidents)
+	l.incidents = append(l.incidents, incident)
+	l.save(incident)
+	log.Printf("Incident opened: %s is %s (%s)", location, kind, reason)
+}
+
+// Function to close the open incident of a kind, if any
+func (l *incidentLog) end(location, kind string, now time.Time) {
+	key := [2]string{location, kind}
+	i, ok := l.open[key]
+	if !ok {
+		return
+	}
+	delete(l.open, key)
+	end := now.UTC()
+	l.incidents[i].End = &end
+	l.save(l.incidents[i])
+	log.Printf("Incident closed: %s is no longer %s after %s", location, kind, end.Sub(l.incidents[i].Start).Round(time.Second))
+}
+
+// Function to append an incident's latest version to the file, if persisted
+func (l *incidentLog) save(incident Incident) {
+	if l.enc == nil {
+		return
+	}
+	if err := l.enc.Encode(incident); err != nil {
+		log.Printf("Error saving incident %s: %v", incident.ID, err)
+	}
+}
+
+// Function to return the incidents of a provider (all when empty) overlapping [from, to); zero bounds are open
+func (l *incidentLog) query(provider string, from, to time.Time) []Incident {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := []Incident{}
+	for _, incident := range l.incidents {
+		if provider != "" && incident.Provider != provider {
+			continue
+		}
+		if !to.IsZero() && !incident.Start.Before(to) {
+			continue
+		}
+		if !from.IsZero() && incident.End != nil && incident.End.Before(from) {
+			continue
+		}
+		result = append(result, incident)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Start.After(result[j].Start) })
+	return result
+}
+
+// Handler for GET /api/v1/incidents, newest first, filtered with ?provider= and RFC 3339 ?from= and ?to=
+func incidentsHandler(c *gin.Context) {
+	query, err := parseSnapshotQuery(nil, c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	found := incidents.query(c.Query("provider"), query.From, query.To)
+	if c.Query(deploymentLabel) != "" {
+		providers, err := getProviders()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		own := map[string]bool{}
+		for _, provider := range deploymentProviders(c, providers) {
+			own[provider.Location] = true
+		}
+		scoped := found[:0:0]
+		for _, incident := range found {
+			if own[incident.Provider] {
+				scoped = append(scoped, incident)
+			}
+		}
+		found = scoped
+	}
+	c.JSON(http.StatusOK, gin.H{"incidents": found})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gauge for the post-processing jobs waiting for a worker
+var jobQueueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "gbfs_job_queue_depth",
+		Help: "Post-processing jobs queued and not yet started",
+	},
+)
+
+// Counter for post-processing jobs by outcome: done, failed, dropped when the queue
+// was full, or coalesced into a newer job of the same key
+var jobsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_jobs_total",
+		Help: "Post-processing jobs by job and outcome: done, failed, dropped or coalesced",
+	},
+	[]string{"job", "outcome"},
+)
+
+// Histogram for how long post-processing jobs run
+var jobDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "gbfs_job_duration_seconds",
+		Help:    "Time post-processing jobs take to run",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	},
+	[]string{"job"},
+)
+
+func init() {
+	prometheus.MustRegister(jobQueueDepth, jobsTotal, jobDuration)
+}
+
+// Struct for one unit of post-processing work
+type job struct {
+	name string
+	run  func() error
+}
+
+// Struct for the bounded queue of work done after a scrape, off the scrape-to-gauge
+// path. Jobs sharing a key run one at a time in submission order, e.g. a provider's
+// history writes; jobs of different keys run in parallel on the workers. Until the
+// workers are started, jobs run inline.
+type jobQueue struct {
+	size int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	started bool
+	closed  bool
+	depth   int
+	pending map[string][]job
+	running map[string]bool
+	// keys with pending jobs and no job running, oldest first
+	ready []string
+	idle  sync.WaitGroup
+}
+
+// Queue of post-processing jobs, sized with --job-queue-size and --job-workers
+var postProcessing = newJobQueue(256)
+
+// Function to create a queue holding at most size jobs
+func newJobQueue(size int) *jobQueue {
+	q := &jobQueue{size: size, pending: map[string][]job{}, running: map[string]bool{}}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Function to start the workers
+func (q *jobQueue) start(workers int) error {
+	if workers < 1 || q.size < 1 {
+		return fmt.Errorf("--job-workers and --job-queue-size must be at least 1")
+	}
+	q.mu.Lock()
+	q.started = true
+	q.mu.Unlock()
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return nil
+}
+
+// Function to queue a job under key. A full queue drops the job, so scrapes never
+// wait for post-processing; with coalesce a job still waiting under the key is
+// replaced, for jobs that only need the latest state.
+func (q *jobQueue) submit(key, name string, coalesce bool, run func() error) {
+	q.mu.Lock()
+	if !q.started || q.closed {
+		q.mu.Unlock()
+		q.execute(job{name: name, run: run})
+		return
+	}
+	defer q.mu.Unlock()
+
+	queued := q.pending[key]
+	if coalesce && len(queued) > 0 {
+		jobsTotal.WithLabelValues(queued[len(queued)-1].name, "coalesced").Inc()
+		queued[len(queued)-1] = job{name: name, run: run}
+		return
+	}
+	if q.depth >= q.size {
+		jobsTotal.WithLabelValues(name, "dropped").Inc()
+		log.Printf("Error queueing %s: the post-processing queue is full", name)
+		return
+	}
+	q.pending[key] = append(queued, job{name: name, run: run})
+	q.depth++
+	q.idle.Add(1)
+	jobQueueDepth.Set(float64(q.depth))
+	if len(queued) == 0 && !q.running[key] {
+		q.ready = append(q.ready, key)
+		q.cond.Signal()
+	}
+}
+
+// Function run by each worker, taking the oldest ready key's next job
+func (q *jobQueue) work() {
+	q.mu.Lock()
+	for {
+		for len(q.ready) == 0 {
+			q.cond.Wait()
+		}
+		key := q.ready[0]
+		q.ready = q.ready[1:]
+		next := q.pending[key][0]
+		q.pending[key] = q.pending[key][1:]
+		q.running[key] = true
+		q.depth--
+		jobQueueDepth.Set(float64(q.depth))
+		q.mu.Unlock()
+
+		q.execute(next)
+
+		q.mu.Lock()
+		delete(q.running, key)
+		if len(q.pending[key]) > 0 {
+			q.ready = append(q.ready, key)
+			q.cond.Signal()
+		} else {
+			delete(q.pending, key)
+		}
+		q.idle.Done()
+	}
+}
+
+// Function to run a job and record its outcome
+func (q *jobQueue) execute(next job) {
+	start := time.Now()
+	err := next.run()
+	jobDuration.WithLabelValues(next.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		jobsTotal.WithLabelValues(next.name, "failed").Inc()
+		log.Printf("Error running %s: %v", next.name, err)
+		return
+	}
+	jobsTotal.WithLabelValues(next.name, "done").Inc()
+}
+
+// Function to stop queueing, running later jobs inline, and wait up to timeout for
+// the queued ones to finish
+func (q *jobQueue) drain(timeout time.Duration) {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.idle.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Error draining post-processing: jobs still queued after %s", timeout)
+	}
+}
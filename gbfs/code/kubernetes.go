@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory holding the pod's service account token, CA and namespace
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// API path of the Provider custom resources, relative to a namespace
+const providerCRDPath = "/apis/gbfs.io/v1alpha1/namespaces/%s/providers"
+
+// Struct for a minimal in-cluster Kubernetes API client
+type kubeClient struct {
+	host   string
+	client *http.Client
+}
+
+// Function to create an API client from the pod's service account
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST is not set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("service account CA contains no certificates")
+	}
+	return &kubeClient{
+		host: "https://" + net.JoinHostPort(host, port),
+		// No overall timeout: watch requests stream for minutes
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// Function to return the namespace the pod runs in
+func inClusterNamespace() string {
+	data, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Struct for an API server response with an unexpected status
+type kubeStatusError struct {
+	method, path string
+	status       string
+	code         int
+	body         string
+}
+
+func (e *kubeStatusError) Error() string {
+	return fmt.Sprintf("kubernetes %s %s: %s: %s", e.method, e.path, e.status, e.body)
+}
+
+// Function to report whether err is an API server response with the given status code
+func isKubeStatus(err error, code int) bool {
+	var statusErr *kubeStatusError
+	return errors.As(err, &statusErr) && statusErr.code == code
+}
+
+// Function to perform a GET against the API server
+func (k *kubeClient) get(path string, query neturl.Values) (*http.Response, error) {
+	return k.do(http.MethodGet, path, query, nil)
+}
+
+// Function to perform a request against the API server, sending body as JSON when not nil;
+// the token is re-read as kubelet rotates it
+func (k *kubeClient) do(method, path string, query neturl.Values, body interface{}) (*http.Response, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+	url := k.host + path
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &kubeStatusError{method: method, path: path, status: resp.Status, code: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
+
+// Struct for the object metadata used by discovery
+type kubeMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// Struct for a ConfigMap holding a providers config document
+type kubeConfigMap struct {
+	Metadata kubeMeta          `json:"metadata"`
+	Data     map[string]string `json:"data"`
+}
+
+// Struct for a Provider custom resource; the resource name is the location unless spec.name is set
+type kubeProvider struct {
+	Metadata kubeMeta `json:"metadata"`
+	Spec     struct {
+		Name    string            `json:"name"`
+		URL     string            `json:"url"`
+		Source  string            `json:"source"`
+		Headers map[string]string `json:"headers"`
+		Verify  *VerifyConfig     `json:"verify"`
+	} `json:"spec"`
+}
+
+// Struct for a list response
+type kubeList struct {
+	Metadata kubeMeta          `json:"metadata"`
+	Items    []json.RawMessage `json:"items"`
+}
+
+// Struct for a watch event
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Struct reconciling the provider set from a watched ConfigMap and/or Provider resources
+type kubeDiscovery struct {
+	kube         *kubeClient
+	namespace    string
+	configMap    string
+	configMapKey string
+	crd          bool
+
+	mu sync.RWMutex
+	// Providers per origin, e.g. "configmap" or "provider/<name>"
+	sources map[string][]ProviderConfig
+	current []Provider
+}
+
+// Discovery in use when serve runs with --k8s-configmap or --k8s-providers; nil otherwise
+var k8sDiscovery *kubeDiscovery
+
+// Function to start watching the cluster for provider definitions, returning
+// once the initial set has been loaded
+func startKubeDiscovery(ref, key string, crd bool) (*kubeDiscovery, error) {
+	kube, err := newInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	d := &kubeDiscovery{
+		kube:         kube,
+		namespace:    inClusterNamespace(),
+		configMapKey: key,
+		crd:          crd,
+		sources:      map[string][]ProviderConfig{},
+	}
+	if ref != "" {
+		if namespace, name, ok := strings.Cut(ref, "/"); ok {
+			d.namespace, d.configMap = namespace, name
+		} else {
+			d.configMap = ref
+		}
+	}
+
+	if d.configMap != "" {
+		rv, err := d.listConfigMap()
+		if err != nil {
+			return nil, err
+		}
+		go d.watchLoop("configmap", rv, d.configMapQuery(), "/api/v1/namespaces/"+d.namespace+"/configmaps", d.listConfigMap, d.applyConfigMapEvent)
+	}
+	if d.crd {
+		rv, err := d.listProviders()
+		if err != nil {
+			return nil, err
+		}
+		go d.watchLoop("providers", rv, neturl.Values{}, fmt.Sprintf(providerCRDPath, d.namespace), d.listProviders, d.applyProviderEvent)
+	}
+	d.reconcile()
+	return d, nil
+}
+
+// Function to return the currently discovered providers
+func (d *kubeDiscovery) providers() []Provider {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]Provider(nil), d.current...)
+}
+
+// Function to select the watched ConfigMap by name
+func (d *kubeDiscovery) configMapQuery() neturl.Values {
+	return neturl.Values{"fieldSelector": {"metadata.name=" + d.configMap}}
+}
+
+// Function to list the watched ConfigMap, replacing its providers, and return the resource version to watch from
+func (d *kubeDiscovery) listConfigMap() (string, error) {
+	list, err := d.list("/api/v1/namespaces/"+d.namespace+"/configmaps", d.configMapQuery())
+	if err != nil {
+		return "", err
+	}
+	d.setSource("configmap", nil)
+	for _, item := range list.Items {
+		d.applyConfigMapEvent(kubeWatchEvent{Type: "ADDED", Object: item})
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Function to list the Provider resources, replacing all of them, and return the resource version to watch from
+func (d *kubeDiscovery) listProviders() (string, error) {
+	list, err := d.list(fmt.Sprintf(providerCRDPath, d.namespace), neturl.Values{})
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	for origin := range d.sources {
+		if strings.HasPrefix(origin, "provider/") {
+			delete(d.sources, origin)
+		}
+	}
+	d.mu.Unlock()
+	for _, item := range list.Items {
+		d.applyProviderEvent(kubeWatchEvent{Type: "ADDED", Object: item})
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Function to GET and decode a list
+func (d *kubeDiscovery) list(path string, query neturl.Values) (kubeList, error) {
+	resp, err := d.kube.get(path, query)
+	if err != nil {
+		return kubeList{}, err
+	}
+	defer resp.Body.Close()
+	var list kubeList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return kubeList{}, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// Function to apply a ConfigMap event; an invalid document keeps the previous providers
+func (d *kubeDiscovery) applyConfigMapEvent(event kubeWatchEvent) {
+	if event.Type == "DELETED" {
+		d.setSource("configmap", nil)
+		return
+	}
+	var cm kubeConfigMap
+	if err := json.Unmarshal(event.Object, &cm); err != nil {
+		log.Printf("Error decoding ConfigMap %s: %v", d.configMap, err)
+		return
+	}
+	data, ok := cm.Data[d.configMapKey]
+	if !ok {
+		log.Printf("Error: ConfigMap %s has no key %s", d.configMap, d.configMapKey)
+		return
+	}
+	config, err := parseConfig("configmap "+d.namespace+"/"+d.configMap, []byte(data))
+	if err != nil {
+		log.Printf("Error loading providers: %v", err)
+		return
+	}
+	d.setSource("configmap", config.Providers)
+}
+
+// Function to apply a Provider resource event; an invalid resource is skipped
+func (d *kubeDiscovery) applyProviderEvent(event kubeWatchEvent) {
+	var p kubeProvider
+	if err := json.Unmarshal(event.Object, &p); err != nil {
+		log.Printf("Error decoding Provider resource: %v", err)
+		return
+	}
+	origin := "provider/" + p.Metadata.Name
+	if event.Type == "DELETED" {
+		d.setSource(origin, nil)
+		return
+	}
+	provider := ProviderConfig{Name: p.Spec.Name, URL: p.Spec.URL, Source: p.Spec.Source, Headers: p.Spec.Headers, Verify: p.Spec.Verify}
+	if provider.Name == "" {
+		provider.Name = p.Metadata.Name
+	}
+	if err := (Config{Providers: []ProviderConfig{provider}}).validate(origin); err != nil {
+		log.Printf("Error loading providers: %v", err)
+		return
+	}
+	d.setSource(origin, []ProviderConfig{provider})
+}
+
+// Function to store the providers of one origin; reconcile applies them
+func (d *kubeDiscovery) setSource(origin string, providers []ProviderConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if providers == nil {
+		delete(d.sources, origin)
+	} else {
+		d.sources[origin] = providers
+	}
+}
+
+// Function to rebuild the provider set from all origins, dropping metrics and
+// state of providers that disappeared. The first origin defining a name wins.
+func (d *kubeDiscovery) reconcile() {
+	d.mu.Lock()
+	origins := make([]string, 0, len(d.sources))
+	for origin := range d.sources {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	var config Config
+	seen := map[string]bool{}
+	for _, origin := range origins {
+		for _, provider := range d.sources[origin] {
+			if seen[provider.Name] {
+				log.Printf("Error: provider %q from %s is already defined, ignoring it", provider.Name, origin)
+				continue
+			}
+			seen[provider.Name] = true
+			config.Providers = append(config.Providers, provider)
+		}
+	}
+	previous := d.current
+	d.current = config.providers()
+	current := d.current
+	d.mu.Unlock()
+
+	kept := make(map[string]string, len(current))
+	for _, provider := range current {
+		kept[provider.Location] = provider.URL
+	}
+	for _, provider := range previous {
+		if url, ok := kept[provider.Location]; ok && url == provider.URL {
+			continue
+		}
+		// Removed providers keep their state; the registry marks them inactive
+		providerBikes.DeleteLabelValues(metricLocation(provider), redactURL(provider.URL))
+	}
+	if len(previous) > 0 || len(current) > 0 {
+		log.Printf("Discovered %d providers from Kubernetes", len(current))
+	}
+}
+
+// Function to watch a resource forever, relisting when the watch expires or fails
+func (d *kubeDiscovery) watchLoop(name, resourceVersion string, query neturl.Values, path string, relist func() (string, error), apply func(kubeWatchEvent)) {
+	for {
+		err := d.watch(resourceVersion, query, path, func(event kubeWatchEvent) {
+			apply(event)
+			d.reconcile()
+		}, &resourceVersion)
+		if err != nil {
+			log.Printf("Error watching %s: %v", name, err)
+			time.Sleep(5 * time.Second)
+		}
+		// A closed or expired watch is resumed from a fresh list
+		rv, err := relist()
+		if err != nil {
+			log.Printf("Error listing %s: %v", name, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		resourceVersion = rv
+		d.reconcile()
+	}
+}
+
+// Function to stream watch events until the server closes the watch
+func (d *kubeDiscovery) watch(resourceVersion string, query neturl.Values, path string, handle func(kubeWatchEvent), latest *string) error {
+	q := neturl.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("watch", "true")
+	q.Set("resourceVersion", resourceVersion)
+	q.Set("allowWatchBookmarks", "true")
+	resp, err := d.kube.get(path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event kubeWatchEvent
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var meta struct {
+			Metadata kubeMeta `json:"metadata"`
+		}
+		json.Unmarshal(event.Object, &meta)
+		switch event.Type {
+		case "BOOKMARK":
+			*latest = meta.Metadata.ResourceVersion
+		case "ERROR":
+			// Typically 410 Gone once the resource version is too old
+			return fmt.Errorf("watch error: %s", strings.TrimSpace(string(event.Object)))
+		default:
+			*latest = meta.Metadata.ResourceVersion
+			handle(event)
+		}
+	}
+}
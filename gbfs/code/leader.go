@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Time format of Lease renewTime and acquireTime
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Struct for the coordination.k8s.io/v1 Lease fields used for election
+type kubeLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// Struct electing a single scraping replica through a Kubernetes Lease
+type leaderElector struct {
+	kube      *kubeClient
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	// Called when this replica becomes leader
+	onElected func()
+
+	leader atomic.Bool
+	// Last successful acquire or renew
+	renewed time.Time
+}
+
+// Elector in use with serve --leader-elect; nil means this replica always scrapes
+var leaderElection *leaderElector
+
+// Gauge reporting whether this replica currently holds the lease
+var leaderGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "gbfs_leader",
+		Help: "1 if this replica is the elected leader scraping upstream feeds, 0 otherwise; always 1 without leader election",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+	// Without leader election the only replica scrapes, so it counts as the leader
+	leaderGauge.Set(1)
+}
+
+// Function to start campaigning for the lease named name in the pod's namespace
+func startLeaderElection(name string, duration time.Duration, onElected func()) (*leaderElector, error) {
+	kube, err := newInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("determining leader election identity: %w", err)
+		}
+	}
+	e := &leaderElector{
+		kube:      kube,
+		namespace: inClusterNamespace(),
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+		onElected: onElected,
+	}
+	leaderGauge.Set(0)
+	go e.run()
+	return e, nil
+}
+
+// Function to report whether this replica should scrape; true without leader election
+func (e *leaderElector) isLeader() bool {
+	if e == nil {
+		return true
+	}
+	return e.leader.Load()
+}
+
+// Function to try to acquire or renew the lease every third of its duration
+func (e *leaderElector) run() {
+	for {
+		held, err := e.tryAcquireOrRenew()
+		if err != nil {
+			log.Printf("Error renewing lease %s: %v", e.name, err)
+			// Keep leading through API server hiccups until the lease would have expired
+			held = e.leader.Load() && time.Since(e.renewed) < e.duration
+		} else if held {
+			e.renewed = time.Now()
+		}
+		was := e.leader.Swap(held)
+		switch {
+		case held && !was:
+			log.Printf("Elected leader as %s, scraping upstream feeds", e.identity)
+			leaderGauge.Set(1)
+			if e.onElected != nil {
+				go e.onElected()
+			}
+		case !held && was:
+			log.Printf("Lost leadership of lease %s, no longer scraping", e.name)
+			leaderGauge.Set(0)
+		}
+		time.Sleep(e.duration / 3)
+	}
+}
+
+// Function to take the lease when it is free or expired, or renew it when held
+func (e *leaderElector) tryAcquireOrRenew() (bool, error) {
+	path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", e.namespace, e.name)
+	now := time.Now().UTC()
+
+	resp, err := e.kube.get(path, nil)
+	if isKubeStatus(err, http.StatusNotFound) {
+		var lease kubeLease
+		lease.APIVersion, lease.Kind = "coordination.k8s.io/v1", "Lease"
+		lease.Metadata.Name, lease.Metadata.Namespace = e.name, e.namespace
+		e.claim(&lease, now)
+		return e.write(http.MethodPost, fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.namespace), &lease)
+	}
+	if err != nil {
+		return false, err
+	}
+	var lease kubeLease
+	err = json.NewDecoder(resp.Body).Decode(&lease)
+	resp.Body.Close()
+	if err != nil {
+		return false, fmt.Errorf("decoding lease: %w", err)
+	}
+
+	if lease.Spec.HolderIdentity != e.identity {
+		renewed, _ := time.Parse(leaseTimeFormat, lease.Spec.RenewTime)
+		expiry := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if lease.Spec.HolderIdentity != "" && now.Before(expiry) {
+			return false, nil
+		}
+		lease.Spec.LeaseTransitions++
+		e.claim(&lease, now)
+	} else {
+		lease.Spec.RenewTime = now.Format(leaseTimeFormat)
+		lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	}
+	// The resource version makes the update fail if another replica wrote first
+	return e.write(http.MethodPut, path, &lease)
+}
+
+// Function to set this replica as holder of the lease
+func (e *leaderElector) claim(lease *kubeLease, now time.Time) {
+	lease.Spec.HolderIdentity = e.identity
+	lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	lease.Spec.AcquireTime = now.Format(leaseTimeFormat)
+	lease.Spec.RenewTime = now.Format(leaseTimeFormat)
+}
+
+// Function to create or update the lease, treating a conflict as losing the race
+func (e *leaderElector) write(method, path string, lease *kubeLease) (bool, error) {
+	resp, err := e.kube.do(method, path, nil, lease)
+	if isKubeStatus(err, http.StatusConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Exit codes: 1 for runtime failures, 2 for invalid configuration that a restart will not fix
+const (
+	exitFailure     = 1
+	exitConfigError = 2
+)
+
+// Struct marking an error as a fatal configuration problem
+type configError struct {
+	err error
+}
+
+func (e configError) Error() string { return e.err.Error() }
+func (e configError) Unwrap() error { return e.err }
+
+// Function to mark err as a configuration error, passing nil through
+func fatalConfig(err error) error {
+	if err == nil {
+		return nil
+	}
+	return configError{err: err}
+}
+
+// Function to map a command error to the process exit code
+func exitCode(err error) int {
+	var cfgErr configError
+	if errors.As(err, &cfgErr) {
+		return exitConfigError
+	}
+	return exitFailure
+}
+
+// Time the server stays up but unready after SIGTERM or a preStop call, so load
+// balancers stop routing to it before connections are closed
+var drainDelay = 5 * time.Second
+
+// Time in-flight requests get to finish once the server shuts down
+var shutdownTimeout = 10 * time.Second
+
+// Lifecycle state reported by the health endpoints
+var (
+	ready    atomic.Bool
+	draining atomic.Bool
+)
+
+// Context cancelled on shutdown, stopping the ingestion loops and their feed requests
+var ingestionCtx, cancelIngestion = context.WithCancel(context.Background())
+
+// Ingestion loops still running, waited for before the shutdown hooks run
+var ingestionLoops sync.WaitGroup
+
+// Functions run after the server has stopped, e.g. to persist state
+var shutdownHooks []func()
+
+// Function to register a function to run on graceful shutdown
+func onShutdown(hook func()) {
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// Function to report that the first successful ingestion finished and traffic can be served
+func markReady() {
+	if !ready.Swap(true) {
+		log.Printf("Ready: first ingestion complete")
+	}
+}
+
+// Function to wait d between ingestion cycles, returning false once shutdown began
+func waitForNextCycle(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ingestionCtx.Done():
+		return false
+	}
+}
+
+// Function to cancel ingestion and wait up to timeout for the running cycle to stop
+func stopIngestion(timeout time.Duration) {
+	cancelIngestion()
+	stopped := make(chan struct{})
+	go func() {
+		ingestionLoops.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("Error stopping ingestion: still running after %s", timeout)
+	}
+}
+
+// Function to stop reporting ready and wait for the drain delay, once
+func drain() {
+	if draining.Swap(true) {
+		return
+	}
+	log.Printf("Draining for %s before shutting down", drainDelay)
+	time.Sleep(drainDelay)
+}
+
+// Handler for liveness probes: the process is up and serving HTTP
+func healthzHandler(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// Handler for readiness and startup probes: unready until the first successful ingestion and while draining
+func readyzHandler(c *gin.Context) {
+	switch {
+	case draining.Load():
+		c.String(http.StatusServiceUnavailable, "draining")
+	case !ready.Load():
+		c.String(http.StatusServiceUnavailable, "waiting for first ingestion")
+	default:
+		c.String(http.StatusOK, "ready")
+	}
+}
+
+// Handler for a Kubernetes preStop httpGet hook, returning once draining is done
+func preStopHandler(c *gin.Context) {
+	drain()
+	c.String(http.StatusOK, "drained")
+}
+
+// Function to serve handler on listenAddr until SIGTERM or SIGINT, then drain and shut down gracefully
+func serveUntilSignal(listenAddr string, handler http.Handler) error {
+	server := &http.Server{Addr: listenAddr, Handler: handler}
+	// Event streams never finish on their own
+	server.RegisterOnShutdown(stationEvents.closeAll)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s", sig)
+	}
+
+	drain()
+	stopIngestion(shutdownTimeout)
+	postProcessing.drain(shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	for _, hook := range shutdownHooks {
+		hook()
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Server stopped")
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for an operator URL published in system_information, e.g. kind purchase_url or ios_store_uri
+type operatorLink struct {
+	Kind string
+	URL  string
+}
+
+// Function to keep the links that can be probed over HTTP; app deep links such as
+// discovery_uri schemes only resolve on a phone
+func probeableLinks(links []operatorLink) []operatorLink {
+	var probeable []operatorLink
+	for _, link := range links {
+		if u, err := neturl.Parse(link.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			probeable = append(probeable, link)
+		}
+	}
+	return probeable
+}
+
+// Struct probing the operator URLs of every provider at most once per interval
+type linkProber struct {
+	interval time.Duration
+	client   *http.Client
+
+	mu      sync.Mutex
+	checked map[string]time.Time
+	// label values exported per provider, so links that are no longer published can be removed
+	series map[string][]operatorLink
+}
+
+// Prober enabled with serve --probe-operator-urls; a zero interval disables it
+var operatorLinks = &linkProber{checked: map[string]time.Time{}, series: map[string][]operatorLink{}}
+
+// Gauge for whether each operator URL answered with a non-error status
+var operatorLinkUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_operator_url_up",
+		Help: "Whether the operator URL from system_information answered with a status below 400",
+	},
+	[]string{"location", "kind", "url"},
+)
+
+// Gauge for how long each operator URL took to answer
+var operatorLinkDuration = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_operator_url_probe_duration_seconds",
+		Help: "Duration of the last probe of the operator URL, redirects included",
+	},
+	[]string{"location", "kind", "url"},
+)
+
+func init() {
+	prometheus.MustRegister(operatorLinkUp, operatorLinkDuration)
+}
+
+// Function to probe a provider's operator URLs in the background when its interval
+// has elapsed, so slow app stores never hold up a scrape
+func (p *linkProber) check(provider Provider, now time.Time) {
+	if p.interval <= 0 || (provider.Source != "" && provider.Source != sourceGBFS) {
+		return
+	}
+	p.mu.Lock()
+	due := now.Sub(p.checked[provider.Location]) >= p.interval
+	if due {
+		p.checked[provider.Location] = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+
+	links := systemInformation(provider).Links
+	go p.probeAll(provider, links)
+}
+
+// Function to probe each link and export the outcome, dropping links no longer published
+func (p *linkProber) probeAll(provider Provider, links []operatorLink) {
+	location := metricLocation(provider)
+	for _, link := range links {
+		start := time.Now()
+		err := p.probe(link.URL)
+		up := 1.0
+		if err != nil {
+			up = 0
+			log.Printf("Error probing %s of %s: %v", link.Kind, provider.Location, redactError(err))
+		}
+		url := redactURL(link.URL)
+		operatorLinkUp.WithLabelValues(location, link.Kind, url).Set(up)
+		operatorLinkDuration.WithLabelValues(location, link.Kind, url).Set(time.Since(start).Seconds())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, old := range p.series[location] {
+		published := false
+		for _, link := range links {
+			published = published || link == old
+		}
+		if !published {
+			operatorLinkUp.DeleteLabelValues(location, old.Kind, redactURL(old.URL))
+			operatorLinkDuration.DeleteLabelValues(location, old.Kind, redactURL(old.URL))
+		}
+	}
+	p.series[location] = links
+}
+
+// Function to build the client used for probes: the egress policy still applies, but
+// redirects are followed across hosts as app store and shortened links need
+func linkProbeClient() *http.Client {
+	client := egress.client()
+	client.CheckRedirect = nil
+	client.Timeout = 10 * time.Second
+	return client
+}
+
+// Function to request a URL with HEAD, falling back to GET for servers that refuse HEAD
+func (p *linkProber) probe(url string) error {
+	status, err := p.request(http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = p.request(http.MethodGet, url)
+	}
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
+
+// Function to make one probe request and return its status
+func (p *linkProber) request(method, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "gbfs-exporter/"+version)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
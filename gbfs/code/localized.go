@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Text in each language of a GBFS 3.x localized string, by lowercase language tag
+type localizedText map[string]string
+
+// Function to decode the translations of a localized string; plain strings carry none
+func (t *localizedText) UnmarshalJSON(data []byte) error {
+	var localized []struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal(data, &localized); err != nil {
+		return nil
+	}
+	*t = make(localizedText, len(localized))
+	for _, translation := range localized {
+		(*t)[strings.ToLower(translation.Language)] = translation.Text
+	}
+	return nil
+}
+
+// Function to return the translation for the first of the languages that has one,
+// matching "fr" for "fr-BE" and the reverse, or fallback when none does
+func (t localizedText) pick(languages []string, fallback string) string {
+	for _, language := range languages {
+		if text, ok := t[language]; ok {
+			return text
+		}
+		primary, _, _ := strings.Cut(language, "-")
+		if text, ok := t[primary]; ok {
+			return text
+		}
+		tags := make([]string, 0, len(t))
+		for tag := range t {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			if strings.HasPrefix(tag, primary+"-") {
+				return t[tag]
+			}
+		}
+	}
+	return fallback
+}
+
+// Function to parse an Accept-Language header into lowercase tags by descending preference, without "*"
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed <= 0 {
+				continue
+			}
+			q = parsed
+		}
+		accepted = append(accepted, weighted{tag, q})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	languages := make([]string, len(accepted))
+	for i, language := range accepted {
+		languages[i] = language.tag
+	}
+	return languages
+}
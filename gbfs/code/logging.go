@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log output formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Format and level set with --log-format and --log-level
+var (
+	logFormat = logFormatText
+	logLevel  = "info"
+)
+
+// Function to send all logging through slog in the configured format and level.
+// Messages of the log package are bridged, at error level when they start with "Error".
+func configureLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid --log-level %q, expected debug, info, warn or error", logLevel)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch logFormat {
+	case logFormatText:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, options)
+		// Route listings and colored access logs would break line-per-record JSON
+		gin.SetMode(gin.ReleaseMode)
+	default:
+		return fmt.Errorf("invalid --log-format %q, expected text or json", logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	log.SetOutput(logBridge{})
+	return nil
+}
+
+// Struct writing log package output to the default slog logger
+type logBridge struct{}
+
+func (logBridge) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(message, "Error"):
+		level = slog.LevelError
+	case strings.HasPrefix(message, "Warning"):
+		level = slog.LevelWarn
+	}
+	slog.Default().Log(context.Background(), level, message)
+	return len(p), nil
+}
+
+// Function to create a router logging each request through slog
+func newRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(requestLogger, gin.Recovery())
+	return router
+}
+
+// Middleware logging the method, path, status and duration of every request
+func requestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		// Not an error: readiness probes answer 503 while starting up and draining
+		level = slog.LevelWarn
+	}
+	slog.Default().Log(c.Request.Context(), level, "Request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+		"client_ip", c.ClientIP(),
+	)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for sampling repeated error logs: the first error of a streak is
+// logged, then only every Nth with the number suppressed, and a summary is
+// logged when the streak ends
+type errorLogSampler struct {
+	// every logs one error in this many after the first; 1 or less logs all of them
+	every int
+
+	mu      sync.Mutex
+	streaks map[string]*errorStreak
+}
+
+// Struct for the consecutive errors of one key
+type errorStreak struct {
+	count      int
+	suppressed int
+	since      time.Time
+}
+
+// Sampler for provider scrape errors; the rate is set with --error-log-every
+var scrapeErrorLog = &errorLogSampler{every: 10, streaks: map[string]*errorStreak{}}
+
+// Counter for every failed scrape, logged or not
+var scrapeFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_scrape_failures_total",
+		Help: "Number of failed provider scrapes",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(scrapeFailures)
+}
+
+// Function to record an error for key, logging it when it is sampled
+func (s *errorLogSampler) failure(key string, format string, args ...any) {
+	s.mu.Lock()
+	streak, ok := s.streaks[key]
+	if !ok {
+		streak = &errorStreak{since: time.Now()}
+		s.streaks[key] = streak
+	}
+	streak.count++
+	logIt := streak.count == 1 || s.every <= 1 || (streak.count-1)%s.every == 0
+	suppressed := streak.suppressed
+	if logIt {
+		streak.suppressed = 0
+	} else {
+		streak.suppressed++
+	}
+	s.mu.Unlock()
+
+	if !logIt {
+		return
+	}
+	if suppressed > 0 {
+		format += " (%d similar errors suppressed, failing since %s)"
+		args = append(args, suppressed, streak.since.Format(time.RFC3339))
+	}
+	log.Printf(format, args...)
+}
+
+// Function to end the error streak of key, summarizing it if errors were suppressed
+func (s *errorLogSampler) success(key string) {
+	s.mu.Lock()
+	streak, ok := s.streaks[key]
+	delete(s.streaks, key)
+	s.mu.Unlock()
+
+	if ok && streak.count > 1 {
+		log.Printf("%s recovered after %d consecutive errors over %s", key, streak.count, time.Since(streak.since).Round(time.Second))
+	}
+}
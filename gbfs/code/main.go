@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Struct to represent the feed URLs from the GBFS response
@@ -21,34 +24,203 @@ type GBFSFeed struct {
 	URL  string `json:"url"`
 }
 
-// Struct for the main GBFS response
-type GBFSMainResponse struct {
-	Data struct {
-		EN struct {
-			Feeds []GBFSFeed `json:"feeds"`
-		} `json:"en"`
-	} `json:"data"`
-}
-
 // Struct for the free bike status response
 type FreeBikeStatus struct {
-	Data struct {
-		Bikes []struct {
-			BikeID string `json:"bike_id"`
-		} `json:"bikes"`
+	LastUpdated gbfsTime `json:"last_updated"`
+	TTL         int      `json:"ttl"`
+	Data        struct {
+		// Bikes also holds the vehicles of the GBFS 3.x vehicle_status feed
+		Bikes []Bike `json:"bikes"`
 	} `json:"data"`
 }
 
-// Struct for provider information, including only Location and URL
+// Timestamp given as POSIX seconds (GBFS 1.x and 2.x) or RFC 3339 (GBFS 3.x)
+type gbfsTime time.Time
+
+// Function to decode either timestamp form; anything else leaves the zero time
+func (t *gbfsTime) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*t = gbfsTime(time.Unix(seconds, 0).UTC())
+		return nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		*t = gbfsTime(parsed.UTC())
+	}
+	return nil
+}
+
+// Struct for a single vehicle in free_bike_status
+type Bike struct {
+	BikeID        string   `json:"bike_id"`
+	Lat           float64  `json:"lat"`
+	Lon           float64  `json:"lon"`
+	IsReserved    gbfsBool `json:"is_reserved"`
+	IsDisabled    gbfsBool `json:"is_disabled"`
+	VehicleTypeID string   `json:"vehicle_type_id,omitempty"`
+	// Category is the canonical vehicle category (bike, ebike, scooter, cargo or other)
+	Category string `json:"vehicle_category,omitempty"`
+	// Battery of electric vehicles, when the feed publishes it; fuel is a fraction from 0 to 1
+	CurrentRangeMeters *float64 `json:"current_range_meters,omitempty"`
+	CurrentFuelPercent *float64 `json:"current_fuel_percent,omitempty"`
+}
+
+// Function to decode a bike, taking its ID from the vehicle_id of GBFS 3.x feeds when bike_id is missing
+func (b *Bike) UnmarshalJSON(data []byte) error {
+	type plain Bike
+	var bike struct {
+		plain
+		VehicleID string `json:"vehicle_id"`
+	}
+	if err := json.Unmarshal(data, &bike); err != nil {
+		return err
+	}
+	*b = Bike(bike.plain)
+	if b.BikeID == "" {
+		b.BikeID = bike.VehicleID
+	}
+	return nil
+}
+
+// Boolean that also accepts the 0/1 integers used by GBFS v1 feeds
+type gbfsBool bool
+
+// Function to decode true/false, 0/1 or their string forms
+func (b *gbfsBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// Struct for a docking station with its current availability
+type Station struct {
+	StationID      string  `json:"station_id"`
+	Name           string  `json:"name"`
+	Lat            float64 `json:"lat"`
+	Lon            float64 `json:"lon"`
+	BikesAvailable int     `json:"num_bikes_available"`
+	DocksAvailable int     `json:"num_docks_available"`
+	// IsRenting is false while the station does not rent out bikes, e.g. when it is not installed
+	IsRenting bool `json:"is_renting"`
+	// Attributes from station_information, when the provider publishes them
+	IsVirtual   bool   `json:"is_virtual_station,omitempty"`
+	IsCharging  bool   `json:"is_charging_station,omitempty"`
+	ParkingType string `json:"parking_type,omitempty"`
+}
+
+// Struct for everything a provider scrape produces
+type ScrapeResult struct {
+	Bikes    []Bike
+	Stations []Station
+	// BikeCount counts free-floating bikes that were not decoded, in count-only mode
+	BikeCount int
+	// LastUpdated is when the provider last refreshed the feed; zero when unknown
+	LastUpdated time.Time
+	// TTL is the shortest ttl of the status feeds; zero when they refresh continuously or publish none
+	TTL time.Duration
+}
+
+// Function to count the bikes available, free-floating plus docked
+func (r ScrapeResult) AvailableBikes() int {
+	total := len(r.Bikes) + r.BikeCount
+	for _, station := range r.Stations {
+		total += station.BikesAvailable
+	}
+	return total
+}
+
+// Struct for provider information
 type Provider struct {
 	Location string
 	URL      string
+	// FallbackURLs are tried in order when URL fails, e.g. while the operator migrates domains
+	FallbackURLs []string
+	// AutoName names the provider after its system_information, for providers registered with only a URL
+	AutoName bool
+	// Source selects the adapter used to scrape URL; empty means GBFS
+	Source string
+	// Headers are sent with every request for this provider; values may hold secret references
+	Headers map[string]string
+	// Verify holds optional digest or signature checks applied to every response
+	Verify *VerifyConfig
+	// QuietHours are daily windows, in Timezone, during which the system is treated as closed
+	QuietHours []clockWindow
+	// SystemHours treats the system as closed outside the rental hours of its system_hours feed
+	SystemHours bool
+	// Timezone is an IANA zone name; empty means the server's local time
+	Timezone string
+	// SystemID is the canonical system_information.system_id, configured or discovered
+	SystemID string
+	// License is the data license of system_information, discovered at every scrape
+	License *DataLicense
+	// Normalize holds transforms fixing malformed feeds before they are parsed
+	Normalize []NormalizeStep
+	// VehicleTypes maps the provider's vehicle_type_id values to canonical categories
+	VehicleTypes map[string]string
+	// Tags group providers for rollup metrics, e.g. country=NL or operator=tier
+	Tags map[string]string
+	// Metadata is administrative data such as permit_id or contact, for the API and info metric
+	Metadata map[string]string
+	// Deployment names the config deployment the provider belongs to, with the labels added to its metrics
+	Deployment       string
+	DeploymentLabels map[string]string
+	// Budget caps the provider's daily upstream requests and bytes; nil uses the serve defaults
+	Budget *ScrapeBudget
+	// Language is the discovery language whose feeds are fetched; empty prefers English
+	Language string
+	// Interval polls the provider at most this often; zero polls it every cycle
+	Interval time.Duration
+	// Timeout overrides --provider-timeout for the provider's scrapes; zero keeps it
+	Timeout time.Duration
+	// Feeds restricts the feeds fetched from discovery to these names; empty fetches all
+	Feeds []string
+	// SkipFeeds are never fetched, whatever Feeds says
+	SkipFeeds []string
+	// FleetCap is the permitted number of deployed vehicles; zero means no cap
+	FleetCap int
+	// Faults are injected into the provider's requests with serve --chaos
+	Faults []FaultRule
+	// Deadline of the scrape in progress, shared by all of its feed requests
+	deadline time.Time
+}
+
+// Struct for the normalized result of scraping a single provider
+type ProviderSnapshot struct {
+	Location       string    `json:"location"`
+	URL            string    `json:"url"`
+	ScrapedAt      time.Time `json:"scraped_at"`
+	AvailableBikes int       `json:"available_bikes"`
+	Error          string    `json:"error,omitempty"`
+	// Closed marks scrapes during quiet hours or outside the system's service hours
+	Closed bool `json:"closed,omitempty"`
+	// Timezone of the system, used to attribute the snapshot to a local day
+	Timezone string `json:"timezone,omitempty"`
+	// Weather at the system, with serve --weather-provider
+	Weather *WeatherObservation `json:"weather,omitempty"`
+	// Deployment the provider belongs to in the config file
+	Deployment string `json:"deployment,omitempty"`
+	// Trips estimated from vehicle churn since the previous scrape
+	EstimatedTrips int `json:"estimated_trips,omitempty"`
+	// License the provider publishes its data under, kept with the data it covers
+	License *DataLicense `json:"license,omitempty"`
 }
 
+// Names of the exported metrics, shared with the generated Grafana dashboard
+const (
+	availableBikesMetric = "available_bikes"
+	totalBikesMetric     = "total_available_bikes"
+)
+
 // Create Prometheus gauges for each provider's bike availability
 var providerBikes = prometheus.NewGaugeVec(
 	prometheus.GaugeOpts{
-		Name: "available_bikes",
+		Name: availableBikesMetric,
 		Help: "Number of bikes available from providers",
 	},
 	[]string{"location", "url"},
@@ -57,15 +229,132 @@ var providerBikes = prometheus.NewGaugeVec(
 // Create a Prometheus gauge for total available bikes across all providers
 var totalBikesGauge = prometheus.NewGauge(
 	prometheus.GaugeOpts{
-		Name: "total_available_bikes",
-		Help: "Total number of bikes available across all providers",
+		Name: totalBikesMetric,
+		Help: "Total number of bikes available across all providers, from the last good scrape of each within --total-freshness",
+	},
+)
+
+// Gauge for how many providers the total was computed from
+var totalContributorsGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: totalBikesMetric + "_providers",
+		Help: "Number of providers whose last good scrape is recent enough to count towards " + totalBikesMetric,
+	},
+)
+
+// How old a provider's last good scrape may be and still count towards the total; 0 is unlimited
+var totalFreshness = 10 * time.Minute
+
+// Histogram for how long each provider's scrape takes, all feed requests included
+var scrapeDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "gbfs_scrape_duration_seconds",
+		Help:    "Duration of provider scrapes, including every feed request and retry",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	},
+	[]string{"location"},
+)
+
+// Gauge for when each provider was last scraped successfully
+var lastSuccessGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_last_success_timestamp_seconds",
+		Help: "Unix time of the provider's last successful scrape",
 	},
+	[]string{"location"},
 )
 
 func init() {
 	// Register Prometheus metrics
 	prometheus.MustRegister(providerBikes)
-	prometheus.MustRegister(totalBikesGauge)
+	prometheus.MustRegister(totalBikesGauge, totalContributorsGauge)
+	prometheus.MustRegister(scrapeDuration, lastSuccessGauge)
+}
+
+// Function to create a provider, detecting the source from the URL unless given explicitly
+func newProvider(location, url, source string, headers map[string]string) Provider {
+	if source == "" {
+		source = detectSource(url)
+	}
+	if adapter, ok := findSourceAdapter(source); ok {
+		url = adapter.expand(url)
+	}
+	return Provider{Location: location, URL: url, Source: source, Headers: headers}
+}
+
+// Function to report whether the provider's config enables fetching the named feed
+func (p Provider) feedEnabled(name string) bool {
+	names := []string{name}
+	if name == "free_bike_status" || name == "vehicle_status" {
+		// GBFS 3.x renamed free_bike_status to vehicle_status; either name configures both
+		names = []string{"free_bike_status", "vehicle_status"}
+	}
+	listed := len(p.Feeds) == 0
+	for _, name := range names {
+		if slices.Contains(p.SkipFeeds, name) {
+			return false
+		}
+		listed = listed || slices.Contains(p.Feeds, name)
+	}
+	return listed
+}
+
+// Function to return the discovery language to use: the provider's own, else --language
+func (p Provider) language() string {
+	if p.Language != "" {
+		return p.Language
+	}
+	return preferredLanguage
+}
+
+// Function to retrieve the providers handled by this instance's shard
+func getProviders() ([]Provider, error) {
+	providers, err := loadProviders()
+	if err != nil {
+		return nil, err
+	}
+	providers = autoNames.apply(providers)
+	// Reconcile before sharding, so providers of other shards are not marked removed
+	providers = registry.reconcile(providers, time.Now())
+	return shardProviders(providers), nil
+}
+
+// Function to retrieve provider details, preferring --provider-url flags, then
+// Kubernetes discovery, then the --config file, then environment variables
+func loadProviders() ([]Provider, error) {
+	if len(providerFlags) == 0 {
+		if k8sDiscovery != nil {
+			return k8sDiscovery.providers(), nil
+		}
+		if config, ok := loadedConfig.current(); ok {
+			return config.providers(), nil
+		}
+		if configPath != "" {
+			config, err := loadConfig(configPath)
+			if err != nil {
+				return nil, err
+			}
+			return config.providers(), nil
+		}
+		return getProvidersFromEnv()
+	}
+
+	var providers []Provider
+	for _, value := range providerFlags {
+		location, url, ok := strings.Cut(value, "=")
+		// A bare URL, whose query may hold "=" too, is named after its system_information
+		if !ok || strings.Contains(location, "://") {
+			provider := newProvider(provisionalLocation(value), value, "", nil)
+			provider.AutoName = true
+			providers = append(providers, provider)
+			continue
+		}
+		if location == "" || url == "" {
+			return nil, fmt.Errorf("invalid --provider-url %q, expected location=url or a URL", value)
+		}
+		providers = append(providers, newProvider(location, url, "", nil))
+	}
+	return providers, nil
 }
 
 // Function to retrieve provider details from environment variables
@@ -86,10 +375,7 @@ func getProvidersFromEnv() ([]Provider, error) {
 
 		// Only add provider if both fields are present
 		if location != "" && url != "" {
-			providers = append(providers, Provider{
-				Location: location,
-				URL:      url,
-			})
+			providers = append(providers, newProvider(location, url, "", nil))
 		}
 	}
 
@@ -99,128 +385,785 @@ func getProvidersFromEnv() ([]Provider, error) {
 	return providers, nil
 }
 
-// Function to fetch the free bike status URL from the main GBFS feed
-func fetchFreeBikeStatusURL(gbfsMainURL string) (string, error) {
-	resp, err := http.Get(gbfsMainURL)
+// Function to build a GET request carrying the provider's headers, with secrets resolved
+func newProviderRequest(provider Provider, url string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	for name, value := range provider.Headers {
+		expanded, err := secrets.expand(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, expanded)
+	}
+	return req, nil
+}
+
+// HTTP client used for provider feeds, enforcing the egress policy
+var feedClient = egress.client()
 
-	var gbfsMain GBFSMainResponse
-	if err := json.NewDecoder(resp.Body).Decode(&gbfsMain); err != nil {
-		return "", err
+// Function to perform a GET request for a provider and return the response body, recording the request in the trace
+func fetchBody(provider Provider, url string, trace *ScrapeTrace) ([]byte, error) {
+	var raw []byte
+	var err error
+	// Discovery is fetched from the first working of the primary and fallback URLs
+	if url == provider.URL && len(provider.FallbackURLs) > 0 {
+		raw, err = discoveryFailover.fetch(provider, trace)
+	} else {
+		raw, err = fetchRaw(provider, url, trace)
+	}
+	if err != nil {
+		return nil, err
 	}
+	body, err := normalizeFeed(provider, url, raw)
+	return body, redactError(failedBodies.keep(provider, url, raw, err))
+}
 
-	// Loop through the feeds to find the "free_bike_status" URL
-	for _, feed := range gbfsMain.Data.EN.Feeds {
-		if feed.Name == "free_bike_status" {
-			return feed.URL, nil
-		}
+// Function to fetch a feed as served, from a replay, the response cache or upstream
+func fetchRaw(provider Provider, url string, trace *ScrapeTrace) ([]byte, error) {
+	var body []byte
+	var err error
+	switch {
+	case activeReplay != nil:
+		body, err = activeReplay.fetch(url, trace)
+	case trace != nil || len(matchingFaults(provider, url, faultTimeout, faultServerError)) > 0:
+		// Traces show the real requests of a scrape, so they bypass the cache, as do
+		// requests that may fail by --chaos, so the fault hits this provider's fetch
+		body, err = fetchUpstream(provider, url, trace)
+		err = redactError(err)
+	default:
+		body, err = fetchCache.fetch(provider, url, func() ([]byte, error) {
+			return fetchUpstream(provider, url, nil)
+		})
+		err = redactError(err)
 	}
+	// Faults injected with serve --chaos corrupt what this provider reads, not what is cached
+	if fault, injected := pickFault(provider, url, trace, faultTruncate, faultStale); injected && err == nil {
+		body = fault.corruptBody(body)
+	}
+	return body, err
+}
 
-	return "", fmt.Errorf("free_bike_status not found in %s", gbfsMainURL)
+// Function to fetch a URL from the provider's upstream server, retrying transient failures
+func fetchUpstream(provider Provider, url string, trace *ScrapeTrace) ([]byte, error) {
+	return withFetchRetries(provider, trace, func() ([]byte, error) {
+		return fetchUpstreamOnce(provider, url, trace)
+	})
 }
 
-// Function to fetch and parse the free bike status data
-func fetchFreeBikeStatusData(freeBikeStatusURL string) (int, error) {
-	resp, err := http.Get(freeBikeStatusURL)
+// Function to make a single request for a URL to the provider's upstream server.
+// Traced dry runs leave budgets, throttling, scrape stats and stored feeds untouched.
+func fetchUpstreamOnce(provider Provider, url string, trace *ScrapeTrace) ([]byte, error) {
+	start := time.Now()
+	ctx, cancel, kind, err := feedContext(provider, url, trace)
 	if err != nil {
-		return 0, err
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, err
+	}
+	defer cancel()
+	body, header, err := requestUpstream(ctx, kind, provider, url, trace)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyFeed(ctx, kind, provider, url, header, body, trace); err != nil {
+		return nil, err
+	}
+	if trace == nil {
+		activeRecorder.save(url, body)
+		feedProxy.store(url, body)
+		rawFeeds.store(provider, url, body)
+	}
+	return body, nil
+}
+
+// Function to send one request of a feed fetch within its context, counting it
+// against the provider's budget, throttling and scrape stats unless traced
+func requestUpstream(ctx context.Context, kind string, provider Provider, url string, trace *ScrapeTrace) ([]byte, http.Header, error) {
+	start := time.Now()
+	if err := egress.checkURL(url); err != nil {
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, nil, err
+	}
+	ctx, hops := withRedirectLog(ctx)
+	req, err := newProviderRequest(provider, url)
+	if err != nil {
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, nil, err
+	}
+	if err := budgets.allow(provider, start); err != nil {
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, nil, err
+	}
+	if err := throttles.allow(provider, start); err != nil {
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, nil, err
+	}
+	// Faults injected with serve --chaos fail the request without reaching the upstream
+	if fault, injected := pickFault(provider, url, trace, faultTimeout, faultServerError); injected {
+		err := feedTimeoutError(ctx, provider, kind, fault.failRequest(ctx, url), trace)
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, nil, err
+	}
+	resp, err := feedClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// Connection failures may not repeat; timeouts would only spend the budget again
+		if ctx.Err() == nil {
+			err = transientError{error: err}
+		}
+		err = feedTimeoutError(ctx, provider, kind, err, trace)
+		if trace == nil {
+			budgets.record(provider, 1+len(hops.recorded()), 0, time.Now())
+			scrapeStats.record(provider, 1+len(hops.recorded()), 0, time.Since(start), err, time.Now())
+		}
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		trace.recordRedirects(hops.recorded())
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	if trace == nil {
+		throttles.observe(provider, resp, time.Now())
+	}
 
-	// Read the response body
+	// Read the response body, accounting every request of the redirect chain
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		err = feedTimeoutError(ctx, provider, kind, err, trace)
+	} else {
+		err = responseStatusError(url, resp)
+	}
+	if trace == nil {
+		budgets.record(provider, 1+len(hops.recorded()), len(body), time.Now())
+		scrapeStats.record(provider, 1+len(hops.recorded()), len(body), time.Since(start), err, time.Now())
+	}
+	trace.recordRequest(url, resp.StatusCode, len(body), time.Since(start), err)
+	trace.recordRedirects(hops.recorded())
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// Struct for the URLs of the status feeds a provider publishes; empty when not published
+type statusFeeds struct {
+	FreeBikeStatus string
+	StationStatus  string
+	// Version declared by the discovery document, deciding the schema tried first
+	Version string
+}
+
+// Function to fetch the status feed URLs from the provider's main GBFS feed.
+// Free-floating systems publish free_bike_status, dock-based ones station_status, some both.
+func fetchStatusFeeds(provider Provider, trace *ScrapeTrace) (statusFeeds, error) {
+	gbfsMainURL := provider.URL
+	body, err := fetchBody(provider, gbfsMainURL, trace)
+	if err != nil {
+		return statusFeeds{}, err
+	}
+
+	discovery, err := parseDiscovery(body)
+	if err != nil {
+		return statusFeeds{}, failedBodies.keep(provider, gbfsMainURL, body, err)
+	}
+	feeds, err := discovery.preferredFeeds(provider.language())
+	if err != nil {
+		return statusFeeds{}, failedBodies.keep(provider, gbfsMainURL, body, err)
+	}
+	trace.recordCount("feeds", len(feeds))
+	if trace == nil {
+		recordFeedVersion(provider, discovery.detectedVersion())
+	}
+
+	found := statusFeeds{Version: discovery.detectedVersion()}
+	for _, feed := range feeds {
+		if !provider.feedEnabled(feed.Name) {
+			continue
+		}
+		switch feed.Name {
+		case "free_bike_status", "vehicle_status":
+			// GBFS 3.x renamed free_bike_status to vehicle_status
+			found.FreeBikeStatus = feed.URL
+		case "station_status":
+			found.StationStatus = feed.URL
+		}
+	}
+	if found.FreeBikeStatus == "" && found.StationStatus == "" {
+		return statusFeeds{}, fmt.Errorf("neither free_bike_status, vehicle_status nor station_status found in %s", gbfsMainURL)
+	}
+	return found, nil
+}
+
+// Function to fetch and parse the free bike status data with the schema of the
+// declared version, or the adjacent one when that fails. Paginated feeds are merged
+// into one list; vehicles that moved to a later page while paging are kept once.
+func fetchFreeBikeStatusData(provider Provider, freeBikeStatusURL, version string, trace *ScrapeTrace) (FreeBikeStatus, error) {
+	body, err := fetchBody(provider, freeBikeStatusURL, trace)
+	if err != nil {
+		return FreeBikeStatus{}, err
+	}
+
+	freeBikeStatus, err := parseWithSchemas(provider, "free_bike_status", version, body, trace, vehicleStatusParsers)
+	if err != nil {
+		return FreeBikeStatus{}, failedBodies.keep(provider, freeBikeStatusURL, body, err)
+	}
+	seen := make(map[string]bool, len(freeBikeStatus.Data.Bikes))
+	for _, bike := range freeBikeStatus.Data.Bikes {
+		seen[bike.BikeID] = true
 	}
+	pages, err := followPages(provider, freeBikeStatusURL, body, trace, func(page []byte) error {
+		next, err := parseWithSchemas(provider, "free_bike_status", version, page, trace, vehicleStatusParsers)
+		if err != nil {
+			return err
+		}
+		for _, bike := range next.Data.Bikes {
+			if bike.BikeID != "" && seen[bike.BikeID] {
+				continue
+			}
+			seen[bike.BikeID] = true
+			freeBikeStatus.Data.Bikes = append(freeBikeStatus.Data.Bikes, bike)
+		}
+		return nil
+	})
+	if err != nil {
+		return FreeBikeStatus{}, err
+	}
+	if pages > 1 {
+		trace.recordCount("pages", pages)
+	}
+	trace.recordCount("bikes", len(freeBikeStatus.Data.Bikes))
+
+	return freeBikeStatus, nil
+}
 
-	// Parse the response into the FreeBikeStatus struct
-	var freeBikeStatus FreeBikeStatus
-	if err := json.Unmarshal(body, &freeBikeStatus); err != nil {
+// Function to fetch the free bike status data and only count the bikes
+func fetchFreeBikeStatusCount(provider Provider, freeBikeStatusURL string, trace *ScrapeTrace) (int, error) {
+	body, err := fetchBody(provider, freeBikeStatusURL, trace)
+	if err != nil {
+		return 0, err
+	}
+	count, err := countFreeBikeStatus(body)
+	if err != nil {
+		return 0, failedBodies.keep(provider, freeBikeStatusURL, body, err)
+	}
+	// Pages are counted as published, so a vehicle moving between pages may count twice
+	pages, err := followPages(provider, freeBikeStatusURL, body, trace, func(page []byte) error {
+		n, err := countFreeBikeStatus(page)
+		count += n
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
+	if pages > 1 {
+		trace.recordCount("pages", pages)
+	}
+	trace.recordCount("bikes", count)
+	return count, nil
+}
+
+// Function to run the full scrape pipeline for a single provider without touching metrics
+func scrapeProvider(provider Provider, trace *ScrapeTrace) (ScrapeResult, error) {
+	result, err := scrapeProviderSource(withScrapeBudget(provider), trace)
+	return result, redactError(err)
+}
+
+// Function to dispatch a scrape to the provider's source adapter
+func scrapeProviderSource(provider Provider, trace *ScrapeTrace) (ScrapeResult, error) {
+	if adapter, ok := findSourceAdapter(provider.Source); ok {
+		return adapter.scrape(provider, trace)
+	}
+
+	// Step 1: Fetch the status feed URLs from the provider
+	feeds, err := fetchStatusFeeds(provider, trace)
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("fetching status feed URLs from %s: %w", provider.URL, err)
+	}
+
+	// Step 2: Fetch the docking stations, merged with their station_information
+	var result ScrapeResult
+	if feeds.StationStatus != "" {
+		stations, err := fetchStations(provider, feeds.StationStatus, feeds.Version, trace)
+		if err != nil {
+			return ScrapeResult{}, fmt.Errorf("fetching station status from %s: %w", feeds.StationStatus, err)
+		}
+		result = stations
+	}
+	if feeds.FreeBikeStatus == "" {
+		return result, nil
+	}
+
+	// Step 3: Fetch the free-floating bikes, or only their number in count-only mode
+	if countOnly {
+		count, err := fetchFreeBikeStatusCount(provider, feeds.FreeBikeStatus, trace)
+		if err != nil {
+			return ScrapeResult{}, fmt.Errorf("fetching free bike status data from %s: %w", feeds.FreeBikeStatus, err)
+		}
+		result.BikeCount = count
+		return result, nil
+	}
+	status, err := fetchFreeBikeStatusData(provider, feeds.FreeBikeStatus, feeds.Version, trace)
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("fetching free bike status data from %s: %w", feeds.FreeBikeStatus, err)
+	}
+	result.Bikes = status.Data.Bikes
+	if ttl := time.Duration(status.TTL) * time.Second; feeds.StationStatus == "" || ttl < result.TTL {
+		result.TTL = ttl
+	}
+	// The older of the two feeds decides how fresh the result is
+	if updated := time.Time(status.LastUpdated); result.LastUpdated.IsZero() || (!updated.IsZero() && updated.Before(result.LastUpdated)) {
+		result.LastUpdated = updated
+	}
+	return result, nil
+}
 
-	// Return the number of bikes
-	return len(freeBikeStatus.Data.Bikes), nil
+// Function to scrape a provider and capture the outcome as a normalized snapshot
+func scrapeSnapshot(provider Provider) ProviderSnapshot {
+	snapshot := ProviderSnapshot{
+		Location:   provider.Location,
+		URL:        redactURL(provider.URL),
+		ScrapedAt:  clock.Now().UTC(),
+		Timezone:   providerTimezone(provider),
+		Deployment: provider.Deployment,
+	}
+	result, err := scrapeProvider(provider, nil)
+	if err != nil {
+		snapshot.Error = err.Error()
+		return snapshot
+	}
+	snapshot.AvailableBikes = result.AvailableBikes()
+	return snapshot
 }
 
-// Function to fetch data and update Prometheus metrics
+// Function to build the per-provider metric updates for a scrape result
+func providerMetricUpdates(provider Provider, numBikes int) []MetricUpdate {
+	return []MetricUpdate{
+		{
+			Metric: availableBikesMetric,
+			Labels: prometheus.Labels{
+				"location": metricLocation(provider),
+				"url":      redactURL(provider.URL),
+			},
+			Value: float64(numBikes),
+			gauge: providerBikes,
+		},
+	}
+}
+
+// Function to fetch data of every provider and update Prometheus metrics
 func ingestGBFSData() {
-	providers, err := getProvidersFromEnv()
+	ingestProviders(false)
+}
+
+// Function to run an ingestion cycle; with onlyDue and adaptive polling only the
+// providers whose interval has elapsed are scraped. Returns the providers that failed.
+func ingestProviders(onlyDue bool) []string {
+	// With leader election only the leader scrapes upstream feeds, and api-only replicas never do
+	if apiOnly || !leaderElection.isLeader() {
+		if liveState.shared == nil {
+			markReady()
+		}
+		return nil
+	}
+
+	// Providers registered with only a URL are named before their first scrape
+	if loaded, err := loadProviders(); err == nil {
+		autoNames.resolve(loaded)
+	}
+	providers, err := getProviders()
 	if err != nil {
 		log.Printf("Error retrieving providers from environment: %v", err)
-		return
+		return nil
 	}
 
-	totalBikes := 0
+	// Providers that are not due, paused or failing still count towards the total
+	configured := providers
+	if onlyDue {
+		providers = providerIntervals.dueProviders(providers, time.Now())
+		providers = adaptivePolling.dueProviders(providers, time.Now())
+		providers = skipQuietProviders(providers, time.Now())
+	}
+	// Providers over their daily budget are paused until UTC midnight
+	providers = budgets.activeProviders(providers, time.Now())
+	// And providers that asked to slow down until they may be scraped again
+	providers = throttles.activeProviders(providers, time.Now())
+	if onlyDue && len(providers) == 0 {
+		return nil
+	}
 
-	// Fetch and update Prometheus metrics for each provider
-	for _, provider := range providers {
-		// Step 1: Fetch the free_bike_status URL from the provider
-		freeBikeStatusURL, err := fetchFreeBikeStatusURL(provider.URL)
+	activeRecorder.beginCycle()
+	snapshots := make([]ProviderSnapshot, 0, len(providers))
+
+	// Scrape the providers concurrently, then apply the outcomes in order
+	start := time.Now()
+	warmingUp := warmup.active.Load()
+	outcomes := make([]scrapeOutcome, len(providers))
+	ingestionPool().run(len(providers), func(i int) {
+		outcomes[i] = scrapeForIngestion(providers[i])
+	})
+	// The warm-up's duration says nothing about the regular cycles
+	if !warmingUp {
+		scrapeWorkers.tune(len(providers), time.Since(start))
+	}
+	activeRecorder.saveLicenses(outcomes)
+	if ingestionCtx.Err() != nil {
+		// Requests cancelled by shutdown are not provider failures
+		log.Printf("Discarding the ingestion cycle interrupted by shutdown")
+		return nil
+	}
+
+	// Update Prometheus metrics for each provider
+	succeeded := 0
+	var failed []string
+	for _, outcome := range outcomes {
+		provider, snapshot, closed := outcome.provider, outcome.snapshot, outcome.snapshot.Closed
+		result, err := outcome.result, outcome.err
 		if err != nil {
-			log.Printf("Error fetching free bike status URL from %s: %v", provider.URL, err)
+			failed = append(failed, provider.Location)
+		}
+		// Providers too slow for the warm-up budget are left to the first regular cycle
+		if err != nil && warmingUp {
 			continue
 		}
-
-		// Step 2: Fetch the number of available bikes
-		numBikes, err := fetchFreeBikeStatusData(freeBikeStatusURL)
+		incidents.observe(provider.Location, err, result.LastUpdated, closed, snapshot.ScrapedAt)
 		if err != nil {
-			log.Printf("Error fetching free bike status data from %s: %v", freeBikeStatusURL, err)
+			scrapeFailures.WithLabelValues(metricLocation(provider)).Inc()
+			scrapeErrorLog.failure("Provider "+provider.Location, "Error scraping provider %s: %v", provider.Location, err)
+			applyFailurePolicy(provider)
+			providerHealth.observe(provider, false, snapshot.ScrapedAt)
+			snapshot.Error = err.Error()
+			snapshots = append(snapshots, snapshot)
+			continue
+		}
+		scrapeErrorLog.success("Provider " + provider.Location)
+		succeeded++
+		liveState.update(provider, result)
+		snapshot.EstimatedTrips = churn.observe(provider, result.Bikes, snapshot.ScrapedAt)
+		dwells.observe(provider, result.Bikes, snapshot.ScrapedAt)
+		recordStationHistory(provider, result.Stations, snapshot.ScrapedAt)
+		numBikes := result.AvailableBikes()
+		snapshot.AvailableBikes = numBikes
+		snapshot.Weather = weather.observe(provider, result, snapshot.ScrapedAt)
+		snapshots = append(snapshots, snapshot)
+
+		// A closed system is reported through gbfs_system_open, not as zero availability
+		if closed {
+			providerBikes.DeleteLabelValues(metricLocation(provider), redactURL(provider.URL))
+			derivedMetrics.forget(provider.Location)
 			continue
 		}
 
-		// Log the bike availability for each provider
-		fmt.Printf("Provider Location: %s, Available Bikes: %d\n", provider.Location, numBikes)
+		slog.Debug("Provider ingested", "location", provider.Location, "available_bikes", numBikes)
+
+		// Update the Prometheus gauges for this provider
+		for _, update := range providerMetricUpdates(provider, numBikes) {
+			update.apply()
+		}
+		// Systems whose vehicle feeds are disabled get no per-category series
+		if !countOnly && provider.feedEnabled("free_bike_status") {
+			updateCategoryMetrics(provider, result.Bikes)
+		}
+		updateFleetMetric(provider, result, snapshot.ScrapedAt)
+		fleetCaps.observe(provider, result, snapshot.ScrapedAt)
+		serviceAreas.observe(provider, result, snapshot.ScrapedAt)
+		derivedMetrics.observe(provider, result)
+		providerHealth.observe(provider, true, snapshot.ScrapedAt)
+		updateStationMetrics(provider, result.Stations)
+		updateStationStatusMetrics(provider, result.Stations)
+		stationAlerts.evaluate(provider, result.Stations, snapshot.ScrapedAt)
+		stationEvents.observe(provider, result.Stations, snapshot.ScrapedAt)
+		subscriptions.evaluate(provider, result.Stations, snapshot.ScrapedAt)
+	}
+
+	// Update the total available bikes gauge and the per-tag rollups
+	totalBikes := updateTotalBikes(configuredStates(configured), providerQuiet, clock.Now())
+	updateRollups(liveState.all(), providerQuiet)
+	updateMetadataInfo(configured)
 
-		// Update the Prometheus gauge for this provider
-		providerBikes.With(prometheus.Labels{
-			"location": provider.Location,
-			"url":      provider.URL,
-		}).Set(float64(numBikes))
+	slog.Info("Ingested data", "providers", len(providers), "succeeded", succeeded, "total_available_bikes", totalBikes)
 
-		totalBikes += numBikes
+	// Cross-reference the fresh data with transit stops, if configured; a pending
+	// update is replaced, as it would read the same latest state
+	if transitStops != nil {
+		postProcessing.submit("transit_stops", "transit_stops", true, func() error {
+			transitStops.update(liveState.all())
+			return nil
+		})
+	}
+	// And with census districts for equity reporting
+	if districts != nil {
+		postProcessing.submit("districts", "districts", true, func() error {
+			districts.update(liveState.all())
+			return nil
+		})
 	}
 
-	// Update the total available bikes gauge
-	totalBikesGauge.Set(float64(totalBikes))
+	// Hand the cycle's results to any configured sinks, cycle after cycle
+	postProcessing.submit("sinks", "sinks", false, func() error {
+		publishToSinks(snapshots)
+		return nil
+	})
+	// Ready once some data was ingested; with no providers there is nothing to wait for
+	if succeeded > 0 || len(configured) == 0 {
+		markReady()
+	}
+	return failed
+}
 
-	// Log the total number of bikes available
-	fmt.Printf("Total Available Bikes: %d\n", totalBikes)
+// Function to return the latest states of the given providers
+func configuredStates(providers []Provider) []ProviderState {
+	configured := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		configured[provider.Location] = true
+	}
+	var states []ProviderState
+	for _, state := range liveState.all() {
+		if configured[state.Provider.Location] {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// Function to set the total from each provider's last good scrape, so one failing
+// provider does not dip the total. Closed systems and scrapes older than
+// --total-freshness are left out; the number of providers counted is exported too.
+func updateTotalBikes(states []ProviderState, closed func(string) bool, now time.Time) int {
+	total, contributors := 0, 0
+	for _, state := range states {
+		if closed(state.Provider.Location) || (totalFreshness > 0 && now.Sub(state.UpdatedAt) > totalFreshness) {
+			continue
+		}
+		total += ScrapeResult{Bikes: state.Bikes, Stations: state.Stations, BikeCount: state.BikeCount}.AvailableBikes()
+		contributors++
+	}
+	totalBikesGauge.Set(float64(total))
+	totalContributorsGauge.Set(float64(contributors))
+	return total
+}
 
-	log.Printf("Ingested data for %d providers. Total bikes available: %d", len(providers), totalBikes)
+// Struct for the scrape of one provider during an ingestion cycle
+type scrapeOutcome struct {
+	provider Provider
+	snapshot ProviderSnapshot
+	result   ScrapeResult
+	err      error
 }
 
-// Background Goroutine to automate ingestion every 5 minutes
-func startAutomatedIngestion() {
+// Function to scrape one provider for an ingestion cycle; safe to run concurrently
+func scrapeForIngestion(provider Provider) scrapeOutcome {
+	provider.SystemID = providerSystemID(provider)
+	provider.License = providerLicense(provider)
+	snapshot := ProviderSnapshot{
+		Location:   provider.Location,
+		URL:        redactURL(provider.URL),
+		ScrapedAt:  clock.Now().UTC(),
+		Timezone:   providerTimezone(provider),
+		Deployment: provider.Deployment,
+		License:    provider.License,
+	}
+	closed := providerClosed(provider, snapshot.ScrapedAt)
+	recordQuietScrape(provider, closed, snapshot.ScrapedAt)
+	snapshot.Closed = closed
+	if closed {
+		systemOpenGauge.WithLabelValues(metricLocation(provider)).Set(0)
+	} else {
+		systemOpenGauge.WithLabelValues(metricLocation(provider)).Set(1)
+	}
+
+	start := time.Now()
+	result, err := scrapeProvider(warmUpBudget(provider), nil)
+	scrapeDuration.WithLabelValues(metricLocation(provider)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		lastSuccessGauge.WithLabelValues(metricLocation(provider)).Set(float64(time.Now().Unix()))
+		categorizeVehicles(provider, result.Bikes)
+		vehiclePrices.observe(provider, snapshot.ScrapedAt)
+		annotateStations(provider, result.Stations)
+		pricing.check(provider, snapshot.ScrapedAt)
+		providerIntervals.observeTTL(provider.Location, result.TTL, snapshot.ScrapedAt)
+		operatorLinks.check(provider, snapshot.ScrapedAt)
+	}
+	// A failed warm-up scrape must not delay the provider's retry
+	if err == nil || !warmup.active.Load() {
+		adaptivePolling.observe(provider, result, err, time.Now())
+	}
+	return scrapeOutcome{provider: provider, snapshot: snapshot, result: result, err: err}
+}
+
+// Background Goroutine to automate ingestion at a fixed interval, or per provider with adaptive polling
+func startAutomatedIngestion(interval time.Duration) {
+	// Adaptive polling runs cycles as often as the shortest interval
+	if adaptivePolling != nil {
+		scrapeWorkers.setTarget(adaptivePolling.min)
+	} else {
+		scrapeWorkers.setTarget(interval)
+	}
+	ingestionLoops.Add(1)
 	go func() {
+		defer ingestionLoops.Done()
+		// A warm-up that reached every provider stands in for the first cycle
+		warm := warmup.timeout > 0 && warmUpIngestion()
 		for {
 			// Run the ingestion process
-			ingestGBFSData()
-			// Wait for 5 minutes before the next ingestion
-			time.Sleep(5 * time.Minute)
+			if !warm {
+				ingestProviders(true)
+			}
+			warm = false
+			// Wait for the configured interval, or until a provider with a shorter one is due
+			wait := interval
+			if adaptivePolling != nil {
+				wait = adaptivePolling.untilNext(time.Now())
+			}
+			if !waitForNextCycle(providerIntervals.untilNext(wait, time.Now())) {
+				log.Printf("Ingestion stopped")
+				return
+			}
 		}
 	}()
 }
 
-func main() {
+// Function to run the exporter HTTP server, starting background ingestion first
+func runServer(listenAddr string, startIngestion func()) error {
 	// Start automated ingestion in the background
-	startAutomatedIngestion()
+	startIngestion()
 
 	// Create a new Gin router
-	router := gin.Default()
+	router := newRouter()
+	router.Use(deploymentScope)
 
 	// Define the API route for manual ingestion (optional)
-	router.POST("/ingest", func(c *gin.Context) {
+	router.POST("/ingest", requireRole(roleOperator), func(c *gin.Context) {
 		ingestGBFSData()
 		c.String(http.StatusOK, "Manual ingestion complete")
 	})
 
-	// Expose Prometheus metrics on /metrics endpoint
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	// Route to re-read the --config file without restarting
+	router.POST("/reload", requireRole(roleAdmin), reloadHandler)
+
+	// Debug route to dry-run a single provider scrape without updating metrics
+	router.POST("/debug/scrape/:provider", requireRole(roleOperator), debugScrapeHandler)
+
+	// Generated Grafana dashboard matching the exported metrics and providers
+	router.GET("/grafana/dashboard.json", requireRole(roleViewer), grafanaDashboardHandler)
+
+	// Optional MDS provider API translation of the ingested vehicles
+	if mdsEnabled {
+		router.GET("/mds/vehicles", requireRole(roleViewer), mdsVehiclesHandler)
+	}
+
+	// Caching proxy for the raw upstream feeds
+	if feedProxy != nil {
+		router.GET("/proxy/:provider/:feed", requireRole(roleViewer), proxyFeedHandler)
+	}
+
+	// Normalized GBFS re-export per provider, e.g. for OpenTripPlanner updaters
+	router.GET("/gbfs/:provider/:feed", requireRole(roleViewer), reexportFeedHandler)
 
-	// Run the server on port 8080
-	router.Run(":8080")
+	// Every provider merged into one virtual GBFS v3 system
+	router.GET("/gbfs-v3/:feed", requireRole(roleViewer), aggregateFeedHandler)
+
+	// Bike availability around GTFS transit stops
+	router.GET("/api/v1/transit-stops", requireRole(roleViewer), transitStopsHandler)
+
+	// Availability of a provider at every stored cycle, optionally with its stations
+	router.GET("/api/v1/history", requireRole(roleViewer), historyHandler)
+
+	// Daily aggregates compacted from the stored history
+	router.GET("/api/v1/daily", requireRole(roleViewer), dailyHandler)
+
+	// Downsampled occupancy history of a single station
+	router.GET("/api/v1/stations/:id/history", requireRole(roleViewer), stationHistoryHandler)
+
+	// Uptime, staleness and error rates of a provider's feeds from stored scrapes
+	router.GET("/api/v1/providers/:name/sla", requireRole(roleViewer), providerSLAHandler)
+
+	// Availability of a provider grouped by temperature and precipitation
+	router.GET("/api/v1/providers/:name/weather", requireRole(roleViewer), providerWeatherHandler)
+
+	// Availability forecast from the snapshot store
+	router.GET("/api/v1/forecast", requireRole(roleViewer), forecastHandler)
+
+	// Pending and firing station alerts
+	router.GET("/api/v1/alerts/stations", requireRole(roleViewer), stationAlertsHandler)
+
+	// Deployed vehicles of each provider compared with its permitted fleet cap
+	router.GET("/api/v1/compliance/fleet", requireRole(roleViewer), fleetComplianceHandler)
+
+	// Service areas outlined from where vehicles and stations were seen, as GeoJSON
+	router.GET("/api/v1/service-areas", requireRole(roleViewer), serviceAreasHandler)
+	router.GET("/api/v1/providers/:name/service-area", requireRole(roleViewer), providerServiceAreaHandler)
+
+	// Current pricing plans and their change history
+	router.GET("/api/v1/providers/:name/pricing", requireRole(roleViewer), providerPricingHandler)
+
+	// Stations with their virtual, charging and parking attributes
+	router.GET("/api/v1/providers/:name/stations", requireRole(roleViewer), providerStationsHandler)
+
+	// Last fetched feed bodies as published, with what normalization dropped, coerced or defaulted
+	router.GET("/api/v1/providers/:name/raw/:feed", requireRole(roleViewer), providerRawFeedHandler)
+
+	// Consumer webhooks notified when a station's bikes or docks cross their threshold
+	router.GET("/api/v1/subscriptions", requireRole(roleViewer), subscriptionsHandler)
+	router.POST("/api/v1/subscriptions", requireRole(roleOperator), createSubscriptionHandler)
+	router.DELETE("/api/v1/subscriptions/:id", requireRole(roleOperator), deleteSubscriptionHandler)
+
+	// Server-sent events for every station change, for live displays
+	router.GET("/api/v1/stream/stations", requireRole(roleViewer), stationStreamHandler)
+
+	// Latest ingested providers and bikes, for clients that query data instead of metrics
+	router.GET("/api/v1/providers", requireRole(roleViewer), providersHandler)
+	router.GET("/api/v1/providers/:name/bikes", requireRole(roleViewer), providerBikesHandler)
+
+	// Latest cycle's snapshots and the key they are signed with, with serve --signing-key
+	router.GET("/api/v1/snapshots/latest", requireRole(roleViewer), latestSnapshotsHandler)
+	router.GET("/.well-known/jwks.json", jwksHandler)
+
+	// Bikes per capita and coverage of census districts
+	router.GET("/api/v1/districts", requireRole(roleViewer), districtsHandler)
+
+	// Provider outages and stale feeds, newest first
+	router.GET("/api/v1/incidents", requireRole(roleViewer), incidentsHandler)
+
+	// Upstream requests and bytes per provider today, against their budgets
+	router.GET("/api/v1/budgets", requireRole(roleViewer), budgetsHandler)
+
+	// Requests, bytes, time and errors caused upstream, per provider and day
+	router.GET("/api/v1/scrape-stats", requireRole(roleViewer), scrapeStatsHandler)
+
+	// Prometheus HTTP service discovery listing one target per provider
+	router.GET("/prometheus/sd", requireRole(roleViewer), prometheusSDHandler)
+
+	// Provider management
+	router.GET("/admin/providers", requireRole(roleAdmin), adminProvidersHandler)
+	router.POST("/admin/providers/:name/deactivate", requireRole(roleAdmin), providerActivationHandler(true))
+	router.POST("/admin/providers/:name/reactivate", requireRole(roleAdmin), providerActivationHandler(false))
+	router.DELETE("/admin/providers/:name", requireRole(roleAdmin), purgeProviderHandler)
+
+	// Expose Prometheus metrics on /metrics endpoint, optionally filtered by ?location=
+	router.GET("/metrics", requireRole(roleViewer), metricsHandler)
+
+	// Totals and health only, for a central Prometheus federating regional exporters
+	router.GET("/federate", requireRole(roleViewer), federateHandler)
+
+	// Public status page of the providers' feed reliability, without API keys
+	router.GET("/status", statusPageHandler)
+	router.GET("/status/badges/:name", statusBadgeHandler)
+
+	// The API and metrics of one config deployment, e.g. /deployments/benelux/api/v1/incidents
+	router.Any("/deployments/:deployment/*path", deploymentRouteHandler(router))
+
+	// Liveness, readiness/startup and preStop endpoints for orchestrators
+	router.GET("/healthz", healthzHandler)
+	router.GET("/readyz", readyzHandler)
+	router.GET("/prestop", preStopHandler)
+
+	// Run the server on the configured address until terminated
+	return serveUntilSignal(listenAddr, router)
+}
+
+func main() {
+	installLogRedaction()
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(exitCode(err))
+	}
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the MDS provider API the /mds/vehicles output follows
+const mdsVersion = "1.2.0"
+
+// Namespace for the name-based UUIDs generated for MDS device and provider IDs
+var mdsNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// Whether the MDS translation endpoint is served
+var mdsEnabled bool
+
+// Struct for a vehicle in the MDS provider /vehicles response
+type mdsVehicle struct {
+	DeviceID         string       `json:"device_id"`
+	ProviderID       string       `json:"provider_id"`
+	ProviderName     string       `json:"provider_name"`
+	VehicleID        string       `json:"vehicle_id"`
+	VehicleType      string       `json:"vehicle_type"`
+	PropulsionTypes  []string     `json:"propulsion_types"`
+	LastEventTime    int64        `json:"last_event_time"`
+	LastVehicleState string       `json:"last_vehicle_state"`
+	LastEventTypes   []string     `json:"last_event_types"`
+	LastTelemetry    mdsTelemetry `json:"last_telemetry"`
+}
+
+// Struct for the telemetry point attached to an MDS vehicle
+type mdsTelemetry struct {
+	DeviceID  string `json:"device_id"`
+	Timestamp int64  `json:"timestamp"`
+	GPS       struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	} `json:"gps"`
+}
+
+// Function to derive a stable version 5 UUID from a name, so IDs survive restarts
+func mdsUUID(name string) string {
+	h := sha1.New()
+	h.Write(mdsNamespace[:])
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// Function to map a GBFS vehicle onto the MDS vehicle state machine
+func mdsVehicleState(bike Bike) (string, []string) {
+	switch {
+	case bool(bike.IsDisabled):
+		return "non_operational", []string{"maintenance"}
+	case bool(bike.IsReserved):
+		return "reserved", []string{"reservation_start"}
+	default:
+		return "available", []string{"service_start"}
+	}
+}
+
+// Function to translate one provider's state into MDS vehicles
+func mdsVehicles(state ProviderState) []mdsVehicle {
+	providerID := mdsUUID("provider:" + state.Provider.Location)
+	eventTime := state.UpdatedAt.UnixMilli()
+
+	vehicles := make([]mdsVehicle, 0, len(state.Bikes))
+	for _, bike := range vehiclePrivacy.bikes(state.Bikes) {
+		deviceID := mdsUUID(state.Provider.Location + ":" + bike.BikeID)
+		vehicleState, eventTypes := mdsVehicleState(bike)
+		vehicleType, propulsion := mdsVehicleKind(bike.Category)
+
+		vehicle := mdsVehicle{
+			DeviceID:         deviceID,
+			ProviderID:       providerID,
+			ProviderName:     state.Provider.Location,
+			VehicleID:        bike.BikeID,
+			VehicleType:      vehicleType,
+			PropulsionTypes:  propulsion,
+			LastEventTime:    eventTime,
+			LastVehicleState: vehicleState,
+			LastEventTypes:   eventTypes,
+		}
+		vehicle.LastTelemetry.DeviceID = deviceID
+		vehicle.LastTelemetry.Timestamp = eventTime
+		vehicle.LastTelemetry.GPS.Lat = bike.Lat
+		vehicle.LastTelemetry.GPS.Lng = bike.Lon
+		vehicles = append(vehicles, vehicle)
+	}
+	return vehicles
+}
+
+// Handler for GET /mds/vehicles, optionally restricted with ?provider=
+func mdsVehiclesHandler(c *gin.Context) {
+	var states []ProviderState
+	if name := c.Query("provider"); name != "" {
+		state, ok := liveState.get(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider " + name})
+			return
+		}
+		states = append(states, state)
+	} else {
+		states = liveState.all()
+	}
+
+	vehicles := []mdsVehicle{}
+	for _, state := range states {
+		vehicles = append(vehicles, mdsVehicles(state)...)
+	}
+
+	c.Header("Content-Type", "application/vnd.mds.provider+json;version="+mdsVersion[:3])
+	c.JSON(http.StatusOK, gin.H{
+		"version":      mdsVersion,
+		"data":         gin.H{"vehicles": vehicles},
+		"last_updated": time.Now().UnixMilli(),
+		"ttl":          0,
+	})
+}
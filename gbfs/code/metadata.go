@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Name of the info metric carrying provider metadata as labels
+const providerMetadataMetric = "gbfs_provider_metadata_info"
+
+// Info metric of the metadata keys chosen with --metadata-info-label; nil when none are
+var providerMetadataInfo *prometheus.GaugeVec
+
+// Metadata keys exported as labels of the info metric, in label order
+var providerMetadataKeys []string
+
+// Function to create and register the info metric for the given metadata keys, which
+// must be valid label names; keys a provider lacks are exported as empty labels
+func configureMetadataInfo(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	seen := map[string]bool{"location": true}
+	for _, key := range keys {
+		if !labelNamePattern.MatchString(key) || strings.HasPrefix(key, "__") {
+			return fmt.Errorf("--metadata-info-label %q is not a valid label name", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("--metadata-info-label %q is given twice or clashes with location", key)
+		}
+		seen[key] = true
+	}
+	providerMetadataKeys = keys
+	providerMetadataInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: providerMetadataMetric,
+			Help: "Static metadata of the provider from its config, always 1; join on location to add it to other series",
+		},
+		append([]string{"location"}, keys...),
+	)
+	return prometheus.Register(providerMetadataInfo)
+}
+
+// Function to replace the info series with those of the configured providers, so
+// edited metadata and removed providers disappear
+func updateMetadataInfo(providers []Provider) {
+	if providerMetadataInfo == nil {
+		return
+	}
+	sorted := append([]Provider{}, providers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Location < sorted[j].Location })
+	providerMetadataInfo.Reset()
+	for _, provider := range sorted {
+		values := []string{metricLocation(provider)}
+		for _, key := range providerMetadataKeys {
+			values = append(values, provider.Metadata[key])
+		}
+		providerMetadataInfo.WithLabelValues(values...).Set(1)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct for a synthetic GBFS system that evolves on every tick
+type mockSystem struct {
+	baseURL  string
+	ttl      int
+	lat, lon float64
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	vehicles []mockVehicle
+	stations []mockStation
+	updated  time.Time
+}
+
+// Struct for a synthetic free-floating vehicle
+type mockVehicle struct {
+	ID         string
+	Lat, Lon   float64
+	IsReserved bool
+	IsDisabled bool
+}
+
+// Struct for a synthetic docking station
+type mockStation struct {
+	ID        string
+	Name      string
+	Lat, Lon  float64
+	Capacity  int
+	Available int
+}
+
+// Function to create a mock system with the given fleet centred on lat/lon
+func newMockSystem(baseURL string, vehicles, stations int, lat, lon float64, seed int64) *mockSystem {
+	m := &mockSystem{
+		baseURL: baseURL,
+		ttl:     60,
+		lat:     lat,
+		lon:     lon,
+		rng:     rand.New(rand.NewSource(seed)),
+		updated: time.Now(),
+	}
+	for i := 0; i < vehicles; i++ {
+		vLat, vLon := m.randomPoint()
+		m.vehicles = append(m.vehicles, mockVehicle{ID: fmt.Sprintf("bike-%04d", i), Lat: vLat, Lon: vLon})
+	}
+	for i := 0; i < stations; i++ {
+		sLat, sLon := m.randomPoint()
+		capacity := 10 + m.rng.Intn(20)
+		m.stations = append(m.stations, mockStation{
+			ID:        fmt.Sprintf("station-%03d", i),
+			Name:      fmt.Sprintf("Mock Station %d", i),
+			Lat:       sLat,
+			Lon:       sLon,
+			Capacity:  capacity,
+			Available: m.rng.Intn(capacity + 1),
+		})
+	}
+	return m
+}
+
+// Function to return a random point within roughly 3km of the system centre
+func (m *mockSystem) randomPoint() (float64, float64) {
+	return m.lat + (m.rng.Float64()-0.5)*0.05, m.lon + (m.rng.Float64()-0.5)*0.08
+}
+
+// Function to advance the simulation: vehicles move, get reserved or disabled,
+// and station occupancy follows a daily cycle with noise
+func (m *mockSystem) tick(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.vehicles {
+		v := &m.vehicles[i]
+		if m.rng.Float64() < 0.1 {
+			v.Lat, v.Lon = m.randomPoint()
+		}
+		v.IsReserved = m.rng.Float64() < 0.05
+		v.IsDisabled = m.rng.Float64() < 0.02
+	}
+
+	// Availability dips around the morning and evening rush
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	demand := 0.5 + 0.3*math.Cos((hour-8)*math.Pi/12)
+	for i := range m.stations {
+		s := &m.stations[i]
+		target := int(float64(s.Capacity) * (1 - demand))
+		s.Available = clampInt(target+m.rng.Intn(5)-2, 0, s.Capacity)
+	}
+	m.updated = now
+}
+
+// Function to clamp v into [lo, hi]
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// Function to wrap feed data in the standard GBFS envelope
+func (m *mockSystem) envelope(data interface{}) gin.H {
+	return gin.H{
+		"last_updated": m.updated.Unix(),
+		"ttl":          m.ttl,
+		"version":      "2.3",
+		"data":         data,
+	}
+}
+
+// Function to register the mock feeds on a router
+func (m *mockSystem) routes(router gin.IRouter) {
+	router.GET("/gbfs.json", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		feeds := []GBFSFeed{}
+		for _, name := range []string{"system_information", "free_bike_status", "station_information", "station_status"} {
+			feeds = append(feeds, GBFSFeed{Name: name, URL: m.baseURL + "/" + name + ".json"})
+		}
+		c.JSON(http.StatusOK, m.envelope(gin.H{"en": gin.H{"feeds": feeds}}))
+	})
+
+	router.GET("/system_information.json", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		c.JSON(http.StatusOK, m.envelope(gin.H{
+			"system_id": "mock",
+			"language":  "en",
+			"name":      "Mock Bike Share",
+			"timezone":  "UTC",
+		}))
+	})
+
+	router.GET("/free_bike_status.json", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		bikes := []gin.H{}
+		for _, v := range m.vehicles {
+			bikes = append(bikes, gin.H{
+				"bike_id":     v.ID,
+				"lat":         v.Lat,
+				"lon":         v.Lon,
+				"is_reserved": v.IsReserved,
+				"is_disabled": v.IsDisabled,
+			})
+		}
+		c.JSON(http.StatusOK, m.envelope(gin.H{"bikes": bikes}))
+	})
+
+	router.GET("/station_information.json", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stations := []gin.H{}
+		for _, s := range m.stations {
+			stations = append(stations, gin.H{
+				"station_id": s.ID,
+				"name":       s.Name,
+				"lat":        s.Lat,
+				"lon":        s.Lon,
+				"capacity":   s.Capacity,
+			})
+		}
+		c.JSON(http.StatusOK, m.envelope(gin.H{"stations": stations}))
+	})
+
+	router.GET("/station_status.json", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stations := []gin.H{}
+		for _, s := range m.stations {
+			stations = append(stations, gin.H{
+				"station_id":          s.ID,
+				"num_bikes_available": s.Available,
+				"num_docks_available": s.Capacity - s.Available,
+				"is_installed":        true,
+				"is_renting":          true,
+				"is_returning":        true,
+				"last_reported":       m.updated.Unix(),
+			})
+		}
+		c.JSON(http.StatusOK, m.envelope(gin.H{"stations": stations}))
+	})
+}
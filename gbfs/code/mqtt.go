@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Struct for a sink publishing per-provider availability to an MQTT broker,
+// optionally announcing each provider as a Home Assistant sensor
+type mqttSink struct {
+	client          mqtt.Client
+	prefix          string
+	discoveryPrefix string
+
+	mu        sync.Mutex
+	announced map[string]bool
+}
+
+// Function to connect to the broker and create the sink. An empty
+// discoveryPrefix disables Home Assistant discovery messages.
+func newMQTTSink(broker, clientID, prefix, discoveryPrefix string) (*mqttSink, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectTimeout(10*time.Second).
+		// Marks every sensor unavailable in Home Assistant if the exporter disappears
+		SetWill(prefix+"/status", "offline", 1, true)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(15 * time.Second) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("connecting to MQTT broker %s: %w", broker, err)
+	}
+
+	sink := &mqttSink{
+		client:          client,
+		prefix:          strings.TrimSuffix(prefix, "/"),
+		discoveryPrefix: strings.TrimSuffix(discoveryPrefix, "/"),
+		announced:       map[string]bool{},
+	}
+	if err := sink.publish(sink.prefix+"/status", "online"); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Function to return the sink name used in logs
+func (s *mqttSink) Name() string {
+	return "mqtt"
+}
+
+// Function to publish a retained message and wait for the broker to accept it
+func (s *mqttSink) publish(topic string, payload interface{}) error {
+	token := s.client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// Function to turn a provider location into a topic- and entity-id-safe slug
+func mqttSlug(location string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, location)
+}
+
+// Function to build the Home Assistant discovery config for a provider sensor
+func (s *mqttSink) discoveryConfig(location, slug string) ([]byte, error) {
+	base := s.prefix + "/" + slug
+	return json.Marshal(map[string]interface{}{
+		"name":                  location + " available bikes",
+		"unique_id":             "gbfs_" + slug + "_available_bikes",
+		"object_id":             "gbfs_" + slug + "_available_bikes",
+		"state_topic":           base + "/available_bikes",
+		"unit_of_measurement":   "bikes",
+		"state_class":           "measurement",
+		"icon":                  "mdi:bike",
+		"availability_mode":     "all",
+		"availability":          []map[string]string{{"topic": s.prefix + "/status"}, {"topic": base + "/availability"}},
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"device": map[string]interface{}{
+			"identifiers":  []string{"gbfs_" + slug},
+			"name":         "GBFS " + location,
+			"manufacturer": "GBFS exporter",
+		},
+	})
+}
+
+// Function to publish a cycle's snapshots, announcing new providers first
+func (s *mqttSink) Publish(snapshots []ProviderSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, snapshot := range snapshots {
+		slug := mqttSlug(snapshot.Location)
+		base := s.prefix + "/" + slug
+
+		if s.discoveryPrefix != "" && !s.announced[slug] {
+			config, err := s.discoveryConfig(snapshot.Location, slug)
+			if err != nil {
+				return err
+			}
+			topic := s.discoveryPrefix + "/sensor/gbfs_" + slug + "/config"
+			if err := s.publish(topic, config); err != nil {
+				return err
+			}
+			s.announced[slug] = true
+		}
+
+		if snapshot.Error != "" {
+			if err := s.publish(base+"/availability", "offline"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.publish(base+"/available_bikes", strconv.Itoa(snapshot.AvailableBikes)); err != nil {
+			return err
+		}
+		if err := s.publish(base+"/availability", "online"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Base URL of the Nextbike live XML API
+const nextbikeXMLAPI = "https://maps.nextbike.net/maps/nextbike-live.xml"
+
+// Struct for the Nextbike live XML document
+type nextbikeMarkers struct {
+	Countries []struct {
+		Cities []struct {
+			UID    string          `xml:"uid,attr"`
+			Name   string          `xml:"name,attr"`
+			Places []nextbikePlace `xml:"place"`
+		} `xml:"city"`
+	} `xml:"country"`
+}
+
+// Struct for a Nextbike place: a station when spot="1", otherwise a free-floating bike position
+type nextbikePlace struct {
+	UID         string  `xml:"uid,attr"`
+	Name        string  `xml:"name,attr"`
+	Lat         float64 `xml:"lat,attr"`
+	Lng         float64 `xml:"lng,attr"`
+	Spot        string  `xml:"spot,attr"`
+	Bikes       string  `xml:"bikes,attr"`
+	FreeRacks   string  `xml:"free_racks,attr"`
+	BikeNumbers string  `xml:"bike_numbers,attr"`
+}
+
+// Function to report whether a URL is a Nextbike XML feed
+func isNextbikeXMLURL(rawURL string) bool {
+	if strings.HasPrefix(rawURL, "nextbike://") {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.HasSuffix(u.Host, "nextbike.net") && strings.HasSuffix(u.Path, ".xml")
+}
+
+// Function to expand the nextbike://<city uid> shorthand into the API URL
+func nextbikeXMLURL(rawURL string) string {
+	if city, ok := strings.CutPrefix(rawURL, "nextbike://"); ok {
+		return nextbikeXMLAPI + "?city=" + url.QueryEscape(strings.Trim(city, "/"))
+	}
+	return rawURL
+}
+
+// Function to parse Nextbike counts, which are capped strings such as "5+"
+func nextbikeCount(value string) int {
+	n, _ := strconv.Atoi(strings.TrimRight(value, "+"))
+	return n
+}
+
+// Function to scrape a Nextbike XML feed into the normalized model
+func scrapeNextbikeXML(provider Provider, trace *ScrapeTrace) (ScrapeResult, error) {
+	body, err := fetchBody(provider, provider.URL, trace)
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("fetching Nextbike feed %s: %w", provider.URL, err)
+	}
+
+	var markers nextbikeMarkers
+	if err := xml.Unmarshal(body, &markers); err != nil {
+		return ScrapeResult{}, failedBodies.keep(provider, provider.URL, body, fmt.Errorf("parsing Nextbike feed %s: %w", provider.URL, err))
+	}
+
+	var result ScrapeResult
+	for _, country := range markers.Countries {
+		for _, city := range country.Cities {
+			for _, place := range city.Places {
+				if place.Spot == "1" {
+					result.Stations = append(result.Stations, Station{
+						StationID:      place.UID,
+						Name:           place.Name,
+						Lat:            place.Lat,
+						Lon:            place.Lng,
+						BikesAvailable: nextbikeCount(place.Bikes),
+						DocksAvailable: nextbikeCount(place.FreeRacks),
+						IsRenting:      true,
+					})
+					continue
+				}
+
+				// Free-floating positions list their bikes by number when known
+				numbers := strings.FieldsFunc(place.BikeNumbers, func(r rune) bool { return r == ',' })
+				for i := 0; i < nextbikeCount(place.Bikes); i++ {
+					id := place.UID + "-" + strconv.Itoa(i)
+					if i < len(numbers) {
+						id = strings.TrimSpace(numbers[i])
+					}
+					result.Bikes = append(result.Bikes, Bike{BikeID: id, Lat: place.Lat, Lon: place.Lng})
+				}
+			}
+		}
+	}
+	trace.recordCount("stations", len(result.Stations))
+	trace.recordCount("bikes", len(result.Bikes))
+	return result, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Struct for one normalization step applied to a provider's feeds before they
+// are parsed, for operators publishing slightly malformed GBFS. Exactly one of
+// Rename, Move, Numbers and Wrap is set. Paths are dot-separated keys where "*"
+// matches every element of an array or object, e.g. data.bikes.*.lat.
+type NormalizeStep struct {
+	// Feed limits the step to feed URLs containing this text; empty applies it to every feed
+	Feed string `yaml:"feed,omitempty"`
+	// Rename renames the key at From to the plain key name To, next to it
+	Rename *NormalizeRename `yaml:"rename,omitempty"`
+	// Move moves the value at the path From to the path To, creating objects on the way
+	Move *NormalizeRename `yaml:"move,omitempty"`
+	// Numbers turns stringified numbers at these paths into numbers
+	Numbers []string `yaml:"numbers,omitempty"`
+	// Wrap nests the whole document under this path, e.g. for a bare array of bikes
+	Wrap string `yaml:"wrap,omitempty"`
+}
+
+// Struct for the source and destination of a rename or move
+type NormalizeRename struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Function to check a step has exactly one well-formed operation
+func (s NormalizeStep) validate() error {
+	ops := 0
+	if s.Rename != nil {
+		ops++
+		if s.Rename.From == "" || s.Rename.To == "" || strings.Contains(s.Rename.To, ".") {
+			return fmt.Errorf("normalize rename needs from and a plain key name in to")
+		}
+	}
+	if s.Move != nil {
+		ops++
+		if s.Move.From == "" || s.Move.To == "" {
+			return fmt.Errorf("normalize move needs from and to")
+		}
+		if strings.Contains(s.Move.From, "*") || strings.Contains(s.Move.To, "*") {
+			return fmt.Errorf("normalize move paths cannot contain *")
+		}
+	}
+	if len(s.Numbers) > 0 {
+		ops++
+	}
+	if s.Wrap != "" {
+		ops++
+		if strings.Contains(s.Wrap, "*") {
+			return fmt.Errorf("normalize wrap path cannot contain *")
+		}
+	}
+	if ops != 1 {
+		return fmt.Errorf("each normalize step needs exactly one of rename, move, numbers or wrap")
+	}
+	return nil
+}
+
+// Function to apply the provider's normalization steps to a feed body
+func normalizeFeed(provider Provider, url string, body []byte) ([]byte, error) {
+	var steps []NormalizeStep
+	for _, step := range provider.Normalize {
+		if step.Feed == "" || strings.Contains(url, step.Feed) {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == 0 {
+		return body, nil
+	}
+
+	// Numbers stay json.Number so re-encoding does not change their formatting
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("normalizing %s: %w", url, err)
+	}
+	for _, step := range steps {
+		switch {
+		case step.Rename != nil:
+			from := splitPath(step.Rename.From)
+			for _, parent := range selectPath(doc, from[:len(from)-1]) {
+				if object, ok := parent.(map[string]any); ok {
+					if value, ok := object[from[len(from)-1]]; ok {
+						delete(object, from[len(from)-1])
+						object[step.Rename.To] = value
+					}
+				}
+			}
+		case step.Move != nil:
+			from := splitPath(step.Move.From)
+			values := selectPath(doc, from[:len(from)-1])
+			if len(values) == 0 {
+				continue
+			}
+			parent, ok := values[0].(map[string]any)
+			if !ok {
+				continue
+			}
+			value, ok := parent[from[len(from)-1]]
+			if !ok {
+				continue
+			}
+			delete(parent, from[len(from)-1])
+			doc = setPath(doc, splitPath(step.Move.To), value)
+		case len(step.Numbers) > 0:
+			for _, path := range step.Numbers {
+				keys := splitPath(path)
+				for _, parent := range selectPath(doc, keys[:len(keys)-1]) {
+					convertNumbers(parent, keys[len(keys)-1])
+				}
+			}
+		case step.Wrap != "":
+			doc = setPath(map[string]any{}, splitPath(step.Wrap), doc)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// Function to split a dot-separated path
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// Function to return every value reached by following keys from doc
+func selectPath(doc any, keys []string) []any {
+	values := []any{doc}
+	for _, key := range keys {
+		var next []any
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]any:
+				if key == "*" {
+					for _, child := range v {
+						next = append(next, child)
+					}
+				} else if child, ok := v[key]; ok {
+					next = append(next, child)
+				}
+			case []any:
+				if key == "*" {
+					next = append(next, v...)
+				} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(v) {
+					next = append(next, v[i])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// Function to set the value at a path of objects, creating or replacing objects
+// on the way, and return the (possibly new) root
+func setPath(doc any, keys []string, value any) any {
+	if len(keys) == 0 {
+		return value
+	}
+	object, ok := doc.(map[string]any)
+	if !ok {
+		object = map[string]any{}
+	}
+	object[keys[0]] = setPath(object[keys[0]], keys[1:], value)
+	return object
+}
+
+// Function to turn stringified numbers under key (or every key or element for "*") of a container into numbers
+func convertNumbers(container any, key string) {
+	convert := func(value any) (any, bool) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		s = strings.TrimSpace(s)
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, false
+		}
+		return json.Number(s), true
+	}
+	switch v := container.(type) {
+	case map[string]any:
+		for k, value := range v {
+			if key != "*" && k != key {
+				continue
+			}
+			if n, ok := convert(value); ok {
+				v[k] = n
+			}
+		}
+	case []any:
+		for i, value := range v {
+			if key != "*" && strconv.Itoa(i) != key {
+				continue
+			}
+			if n, ok := convert(value); ok {
+				v[i] = n
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TTL advertised in the re-exported feeds
+const reexportTTL = 60
+
+// Function to work out the externally visible base URL of a request, honoring reverse proxy headers
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
+
+// Function to wrap feed data in a GBFS v2.3 envelope
+func reexportEnvelope(updated time.Time, data interface{}) gin.H {
+	return gin.H{
+		"last_updated": updated.Unix(),
+		"ttl":          reexportTTL,
+		"version":      "2.3",
+		"data":         data,
+	}
+}
+
+// Function to list the feeds available for a provider's state
+func reexportFeedNames(state ProviderState) []string {
+	feeds := []string{"system_information", "free_bike_status"}
+	if len(state.Stations) > 0 {
+		feeds = append(feeds, "station_information", "station_status")
+	}
+	return feeds
+}
+
+// Handler for GET /gbfs/:provider/:feed, re-serving the latest normalized data
+// of a provider as a GBFS v2.3 system so OpenTripPlanner and other GBFS
+// consumers can use the exporter as a caching proxy
+func reexportFeedHandler(c *gin.Context) {
+	state, ok := liveState.get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no data for provider " + c.Param("provider")})
+		return
+	}
+	location := state.Provider.Location
+
+	c.Header("Cache-Control", "max-age=60")
+	switch c.Param("feed") {
+	case "gbfs.json":
+		base := requestBaseURL(c) + "/gbfs/" + url.PathEscape(location) + "/"
+		feeds := []GBFSFeed{}
+		for _, name := range reexportFeedNames(state) {
+			feeds = append(feeds, GBFSFeed{Name: name, URL: base + name + ".json"})
+		}
+		c.JSON(http.StatusOK, reexportEnvelope(state.UpdatedAt, gin.H{"en": gin.H{"feeds": feeds}}))
+
+	case "system_information.json":
+		c.JSON(http.StatusOK, reexportEnvelope(state.UpdatedAt, gin.H{
+			"system_id": mqttSlug(location),
+			"language":  "en",
+			"name":      location,
+			"timezone":  "Etc/UTC",
+		}))
+
+	case "free_bike_status.json":
+		bikes := vehiclePrivacy.bikes(state.Bikes)
+		if bikes == nil {
+			bikes = []Bike{}
+		}
+		c.JSON(http.StatusOK, reexportEnvelope(state.UpdatedAt, gin.H{"bikes": bikes}))
+
+	case "station_information.json":
+		stations := []gin.H{}
+		for _, s := range state.Stations {
+			station := gin.H{
+				"station_id": s.StationID,
+				"name":       s.Name,
+				"lat":        s.Lat,
+				"lon":        s.Lon,
+			}
+			if s.IsVirtual {
+				station["is_virtual_station"] = true
+			}
+			if s.IsCharging {
+				station["is_charging_station"] = true
+			}
+			if s.ParkingType != "" {
+				station["parking_type"] = s.ParkingType
+			}
+			stations = append(stations, station)
+		}
+		c.JSON(http.StatusOK, reexportEnvelope(state.UpdatedAt, gin.H{"stations": stations}))
+
+	case "station_status.json":
+		stations := []gin.H{}
+		for _, s := range state.Stations {
+			stations = append(stations, gin.H{
+				"station_id":          s.StationID,
+				"num_bikes_available": s.BikesAvailable,
+				"num_docks_available": s.DocksAvailable,
+				"is_installed":        true,
+				"is_renting":          s.IsRenting,
+				"is_returning":        true,
+				"last_reported":       state.UpdatedAt.Unix(),
+			})
+		}
+		c.JSON(http.StatusOK, reexportEnvelope(state.UpdatedAt, gin.H{"stations": stations}))
+
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown feed " + c.Param("feed")})
+	}
+}
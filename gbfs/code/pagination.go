@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Most pages followed per paginated feed, set with --max-feed-pages
+var maxFeedPages = 50
+
+// Gauge for the pages of each provider's latest vehicle feed
+var feedPagesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_feed_pages",
+		Help: "Pages fetched for the provider's latest vehicle feed, 1 when it is not paginated",
+	},
+	[]string{"location"},
+)
+
+// Counter for paginated feeds whose pages were not all followed
+var feedPaginationStopped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_feed_pagination_stopped_total",
+		Help: "Paginated vehicle feeds cut short by --max-feed-pages, a repeated page or a next page on another host",
+	},
+	[]string{"location", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(feedPagesGauge, feedPaginationStopped)
+}
+
+// Struct for the pagination extensions operators add to large feeds: a next_page
+// URL at the top level or in data, or a links.next URL
+type feedPagination struct {
+	NextPage string `json:"next_page"`
+	Links    struct {
+		Next string `json:"next"`
+	} `json:"links"`
+	Data struct {
+		NextPage string `json:"next_page"`
+	} `json:"data"`
+}
+
+// Function to return the absolute URL of the page following body, empty on the last page
+func nextPageURL(body []byte, pageURL string) (string, error) {
+	var pagination feedPagination
+	if err := json.Unmarshal(body, &pagination); err != nil {
+		return "", err
+	}
+	next := pagination.NextPage
+	if next == "" {
+		next = pagination.Data.NextPage
+	}
+	if next == "" {
+		next = pagination.Links.Next
+	}
+	if next == "" {
+		return "", nil
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("invalid next page %q: %w", next, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Function to follow the pages after a feed's first body, calling page with each
+// one. Pagination stops at --max-feed-pages, at a page already fetched and at a
+// next page on another host, which is logged and counted rather than failing the
+// scrape. Returns the number of pages, including the first.
+func followPages(provider Provider, firstURL string, first []byte, trace *ScrapeTrace, page func([]byte) error) (int, error) {
+	pages := 1
+	firstPage, _ := url.Parse(firstURL)
+	visited := map[string]bool{firstURL: true}
+	body, pageURL := first, firstURL
+	for {
+		next, err := nextPageURL(body, pageURL)
+		if err != nil {
+			return pages, failedBodies.keep(provider, pageURL, body, err)
+		}
+		if next == "" {
+			break
+		}
+		reason := ""
+		switch parsed, _ := url.Parse(next); {
+		case visited[next]:
+			reason = "repeated_page"
+		case parsed.Host != firstPage.Host:
+			reason = "other_host"
+		case pages >= maxFeedPages:
+			reason = "max_pages"
+		}
+		if reason != "" {
+			if trace == nil {
+				feedPaginationStopped.WithLabelValues(metricLocation(provider), reason).Inc()
+			}
+			log.Printf("Warning: stopped following pages of %s after %d (%s)", redactURL(firstURL), pages, reason)
+			break
+		}
+
+		visited[next] = true
+		body, err = fetchBody(provider, next, trace)
+		if err != nil {
+			return pages, fmt.Errorf("fetching page %d: %w", pages+1, err)
+		}
+		pageURL = next
+		pages++
+		if err := page(body); err != nil {
+			return pages, failedBodies.keep(provider, pageURL, body, fmt.Errorf("page %d: %w", pages, err))
+		}
+	}
+	if trace == nil {
+		feedPagesGauge.WithLabelValues(metricLocation(provider)).Set(float64(pages))
+	}
+	return pages, nil
+}
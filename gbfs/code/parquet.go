@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Struct for one snapshot row in Parquet exports
+type snapshotRow struct {
+	Location       string `parquet:"location"`
+	URL            string `parquet:"url"`
+	ScrapedAt      int64  `parquet:"scraped_at,timestamp(millisecond)"`
+	AvailableBikes int64  `parquet:"available_bikes"`
+	Error          string `parquet:"error,optional"`
+	LicenseURL     string `parquet:"license_url,optional"`
+	Attribution    string `parquet:"attribution_organization_name,optional"`
+}
+
+// Function to write snapshots as a single Parquet file
+func writeSnapshotsParquet(w io.Writer, snapshots []ProviderSnapshot) error {
+	rows := make([]snapshotRow, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		row := snapshotRow{
+			Location:       snapshot.Location,
+			URL:            snapshot.URL,
+			ScrapedAt:      snapshot.ScrapedAt.UnixMilli(),
+			AvailableBikes: int64(snapshot.AvailableBikes),
+			Error:          snapshot.Error,
+		}
+		if snapshot.License != nil {
+			row.LicenseURL, row.Attribution = snapshot.License.URL, snapshot.License.Attribution
+		}
+		rows = append(rows, row)
+	}
+
+	pw := parquet.NewGenericWriter[snapshotRow](w)
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
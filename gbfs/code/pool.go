@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Struct for the outbound connection pool settings of the feed client
+type poolOptions struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+	keepAlive           time.Duration
+	http2               bool
+	// dnsCacheTTL caches resolved addresses for this long; 0 resolves on every dial
+	dnsCacheTTL time.Duration
+}
+
+// Connection pool settings, set with the root --http-* and --dns-cache-ttl flags
+var connPool = poolOptions{
+	maxIdleConns:        100,
+	maxIdleConnsPerHost: 2,
+	idleConnTimeout:     90 * time.Second,
+	keepAlive:           30 * time.Second,
+	http2:               true,
+}
+
+// Function to check the pool settings
+func (o poolOptions) validate() error {
+	if o.maxIdleConns < 0 || o.maxIdleConnsPerHost < 0 || o.maxConnsPerHost < 0 {
+		return fmt.Errorf("connection pool sizes cannot be negative")
+	}
+	if o.dnsCacheTTL < 0 {
+		return fmt.Errorf("--dns-cache-ttl cannot be negative")
+	}
+	return nil
+}
+
+// Struct for a cache of DNS lookups shared by every feed request; it also orders
+// addresses for --ip-family prefer-ipv4 or prefer-ipv6
+type dnsCache struct {
+	ttl      time.Duration
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// Struct for the cached addresses of one host
+type dnsEntry struct {
+	addrs      []string
+	resolvedAt time.Time
+}
+
+// Function to create a DNS cache
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{ttl: ttl, resolver: net.DefaultResolver, entries: map[string]dnsEntry{}}
+}
+
+// Function to return a host's addresses, resolving it when the cached entry is stale
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Since(entry.resolvedAt) < c.ttl {
+		return dialing.order(entry.addrs), nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		// A stale answer beats failing every request while DNS is down
+		if ok {
+			return dialing.order(entry.addrs), nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, resolvedAt: time.Now()}
+	c.mu.Unlock()
+	return dialing.order(addrs), nil
+}
+
+// Function to wrap a dial function so it connects to cached addresses, trying each in turn
+func (c *dnsCache) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of pricing plan changes
+const (
+	pricingAdded   = "added"
+	pricingRemoved = "removed"
+	pricingChanged = "changed"
+)
+
+// Struct for the pricing plans a provider published at one time, by plan_id
+type PricingSnapshot struct {
+	Provider string                     `json:"provider"`
+	At       time.Time                  `json:"at"`
+	Plans    map[string]json.RawMessage `json:"plans"`
+}
+
+// Struct for a pricing plan that appeared, disappeared or changed between two snapshots
+type PricingChange struct {
+	Provider string          `json:"provider"`
+	At       time.Time       `json:"at"`
+	PlanID   string          `json:"plan_id"`
+	Kind     string          `json:"kind"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+// Struct for the body POSTed to pricing webhooks when an operator changes prices
+type pricingPayload struct {
+	Event   string          `json:"event"`
+	Changes []PricingChange `json:"changes"`
+}
+
+// Struct tracking system_pricing_plans per provider, optionally persisted as JSON
+// lines holding every snapshot whose plans differed from the one before
+type pricingTracker struct {
+	interval  time.Duration
+	notifiers []*webhookSink
+
+	mu      sync.Mutex
+	enabled bool
+	latest  map[string]PricingSnapshot
+	checked map[string]time.Time
+	changes []PricingChange
+	enc     *json.Encoder
+	f       *os.File
+}
+
+// Pricing tracking, enabled with serve --pricing-history or --pricing-webhook
+var pricing = &pricingTracker{interval: time.Hour, latest: map[string]PricingSnapshot{}, checked: map[string]time.Time{}}
+
+// Counter for the pricing plan changes detected per provider
+var pricingChanges = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_pricing_changes_total",
+		Help: "Number of pricing plans added, removed or changed by the provider",
+	},
+	[]string{"location", "kind"},
+)
+
+func init() {
+	prometheus.MustRegister(pricingChanges)
+}
+
+// Function to load the pricing snapshots kept in a JSON lines file and append new ones to it
+func (p *pricingTracker) persistTo(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dec := json.NewDecoder(f)
+	for {
+		var snapshot PricingSnapshot
+		if err := dec.Decode(&snapshot); err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if previous, ok := p.latest[snapshot.Provider]; ok {
+			p.changes = append(p.changes, diffPricing(previous, snapshot)...)
+		}
+		p.latest[snapshot.Provider] = snapshot
+	}
+	p.f, p.enc = f, json.NewEncoder(f)
+	p.enabled = true
+	return nil
+}
+
+// Function to compare two snapshots of a provider's plans
+func diffPricing(before, after PricingSnapshot) []PricingChange {
+	var changes []PricingChange
+	for id, plan := range after.Plans {
+		previous, ok := before.Plans[id]
+		switch {
+		case !ok:
+			changes = append(changes, PricingChange{Provider: after.Provider, At: after.At, PlanID: id, Kind: pricingAdded, After: plan})
+		case !bytes.Equal(previous, plan):
+			changes = append(changes, PricingChange{Provider: after.Provider, At: after.At, PlanID: id, Kind: pricingChanged, Before: previous, After: plan})
+		}
+	}
+	for id, plan := range before.Plans {
+		if _, ok := after.Plans[id]; !ok {
+			changes = append(changes, PricingChange{Provider: after.Provider, At: after.At, PlanID: id, Kind: pricingRemoved, Before: plan})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].PlanID < changes[j].PlanID })
+	return changes
+}
+
+// Function to fetch a provider's system_pricing_plans, keyed by plan_id with each
+// plan re-encoded canonically so key order and whitespace never count as changes
+func fetchPricingPlans(provider Provider) (map[string]json.RawMessage, bool, error) {
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	url, ok := providerFeedURL(provider, body, "system_pricing_plans")
+	if !ok {
+		return nil, false, nil
+	}
+	body, err = fetchBody(provider, url, nil)
+	if err != nil {
+		return nil, true, err
+	}
+	var feed struct {
+		Data struct {
+			Plans []map[string]any `json:"plans"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, true, failedBodies.keep(provider, url, body, fmt.Errorf("parsing system_pricing_plans: %w", err))
+	}
+	plans := make(map[string]json.RawMessage, len(feed.Data.Plans))
+	for _, plan := range feed.Data.Plans {
+		id, _ := plan["plan_id"].(string)
+		canonical, err := json.Marshal(plan)
+		if err != nil {
+			return nil, true, err
+		}
+		plans[id] = canonical
+	}
+	return plans, true, nil
+}
+
+// Function to check a provider's pricing plans at most once per interval,
+// recording and announcing any change; safe to run concurrently
+func (p *pricingTracker) check(provider Provider, now time.Time) {
+	if provider.Source != "" && provider.Source != sourceGBFS {
+		return
+	}
+	p.mu.Lock()
+	due := p.enabled && now.Sub(p.checked[provider.Location]) >= p.interval
+	if due {
+		p.checked[provider.Location] = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+
+	plans, listed, err := fetchPricingPlans(provider)
+	if err != nil {
+		log.Printf("Error reading system_pricing_plans of %s: %v", provider.Location, err)
+		return
+	}
+	if !listed {
+		return
+	}
+	snapshot := PricingSnapshot{Provider: provider.Location, At: now.UTC(), Plans: plans}
+
+	p.mu.Lock()
+	previous, known := p.latest[provider.Location]
+	var changes []PricingChange
+	if known {
+		changes = diffPricing(previous, snapshot)
+		if len(changes) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		p.changes = append(p.changes, changes...)
+	}
+	p.latest[provider.Location] = snapshot
+	if p.enc != nil {
+		if err := p.enc.Encode(snapshot); err != nil {
+			log.Printf("Error saving pricing plans of %s: %v", provider.Location, err)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, change := range changes {
+		pricingChanges.WithLabelValues(metricLocation(provider), change.Kind).Inc()
+		log.Printf("Pricing plan %s of %s %s", change.PlanID, provider.Location, change.Kind)
+	}
+	if len(changes) > 0 {
+		go p.notify(changes)
+	}
+}
+
+// Function to POST pricing changes to every pricing webhook
+func (p *pricingTracker) notify(changes []PricingChange) {
+	body, err := json.Marshal(pricingPayload{Event: "pricing_changed", Changes: changes})
+	if err != nil {
+		log.Printf("Error encoding pricing changes: %v", err)
+		return
+	}
+	for _, notifier := range p.notifiers {
+		if err := notifier.send(body); err != nil {
+			log.Printf("Error notifying %s of pricing changes: %v", notifier.Name(), err)
+		}
+	}
+}
+
+// Handler for GET /api/v1/providers/:name/pricing, returning the current plans and their change history, newest first
+func providerPricingHandler(c *gin.Context) {
+	pricing.mu.Lock()
+	defer pricing.mu.Unlock()
+	if !pricing.enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pricing tracking is not enabled"})
+		return
+	}
+	name := c.Param("name")
+	current, ok := pricing.latest[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no pricing plans recorded for provider " + name})
+		return
+	}
+	history := []PricingChange{}
+	for i := len(pricing.changes) - 1; i >= 0; i-- {
+		if pricing.changes[i].Provider == name {
+			history = append(history, pricing.changes[i])
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":   name,
+		"checked_at": current.At,
+		"plans":      current.Plans,
+		"changes":    history,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Most decimal places --coordinate-precision accepts; six is already about 10 cm
+const maxCoordinatePrecision = 6
+
+// Struct for the privacy mode applied to vehicle positions before they leave the
+// exporter: moved by up to jitter meters, then truncated to precision decimals.
+// Stations are public infrastructure and are republished as published.
+type coordinatePrivacy struct {
+	// decimal places kept, 0 keeps positions as published
+	precision int
+	// largest distance in meters positions are moved, 0 disables jitter
+	jitter float64
+	salt   []byte
+}
+
+// Privacy mode of vehicle positions in the API and archives, set with
+// --coordinate-precision, --coordinate-jitter and --privacy-salt
+var vehiclePrivacy coordinatePrivacy
+
+// Function to validate the privacy flags and set the jitter salt, random per
+// process when none is configured
+func (p *coordinatePrivacy) configure(precision int, jitter float64, salt string) error {
+	if precision < 0 || precision > maxCoordinatePrecision {
+		return fmt.Errorf("--coordinate-precision must be between 0 and %d", maxCoordinatePrecision)
+	}
+	if jitter < 0 {
+		return fmt.Errorf("--coordinate-jitter must not be negative")
+	}
+	p.precision, p.jitter = precision, jitter
+	if salt != "" {
+		p.salt = []byte(salt)
+		return nil
+	}
+	p.salt = make([]byte, 32)
+	_, err := rand.Read(p.salt)
+	return err
+}
+
+// Function to report whether vehicle positions are altered
+func (p coordinatePrivacy) enabled() bool {
+	return p.precision > 0 || p.jitter > 0
+}
+
+// Function to return the position to publish for a vehicle. The jitter is derived
+// from the vehicle and its position, so repeated requests cannot be averaged back
+// to the true position while the vehicle stays parked.
+func (p coordinatePrivacy) position(id string, lat, lon float64) (float64, float64) {
+	if p.jitter > 0 {
+		mac := hmac.New(sha256.New, p.salt)
+		mac.Write([]byte(id + "," + strconv.FormatFloat(lat, 'g', -1, 64) + "," + strconv.FormatFloat(lon, 'g', -1, 64)))
+		sum := mac.Sum(nil)
+		distance := p.jitter * math.Sqrt(float64(binary.BigEndian.Uint32(sum[0:4]))/math.MaxUint32)
+		bearing := 2 * math.Pi * float64(binary.BigEndian.Uint32(sum[4:8])) / math.MaxUint32
+		lat += distance * math.Cos(bearing) / metresPerDegree
+		lon += distance * math.Sin(bearing) / (metresPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	}
+	if p.precision > 0 {
+		scale := math.Pow(10, float64(p.precision))
+		lat, lon = math.Trunc(lat*scale)/scale, math.Trunc(lon*scale)/scale
+	}
+	return lat, lon
+}
+
+// Function to return the vehicles with their positions altered, leaving the
+// ingested state untouched
+func (p coordinatePrivacy) bikes(bikes []Bike) []Bike {
+	if !p.enabled() {
+		return bikes
+	}
+	altered := make([]Bike, len(bikes))
+	for i, bike := range bikes {
+		bike.Lat, bike.Lon = p.position(bike.BikeID, bike.Lat, bike.Lon)
+		altered[i] = bike
+	}
+	return altered
+}
+
+// Function to alter the vehicle positions in a raw feed body, in data.bikes of
+// free_bike_status and data.vehicles of vehicle_status. Other feeds and bodies that
+// are not JSON are returned unchanged.
+func (p coordinatePrivacy) body(body []byte) []byte {
+	if !p.enabled() || (!bytes.Contains(body, []byte(`"bikes"`)) && !bytes.Contains(body, []byte(`"vehicles"`))) {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	data, _ := doc["data"].(map[string]any)
+	altered := false
+	for _, list := range []string{"bikes", "vehicles"} {
+		vehicles, _ := data[list].([]any)
+		for _, vehicle := range vehicles {
+			object, ok := vehicle.(map[string]any)
+			if !ok {
+				continue
+			}
+			lat, err1 := jsonFloat(object["lat"])
+			lon, err2 := jsonFloat(object["lon"])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			id, _ := object["bike_id"].(string)
+			if vehicleID, ok := object["vehicle_id"].(string); ok {
+				id = vehicleID
+			}
+			lat, lon = p.position(id, lat, lon)
+			object["lat"] = json.Number(strconv.FormatFloat(lat, 'f', -1, 64))
+			object["lon"] = json.Number(strconv.FormatFloat(lon, 'f', -1, 64))
+			altered = true
+		}
+	}
+	if !altered {
+		return body
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// Function to read a number decoded with UseNumber
+func jsonFloat(value any) (float64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("not a number")
+	}
+	return number.Float64()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Struct for one target group in the Prometheus http_sd format
+type httpSDTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Handler for GET /prometheus/sd, returning one target group per provider.
+// Each group scrapes /metrics?location=<provider>, so a Prometheus fleet can
+// hashmod-shard on the location label.
+func prometheusSDHandler(c *gin.Context) {
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	target := c.Query("target")
+	if target == "" {
+		target = c.Request.Host
+	}
+
+	providers = deploymentProviders(c, providers)
+	groups := make([]httpSDTargetGroup, 0, len(providers))
+	for _, provider := range providers {
+		labels := map[string]string{
+			"__metrics_path__":  "/metrics",
+			"__param_location":  metricLocation(provider),
+			"location":          metricLocation(provider),
+			"gbfs_provider_url": redactURL(provider.URL),
+		}
+		if provider.Deployment != "" {
+			labels[deploymentLabel] = provider.Deployment
+		}
+		groups = append(groups, httpSDTargetGroup{Targets: []string{target}, Labels: labels})
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// Function to wrap a gatherer so only series labelled with the given location remain
+func locationGatherer(gatherer prometheus.Gatherer, location string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+
+		var filtered []*dto.MetricFamily
+		for _, family := range families {
+			var metrics []*dto.Metric
+			for _, metric := range family.Metric {
+				for _, label := range metric.Label {
+					if label.GetName() == "location" && label.GetValue() == location {
+						metrics = append(metrics, metric)
+						break
+					}
+				}
+			}
+			if len(metrics) > 0 {
+				family.Metric = metrics
+				filtered = append(filtered, family)
+			}
+		}
+		return filtered, nil
+	})
+}
+
+// Handler for GET /metrics, optionally restricted to one provider with ?location=
+// or to one config deployment with ?deployment=
+func metricsHandler(c *gin.Context) {
+	gatherer := requestGatherer(c)
+	if gatherer == prometheus.Gatherer(prometheus.DefaultGatherer) {
+		promhttp.Handler().ServeHTTP(c.Writer, c.Request)
+		return
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
+
+// Function to return the gatherer of a metrics request, restricted by ?location= and
+// labelled and restricted by deployment when the config has deployments
+func requestGatherer(c *gin.Context) prometheus.Gatherer {
+	location := c.Query("location")
+	providers, _ := getProviders()
+	deployed := false
+	for _, provider := range providers {
+		deployed = deployed || provider.Deployment != ""
+	}
+	gatherer := prometheus.Gatherer(prometheus.DefaultGatherer)
+	if location != "" {
+		gatherer = locationGatherer(gatherer, location)
+	}
+	if deployed {
+		gatherer = deploymentGatherer(gatherer, providers, c.Query(deploymentLabel))
+	}
+	return gatherer
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shortest freshness applied to cached feeds, so ttl=0 feeds still shield the upstream from bursts
+const proxyMinTTL = 15 * time.Second
+
+// Struct for a cached upstream response
+type proxyEntry struct {
+	body      []byte
+	fetchedAt time.Time
+	ttl       time.Duration
+	etag      string
+}
+
+// Function to report how long the entry remains fresh
+func (e proxyEntry) remaining(now time.Time) time.Duration {
+	return e.fetchedAt.Add(e.ttl).Sub(now)
+}
+
+// Struct caching the raw upstream feed bodies by URL for the proxy endpoint
+type proxyCache struct {
+	mu      sync.RWMutex
+	entries map[string]proxyEntry
+}
+
+// Cache of upstream responses; nil unless proxy mode is enabled
+var feedProxy *proxyCache
+
+// Function to create an empty proxy cache
+func newProxyCache() *proxyCache {
+	return &proxyCache{entries: map[string]proxyEntry{}}
+}
+
+// Function to remember a fetched body, taking its freshness from the GBFS ttl field
+func (p *proxyCache) store(url string, body []byte) {
+	if p == nil {
+		return
+	}
+	var envelope struct {
+		TTL int `json:"ttl"`
+	}
+	json.Unmarshal(body, &envelope)
+	sum := sha256.Sum256(body)
+	ttl := time.Duration(envelope.TTL) * time.Second
+	if ttl < proxyMinTTL {
+		ttl = proxyMinTTL
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[url] = proxyEntry{
+		body:      body,
+		fetchedAt: time.Now(),
+		ttl:       ttl,
+		etag:      `"` + hex.EncodeToString(sum[:8]) + `"`,
+	}
+}
+
+// Function to return the cached entry for a URL
+func (p *proxyCache) get(url string) (proxyEntry, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	entry, ok := p.entries[url]
+	return entry, ok
+}
+
+// Error for a feed that was fetched without reaching the proxy cache, e.g. from a
+// replay or a response shared with another provider's fetch
+var errProxyNotCached = errors.New("feed is not cached by the proxy yet")
+
+// Function to return the cached entry while fresh, otherwise fetch it upstream
+func (p *proxyCache) fetch(provider Provider, url string) (proxyEntry, error) {
+	if entry, ok := p.get(url); ok && entry.remaining(time.Now()) > 0 {
+		return entry, nil
+	}
+	if _, err := fetchBody(provider, url, nil); err != nil {
+		// Serve stale data rather than nothing when the upstream is down
+		if entry, ok := p.get(url); ok {
+			return entry, nil
+		}
+		return proxyEntry{}, err
+	}
+	entry, ok := p.get(url)
+	if !ok {
+		return proxyEntry{}, errProxyNotCached
+	}
+	return entry, nil
+}
+
+// Function to respond to a feed the proxy could not serve
+func proxyFetchError(c *gin.Context, err error) {
+	status := http.StatusBadGateway
+	if errors.Is(err, errProxyNotCached) {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// Handler for GET /proxy/:provider/:feed, re-serving upstream feeds with caching
+// headers derived from their ttl. Feed URLs inside gbfs.json are rewritten to
+// point back at the proxy.
+func proxyFeedHandler(c *gin.Context) {
+	provider, ok, err := findProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok || provider.Source != sourceGBFS {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown GBFS provider " + c.Param("provider")})
+		return
+	}
+
+	discovery, err := feedProxy.fetch(provider, discoveryFailover.activeURL(provider))
+	if err != nil {
+		proxyFetchError(c, err)
+		return
+	}
+
+	feed := strings.TrimSuffix(c.Param("feed"), ".json")
+	entry := discovery
+	if feed != "gbfs" {
+		target, found := proxyFeedURL(discovery.body, feed)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "provider does not publish " + feed})
+			return
+		}
+		if entry, err = feedProxy.fetch(provider, target); err != nil {
+			proxyFetchError(c, err)
+			return
+		}
+	}
+
+	body := vehiclePrivacy.body(entry.body)
+	if feed == "gbfs" {
+		body = proxyRewriteDiscovery(body, requestBaseURL(c)+"/proxy/"+url.PathEscape(provider.Location)+"/")
+	}
+
+	maxAge := int(entry.remaining(time.Now()).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	c.Header("Last-Modified", entry.fetchedAt.UTC().Format(http.TimeFormat))
+	c.Header("ETag", entry.etag)
+	if c.GetHeader("If-None-Match") == entry.etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// Function to find the URL of a named feed in a discovery document, in any language
+func proxyFeedURL(discoveryBody []byte, name string) (string, bool) {
+	return discoveryFeedURL(discoveryBody, name, "")
+}
+
+// Function to find the URL of a feed the provider's config enables, in its configured language if published
+func providerFeedURL(provider Provider, discoveryBody []byte, name string) (string, bool) {
+	if !provider.feedEnabled(name) {
+		return "", false
+	}
+	return discoveryFeedURL(discoveryBody, name, provider.language())
+}
+
+// Function to find the URL of a named feed in a discovery document, preferring the given language
+func discoveryFeedURL(discoveryBody []byte, name, language string) (string, bool) {
+	discovery, err := parseDiscovery(discoveryBody)
+	if err != nil {
+		return "", false
+	}
+	byLanguage, err := discovery.FeedsByLanguage()
+	if err != nil {
+		return "", false
+	}
+	for _, language := range append([]string{language}, append(discovery.Languages(), "")...) {
+		for _, feed := range byLanguage[language] {
+			if feed.Name == name {
+				return feed.URL, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Function to point every feed URL of a discovery document at the proxy
+func proxyRewriteDiscovery(body []byte, base string) []byte {
+	discovery, err := parseDiscovery(body)
+	if err != nil {
+		return body
+	}
+	byLanguage, err := discovery.FeedsByLanguage()
+	if err != nil {
+		return body
+	}
+	rewritten := string(body)
+	for _, feeds := range byLanguage {
+		for _, feed := range feeds {
+			old, _ := json.Marshal(feed.URL)
+			replacement, _ := json.Marshal(base + feed.Name + ".json")
+			rewritten = strings.ReplaceAll(rewritten, string(old), string(replacement))
+		}
+	}
+	return []byte(rewritten)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Polling interval for providers inside their quiet or closed hours
+var quietInterval = 30 * time.Minute
+
+// How long a provider's system_hours feed is cached
+const systemHoursRefresh = 24 * time.Hour
+
+// Struct for a daily window in minutes since midnight; an end before the start wraps past midnight
+type clockWindow struct {
+	start, end int
+}
+
+// Function to parse a window such as "22:00-06:00"
+func parseClockWindow(value string) (clockWindow, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return clockWindow{}, fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM", value)
+	}
+	start, err := parseClockMinutes(from)
+	if err != nil {
+		return clockWindow{}, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+	end, err := parseClockMinutes(to)
+	if err != nil {
+		return clockWindow{}, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+	return clockWindow{start: start, end: end}, nil
+}
+
+// Function to parse HH:MM or HH:MM:SS into minutes since midnight; GBFS allows hours past 24
+func parseClockMinutes(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 47 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Function to report whether the minute of the day falls inside the window
+func (w clockWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// Struct for the GBFS v2 system_hours feed
+type systemHoursFeed struct {
+	Data struct {
+		RentalHours []struct {
+			Days      []string `json:"days"`
+			StartTime string   `json:"start_time"`
+			EndTime   string   `json:"end_time"`
+		} `json:"rental_hours"`
+	} `json:"data"`
+}
+
+// Struct for parsed rental hours: windows per weekday, where an end past 24:00 runs into the next day
+type rentalHours struct {
+	windows   [7][][2]int
+	fetchedAt time.Time
+}
+
+// Function to report whether the system is open at the weekday and minute of the day
+func (h rentalHours) open(day time.Weekday, minute int) bool {
+	for _, w := range h.windows[day] {
+		if minute >= w[0] && minute < w[1] {
+			return true
+		}
+	}
+	previous := (day + 6) % 7
+	for _, w := range h.windows[previous] {
+		if w[1] > 24*60 && minute < w[1]-24*60 {
+			return true
+		}
+	}
+	return false
+}
+
+// Cache of system_hours per provider location
+var systemHours = struct {
+	sync.Mutex
+	byLocation map[string]rentalHours
+}{byLocation: map[string]rentalHours{}}
+
+// Time each provider was last scraped while closed, to poll it at quietInterval
+var quietScrapes = struct {
+	sync.Mutex
+	last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+// Gauge reporting whether each system is open, so closed systems are not mistaken for empty ones
+var systemOpenGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_system_open",
+		Help: "1 if the system is within its service hours, 0 during quiet hours or outside system_hours",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(systemOpenGauge)
+}
+
+// Function to return the provider's rental hours, fetching system_hours when the cache is stale
+func providerRentalHours(provider Provider) (rentalHours, bool) {
+	systemHours.Lock()
+	cached, ok := systemHours.byLocation[provider.Location]
+	systemHours.Unlock()
+	if ok && clock.Now().Sub(cached.fetchedAt) < systemHoursRefresh {
+		return cached, true
+	}
+
+	hours, err := fetchRentalHours(provider)
+	if err != nil {
+		log.Printf("Error fetching system_hours for %s: %v", provider.Location, err)
+		return cached, ok
+	}
+	systemHours.Lock()
+	systemHours.byLocation[provider.Location] = hours
+	systemHours.Unlock()
+	return hours, true
+}
+
+// Function to fetch and parse the provider's system_hours feed
+func fetchRentalHours(provider Provider) (rentalHours, error) {
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return rentalHours{}, err
+	}
+	url, ok := providerFeedURL(provider, body, "system_hours")
+	if !ok {
+		return rentalHours{}, fmt.Errorf("system_hours not found in %s", provider.URL)
+	}
+	body, err = fetchBody(provider, url, nil)
+	if err != nil {
+		return rentalHours{}, err
+	}
+	var feed systemHoursFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return rentalHours{}, fmt.Errorf("parsing system_hours: %w", err)
+	}
+
+	days := map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+		"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+	hours := rentalHours{fetchedAt: clock.Now()}
+	for _, rental := range feed.Data.RentalHours {
+		start, err := parseClockMinutes(rental.StartTime)
+		if err != nil {
+			return rentalHours{}, err
+		}
+		end, err := parseClockMinutes(rental.EndTime)
+		if err != nil {
+			return rentalHours{}, err
+		}
+		// 23:59:59 closes at the end of the day
+		if end == 23*60+59 {
+			end = 24 * 60
+		}
+		for _, name := range rental.Days {
+			if day, ok := days[strings.ToLower(name)]; ok {
+				hours.windows[day] = append(hours.windows[day], [2]int{start, end})
+			}
+		}
+	}
+	return hours, nil
+}
+
+// Function to report whether the provider is inside its quiet hours or outside its system_hours
+func providerClosed(provider Provider, now time.Time) bool {
+	if len(provider.QuietHours) == 0 && !provider.SystemHours {
+		return false
+	}
+	local := now.In(providerLocation(provider))
+	minute := local.Hour()*60 + local.Minute()
+	for _, window := range provider.QuietHours {
+		if window.contains(minute) {
+			return true
+		}
+	}
+	if provider.SystemHours {
+		if hours, ok := providerRentalHours(provider); ok {
+			return !hours.open(local.Weekday(), minute)
+		}
+	}
+	return false
+}
+
+// Function to drop closed providers that were scraped less than quietInterval ago
+func skipQuietProviders(providers []Provider, now time.Time) []Provider {
+	due := providers[:0:0]
+	for _, provider := range providers {
+		quietScrapes.Lock()
+		last, ok := quietScrapes.last[provider.Location]
+		quietScrapes.Unlock()
+		if ok && now.Sub(last) < quietInterval && providerClosed(provider, now) {
+			continue
+		}
+		due = append(due, provider)
+	}
+	return due
+}
+
+// Function to record a scrape of a closed provider, or forget it once open again
+func recordQuietScrape(provider Provider, closed bool, now time.Time) {
+	quietScrapes.Lock()
+	defer quietScrapes.Unlock()
+	if closed {
+		quietScrapes.last[provider.Location] = now
+	} else {
+		delete(quietScrapes.last, provider.Location)
+	}
+}
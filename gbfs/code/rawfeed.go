@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct for the last body fetched from one feed URL
+type rawFeed struct {
+	body      []byte
+	fetchedAt time.Time
+}
+
+// Struct keeping the last raw body of every feed fetched from each provider, for
+// showing operators exactly what they served
+type rawFeedStore struct {
+	mu    sync.Mutex
+	feeds map[string]map[string]rawFeed
+}
+
+// Raw feeds of every provider, by location and URL, fed by upstream fetches
+var rawFeeds = &rawFeedStore{feeds: map[string]map[string]rawFeed{}}
+
+// Function to remember the body last fetched from a provider's feed URL
+func (s *rawFeedStore) store(provider Provider, url string, body []byte) {
+	if provider.Location == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.feeds[provider.Location] == nil {
+		s.feeds[provider.Location] = map[string]rawFeed{}
+	}
+	s.feeds[provider.Location][url] = rawFeed{body: body, fetchedAt: time.Now()}
+}
+
+// Function to return the body last fetched from a provider's feed URL
+func (s *rawFeedStore) get(location, url string) (rawFeed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed, ok := s.feeds[location][url]
+	return feed, ok
+}
+
+// Function to forget a provider's raw feeds
+func (s *rawFeedStore) forget(location string) {
+	s.mu.Lock()
+	delete(s.feeds, location)
+	s.mu.Unlock()
+}
+
+// Struct for what the exporter reads from the records of a feed: where they are,
+// the fields it decodes and the value it assumes for fields that are missing
+type rawFeedSchema struct {
+	records  string
+	fields   map[string]reflect.Type
+	defaults map[string]any
+}
+
+// Function to list the JSON fields of a struct type with their Go types
+func jsonFields(t reflect.Type, extra ...string) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	for _, name := range extra {
+		fields[name] = reflect.TypeOf("")
+	}
+	return fields
+}
+
+// Record schemas of the feeds covered by the normalization report
+var rawFeedSchemas = func() map[string]rawFeedSchema {
+	// Bikes are decoded from vehicle_id as well in GBFS 3.x
+	bikes := jsonFields(reflect.TypeOf(Bike{}), "vehicle_id")
+	bikeDefaults := map[string]any{"is_reserved": false, "is_disabled": false}
+	return map[string]rawFeedSchema{
+		"free_bike_status": {records: "data.bikes", fields: bikes, defaults: bikeDefaults},
+		"vehicle_status":   {records: "data.vehicles", fields: bikes, defaults: bikeDefaults},
+		"station_status": {
+			records: "data.stations",
+			fields:  jsonFields(reflect.TypeOf(stationStatus{})),
+			// A station that does not say it is installed is not counted as renting
+			defaults: map[string]any{"num_docks_available": 0, "is_installed": false, "is_renting": false},
+		},
+		"station_information": {
+			records:  "data.stations",
+			fields:   jsonFields(reflect.TypeOf(stationInformation{})),
+			defaults: map[string]any{"capacity": 0, "is_virtual_station": false, "is_charging_station": false},
+		},
+		"vehicle_types": {
+			records: "data.vehicle_types",
+			fields:  jsonFields(reflect.TypeOf(vehicleTypesFeed{}.Data.VehicleTypes).Elem()),
+		},
+	}
+}()
+
+// Struct for a field and the number of records it applies to
+type NormalizationField struct {
+	Field   string `json:"field"`
+	Records int    `json:"records"`
+	// From is the JSON type published for coerced fields
+	From string `json:"from,omitempty"`
+	// Default is the value assumed for defaulted fields
+	Default any `json:"default,omitempty"`
+}
+
+// Struct for how the exporter's reading of a feed differs from what the operator published
+type NormalizationReport struct {
+	// Steps are the provider's normalize steps applied before parsing
+	Steps []string `json:"normalize_steps"`
+	// Checked is false for feeds whose fields the report does not cover
+	Checked bool `json:"checked"`
+	Records int  `json:"records"`
+	// Dropped fields are published but not read
+	Dropped []NormalizationField `json:"dropped"`
+	// Coerced fields are read after converting their type, e.g. 0/1 or "true" to a boolean
+	Coerced []NormalizationField `json:"coerced"`
+	// Defaulted fields are missing or null and read as their default
+	Defaulted []NormalizationField `json:"defaulted"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// Function to describe a normalize step
+func (s NormalizeStep) describe() string {
+	var op string
+	switch {
+	case s.Rename != nil:
+		op = fmt.Sprintf("rename %s to %s", s.Rename.From, s.Rename.To)
+	case s.Move != nil:
+		op = fmt.Sprintf("move %s to %s", s.Move.From, s.Move.To)
+	case len(s.Numbers) > 0:
+		op = "numbers " + strings.Join(s.Numbers, ", ")
+	default:
+		op = "wrap in " + s.Wrap
+	}
+	if s.Feed != "" {
+		op += " (feeds matching " + s.Feed + ")"
+	}
+	return op
+}
+
+// Function to name the JSON type of a decoded value
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// Function to report how a feed body is read: the normalize steps applied to it,
+// then per field of its records what was dropped, coerced or defaulted
+func normalizationReport(provider Provider, feed, url string, raw []byte) NormalizationReport {
+	report := NormalizationReport{Steps: []string{}, Dropped: []NormalizationField{}, Coerced: []NormalizationField{}, Defaulted: []NormalizationField{}}
+	for _, step := range provider.Normalize {
+		if step.Feed == "" || strings.Contains(url, step.Feed) {
+			report.Steps = append(report.Steps, step.describe())
+		}
+	}
+	schema, ok := rawFeedSchemas[feed]
+	if !ok {
+		return report
+	}
+	report.Checked = true
+
+	body, err := normalizeFeed(provider, url, raw)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		report.Error = fmt.Sprintf("invalid JSON: %v", err)
+		return report
+	}
+
+	dropped, defaulted := map[string]int{}, map[string]int{}
+	coerced := map[[2]string]int{}
+	for _, list := range selectPath(doc, splitPath(schema.records)) {
+		records, _ := list.([]any)
+		for _, record := range records {
+			object, ok := record.(map[string]any)
+			if !ok {
+				continue
+			}
+			report.Records++
+			for field, value := range object {
+				t, known := schema.fields[field]
+				if !known {
+					dropped[field]++
+					continue
+				}
+				published := jsonTypeName(value)
+				switch {
+				case t == reflect.TypeOf(gbfsBool(false)) && published != "boolean" && published != "null":
+					coerced[[2]string{field, published}]++
+				case t == reflect.TypeOf(gbfsText("")) && published == "array":
+					// Localized names are read as their first translation for metrics
+					coerced[[2]string{field, published}]++
+				}
+			}
+			for field := range schema.defaults {
+				if value, ok := object[field]; !ok || value == nil {
+					defaulted[field]++
+				}
+			}
+		}
+	}
+
+	for field, count := range dropped {
+		report.Dropped = append(report.Dropped, NormalizationField{Field: field, Records: count})
+	}
+	for key, count := range coerced {
+		report.Coerced = append(report.Coerced, NormalizationField{Field: key[0], Records: count, From: key[1]})
+	}
+	for field, count := range defaulted {
+		report.Defaulted = append(report.Defaulted, NormalizationField{Field: field, Records: count, Default: schema.defaults[field]})
+	}
+	for _, fields := range [][]NormalizationField{report.Dropped, report.Coerced, report.Defaulted} {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	}
+	return report
+}
+
+// Handler for GET /api/v1/providers/:name/raw/:feed, returning the feed body last
+// fetched from the provider as published, with a report of how it was normalized,
+// for settling data quality questions with operators
+func providerRawFeedHandler(c *gin.Context) {
+	provider, found, err := findProvider(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	if provider.Source != "" && provider.Source != sourceGBFS {
+		c.JSON(http.StatusNotFound, gin.H{"error": "raw feeds are kept for GBFS providers only"})
+		return
+	}
+
+	feed := strings.TrimSuffix(c.Param("feed"), ".json")
+	url := discoveryFailover.activeURL(provider)
+	if feed != "gbfs" {
+		discovery, ok := rawFeeds.get(provider.Location, url)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "gbfs.json has not been fetched yet"})
+			return
+		}
+		if url, ok = discoveryFeedURL(discovery.body, feed, provider.language()); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "provider does not publish " + feed})
+			return
+		}
+	}
+	raw, ok := rawFeeds.get(provider.Location, url)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": feed + " has not been fetched yet"})
+		return
+	}
+
+	body := vehiclePrivacy.body(raw.body)
+	var published any = json.RawMessage(body)
+	if !json.Valid(body) {
+		published = string(body)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":   provider.Location,
+		"feed":       feed,
+		"url":        redactURL(url),
+		"fetched_at": raw.fetchedAt,
+		"raw":        published,
+		"report":     normalizationReport(provider, feed, url, raw.body),
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the per-cycle directory names, chosen so they sort chronologically
+const recordCycleLayout = "20060102T150405.000Z"
+
+// Recorder saving raw feed responses, one directory per ingestion cycle
+var activeRecorder *feedRecorder
+
+// Replay source serving recorded responses instead of fetching upstream
+var activeReplay *feedReplay
+
+// Struct for writing raw responses to dir/<cycle>/<feed file>, with diffs only
+// as <feed file>.diff against the URL's previous recording
+type feedRecorder struct {
+	dir   string
+	diffs bool
+
+	mu       sync.Mutex
+	cycleDir string
+	bases    map[string]recordedBase
+}
+
+// Function to create a recorder rooted at dir
+func newFeedRecorder(dir string, diffs bool) (*feedRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &feedRecorder{dir: dir, diffs: diffs, bases: map[string]recordedBase{}}, nil
+}
+
+// Function to start a new recording cycle; subsequent responses go into a fresh directory
+func (r *feedRecorder) beginCycle() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cycleDir = ""
+}
+
+// Function to save a response body for url in the current cycle
+func (r *feedRecorder) save(url string, body []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cycleDir == "" {
+		r.cycleDir = filepath.Join(r.dir, time.Now().UTC().Format(recordCycleLayout))
+		if err := os.MkdirAll(r.cycleDir, 0o755); err != nil {
+			log.Printf("Error creating record directory %s: %v", r.cycleDir, err)
+			r.cycleDir = ""
+			return
+		}
+	}
+	name, data := recordFileName(url), vehiclePrivacy.body(body)
+	if r.diffs {
+		name, data = r.encodeRecording(url, name, data)
+	}
+	if err := os.WriteFile(filepath.Join(r.cycleDir, name), data, 0o644); err != nil {
+		log.Printf("Error recording %s: %v", url, err)
+		// The next recording must not depend on this one
+		delete(r.bases, url)
+	}
+}
+
+// File of a recorded cycle listing the data license of each provider recorded
+const recordLicensesFile = "licenses.json"
+
+// Function to save the data licenses of the cycle's providers next to their
+// recorded feeds, so copies of the archive keep the attribution they need
+func (r *feedRecorder) saveLicenses(outcomes []scrapeOutcome) {
+	if r == nil {
+		return
+	}
+	licenses := map[string]*DataLicense{}
+	for _, outcome := range outcomes {
+		if outcome.provider.License != nil {
+			licenses[outcome.provider.Location] = outcome.provider.License
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cycleDir == "" || len(licenses) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(licenses, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(r.cycleDir, recordLicensesFile), append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		log.Printf("Error recording data licenses: %v", err)
+	}
+}
+
+// Function to map a feed URL to a stable, filesystem-safe and still readable file
+// name. The query string, which may hold API tokens, only goes into the hash.
+func recordFileName(url string) string {
+	path, _, _ := strings.Cut(url, "?")
+	return recordFileNameOf(path, url)
+}
+
+// Function to return the name recordings of url had before query strings were left
+// out, so archives recorded then can still be replayed
+func legacyRecordFileName(url string) string {
+	return recordFileNameOf(url, url)
+}
+
+// Function to build a recording's file name from the readable part and the hashed URL
+func recordFileNameOf(readablePart, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	readable := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimPrefix(strings.TrimPrefix(readablePart, "https://"), "http://"))
+	if len(readable) > 80 {
+		readable = readable[:80]
+	}
+	return readable + "-" + hex.EncodeToString(sum[:4]) + ".json"
+}
+
+// Struct for one recorded cycle available for replay
+type replayCycle struct {
+	name string
+	at   time.Time
+}
+
+// Interface for a store of recorded cycles, either a local --record directory
+// or a copy of one in object storage
+type feedArchive interface {
+	listCycles() ([]string, error)
+	readFile(cycle, name string) ([]byte, error)
+}
+
+// Struct for an archive in a local directory
+type localArchive struct {
+	dir string
+}
+
+// Function to list the cycle directories in the archive
+func (a localArchive) listCycles() ([]string, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Function to read one recorded response
+func (a localArchive) readFile(cycle, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(a.dir, cycle, name))
+}
+
+// Function to open an archive from a local path or s3://bucket/prefix URL
+func openArchive(location string) (feedArchive, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return newS3Archive(location)
+	}
+	return localArchive{dir: location}, nil
+}
+
+// Struct serving recorded responses cycle by cycle
+type feedReplay struct {
+	reader *recordingReader
+	cycles []replayCycle
+
+	mu      sync.Mutex
+	current int
+}
+
+// Function to load the recorded cycles in an archive, oldest first
+func newFeedReplay(location string) (*feedReplay, error) {
+	archive, err := openArchive(location)
+	if err != nil {
+		return nil, err
+	}
+	names, err := archive.listCycles()
+	if err != nil {
+		return nil, err
+	}
+
+	var cycles []replayCycle
+	for _, name := range names {
+		at, err := time.Parse(recordCycleLayout, name)
+		if err != nil {
+			continue
+		}
+		cycles = append(cycles, replayCycle{name: name, at: at})
+	}
+	if len(cycles) == 0 {
+		return nil, fmt.Errorf("no recorded cycles found in %s", location)
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i].at.Before(cycles[j].at) })
+	return &feedReplay{reader: newRecordingReader(archive), cycles: cycles}, nil
+}
+
+// Function to select the cycle served by fetch
+func (r *feedReplay) setCycle(i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = i
+}
+
+// Function to return the recorded body for url in the current cycle
+func (r *feedReplay) fetch(url string, trace *ScrapeTrace) ([]byte, error) {
+	start := time.Now()
+	r.mu.Lock()
+	cycle := r.cycles[r.current]
+	r.mu.Unlock()
+
+	body, err := r.reader.read(cycle.name, recordFileName(url))
+	if err != nil && strings.Contains(url, "?") {
+		body, err = r.reader.read(cycle.name, legacyRecordFileName(url))
+	}
+	if err != nil {
+		err = fmt.Errorf("no recording of %s in cycle %s", url, cycle.name)
+		trace.recordRequest(url, 0, 0, time.Since(start), err)
+		return nil, err
+	}
+	trace.recordRequest(url, 200, len(body), time.Since(start), nil)
+	return body, nil
+}
+
+// Background Goroutine running every recorded cycle through ingestion at its
+// recorded time, so analytics and alerts see the archive as it happened, compressing
+// the recorded gaps between cycles by speed; speed 0 runs the cycles back to back
+func startReplayIngestion(replay *feedReplay, speed float64) {
+	replayed := &manualClock{now: replay.cycles[0].at}
+	clock = replayed
+	ingestionLoops.Add(1)
+	go func() {
+		defer ingestionLoops.Done()
+		for i, cycle := range replay.cycles {
+			if ingestionCtx.Err() != nil {
+				return
+			}
+			replay.setCycle(i)
+			replayed.set(cycle.at)
+
+			log.Printf("Replaying cycle %d/%d recorded at %s", i+1, len(replay.cycles), cycle.at.Format(time.RFC3339))
+			ingestGBFSData()
+
+			if i+1 < len(replay.cycles) && speed > 0 {
+				gap := replay.cycles[i+1].at.Sub(cycle.at)
+				if !waitForNextCycle(time.Duration(float64(gap) / speed)) {
+					return
+				}
+			}
+		}
+		log.Printf("Replay finished after %d cycles", len(replay.cycles))
+	}()
+}
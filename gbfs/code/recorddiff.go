@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"sync"
+)
+
+// Suffix of recorded bodies stored as a diff against the previous recording of the URL
+const recordDiffSuffix = ".diff"
+
+// Magic line starting every diff file
+const recordDiffMagic = "GBFSDIFF1\n"
+
+// Length of the blocks matched between a body and its base; shorter common runs are
+// stored literally
+const diffBlockSize = 16
+
+// Diffs written in a row before a URL's body is stored in full again, which bounds
+// the chain replay has to follow
+const recordKeyframeInterval = 100
+
+// Diff operations: copy a run of the base or insert literal bytes
+const (
+	diffCopy   byte = 'C'
+	diffInsert byte = 'I'
+)
+
+// Function to encode target as copies from base and literal inserts. The base is
+// indexed by its aligned blocks; every match is extended as far as it goes both ways.
+func encodeDiff(base, target []byte) []byte {
+	index := make(map[string]int, len(base)/diffBlockSize)
+	for i := 0; i+diffBlockSize <= len(base); i += diffBlockSize {
+		block := string(base[i : i+diffBlockSize])
+		if _, ok := index[block]; !ok {
+			index[block] = i
+		}
+	}
+
+	var out bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(v int) {
+		out.Write(scratch[:binary.PutUvarint(scratch[:], uint64(v))])
+	}
+	literalStart := 0
+	flushLiteral := func(end int) {
+		if end > literalStart {
+			out.WriteByte(diffInsert)
+			putUvarint(end - literalStart)
+			out.Write(target[literalStart:end])
+		}
+	}
+
+	for i := 0; i+diffBlockSize <= len(target); {
+		offset, ok := index[string(target[i:i+diffBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+		// Extend backwards into the literal run, then forwards
+		start, baseStart := i, offset
+		for start > literalStart && baseStart > 0 && target[start-1] == base[baseStart-1] {
+			start--
+			baseStart--
+		}
+		end, baseEnd := i+diffBlockSize, offset+diffBlockSize
+		for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+			end++
+			baseEnd++
+		}
+		flushLiteral(start)
+		out.WriteByte(diffCopy)
+		putUvarint(baseStart)
+		putUvarint(end - start)
+		i, literalStart = end, end
+	}
+	flushLiteral(len(target))
+	return out.Bytes()
+}
+
+// Function to rebuild a body from its base and the operations of encodeDiff
+func applyDiff(base, ops []byte) ([]byte, error) {
+	var out bytes.Buffer
+	r := bytes.NewReader(ops)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case diffCopy:
+			offset, err1 := binary.ReadUvarint(r)
+			length, err2 := binary.ReadUvarint(r)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, err
+			}
+			if offset > uint64(len(base)) || length > uint64(len(base))-offset {
+				return nil, fmt.Errorf("copy of %d bytes at %d is outside the %d byte base", length, offset, len(base))
+			}
+			out.Write(base[offset : offset+length])
+		case diffInsert:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if length > uint64(r.Len()) {
+				return nil, fmt.Errorf("insert of %d bytes past the end of the diff", length)
+			}
+			literal := make([]byte, length)
+			r.Read(literal)
+			out.Write(literal)
+		default:
+			return nil, fmt.Errorf("unknown diff operation %q", op)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// Function to wrap a diff in its file format: the magic line, the base cycle, the
+// length and CRC-32 of the rebuilt body, then the operations
+func marshalRecordDiff(baseCycle string, target, ops []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString(recordDiffMagic)
+	out.WriteString(baseCycle + "\n")
+	fmt.Fprintf(&out, "%d %08x\n", len(target), crc32.ChecksumIEEE(target))
+	out.Write(ops)
+	return out.Bytes()
+}
+
+// Function to split a diff file into its base cycle, expected length and checksum, and operations
+func unmarshalRecordDiff(data []byte) (string, int, uint32, []byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(recordDiffMagic))
+	if !ok {
+		return "", 0, 0, nil, fmt.Errorf("not a recorded diff")
+	}
+	baseCycle, rest, ok1 := bytes.Cut(rest, []byte("\n"))
+	header, ops, ok2 := bytes.Cut(rest, []byte("\n"))
+	if !ok1 || !ok2 {
+		return "", 0, 0, nil, fmt.Errorf("truncated diff header")
+	}
+	var length int
+	var sum uint32
+	if _, err := fmt.Sscanf(string(header), "%d %x", &length, &sum); err != nil {
+		return "", 0, 0, nil, fmt.Errorf("invalid diff header: %w", err)
+	}
+	return string(baseCycle), length, sum, ops, nil
+}
+
+// Struct for the latest recording of a URL, the base of its next diff
+type recordedBase struct {
+	cycle string
+	body  []byte
+	// diffs written since the body was last stored in full
+	chain int
+}
+
+// Function to return the file name and content to record for body: a diff against
+// the URL's previous recording when that is smaller than half the body, else the
+// body itself. Only used with --record-diffs.
+func (r *feedRecorder) encodeRecording(url, name string, body []byte) (string, []byte) {
+	cycle := filepath.Base(r.cycleDir)
+	previous, ok := r.bases[url]
+	r.bases[url] = recordedBase{cycle: cycle, body: body}
+	if !ok || previous.chain >= recordKeyframeInterval {
+		return name, body
+	}
+	ops := encodeDiff(previous.body, body)
+	if len(ops) > len(body)/2 {
+		return name, body
+	}
+	r.bases[url] = recordedBase{cycle: cycle, body: body, chain: previous.chain + 1}
+	return name + recordDiffSuffix, marshalRecordDiff(previous.cycle, body, ops)
+}
+
+// Struct reading recorded bodies from an archive, rebuilding those stored as diffs.
+// The latest body of each file is kept, so replaying cycles in order applies one
+// diff per file and cycle.
+type recordingReader struct {
+	archive feedArchive
+
+	mu     sync.Mutex
+	latest map[string]recordedBase
+}
+
+// Function to create a reader over an archive
+func newRecordingReader(archive feedArchive) *recordingReader {
+	return &recordingReader{archive: archive, latest: map[string]recordedBase{}}
+}
+
+// Function to read the body recorded as name in a cycle, in full or as a diff
+func (r *recordingReader) read(cycle, name string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readLocked(cycle, name, 0)
+}
+
+func (r *recordingReader) readLocked(cycle, name string, depth int) ([]byte, error) {
+	if latest, ok := r.latest[name]; ok && latest.cycle == cycle {
+		return latest.body, nil
+	}
+	if depth > recordKeyframeInterval {
+		return nil, fmt.Errorf("diff chain of %s is longer than %d", name, recordKeyframeInterval)
+	}
+	body, err := r.archive.readFile(cycle, name)
+	if err != nil {
+		data, diffErr := r.archive.readFile(cycle, name+recordDiffSuffix)
+		if diffErr != nil {
+			return nil, err
+		}
+		baseCycle, length, sum, ops, err := unmarshalRecordDiff(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s%s: %w", cycle, name, recordDiffSuffix, err)
+		}
+		base, err := r.readLocked(baseCycle, name, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = applyDiff(base, ops); err != nil {
+			return nil, fmt.Errorf("%s/%s%s: %w", cycle, name, recordDiffSuffix, err)
+		}
+		if len(body) != length || crc32.ChecksumIEEE(body) != sum {
+			return nil, fmt.Errorf("%s/%s%s: rebuilt body does not match its checksum", cycle, name, recordDiffSuffix)
+		}
+	}
+	r.latest[name] = recordedBase{cycle: cycle, body: body}
+	return body, nil
+}
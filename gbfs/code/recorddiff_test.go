@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestDiffRoundTrip(t *testing.T) {
+	feed := []byte(strings.Repeat(`{"station_id":"s1","num_bikes_available":4},`, 20))
+	changed := bytes.Replace(feed, []byte(`"num_bikes_available":4`), []byte(`"num_bikes_available":5`), 3)
+	tests := []struct {
+		name         string
+		base, target []byte
+	}{
+		{"both empty", nil, nil},
+		{"empty base", nil, feed},
+		{"empty target", feed, nil},
+		{"identical", feed, feed},
+		{"shorter than a block", []byte("abc"), []byte("abd")},
+		{"small edits", feed, changed},
+		{"prefix added", feed, append([]byte("header\n"), feed...)},
+		{"truncated", feed, feed[:len(feed)/2]},
+		{"unrelated", feed, bytes.ToUpper(feed)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := encodeDiff(tt.base, tt.target)
+			got, err := applyDiff(tt.base, ops)
+			if err != nil {
+				t.Fatalf("applyDiff: %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Fatalf("round trip = %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestDiffCopiesSharedBlocks(t *testing.T) {
+	feed := []byte(strings.Repeat("0123456789abcdef", 64))
+	target := append(append([]byte{}, feed...), "tail"...)
+	if ops := encodeDiff(feed, target); len(ops) > 32 {
+		t.Errorf("diff of an appended body is %d bytes, want a copy and a short insert", len(ops))
+	}
+}
+
+func TestApplyDiffMalformed(t *testing.T) {
+	base := []byte("0123456789")
+	op := func(kind byte, values ...uint64) []byte {
+		out := []byte{kind}
+		for _, v := range values {
+			out = binary.AppendUvarint(out, v)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		ops  []byte
+		err  string
+	}{
+		{"unknown operation", []byte("X"), "unknown diff operation"},
+		{"copy past the base", op(diffCopy, 8, 5), "outside the 10 byte base"},
+		{"copy offset past the base", op(diffCopy, 11, 0), "outside the 10 byte base"},
+		{"copy length overflowing", op(diffCopy, 1, 1<<64-1), "outside the 10 byte base"},
+		{"copy missing its length", op(diffCopy, 1), "EOF"},
+		{"insert past the end", append(op(diffInsert, 4), "ab"...), "past the end of the diff"},
+		{"insert missing its length", []byte{diffInsert}, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyDiff(base, tt.ops)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("applyDiff() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+	if got, err := applyDiff(base, nil); err != nil || len(got) != 0 {
+		t.Errorf("applyDiff with no operations = %q, %v", got, err)
+	}
+}
+
+func TestRecordDiffFile(t *testing.T) {
+	target := []byte(`{"data":{}}`)
+	ops := encodeDiff([]byte(`{"data":{"x":1}}`), target)
+	cycle, length, sum, gotOps, err := unmarshalRecordDiff(marshalRecordDiff("20260101T000000Z", target, ops))
+	if err != nil {
+		t.Fatalf("unmarshalRecordDiff: %v", err)
+	}
+	if cycle != "20260101T000000Z" || length != len(target) || sum != crc32.ChecksumIEEE(target) || !bytes.Equal(gotOps, ops) {
+		t.Errorf("unmarshalRecordDiff = %q, %d, %08x, %q", cycle, length, sum, gotOps)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"empty", "", "not a recorded diff"},
+		{"plain body", `{"data":{}}`, "not a recorded diff"},
+		{"missing header", recordDiffMagic + "20260101T000000Z", "truncated diff header"},
+		{"missing operations line", recordDiffMagic + "20260101T000000Z\n11 0000abcd", "truncated diff header"},
+		{"invalid header", recordDiffMagic + "20260101T000000Z\nlong crc\n", "invalid diff header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, err := unmarshalRecordDiff([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unmarshalRecordDiff() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
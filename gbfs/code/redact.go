@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replacement for every redacted value
+const redacted = "REDACTED"
+
+// Fragments that mark a query parameter as carrying a credential
+var sensitiveParamFragments = []string{"key", "token", "secret", "password", "passwd", "auth", "signature", "sig", "credential", "session"}
+
+// Pattern for URLs embedded in free text such as error messages
+var embeddedURLPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// Secret values resolved at runtime, scrubbed from any text that passes through redactText
+var knownSecrets = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: map[string]bool{}}
+
+// Function to remember a secret value so it is never logged or exported
+func registerSecretValue(value string) {
+	// Very short values would redact unrelated text
+	if len(value) < 4 {
+		return
+	}
+	knownSecrets.Lock()
+	knownSecrets.values[value] = true
+	knownSecrets.Unlock()
+}
+
+// Function to report whether a query parameter name looks like it carries a credential
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, fragment := range sensitiveParamFragments {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to strip credentials from a URL: passwords and token-like user
+// info, and the values of credential query parameters. URLs without
+// credentials are returned unchanged, so metric label values stay stable.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	changed := false
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		} else {
+			u.User = url.User(redacted)
+		}
+		changed = true
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name, values := range query {
+			if isSensitiveParam(name) {
+				for i := range values {
+					values[i] = redacted
+				}
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return u.String()
+}
+
+// Function to redact every URL and known secret inside free text
+func redactText(text string) string {
+	text = embeddedURLPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// Punctuation that ends a sentence is not part of the URL
+		trimmed := strings.TrimRight(match, ".,;:)")
+		return redactURL(trimmed) + match[len(trimmed):]
+	})
+
+	knownSecrets.RLock()
+	defer knownSecrets.RUnlock()
+	for secret := range knownSecrets.values {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// Struct for an error whose message has been sanitized; Unwrap keeps errors.Is/As working
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e redactedError) Error() string { return e.msg }
+func (e redactedError) Unwrap() error { return e.err }
+
+// Function to sanitize an error message, passing nil through
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(redactedError); ok {
+		return err
+	}
+	return redactedError{msg: redactText(err.Error()), err: err}
+}
+
+// Struct for an io.Writer that redacts everything written through it
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactText(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Function to route the standard logger and Gin's request logs through redaction
+func installLogRedaction() {
+	log.SetOutput(redactingWriter{os.Stderr})
+	gin.DefaultWriter = redactingWriter{os.Stdout}
+	gin.DefaultErrorWriter = redactingWriter{os.Stderr}
+}
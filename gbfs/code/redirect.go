@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Struct for the redirect rules of feed requests
+type redirectPolicy struct {
+	// maxHops is the most redirects followed per request; 0 follows none
+	maxHops int
+	// crossHosts are exact or "*.suffix" hosts a request may be redirected to
+	// from another host; empty allows any host the egress policy allows
+	crossHosts []string
+}
+
+// Redirect rules, set with --max-redirects and --redirect-host
+var redirects = &redirectPolicy{maxHops: 10}
+
+// Struct for a redirect followed while fetching a feed
+type TraceRedirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// Struct collecting the redirects of one request, carried in its context
+type redirectLog struct {
+	mu   sync.Mutex
+	hops []TraceRedirect
+}
+
+// Context key of a request's redirect log
+type redirectLogKey struct{}
+
+// Function to attach a redirect log to a request context
+func withRedirectLog(ctx context.Context) (context.Context, *redirectLog) {
+	log := &redirectLog{}
+	return context.WithValue(ctx, redirectLogKey{}, log), log
+}
+
+// Function to return the redirects recorded so far
+func (l *redirectLog) recorded() []TraceRedirect {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]TraceRedirect(nil), l.hops...)
+}
+
+// Function to decide whether to follow a redirect to req, after the requests in
+// via; the egress policy applies to every hop
+func (p *redirectPolicy) check(req *http.Request, via []*http.Request) error {
+	previous := via[len(via)-1]
+	if log, ok := req.Context().Value(redirectLogKey{}).(*redirectLog); ok {
+		status := 0
+		if req.Response != nil {
+			status = req.Response.StatusCode
+		}
+		log.mu.Lock()
+		log.hops = append(log.hops, TraceRedirect{From: redactURL(previous.URL.String()), To: redactURL(req.URL.String()), Status: status})
+		log.mu.Unlock()
+	}
+
+	if len(via) > p.maxHops {
+		if p.maxHops == 0 {
+			return fmt.Errorf("redirect to %s not followed: redirects are disabled", redactURL(req.URL.String()))
+		}
+		return fmt.Errorf("stopped after %d redirects", p.maxHops)
+	}
+	if len(p.crossHosts) > 0 && req.URL.Hostname() != via[0].URL.Hostname() {
+		allowed := false
+		for _, pattern := range p.crossHosts {
+			if hostMatches(req.URL.Hostname(), pattern) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("redirect from %s to %s not allowed: host is not in --redirect-host", via[0].URL.Hostname(), req.URL.Hostname())
+		}
+	}
+	return egress.checkURL(req.URL.String())
+}
+
+// Function to attach a request's redirects to the last request in the trace
+func (t *ScrapeTrace) recordRedirects(hops []TraceRedirect) {
+	if t == nil || len(hops) == 0 || len(t.Requests) == 0 {
+		return
+	}
+	t.Requests[len(t.Requests)-1].Redirects = hops
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Statuses of a provider in the registry
+const (
+	// The provider is configured and scraped
+	providerActive = "active"
+	// The provider was removed from the config; its history stays queryable
+	providerRemoved = "removed"
+	// An admin paused the provider; it stays configured but is not scraped
+	providerDeactivated = "deactivated"
+)
+
+// Struct for every provider this instance has seen, with its status
+type ProviderRecord struct {
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Source     string    `json:"source"`
+	Deployment string    `json:"deployment,omitempty"`
+	Status     string    `json:"status"`
+	FirstSeen  time.Time `json:"first_seen"`
+	// Since is when the provider entered its current status
+	Since time.Time `json:"since"`
+
+	provider Provider
+}
+
+// Struct tracking providers across config changes, so removing one soft-deletes
+// it instead of orphaning its state, optionally persisted as a JSON file
+type providerRegistry struct {
+	mu      sync.Mutex
+	records map[string]*ProviderRecord
+	path    string
+}
+
+// Registry consulted whenever providers are loaded; persisted with serve --provider-registry
+var registry = &providerRegistry{records: map[string]*ProviderRecord{}}
+
+// Function to load the records kept in a JSON file and save every status change to it
+func (r *providerRegistry) persistTo(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []*ProviderRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, record := range records {
+		record.provider = newProvider(record.Name, record.URL, record.Source, nil)
+		record.provider.Deployment = record.Deployment
+		r.records[record.Name] = record
+	}
+	return nil
+}
+
+// Function to write the records to the registry file, if persisted; called with the lock held
+func (r *providerRegistry) save() {
+	if r.path == "" {
+		return
+	}
+	records := r.sorted()
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		// Write then rename, so a crash never leaves a truncated registry
+		tmp := r.path + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, r.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving provider registry %s: %v", r.path, err)
+	}
+}
+
+// Function to return the records sorted by name; called with the lock held
+func (r *providerRegistry) sorted() []ProviderRecord {
+	records := make([]ProviderRecord, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records
+}
+
+// Function to update the registry with the configured providers and return those
+// to scrape: new and re-added providers become active, missing ones removed, and
+// deactivated ones are left out
+func (r *providerRegistry) reconcile(providers []Provider, now time.Time) []Provider {
+	r.mu.Lock()
+	changed := false
+	configured := make(map[string]bool, len(providers))
+	active := providers[:0:0]
+	for _, provider := range providers {
+		configured[provider.Location] = true
+		record, ok := r.records[provider.Location]
+		switch {
+		case !ok:
+			record = &ProviderRecord{Name: provider.Location, Status: providerActive, FirstSeen: now.UTC(), Since: now.UTC()}
+			r.records[provider.Location] = record
+			changed = true
+		case record.Status == providerRemoved:
+			log.Printf("Provider %s reactivated after being removed since %s", provider.Location, record.Since.Format(time.RFC3339))
+			record.Status, record.Since = providerActive, now.UTC()
+			changed = true
+		}
+		if record.URL != redactURL(provider.URL) || record.Source != provider.Source || record.Deployment != provider.Deployment {
+			record.URL, record.Source, record.Deployment = redactURL(provider.URL), provider.Source, provider.Deployment
+			changed = true
+		}
+		record.provider = provider
+		if record.Status != providerDeactivated {
+			active = append(active, provider)
+		}
+	}
+
+	var removed []Provider
+	for name, record := range r.records {
+		if configured[name] || record.Status == providerRemoved {
+			continue
+		}
+		log.Printf("Provider %s removed from the config, keeping its history", name)
+		record.Status, record.Since = providerRemoved, now.UTC()
+		removed = append(removed, record.provider)
+		changed = true
+	}
+	if changed {
+		r.save()
+	}
+	r.mu.Unlock()
+
+	for _, provider := range removed {
+		retireProviderMetrics(provider)
+	}
+	return active
+}
+
+// Function to drop the record of a provider that was only known under a provisional
+// name, so it does not linger as removed once the provider is named
+func (r *providerRegistry) drop(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.records[name]; ok {
+		delete(r.records, name)
+		r.save()
+	}
+}
+
+// Function to delete the live series of a provider that is no longer scraped
+func retireProviderMetrics(provider Provider) {
+	providerBikes.DeleteLabelValues(metricLocation(provider), redactURL(provider.URL))
+	updateStationStatusMetrics(provider, nil)
+	retireFleetCapMetrics(provider)
+	retireDwellMetrics(provider)
+	throttledUntilGauge.DeleteLabelValues(metricLocation(provider))
+	dwells.forget(provider.Location)
+	derivedMetrics.forget(provider.Location)
+	stationEvents.forget(provider.Location)
+	rawFeeds.forget(provider.Location)
+	discoveryFailover.forget(provider)
+	labelLimits.forget(provider.Location)
+	vehiclePrices.forget(provider.Location)
+	for _, gauge := range []*prometheus.GaugeVec{vehiclesByCategory, vehicleRangeAverage, vehicleFuelAverage, providerHealthScore, providerHealthComponent, vehicleUnlockPrice, vehiclePerMinutePrice} {
+		gauge.DeletePartialMatch(prometheus.Labels{"location": metricLocation(provider)})
+	}
+}
+
+// Function to return a provider that is no longer active, for queries of its history
+func (r *providerRegistry) inactive(name string) (Provider, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.records[name]
+	if !ok || record.Status == providerActive {
+		return Provider{}, false
+	}
+	return record.provider, true
+}
+
+// Function to return the records that are not active, sorted by name
+func (r *providerRegistry) inactiveRecords() []ProviderRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []ProviderRecord
+	for _, record := range r.sorted() {
+		if record.Status != providerActive {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Function to return the status of a provider, active when unknown
+func (r *providerRegistry) status(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if record, ok := r.records[name]; ok {
+		return record.Status
+	}
+	return providerActive
+}
+
+// Function to return when a provider was first configured, zero when unknown
+func (r *providerRegistry) firstSeen(name string) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if record, ok := r.records[name]; ok {
+		return record.FirstSeen
+	}
+	return time.Time{}
+}
+
+// Function to pause or resume scraping a configured provider
+func (r *providerRegistry) setDeactivated(name string, deactivated bool, now time.Time) (ProviderRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.records[name]
+	if !ok {
+		return ProviderRecord{}, fmt.Errorf("unknown provider %s", name)
+	}
+	if record.Status == providerRemoved {
+		return ProviderRecord{}, fmt.Errorf("provider %s was removed from the config; add it back to reactivate it", name)
+	}
+	status := providerActive
+	if deactivated {
+		status = providerDeactivated
+	}
+	if record.Status != status {
+		record.Status, record.Since = status, now.UTC()
+		r.save()
+		if deactivated {
+			retireProviderMetrics(record.provider)
+		}
+	}
+	return *record, nil
+}
+
+// Function to forget a removed provider along with its latest state
+func (r *providerRegistry) purge(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.records[name]
+	if !ok {
+		return fmt.Errorf("unknown provider %s", name)
+	}
+	if record.Status != providerRemoved {
+		return fmt.Errorf("provider %s is still configured; remove it from the config first", name)
+	}
+	delete(r.records, name)
+	r.save()
+	liveState.remove(name)
+	serviceAreas.forget(name)
+	return nil
+}
+
+// Handler for POST /admin/providers/:name/deactivate and /reactivate
+func providerActivationHandler(deactivate bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		record, err := registry.setDeactivated(c.Param("name"), deactivate, time.Now())
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// Handler for DELETE /admin/providers/:name, purging a removed provider's latest state
+func purgeProviderHandler(c *gin.Context) {
+	if err := registry.purge(c.Param("name")); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct holding the config file serve runs with, replaced on SIGHUP or POST /reload
+type configState struct {
+	// Retries of the deployment webhooks started from the config
+	retries int
+
+	mu     sync.RWMutex
+	config *Config
+}
+
+// Config loaded by serve; other commands read --config directly
+var loadedConfig = &configState{}
+
+// Counter for config reloads by result
+var configReloads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_config_reloads_total",
+		Help: "Number of config file reloads, by result (success or failure)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(configReloads)
+}
+
+// Function to return the loaded config, if serve loaded one
+func (s *configState) current() (Config, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.config == nil {
+		return Config{}, false
+	}
+	return *s.config, true
+}
+
+// Function to start using a validated config: its providers, deployment webhooks, station alerts and derived metrics
+func (s *configState) apply(config Config) error {
+	if err := checkProviderSecrets(config.providers()); err != nil {
+		return err
+	}
+	if err := setupDeploymentSinks(config, s.retries); err != nil {
+		return err
+	}
+	stationAlerts.setRules(config.StationAlerts)
+	derivedMetrics.setRules(config.DerivedMetrics)
+	s.mu.Lock()
+	s.config = &config
+	s.mu.Unlock()
+	return nil
+}
+
+// Function to re-read --config; an invalid file leaves the running config in place
+func (s *configState) reload() (Config, error) {
+	config, err := loadConfig(configPath)
+	if err == nil {
+		err = s.apply(config)
+	}
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		return Config{}, err
+	}
+	configReloads.WithLabelValues("success").Inc()
+	log.Printf("Reloaded %s: %d providers", configPath, len(config.providers()))
+	return config, nil
+}
+
+// Function to reload the config on every SIGHUP and scrape its providers right away
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if _, err := loadedConfig.reload(); err != nil {
+				log.Printf("Error reloading config, keeping the previous one: %v", err)
+				continue
+			}
+			ingestGBFSData()
+		}
+	}()
+}
+
+// Handler for POST /reload, re-reading the config file and starting an ingestion of its providers
+func reloadHandler(c *gin.Context) {
+	if _, ok := loadedConfig.current(); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no --config file is loaded"})
+		return
+	}
+	config, err := loadedConfig.reload()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	go ingestGBFSData()
+	c.JSON(http.StatusOK, gin.H{"providers": len(config.providers())})
+}
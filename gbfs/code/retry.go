@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Retry settings for feed requests, set with the root --fetch-retries and --fetch-retry-backoff flags
+var fetchRetries = struct {
+	attempts int
+	backoff  time.Duration
+	// maxWait caps the wait between attempts, including a Retry-After asked for by the server
+	maxWait time.Duration
+}{
+	attempts: 2,
+	backoff:  500 * time.Millisecond,
+	maxWait:  30 * time.Second,
+}
+
+// Counter for feed requests retried after a transient failure
+var fetchRetriesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_fetch_retries_total",
+		Help: "Number of feed requests retried after a network error, 429 or 5xx response",
+	},
+	[]string{"location"},
+)
+
+func init() {
+	prometheus.MustRegister(fetchRetriesTotal)
+}
+
+// Error type for failures that a later attempt may not repeat, with the wait the server asked for
+type transientError struct {
+	error
+	retryAfter time.Duration
+}
+
+// Function to check the retry flags
+func validFetchRetries() error {
+	if fetchRetries.attempts < 0 {
+		return fmt.Errorf("--fetch-retries cannot be negative")
+	}
+	if fetchRetries.backoff < 0 {
+		return fmt.Errorf("--fetch-retry-backoff cannot be negative")
+	}
+	return nil
+}
+
+// Function to turn a non-2xx response into an error, transient for 429 and 5xx
+func responseStatusError(url string, resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("%s returned %s", url, resp.Status)
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return err
+	}
+	return transientError{error: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
+
+// Function to parse a Retry-After header given in seconds or as an HTTP date; zero when absent
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// Function to run a feed request, retrying transient failures with exponential backoff
+// as long as the wait fits in what is left of the provider's scrape budget. Shutdown
+// ends the wait and returns the last failure. Retries of traced dry runs are not counted.
+func withFetchRetries(provider Provider, trace *ScrapeTrace, fetch func() ([]byte, error)) ([]byte, error) {
+	backoff := fetchRetries.backoff
+	for attempt := 0; ; attempt++ {
+		body, err := fetch()
+		var transient transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= fetchRetries.attempts {
+			return body, err
+		}
+		wait := max(backoff, transient.retryAfter)
+		if wait > fetchRetries.maxWait {
+			return body, err
+		}
+		if !provider.deadline.IsZero() && time.Now().Add(wait).After(provider.deadline) {
+			return body, err
+		}
+		// A 429 or 503 with Retry-After paused the provider, so a retry would only be refused
+		if throttles.allow(provider, time.Now()) != nil {
+			return body, err
+		}
+		if trace == nil {
+			fetchRetriesTotal.WithLabelValues(metricLocation(provider)).Inc()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ingestionCtx.Done():
+			timer.Stop()
+			return body, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gauge for bikes available summed over the providers sharing a tag value
+var rollupBikes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_rollup_available_bikes",
+		Help: "Number of bikes available across the providers with the same value of a configured tag",
+	},
+	[]string{"tag", "value"},
+)
+
+// Gauge for the number of providers contributing to each rollup
+var rollupProviders = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_rollup_providers",
+		Help: "Number of providers with the same value of a configured tag",
+	},
+	[]string{"tag", "value"},
+)
+
+func init() {
+	prometheus.MustRegister(rollupBikes, rollupProviders)
+}
+
+// Struct for the key of a rollup
+type rollupKey struct {
+	tag, value string
+}
+
+// Function to sum availability per tag value from each provider's latest state,
+// leaving out providers that are closed
+func computeRollups(states []ProviderState, closed func(location string) bool) (map[rollupKey]int, map[rollupKey]int) {
+	bikes := map[rollupKey]int{}
+	providers := map[rollupKey]int{}
+	for _, state := range states {
+		if len(state.Provider.Tags) == 0 || closed(state.Provider.Location) {
+			continue
+		}
+		numBikes := ScrapeResult{Bikes: state.Bikes, Stations: state.Stations, BikeCount: state.BikeCount}.AvailableBikes()
+		for tag, value := range state.Provider.Tags {
+			key := rollupKey{tag: tag, value: value}
+			bikes[key] += numBikes
+			providers[key]++
+		}
+	}
+	return bikes, providers
+}
+
+// Function to replace the rollup gauges, so tag values no longer in use disappear
+func updateRollups(states []ProviderState, closed func(location string) bool) {
+	bikes, providers := computeRollups(states, closed)
+	keys := make([]rollupKey, 0, len(bikes))
+	for key := range bikes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].tag != keys[j].tag {
+			return keys[i].tag < keys[j].tag
+		}
+		return keys[i].value < keys[j].value
+	})
+
+	rollupBikes.Reset()
+	rollupProviders.Reset()
+	for _, key := range keys {
+		rollupBikes.WithLabelValues(key.tag, key.value).Set(float64(bikes[key]))
+		rollupProviders.WithLabelValues(key.tag, key.value).Set(float64(providers[key]))
+	}
+}
+
+// Function to report whether a provider's last scrape fell in its quiet or closed hours
+func providerQuiet(location string) bool {
+	quietScrapes.Lock()
+	defer quietScrapes.Unlock()
+	_, ok := quietScrapes.last[location]
+	return ok
+}
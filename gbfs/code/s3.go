@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Struct for a recorded archive stored under an S3 prefix, read with the plain
+// REST API so no SDK is needed. Credentials come from the standard
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN variables;
+// AWS_ENDPOINT_URL allows S3-compatible stores such as MinIO.
+type s3Archive struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint string
+	keyID    string
+	secret   string
+	token    string
+}
+
+// Function to create an archive from an s3://bucket/prefix URL
+func newS3Archive(location string) (*s3Archive, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing bucket in %s", location)
+	}
+
+	a := &s3Archive{
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		region:   os.Getenv("AWS_REGION"),
+		endpoint: strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		keyID:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if a.region == "" {
+		a.region = "us-east-1"
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://s3." + a.region + ".amazonaws.com"
+	}
+	if a.prefix != "" {
+		a.prefix += "/"
+	}
+	if a.keyID == "" || a.secret == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to read %s", location)
+	}
+	return a, nil
+}
+
+// Struct for the parts of a ListObjectsV2 response we use
+type s3ListResult struct {
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Function to list the cycle "directories" directly under the prefix
+func (a *s3Archive) listCycles() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("delimiter", "/")
+		query.Set("prefix", a.prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := a.do("/"+a.bucket, query)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("parsing S3 listing: %w", err)
+		}
+		for _, p := range result.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, a.prefix), "/"))
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Function to read one recorded response
+func (a *s3Archive) readFile(cycle, name string) ([]byte, error) {
+	return a.do("/"+a.bucket+"/"+a.prefix+cycle+"/"+name, nil)
+}
+
+// Function to perform a signed GET request against the bucket
+func (a *s3Archive) do(path string, query url.Values) ([]byte, error) {
+	u, err := url.Parse(a.endpoint + s3EscapePath(path))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	a.sign(req, time.Now().UTC(), emptyPayloadHash)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("S3 GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// Function to upload an object under the bucket
+func (a *s3Archive) put(key string, body []byte) error {
+	u, err := url.Parse(a.endpoint + s3EscapePath("/"+a.bucket+"/"+key))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	a.sign(req, time.Now().UTC(), hex.EncodeToString(sum[:]))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("S3 PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+// SHA-256 of an empty payload, as signed for GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Function to add AWS Signature Version 4 headers to a request with the given payload hash
+func (a *s3Archive) sign(req *http.Request, now time.Time, payloadHash string) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if a.token != "" {
+		req.Header.Set("x-amz-security-token", a.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + a.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+a.secret), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+a.keyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Function to compute an HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Function to URI-encode a path the way SigV4 expects, keeping slashes
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Function to build a SigV4 canonical query string
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, s3Escape(key)+"="+s3Escape(query.Get(key)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// Function to percent-encode everything except RFC 3986 unreserved characters
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
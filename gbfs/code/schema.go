@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Schema generations of the status feeds, whose field names differ: 1.x and 2.x
+// publish data.bikes and num_bikes_available, 3.x data.vehicles and num_vehicles_available
+const (
+	schemaV2 = "v2"
+	schemaV3 = "v3"
+)
+
+// Gauge for the schema each provider's status feeds were last parsed with
+var feedSchemaInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_feed_schema_info",
+		Help: "Schema (v2 or v3 field names) a status feed was last parsed with, always 1",
+	},
+	[]string{"location", "feed", "schema"},
+)
+
+// Counter for feeds that only parsed with a schema other than the declared version's
+var feedSchemaFallbacks = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_feed_schema_fallbacks_total",
+		Help: "Status feeds that failed to parse under the declared GBFS version's schema and parsed under the adjacent one",
+	},
+	[]string{"location", "feed", "schema"},
+)
+
+// Schema last exported per provider and feed, so a change replaces the old series
+var feedSchemas = struct {
+	sync.Mutex
+	byFeed map[[2]string]string
+}{byFeed: map[[2]string]string{}}
+
+func init() {
+	prometheus.MustRegister(feedSchemaInfo, feedSchemaFallbacks)
+}
+
+// Function to order the schemas to try for a declared version, its own first
+func schemaOrder(version string) []string {
+	major, _, _ := strings.Cut(version, ".")
+	if n, err := strconv.Atoi(major); err == nil && n >= 3 {
+		return []string{schemaV3, schemaV2}
+	}
+	return []string{schemaV2, schemaV3}
+}
+
+// Function to parse a feed with the schema of the declared version, retrying with the
+// adjacent one before failing. The schema that worked is exported; when all fail the
+// declared schema's error is returned, as it describes what the feed should look like.
+// Traced dry runs export nothing.
+func parseWithSchemas[T any](provider Provider, feed, version string, body []byte, trace *ScrapeTrace, parsers map[string]func([]byte) (T, error)) (T, error) {
+	var firstErr error
+	for i, schema := range schemaOrder(version) {
+		parsed, err := parsers[schema](body)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if trace == nil {
+			if i > 0 {
+				feedSchemaFallbacks.WithLabelValues(metricLocation(provider), feed, schema).Inc()
+			}
+			recordFeedSchema(provider, feed, version, schema)
+		}
+		return parsed, nil
+	}
+	var zero T
+	return zero, firstErr
+}
+
+// Function to export the schema a provider's feed parsed with, logging when it changes
+func recordFeedSchema(provider Provider, feed, version, schema string) {
+	location := metricLocation(provider)
+	key := [2]string{location, feed}
+	feedSchemas.Lock()
+	defer feedSchemas.Unlock()
+	previous, ok := feedSchemas.byFeed[key]
+	if ok && previous == schema {
+		return
+	}
+	if ok {
+		feedSchemaInfo.DeleteLabelValues(location, feed, previous)
+	}
+	if schema != schemaOrder(version)[0] {
+		log.Printf("Warning: %s declares GBFS %s but its %s only parses with %s field names", provider.Location, version, feed, schema)
+	}
+	feedSchemas.byFeed[key] = schema
+	feedSchemaInfo.WithLabelValues(location, feed, schema).Set(1)
+}
+
+// Parsers of free_bike_status (v2) and vehicle_status (v3), each requiring its own vehicle list
+var vehicleStatusParsers = map[string]func([]byte) (FreeBikeStatus, error){
+	schemaV2: func(body []byte) (FreeBikeStatus, error) {
+		var feed struct {
+			LastUpdated gbfsTime `json:"last_updated"`
+			TTL         int      `json:"ttl"`
+			Data        struct {
+				Bikes *[]Bike `json:"bikes"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &feed); err != nil {
+			return FreeBikeStatus{}, err
+		}
+		if feed.Data.Bikes == nil {
+			return FreeBikeStatus{}, fmt.Errorf("free_bike_status has no data.bikes")
+		}
+		status := FreeBikeStatus{LastUpdated: feed.LastUpdated, TTL: feed.TTL}
+		status.Data.Bikes = *feed.Data.Bikes
+		return status, nil
+	},
+	schemaV3: func(body []byte) (FreeBikeStatus, error) {
+		var feed struct {
+			LastUpdated gbfsTime `json:"last_updated"`
+			TTL         int      `json:"ttl"`
+			Data        struct {
+				Vehicles *[]Bike `json:"vehicles"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &feed); err != nil {
+			return FreeBikeStatus{}, err
+		}
+		if feed.Data.Vehicles == nil {
+			return FreeBikeStatus{}, fmt.Errorf("vehicle_status has no data.vehicles")
+		}
+		status := FreeBikeStatus{LastUpdated: feed.LastUpdated, TTL: feed.TTL}
+		status.Data.Bikes = *feed.Data.Vehicles
+		return status, nil
+	},
+}
+
+// Parsers of station_status requiring every station to count its bikes the version's way
+var stationStatusParsers = map[string]func([]byte) (stationStatusFeed, error){
+	schemaV2: func(body []byte) (stationStatusFeed, error) {
+		return parseStationStatus(body, "num_bikes_available", func(status stationStatus) bool { return status.BikesAvailable != nil })
+	},
+	schemaV3: func(body []byte) (stationStatusFeed, error) {
+		return parseStationStatus(body, "num_vehicles_available", func(status stationStatus) bool { return status.VehiclesAvailable != nil })
+	},
+}
+
+// Function to parse station_status, failing when a station lacks the counted field
+func parseStationStatus(body []byte, field string, counted func(stationStatus) bool) (stationStatusFeed, error) {
+	var feed stationStatusFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return stationStatusFeed{}, fmt.Errorf("parsing station_status: %w", err)
+	}
+	for _, status := range feed.Data.Stations {
+		if !counted(status) {
+			return stationStatusFeed{}, fmt.Errorf("station_status station %q has no %s", status.StationID, field)
+		}
+	}
+	return feed, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UTC days of upstream statistics kept for /api/v1/scrape-stats, today included
+const scrapeStatsDays = 7
+
+// Struct for a provider's upstream requests during one UTC day
+type ScrapeStats struct {
+	// Provider is empty in a day's total
+	Provider string `json:"provider,omitempty"`
+	Requests int    `json:"requests"`
+	// Errors are requests that failed to connect, timed out or returned an error status
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Bytes     int64   `json:"bytes"`
+	// DurationSeconds is the time spent waiting on the upstream, summed over the requests
+	DurationSeconds     float64 `json:"duration_seconds"`
+	MeanDurationSeconds float64 `json:"mean_duration_seconds"`
+	MaxDurationSeconds  float64 `json:"max_duration_seconds"`
+}
+
+// Function to add another provider's or day's statistics
+func (s *ScrapeStats) add(other ScrapeStats) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.Bytes += other.Bytes
+	s.DurationSeconds += other.DurationSeconds
+	s.MaxDurationSeconds = max(s.MaxDurationSeconds, other.MaxDurationSeconds)
+}
+
+// Function to fill in the rates derived from the sums
+func (s ScrapeStats) summarized() ScrapeStats {
+	if s.Requests > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+		s.MeanDurationSeconds = s.DurationSeconds / float64(s.Requests)
+	}
+	return s
+}
+
+// Struct for the upstream statistics of every provider during one UTC day
+type ScrapeStatsDay struct {
+	Day       string        `json:"day"`
+	Total     ScrapeStats   `json:"total"`
+	Providers []ScrapeStats `json:"providers"`
+}
+
+// Struct accounting what the exporter asks of each upstream, per UTC day
+type scrapeStatsTracker struct {
+	mu sync.Mutex
+	// statistics by day and provider
+	days map[string]map[string]*ScrapeStats
+}
+
+// Upstream statistics, fed by every request to a provider
+var scrapeStats = &scrapeStatsTracker{days: map[string]map[string]*ScrapeStats{}}
+
+// Function to account one upstream request with the redirects it followed
+func (t *scrapeStatsTracker) record(provider Provider, requests, bytes int, duration time.Duration, err error, now time.Time) {
+	if provider.Location == "" {
+		return
+	}
+	day := now.UTC().Format(time.DateOnly)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.days[day] == nil {
+		t.days[day] = map[string]*ScrapeStats{}
+		// Days past the retention are dropped as a new one starts
+		oldest := now.UTC().AddDate(0, 0, -(scrapeStatsDays - 1)).Format(time.DateOnly)
+		for kept := range t.days {
+			if kept < oldest {
+				delete(t.days, kept)
+			}
+		}
+	}
+	stats, ok := t.days[day][provider.Location]
+	if !ok {
+		stats = &ScrapeStats{Provider: provider.Location}
+		t.days[day][provider.Location] = stats
+	}
+	stats.Requests += requests
+	if err != nil {
+		stats.Errors++
+	}
+	stats.Bytes += int64(bytes)
+	stats.DurationSeconds += duration.Seconds()
+	stats.MaxDurationSeconds = max(stats.MaxDurationSeconds, duration.Seconds())
+}
+
+// Function to list the statistics of the given providers for the latest days, newest first
+func (t *scrapeStatsTracker) report(providers []Provider, days int) []ScrapeStatsDay {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.days))
+	for day := range t.days {
+		names = append(names, day)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	if len(names) > days {
+		names = names[:days]
+	}
+
+	report := make([]ScrapeStatsDay, 0, len(names))
+	for _, day := range names {
+		entry := ScrapeStatsDay{Day: day, Providers: []ScrapeStats{}}
+		for _, provider := range providers {
+			stats, ok := t.days[day][provider.Location]
+			if !ok {
+				continue
+			}
+			entry.Providers = append(entry.Providers, stats.summarized())
+			entry.Total.add(*stats)
+		}
+		sort.Slice(entry.Providers, func(i, j int) bool { return entry.Providers[i].Provider < entry.Providers[j].Provider })
+		entry.Total = entry.Total.summarized()
+		report = append(report, entry)
+	}
+	return report
+}
+
+// Handler for GET /api/v1/scrape-stats, summarizing per provider and UTC day the
+// requests, bytes, time and errors the exporter caused upstream. ?days= picks how
+// many days back, up to a week; ?provider= narrows it to one provider.
+func scrapeStatsHandler(c *gin.Context) {
+	days := scrapeStatsDays
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > scrapeStatsDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and " + strconv.Itoa(scrapeStatsDays)})
+			return
+		}
+		days = n
+	}
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	providers = deploymentProviders(c, providers)
+	if name := c.Query("provider"); name != "" {
+		provider, found, err := findProvider(name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+			return
+		}
+		providers = []Provider{provider}
+	}
+	c.JSON(http.StatusOK, gin.H{"days": scrapeStats.report(providers, days)})
+}
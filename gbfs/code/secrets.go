@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pattern for secret references embedded in config values, e.g.
+// "Bearer ${vault:secret/data/gbfs#dott_token}", "${env:DOTT_TOKEN}" or "${file:/run/secrets/token}"
+var secretRefPattern = regexp.MustCompile(`\$\{(vault|env|file):([^}]+)\}`)
+
+// Struct caching resolved secrets so rotated values are picked up after refresh
+type secretResolver struct {
+	refresh time.Duration
+	client  *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+	// Token obtained through Vault's Kubernetes auth method
+	vaultToken   string
+	vaultExpires time.Time
+}
+
+// Struct for a resolved secret and when it was read
+type cachedSecret struct {
+	value    string
+	resolved time.Time
+}
+
+// Resolver used for provider headers and storage URIs
+var secrets = &secretResolver{
+	refresh: 5 * time.Minute,
+	client:  &http.Client{Timeout: 10 * time.Second},
+	cache:   map[string]cachedSecret{},
+}
+
+// Function to replace every secret reference in value with its current value.
+// When a refresh fails the previously resolved value keeps being used.
+func (r *secretResolver) expand(value string) (string, error) {
+	var firstErr error
+	expanded := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		secret, err := r.get(ref)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return secret
+	})
+	return expanded, firstErr
+}
+
+// Function to return a cached secret, re-resolving it once the refresh interval has passed
+func (r *secretResolver) get(ref string) (string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[ref]
+	r.mu.Unlock()
+	if ok && time.Since(cached.resolved) < r.refresh {
+		return cached.value, nil
+	}
+
+	match := secretRefPattern.FindStringSubmatch(ref)
+	value, err := r.resolve(match[1], match[2])
+	if err != nil {
+		if ok {
+			return cached.value, nil
+		}
+		return "", fmt.Errorf("resolving %s: %w", ref, err)
+	}
+
+	registerSecretValue(value)
+	r.mu.Lock()
+	r.cache[ref] = cachedSecret{value: value, resolved: time.Now()}
+	r.mu.Unlock()
+	return value, nil
+}
+
+// Function to read a secret from its backend
+func (r *secretResolver) resolve(kind, path string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(path)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", path)
+		}
+		return value, nil
+	case "file":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return r.readVault(path)
+	}
+}
+
+// Function to read "<path>#<key>" from Vault. KV v2 paths include the data/
+// segment, e.g. secret/data/gbfs#dott_token. Authentication uses VAULT_TOKEN,
+// or the Kubernetes auth method when VAULT_K8S_ROLE is set.
+func (r *secretResolver) readVault(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || key == "" {
+		return "", fmt.Errorf("vault reference %q needs a #key", ref)
+	}
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := r.vaultAuthToken(addr)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := r.doVault(req, &response); err != nil {
+		return "", err
+	}
+
+	data := response.Data
+	// KV v2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string key %s", path, key)
+	}
+	return value, nil
+}
+
+// Function to return a Vault token, logging in with the pod's service account when configured
+func (r *secretResolver) vaultAuthToken(addr string) (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	role := os.Getenv("VAULT_K8S_ROLE")
+	if role == "" {
+		return "", fmt.Errorf("set VAULT_TOKEN or VAULT_K8S_ROLE to authenticate to Vault")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.vaultToken != "" && time.Now().Before(r.vaultExpires) {
+		return r.vaultToken, nil
+	}
+
+	jwt, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+	if err != nil {
+		return "", fmt.Errorf("reading service account token: %w", err)
+	}
+	mount := envOr("VAULT_K8S_MOUNT", "kubernetes")
+	body, _ := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+	req, err := http.NewRequest(http.MethodPost, addr+"/v1/auth/"+mount+"/login", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := r.doVault(req, &response); err != nil {
+		return "", fmt.Errorf("vault kubernetes login: %w", err)
+	}
+	r.vaultToken = response.Auth.ClientToken
+	// Renew well before the lease runs out
+	r.vaultExpires = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 3 / 4)
+	return r.vaultToken, nil
+}
+
+// Function to perform a Vault request and decode its JSON response
+func (r *secretResolver) doVault(req *http.Request, out interface{}) error {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Function to resolve every secret the providers reference, so misconfigured
+// secrets fail at startup rather than on the first scrape
+func checkProviderSecrets(providers []Provider) error {
+	for _, provider := range providers {
+		for name, value := range provider.Headers {
+			if _, err := secrets.expand(value); err != nil {
+				return fmt.Errorf("provider %s header %s: %w", provider.Location, name, err)
+			}
+		}
+	}
+	return nil
+}
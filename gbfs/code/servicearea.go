@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Size in meters of the cells observed positions are bucketed into; each cell
+// keeps one position, which bounds memory however long a provider is observed
+const serviceAreaCellMeters = 100
+
+// Default concavity of the concave hull; larger values give a smoother outline
+// closer to the convex hull, 1 follows the observations most tightly
+const defaultServiceAreaConcavity = 2.0
+
+// Edges shorter than this are not dug into, so the outline does not trace every cell
+const serviceAreaMinEdgeMeters = 3 * serviceAreaCellMeters
+
+// Struct for a cell in which a vehicle or station was seen
+type serviceAreaCell struct {
+	lat, lon float64
+	lastSeen time.Time
+}
+
+// Struct for the cells observed of one provider and its cached outlines
+type serviceAreaCells struct {
+	cells     map[[2]int]serviceAreaCell
+	firstSeen time.Time
+	// version changes whenever a cell is added or expires, invalidating the cache
+	version int
+	cache   map[string]serviceAreaOutline
+}
+
+// Struct for a computed outline of a provider's service area
+type serviceAreaOutline struct {
+	version int
+	ring    [][2]float64
+	area    float64
+	at      time.Time
+}
+
+// Struct accumulating where each provider's vehicles and stations are seen, to
+// derive service areas for systems that publish no geofencing_zones
+type serviceAreaTracker struct {
+	// window after which a cell nobody was seen in is forgotten; 0 keeps every cell
+	window time.Duration
+
+	mu        sync.Mutex
+	providers map[string]*serviceAreaCells
+}
+
+// Service areas fed by ingestion, with the window set by --service-area-window
+var serviceAreas = &serviceAreaTracker{window: 30 * 24 * time.Hour, providers: map[string]*serviceAreaCells{}}
+
+// Function to record the positions of a scrape's vehicles and stations
+func (t *serviceAreaTracker) observe(provider Provider, result ScrapeResult, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	area, ok := t.providers[provider.Location]
+	if !ok {
+		area = &serviceAreaCells{cells: map[[2]int]serviceAreaCell{}, firstSeen: now, cache: map[string]serviceAreaOutline{}}
+		t.providers[provider.Location] = area
+	}
+	seen := func(lat, lon float64) {
+		// Positions left at 0,0 or outside the globe are feed errors, not the service area
+		if (lat == 0 && lon == 0) || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return
+		}
+		key := serviceAreaCellKey(lat, lon)
+		cell, known := area.cells[key]
+		if !known {
+			cell = serviceAreaCell{lat: lat, lon: lon}
+			area.version++
+		}
+		cell.lastSeen = now
+		area.cells[key] = cell
+	}
+	for _, bike := range result.Bikes {
+		seen(bike.Lat, bike.Lon)
+	}
+	for _, station := range result.Stations {
+		seen(station.Lat, station.Lon)
+	}
+
+	if t.window > 0 {
+		for key, cell := range area.cells {
+			if now.Sub(cell.lastSeen) > t.window {
+				delete(area.cells, key)
+				area.version++
+			}
+		}
+	}
+}
+
+// Function to return the cell of a position; longitude cells are as wide in
+// meters as latitude cells at the position's latitude
+func serviceAreaCellKey(lat, lon float64) [2]int {
+	cellDeg := float64(serviceAreaCellMeters) / 111320
+	row := int(math.Floor(lat / cellDeg))
+	lonDeg := cellDeg / max(math.Cos(float64(row)*cellDeg*math.Pi/180), 0.01)
+	return [2]int{row, int(math.Floor(lon / lonDeg))}
+}
+
+// Function to forget a provider's observations
+func (t *serviceAreaTracker) forget(location string) {
+	t.mu.Lock()
+	delete(t.providers, location)
+	t.mu.Unlock()
+}
+
+// Function to return a provider's outline, from the cache while no cell changed.
+// concavity 0 computes the convex hull. Returns false before three cells were seen.
+func (t *serviceAreaTracker) outline(location string, concavity float64, now time.Time) (serviceAreaOutline, int, time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	area, ok := t.providers[location]
+	if !ok || len(area.cells) < 3 {
+		return serviceAreaOutline{}, 0, time.Time{}, false
+	}
+	key := strconv.FormatFloat(concavity, 'g', -1, 64)
+	if cached, ok := area.cache[key]; ok && cached.version == area.version {
+		return cached, len(area.cells), area.firstSeen, true
+	}
+
+	points := make([][2]float64, 0, len(area.cells))
+	for _, cell := range area.cells {
+		points = append(points, [2]float64{cell.lon, cell.lat})
+	}
+	ring, squareMeters := serviceAreaHull(points, concavity)
+	if len(ring) < 4 {
+		return serviceAreaOutline{}, 0, time.Time{}, false
+	}
+	computed := serviceAreaOutline{version: area.version, ring: ring, area: squareMeters, at: now}
+	area.cache[key] = computed
+	return computed, len(area.cells), area.firstSeen, true
+}
+
+// Function to outline [lon, lat] points as a closed counter-clockwise ring, with its
+// area in square meters. The convex hull is computed first; with a concavity its edges
+// are then dug in towards the nearest inner point while the edge is more than
+// concavity times longer than the point is far from it, as in the concaveman algorithm.
+func serviceAreaHull(points [][2]float64, concavity float64) ([][2]float64, float64) {
+	// Project onto a plane in meters around the points' mean latitude
+	meanLat := 0.0
+	for _, p := range points {
+		meanLat += p[1]
+	}
+	meanLat /= float64(len(points))
+	scale := math.Cos(meanLat * math.Pi / 180)
+	xy := make([][2]float64, len(points))
+	for i, p := range points {
+		xy[i] = [2]float64{p[0] * 111320 * scale, p[1] * 111320}
+	}
+
+	hull := convexHull(xy)
+	if len(hull) < 3 {
+		return nil, 0
+	}
+	if concavity > 0 {
+		hull = digHull(xy, hull, concavity)
+	}
+
+	ring := make([][2]float64, 0, len(hull)+1)
+	squareMeters := 0.0
+	for i, index := range hull {
+		ring = append(ring, points[index])
+		a, b := xy[index], xy[hull[(i+1)%len(hull)]]
+		squareMeters += a[0]*b[1] - b[0]*a[1]
+	}
+	return append(ring, ring[0]), math.Abs(squareMeters) / 2
+}
+
+// Function to return the indexes of the convex hull of points, counter-clockwise,
+// with the monotone chain algorithm
+func convexHull(points [][2]float64) []int {
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	// Fewer than three points are their own hull
+	if len(points) < 3 {
+		return order
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := points[order[i]], points[order[j]]
+		return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+	})
+	cross := func(o, a, b int) float64 {
+		return (points[a][0]-points[o][0])*(points[b][1]-points[o][1]) - (points[a][1]-points[o][1])*(points[b][0]-points[o][0])
+	}
+	hull := make([]int, 0, 2*len(points))
+	for _, i := range order {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], i) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, i)
+	}
+	lower := len(hull) + 1
+	for k := len(order) - 2; k >= 0; k-- {
+		i := order[k]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], i) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, i)
+	}
+	return hull[:len(hull)-1]
+}
+
+// Function to dig the edges of a convex hull in towards inner points, returning the
+// indexes of the concave outline. Points replace an edge only when they are closer
+// to it than to the neighbouring edges and the new edges cross no other edge.
+func digHull(points [][2]float64, hull []int, concavity float64) []int {
+	next := make(map[int]int, len(hull))
+	prev := make(map[int]int, len(hull))
+	for i, index := range hull {
+		next[index] = hull[(i+1)%len(hull)]
+		prev[index] = hull[(i+len(hull)-1)%len(hull)]
+	}
+	queue := append([]int{}, hull...)
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		b := next[a]
+		length := pointDistance(points[a], points[b])
+		if length < serviceAreaMinEdgeMeters {
+			continue
+		}
+		maxDistance := length / concavity
+
+		candidate, best := -1, math.Inf(1)
+		for p := range points {
+			if _, onHull := next[p]; onHull {
+				continue
+			}
+			d := segmentDistance(points[p], points[a], points[b])
+			if d >= best || d >= maxDistance {
+				continue
+			}
+			if d >= segmentDistance(points[p], points[prev[a]], points[a]) || d >= segmentDistance(points[p], points[b], points[next[b]]) {
+				continue
+			}
+			candidate, best = p, d
+		}
+		if candidate < 0 || min(pointDistance(points[candidate], points[a]), pointDistance(points[candidate], points[b])) > maxDistance {
+			continue
+		}
+		if hullCrossed(points, next, a, b, candidate) {
+			continue
+		}
+		next[a], prev[candidate] = candidate, a
+		next[candidate], prev[b] = b, candidate
+		queue = append(queue, a, candidate)
+	}
+
+	outline := []int{hull[0]}
+	for index := next[hull[0]]; index != hull[0]; index = next[index] {
+		outline = append(outline, index)
+	}
+	return outline
+}
+
+// Function to report whether replacing the edge a-b with a-p and p-b would cross
+// another edge of the outline
+func hullCrossed(points [][2]float64, next map[int]int, a, b, p int) bool {
+	for start, end := range next {
+		if start == a || start == b || end == a || end == b {
+			continue
+		}
+		if segmentsCross(points[a], points[p], points[start], points[end]) || segmentsCross(points[p], points[b], points[start], points[end]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to return the distance between two projected points
+func pointDistance(a, b [2]float64) float64 {
+	return math.Hypot(a[0]-b[0], a[1]-b[1])
+}
+
+// Function to return the distance from p to the segment a-b
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return pointDistance(p, a)
+	}
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	t = max(0, min(1, t))
+	return pointDistance(p, [2]float64{a[0] + t*dx, a[1] + t*dy})
+}
+
+// Function to report whether the segments a-b and c-d properly cross
+func segmentsCross(a, b, c, d [2]float64) bool {
+	orient := func(p, q, r [2]float64) float64 {
+		return (q[0]-p[0])*(r[1]-p[1]) - (q[1]-p[1])*(r[0]-p[0])
+	}
+	d1, d2 := orient(c, d, a), orient(c, d, b)
+	d3, d4 := orient(a, b, c), orient(a, b, d)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// Function to build the GeoJSON Feature of a provider's service area
+func serviceAreaFeature(location, shape string, concavity float64, license *DataLicense, now time.Time) (gin.H, bool) {
+	if shape == "convex" {
+		concavity = 0
+	}
+	outline, cells, since, ok := serviceAreas.outline(location, concavity, now)
+	if !ok {
+		return nil, false
+	}
+	properties := gin.H{
+		"provider":       location,
+		"shape":          shape,
+		"area_km2":       math.Round(outline.area/1e4) / 100,
+		"observed_cells": cells,
+		"cell_meters":    serviceAreaCellMeters,
+		"observed_since": since,
+		"computed_at":    outline.at,
+	}
+	if shape == "concave" {
+		properties["concavity"] = concavity
+	}
+	if license != nil {
+		properties["license"] = license
+	}
+	return gin.H{
+		"type":       "Feature",
+		"geometry":   gin.H{"type": "Polygon", "coordinates": [][][2]float64{outline.ring}},
+		"properties": properties,
+	}, true
+}
+
+// Function to read the shape and concavity query parameters of the service area endpoints
+func serviceAreaShape(c *gin.Context) (string, float64, error) {
+	shape := c.DefaultQuery("shape", "concave")
+	if shape != "concave" && shape != "convex" {
+		return "", 0, fmt.Errorf("shape must be concave or convex")
+	}
+	concavity := defaultServiceAreaConcavity
+	if value := c.Query("concavity"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 1 {
+			return "", 0, fmt.Errorf("concavity must be a number of at least 1")
+		}
+		concavity = parsed
+	}
+	return shape, concavity, nil
+}
+
+// Handler for GET /api/v1/providers/:name/service-area, the GeoJSON outline of where
+// the provider's vehicles and stations were seen within --service-area-window
+func providerServiceAreaHandler(c *gin.Context) {
+	shape, concavity, err := serviceAreaShape(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	provider, found, err := findProvider(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+	feature, ok := serviceAreaFeature(provider.Location, shape, concavity, dataLicenses()[provider.Location], clock.Now().UTC())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "too few positions observed yet to outline a service area"})
+		return
+	}
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, feature)
+}
+
+// Handler for GET /api/v1/service-areas, a GeoJSON FeatureCollection of the service
+// area of every configured provider with enough observations, scoped to the
+// deployment when given
+func serviceAreasHandler(c *gin.Context) {
+	shape, concavity, err := serviceAreaShape(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := clock.Now().UTC()
+	licenses := dataLicenses()
+	features := []gin.H{}
+	for _, provider := range deploymentProviders(c, providers) {
+		if feature, ok := serviceAreaFeature(provider.Location, shape, concavity, licenses[provider.Location], now); ok {
+			features = append(features, feature)
+		}
+	}
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, gin.H{"type": "FeatureCollection", "features": features})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestConvexHull(t *testing.T) {
+	tests := []struct {
+		name   string
+		points [][2]float64
+		want   []int
+	}{
+		{"no points", nil, []int{}},
+		{"one point", [][2]float64{{1, 1}}, []int{0}},
+		{"two points", [][2]float64{{1, 1}, {0, 0}}, []int{0, 1}},
+		{"duplicates", [][2]float64{{1, 1}, {1, 1}, {1, 1}}, []int{0, 0}},
+		{"collinear", [][2]float64{{0, 0}, {2, 2}, {1, 1}, {3, 3}}, []int{0, 3}},
+		{"triangle", [][2]float64{{0, 0}, {0, 2}, {2, 0}}, []int{0, 2, 1}},
+		// Inner, duplicate and edge points are left out
+		{"square", [][2]float64{{1, 1}, {0, 0}, {2, 0}, {2, 2}, {0, 2}, {1, 0}, {2, 2}}, []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convexHull(tt.points)
+			if len(got) != len(tt.want) {
+				t.Fatalf("convexHull() = %v, want %v", got, tt.want)
+			}
+			// Vertices may repeat when duplicated, so compare positions
+			for i := range got {
+				if tt.points[got[i]] != tt.points[tt.want[i]] {
+					t.Fatalf("convexHull() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceAreaHullDegenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		points [][2]float64
+	}{
+		{"one point", [][2]float64{{13.4, 52.5}}},
+		{"two points", [][2]float64{{13.4, 52.5}, {13.5, 52.5}}},
+		{"duplicates", [][2]float64{{13.4, 52.5}, {13.4, 52.5}, {13.4, 52.5}}},
+		{"collinear", [][2]float64{{13.4, 52.5}, {13.5, 52.5}, {13.6, 52.5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, concavity := range []float64{0, defaultServiceAreaConcavity} {
+				if ring, area := serviceAreaHull(tt.points, concavity); ring != nil || area != 0 {
+					t.Errorf("serviceAreaHull(concavity %v) = %v, %v, want no outline", concavity, ring, area)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceAreaHullSquare(t *testing.T) {
+	// A 0.01° square on the equator is about 1113 m wide
+	points := [][2]float64{{0, 0}, {0.01, 0}, {0.01, 0.01}, {0, 0.01}, {0.005, 0.005}}
+	ring, area := serviceAreaHull(points, 0)
+	want := [][2]float64{{0, 0}, {0.01, 0}, {0.01, 0.01}, {0, 0.01}, {0, 0}}
+	if !slices.Equal(ring, want) {
+		t.Errorf("ring = %v, want %v", ring, want)
+	}
+	if side := 0.01 * 111320; math.Abs(area-side*side) > 1 {
+		t.Errorf("area = %v, want %v", area, side*side)
+	}
+}
+
+func TestServiceAreaHullConcave(t *testing.T) {
+	// A 4.5 km grid of points 445 m apart with a wide notch cut into its right side
+	var points [][2]float64
+	for x := 0; x <= 10; x++ {
+		for y := 0; y <= 10; y++ {
+			if x > 6 && y > 1 && y < 9 {
+				continue
+			}
+			points = append(points, [2]float64{float64(x) * 0.004, float64(y) * 0.004})
+		}
+	}
+	convex, convexArea := serviceAreaHull(points, 0)
+	concave, concaveArea := serviceAreaHull(points, 1)
+	if len(convex) != 5 {
+		t.Errorf("convex ring has %d points, want 5", len(convex))
+	}
+	if concave[0] != concave[len(concave)-1] {
+		t.Errorf("concave ring is not closed: %v", concave)
+	}
+	if concaveArea >= convexArea*0.9 {
+		t.Errorf("concave area %v is not dug into the convex area %v", concaveArea, convexArea)
+	}
+	for i := 0; i+1 < len(concave); i++ {
+		for j := i + 2; j+1 < len(concave); j++ {
+			if segmentsCross(concave[i], concave[i+1], concave[j], concave[j+1]) {
+				t.Fatalf("edges %d and %d of the concave ring cross", i, j)
+			}
+		}
+	}
+}
+
+func TestSegmentsCross(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, c, d [2]float64
+		want       bool
+	}{
+		{"crossing", [2]float64{0, 0}, [2]float64{2, 2}, [2]float64{0, 2}, [2]float64{2, 0}, true},
+		{"parallel", [2]float64{0, 0}, [2]float64{2, 0}, [2]float64{0, 1}, [2]float64{2, 1}, false},
+		{"collinear overlap", [2]float64{0, 0}, [2]float64{2, 0}, [2]float64{1, 0}, [2]float64{3, 0}, false},
+		{"shared endpoint", [2]float64{0, 0}, [2]float64{1, 1}, [2]float64{1, 1}, [2]float64{2, 0}, false},
+		{"touching", [2]float64{0, 0}, [2]float64{2, 0}, [2]float64{1, 0}, [2]float64{1, 2}, false},
+		{"apart", [2]float64{0, 0}, [2]float64{1, 1}, [2]float64{3, 0}, [2]float64{2, 1}, false},
+		{"degenerate", [2]float64{1, 1}, [2]float64{1, 1}, [2]float64{0, 2}, [2]float64{2, 0}, false},
+	}
+	for _, tt := range tests {
+		if got := segmentsCross(tt.a, tt.b, tt.c, tt.d); got != tt.want {
+			t.Errorf("%s: segmentsCross() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+)
+
+// Shard of the providers handled by this instance; a count of 1 disables sharding
+var shardIndex, shardCount = 0, 1
+
+// Function to read the shard from $GBFS_SHARD_INDEX and $GBFS_SHARD_COUNT unless set by flags
+func configureShard(indexSet, countSet bool) error {
+	if !indexSet {
+		if value := os.Getenv("GBFS_SHARD_INDEX"); value != "" {
+			index, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid GBFS_SHARD_INDEX %q: %w", value, err)
+			}
+			shardIndex = index
+		}
+	}
+	if !countSet {
+		if value := os.Getenv("GBFS_SHARD_COUNT"); value != "" {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid GBFS_SHARD_COUNT %q: %w", value, err)
+			}
+			shardCount = count
+		}
+	}
+	if shardCount < 1 {
+		return fmt.Errorf("shard count must be at least 1, got %d", shardCount)
+	}
+	if shardIndex < 0 || shardIndex >= shardCount {
+		return fmt.Errorf("shard index must be between 0 and %d, got %d", shardCount-1, shardIndex)
+	}
+	return nil
+}
+
+// Function to return the shard owning a provider location. Rendezvous hashing
+// keeps most providers on the same shard when the shard count changes.
+func providerShard(location string, count int) int {
+	best, bestWeight := 0, uint64(0)
+	for shard := 0; shard < count; shard++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s\x00%d", location, shard)
+		if weight := h.Sum64(); shard == 0 || weight > bestWeight {
+			best, bestWeight = shard, weight
+		}
+	}
+	return best
+}
+
+// Function to keep only the providers assigned to this instance's shard
+func shardProviders(providers []Provider) []Provider {
+	if shardCount <= 1 {
+		return providers
+	}
+	owned := providers[:0:0]
+	for _, provider := range providers {
+		if providerShard(provider.Location, shardCount) == shardIndex {
+			owned = append(owned, provider)
+		}
+	}
+	return owned
+}
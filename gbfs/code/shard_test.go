@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestProviderShard(t *testing.T) {
+	locations := make([]string, 200)
+	for i := range locations {
+		locations[i] = fmt.Sprintf("city-%d", i)
+	}
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{"degenerate zero count", 0},
+		{"single shard", 1},
+		{"two shards", 2},
+		{"many shards", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := map[int]bool{}
+			for _, location := range locations {
+				shard := providerShard(location, tt.count)
+				if tt.count <= 1 {
+					if shard != 0 {
+						t.Fatalf("providerShard(%q, %d) = %d, want 0", location, tt.count, shard)
+					}
+					continue
+				}
+				if shard < 0 || shard >= tt.count {
+					t.Fatalf("providerShard(%q, %d) = %d, out of range", location, tt.count, shard)
+				}
+				if again := providerShard(location, tt.count); again != shard {
+					t.Fatalf("providerShard(%q, %d) is unstable: %d then %d", location, tt.count, shard, again)
+				}
+				used[shard] = true
+			}
+			if tt.count > 1 && len(used) != tt.count {
+				t.Errorf("%d locations used %d of %d shards", len(locations), len(used), tt.count)
+			}
+		})
+	}
+}
+
+func TestProviderShardGrowth(t *testing.T) {
+	// Adding a shard only moves providers onto the new shard
+	for i := 0; i < 200; i++ {
+		location := fmt.Sprintf("city-%d", i)
+		before, after := providerShard(location, 4), providerShard(location, 5)
+		if before != after && after != 4 {
+			t.Errorf("%s moved from shard %d to existing shard %d", location, before, after)
+		}
+	}
+}
+
+func TestShardProviders(t *testing.T) {
+	defer func(index, count int) { shardIndex, shardCount = index, count }(shardIndex, shardCount)
+	providers := make([]Provider, 50)
+	for i := range providers {
+		providers[i] = Provider{Location: fmt.Sprintf("city-%d", i)}
+	}
+
+	shardIndex, shardCount = 0, 1
+	if got := shardProviders(providers); len(got) != len(providers) {
+		t.Errorf("unsharded instance kept %d of %d providers", len(got), len(providers))
+	}
+	if got := shardProviders(nil); len(got) != 0 {
+		t.Errorf("shardProviders(nil) = %v", got)
+	}
+
+	shardCount = 3
+	seen := map[string]int{}
+	for shardIndex = 0; shardIndex < shardCount; shardIndex++ {
+		for _, provider := range shardProviders(providers) {
+			seen[provider.Location]++
+		}
+	}
+	for _, provider := range providers {
+		if seen[provider.Location] != 1 {
+			t.Errorf("%s is owned by %d shards, want 1", provider.Location, seen[provider.Location])
+		}
+	}
+}
+
+func TestConfigureShard(t *testing.T) {
+	defer func(index, count int) { shardIndex, shardCount = index, count }(shardIndex, shardCount)
+	tests := []struct {
+		name         string
+		index, count string
+		flagIndex    int
+		flagCount    int
+		indexSet     bool
+		countSet     bool
+		wantIndex    int
+		wantCount    int
+		err          string
+	}{
+		{name: "defaults", flagCount: 1, wantCount: 1},
+		{name: "environment", index: "2", count: "4", flagCount: 1, wantIndex: 2, wantCount: 4},
+		{name: "flags win", index: "2", count: "4", flagIndex: 1, flagCount: 3, indexSet: true, countSet: true, wantIndex: 1, wantCount: 3},
+		{name: "malformed index", index: "one", flagCount: 1, err: "invalid GBFS_SHARD_INDEX"},
+		{name: "malformed count", count: "4x", flagCount: 1, err: "invalid GBFS_SHARD_COUNT"},
+		{name: "zero count", count: "0", flagCount: 1, err: "shard count must be at least 1"},
+		{name: "negative index", index: "-1", flagCount: 1, err: "shard index must be between 0 and 0"},
+		{name: "index past count", index: "3", count: "3", flagCount: 1, err: "shard index must be between 0 and 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GBFS_SHARD_INDEX", tt.index)
+			t.Setenv("GBFS_SHARD_COUNT", tt.count)
+			shardIndex, shardCount = tt.flagIndex, tt.flagCount
+			err := configureShard(tt.indexSet, tt.countSet)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("configureShard() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configureShard(): %v", err)
+			}
+			if shardIndex != tt.wantIndex || shardCount != tt.wantCount {
+				t.Errorf("shard = %d/%d, want %d/%d", shardIndex, shardCount, tt.wantIndex, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct signing outgoing snapshots as JWS (RFC 7515) with an Ed25519 key, so
+// downstream systems can verify where the data came from and that it is unchanged
+type snapshotSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	latest []byte
+}
+
+// Signer set up with serve --signing-key; nil sends and serves snapshots unsigned
+var signer *snapshotSigner
+
+// Function to load an Ed25519 private key from a PEM file, e.g. from `openssl genpkey -algorithm ed25519`
+func loadSnapshotSigner(path, keyID string) (*snapshotSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	if keyID == "" {
+		// Default to the RFC 7638 thumbprint, so rotating the key changes the ID
+		keyID = jwkThumbprint(key.Public().(ed25519.PublicKey))
+	}
+	return &snapshotSigner{key: key, keyID: keyID}, nil
+}
+
+// Function to compute the RFC 7638 thumbprint of an Ed25519 public key
+func jwkThumbprint(public ed25519.PublicKey) string {
+	canonical := `{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(public) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Function to sign a payload as a compact JWS; detached leaves the payload out, for bodies sent alongside
+func (s *snapshotSigner) sign(payload []byte, detached bool) string {
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": s.keyID, "cty": "json"})
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(s.key, []byte(protected+"."+encoded))
+	if detached {
+		encoded = ""
+	}
+	return protected + "." + encoded + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Function to remember the latest cycle's webhook payload for GET /api/v1/snapshots/latest
+func (s *snapshotSigner) record(snapshots []ProviderSnapshot) {
+	body, err := json.Marshal(webhookPayload{CycleAt: clock.Now().UTC(), Providers: snapshots})
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.latest = body
+	s.mu.Unlock()
+}
+
+// Handler for GET /api/v1/snapshots/latest, returning the latest cycle's snapshots with a
+// detached JWS in X-GBFS-JWS, or as a compact JWS with ?format=jws
+func latestSnapshotsHandler(c *gin.Context) {
+	if signer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot signing is not configured"})
+		return
+	}
+	signer.mu.Lock()
+	body := signer.latest
+	signer.mu.Unlock()
+	if body == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no ingestion cycle has completed yet"})
+		return
+	}
+	if c.Query("format") == "jws" {
+		c.Data(http.StatusOK, "application/jose", []byte(signer.sign(body, false)))
+		return
+	}
+	c.Header("X-GBFS-JWS", signer.sign(body, true))
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// Handler for GET /.well-known/jwks.json, publishing the public key snapshots are signed with
+func jwksHandler(c *gin.Context) {
+	if signer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot signing is not configured"})
+		return
+	}
+	public := signer.key.Public().(ed25519.PublicKey)
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"alg": "EdDSA",
+		"use": "sig",
+		"kid": signer.keyID,
+		"x":   base64.RawURLEncoding.EncodeToString(public),
+	}}})
+}
@@ -0,0 +1,25 @@
+package main
+
+import "log"
+
+// Interface for destinations that receive the snapshots of every ingestion cycle
+type Sink interface {
+	Name() string
+	Publish(snapshots []ProviderSnapshot) error
+}
+
+// Sinks enabled for this process, configured from serve flags
+var activeSinks []Sink
+
+// Function to hand a cycle's snapshots to every sink; a failing sink never blocks the others
+func publishToSinks(snapshots []ProviderSnapshot) {
+	if signer != nil {
+		signer.record(snapshots)
+	}
+	for _, sink := range activeSinks {
+		if err := sink.Publish(snapshots); err != nil {
+			log.Printf("Error publishing to %s sink: %v", sink.Name(), err)
+		}
+	}
+	publishToDeploymentSinks(snapshots)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Windows reported by the SLA endpoint when none are requested
+var defaultSLAWindows = []string{"24h", "7d", "30d"}
+
+// Store that serve persists every cycle's snapshots to, set with --store
+var snapshotStore SnapshotStore
+
+// Struct for the sink writing every cycle's snapshots to the snapshot store
+type storeSink struct {
+	store SnapshotStore
+}
+
+// Function to return the sink name
+func (s *storeSink) Name() string {
+	return "store"
+}
+
+// Function to save a cycle's snapshots
+func (s *storeSink) Publish(snapshots []ProviderSnapshot) error {
+	return s.store.SaveSnapshots(snapshots)
+}
+
+// Struct for a provider's feed reliability over one window
+type SLAReport struct {
+	Window string `json:"window"`
+	// Scrapes counts the attempts in the window, excluding quiet or closed hours
+	Scrapes int `json:"scrapes"`
+	Errors  int `json:"errors"`
+	// Uptime is the share of scrapes that succeeded
+	Uptime    float64 `json:"uptime"`
+	ErrorRate float64 `json:"error_rate"`
+	// AverageStaleness is the mean age, at each scrape, of the last successful data
+	AverageStaleness float64 `json:"average_staleness_seconds"`
+	// LongestOutage is the longest stretch of consecutive failed scrapes
+	LongestOutage float64 `json:"longest_outage_seconds"`
+}
+
+// Function to parse a window such as 90m, 24h or 7d
+func parseSLAWindow(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window %q", value)
+	}
+	return window, nil
+}
+
+// Function to compute the report of the snapshots in [from, to), ordered by scrape time
+func computeSLA(window string, snapshots []ProviderSnapshot, from, to time.Time) SLAReport {
+	report := SLAReport{Window: window}
+	var lastSuccess, outageStart time.Time
+	var staleness time.Duration
+	for _, snapshot := range snapshots {
+		failed := snapshot.Error != ""
+		// Earlier snapshots only establish when data was last fresh
+		if snapshot.ScrapedAt.Before(from) {
+			if !failed {
+				lastSuccess = snapshot.ScrapedAt
+			}
+			continue
+		}
+		if !snapshot.ScrapedAt.Before(to) {
+			break
+		}
+		if snapshot.Closed {
+			continue
+		}
+
+		report.Scrapes++
+		if failed {
+			report.Errors++
+			if outageStart.IsZero() {
+				outageStart = snapshot.ScrapedAt
+			}
+			if !lastSuccess.IsZero() {
+				staleness += snapshot.ScrapedAt.Sub(lastSuccess)
+			}
+			continue
+		}
+		if !outageStart.IsZero() {
+			report.LongestOutage = max(report.LongestOutage, snapshot.ScrapedAt.Sub(outageStart).Seconds())
+			outageStart = time.Time{}
+		}
+		lastSuccess = snapshot.ScrapedAt
+	}
+	if !outageStart.IsZero() {
+		report.LongestOutage = max(report.LongestOutage, to.Sub(outageStart).Seconds())
+	}
+	if report.Scrapes > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(report.Scrapes)
+		report.Uptime = 1 - report.ErrorRate
+		report.AverageStaleness = staleness.Seconds() / float64(report.Scrapes)
+	}
+	return report
+}
+
+// Handler for a provider's uptime, staleness and error rate, computed from the
+// snapshot store over the windows given as ?window=24h&window=7d
+func providerSLAHandler(c *gin.Context) {
+	reader, ok := snapshotStore.(SnapshotReader)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot storage is not enabled"})
+		return
+	}
+
+	windows := c.QueryArray("window")
+	if len(windows) == 0 {
+		windows = defaultSLAWindows
+	}
+	durations := make([]time.Duration, len(windows))
+	var longest time.Duration
+	for i, window := range windows {
+		duration, err := parseSLAWindow(window)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		durations[i] = duration
+		longest = max(longest, duration)
+	}
+
+	name := c.Param("name")
+	now := clock.Now().UTC()
+	// A day before the longest window tells how stale data was when it opened
+	query := SnapshotQuery{Providers: []string{name}, From: now.Add(-longest - 24*time.Hour), To: now}
+	snapshots, err := reader.QuerySnapshots(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(snapshots) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no stored scrapes for provider " + name})
+		return
+	}
+
+	reports := make([]SLAReport, len(windows))
+	for i, window := range windows {
+		reports[i] = computeSLA(window, snapshots, now.Add(-durations[i]), now)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":     name,
+		"generated_at": now,
+		"windows":      reports,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+// Provider sources
+const (
+	sourceGBFS        = "gbfs"
+	sourceCityBikes   = "citybikes"
+	sourceNextbikeXML = "nextbike-xml"
+)
+
+// Struct for an adapter turning a non-GBFS source into the normalized scrape result
+type sourceAdapter struct {
+	name string
+	// detect reports whether a URL belongs to this source
+	detect func(rawURL string) bool
+	// expand turns shorthand URLs such as citybikes://<network> into fetchable ones
+	expand func(rawURL string) string
+	scrape func(provider Provider, trace *ScrapeTrace) (ScrapeResult, error)
+}
+
+// Adapters for the non-GBFS sources, checked in order when detecting a URL's source
+var sourceAdapters = []sourceAdapter{
+	{name: sourceCityBikes, detect: isCityBikesURL, expand: cityBikesURL, scrape: scrapeCityBikes},
+	{name: sourceNextbikeXML, detect: isNextbikeXMLURL, expand: nextbikeXMLURL, scrape: scrapeNextbikeXML},
+}
+
+// Function to look up the adapter for a source; GBFS has none
+func findSourceAdapter(source string) (sourceAdapter, bool) {
+	for _, adapter := range sourceAdapters {
+		if adapter.name == source {
+			return adapter, true
+		}
+	}
+	return sourceAdapter{}, false
+}
+
+// Function to report whether a source name is known
+func validSource(source string) bool {
+	if source == "" || source == sourceGBFS {
+		return true
+	}
+	_, ok := findSourceAdapter(source)
+	return ok
+}
+
+// Function to pick the source for a provider URL, defaulting to GBFS
+func detectSource(rawURL string) string {
+	for _, adapter := range sourceAdapters {
+		if adapter.detect(rawURL) {
+			return adapter.name
+		}
+	}
+	return sourceGBFS
+}
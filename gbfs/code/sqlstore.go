@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timeout for a single storage statement; station queries may scan weeks of samples
+const sqlStoreTimeout = 30 * time.Second
+
+// Struct for snapshots, station samples and daily aggregates kept in PostgreSQL, so
+// history can be analyzed over weeks with SQL. It serves --store, --station-history
+// and --daily-store.
+type postgresStore struct {
+	db *sql.DB
+}
+
+// Function to connect to PostgreSQL and create the history tables if needed
+func newPostgresStore(uri string) (*postgresStore, error) {
+	db, err := sql.Open("pgx", uri)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS gbfs_snapshots (
+			location        TEXT NOT NULL,
+			scraped_at      TIMESTAMPTZ NOT NULL,
+			available_bikes INTEGER NOT NULL,
+			error           TEXT NOT NULL DEFAULT '',
+			snapshot        JSONB NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS gbfs_snapshots_location_time ON gbfs_snapshots (location, scraped_at)`,
+		`CREATE TABLE IF NOT EXISTS gbfs_station_samples (
+			location        TEXT NOT NULL,
+			station_id      TEXT NOT NULL,
+			at              TIMESTAMPTZ NOT NULL,
+			bikes_available INTEGER NOT NULL,
+			docks_available INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS gbfs_station_samples_station_time ON gbfs_station_samples (location, station_id, at)`,
+		`CREATE TABLE IF NOT EXISTS gbfs_daily (
+			location  TEXT NOT NULL,
+			date      DATE NOT NULL,
+			aggregate JSONB NOT NULL,
+			PRIMARY KEY (location, date)
+		)`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating history tables: %w", redactError(err))
+		}
+	}
+	return &postgresStore{db: db}, nil
+}
+
+// Function to insert one row per snapshot in a single transaction
+func (s *postgresStore) SaveSnapshots(snapshots []ProviderSnapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, snapshot := range snapshots {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO gbfs_snapshots (location, scraped_at, available_bikes, error, snapshot)
+			VALUES ($1, $2, $3, $4, $5)`,
+			snapshot.Location, snapshot.ScrapedAt, snapshot.AvailableBikes, snapshot.Error, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Function to read the snapshots matching the query in time order
+func (s *postgresStore) QuerySnapshots(query SnapshotQuery) ([]ProviderSnapshot, error) {
+	where, args := []string{"TRUE"}, []any{}
+	if len(query.Providers) > 0 {
+		args = append(args, query.Providers)
+		where = append(where, fmt.Sprintf("location = ANY($%d)", len(args)))
+	}
+	if !query.From.IsZero() {
+		args = append(args, query.From)
+		where = append(where, fmt.Sprintf("scraped_at >= $%d", len(args)))
+	}
+	if !query.To.IsZero() {
+		args = append(args, query.To)
+		where = append(where, fmt.Sprintf("scraped_at < $%d", len(args)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT snapshot FROM gbfs_snapshots WHERE `+strings.Join(where, " AND ")+` ORDER BY scraped_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []ProviderSnapshot
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var snapshot ProviderSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("decoding snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// Function to insert a scrape's station samples in a single transaction
+func (s *postgresStore) SaveStationSamples(samples []StationSample) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, sample := range samples {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO gbfs_station_samples (location, station_id, at, bikes_available, docks_available)
+			VALUES ($1, $2, $3, $4, $5)`,
+			sample.Location, sample.StationID, sample.At, sample.BikesAvailable, sample.DocksAvailable); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Function to read a station's samples in [from, to) in time order; an empty
+// station ID reads every station of the providers
+func (s *postgresStore) QueryStationSamples(stationID string, providers []string, from, to time.Time) ([]StationSample, error) {
+	where, args := []string{"at >= $1", "at < $2"}, []any{from, to}
+	if stationID != "" {
+		args = append(args, stationID)
+		where = append(where, fmt.Sprintf("station_id = $%d", len(args)))
+	}
+	if len(providers) > 0 {
+		args = append(args, providers)
+		where = append(where, fmt.Sprintf("location = ANY($%d)", len(args)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT location, station_id, at, bikes_available, docks_available
+		FROM gbfs_station_samples WHERE `+strings.Join(where, " AND ")+` ORDER BY at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []StationSample
+	for rows.Next() {
+		var sample StationSample
+		if err := rows.Scan(&sample.Location, &sample.StationID, &sample.At, &sample.BikesAvailable, &sample.DocksAvailable); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// Function to delete the snapshots scraped before the cutoff
+func (s *postgresStore) PruneSnapshots(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM gbfs_snapshots WHERE scraped_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// Function to insert or replace the aggregates' days in a single transaction
+func (s *postgresStore) SaveDaily(aggregates []DailyAggregate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, aggregate := range aggregates {
+		data, err := json.Marshal(aggregate)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO gbfs_daily (location, date, aggregate) VALUES ($1, $2, $3)
+			ON CONFLICT (location, date) DO UPDATE SET aggregate = EXCLUDED.aggregate`,
+			aggregate.Location, aggregate.Date, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Function to read the daily aggregates matching the query by provider and date
+func (s *postgresStore) QueryDaily(query DailyQuery) ([]DailyAggregate, error) {
+	where, args := []string{"TRUE"}, []any{}
+	if len(query.Providers) > 0 {
+		args = append(args, query.Providers)
+		where = append(where, fmt.Sprintf("location = ANY($%d)", len(args)))
+	}
+	if query.From != "" {
+		args = append(args, query.From)
+		where = append(where, fmt.Sprintf("date >= $%d", len(args)))
+	}
+	if query.To != "" {
+		args = append(args, query.To)
+		where = append(where, fmt.Sprintf("date <= $%d", len(args)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStoreTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT aggregate FROM gbfs_daily WHERE `+strings.Join(where, " AND ")+` ORDER BY location, date`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []DailyAggregate
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var aggregate DailyAggregate
+		if err := json.Unmarshal(data, &aggregate); err != nil {
+			return nil, fmt.Errorf("decoding daily aggregate: %w", err)
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, rows.Err()
+}
+
+// Function to close the connection pool
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
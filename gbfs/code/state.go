@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Struct for the latest successfully ingested data of one provider
+type ProviderState struct {
+	Provider  Provider
+	UpdatedAt time.Time
+	Bikes     []Bike
+	Stations  []Station
+	// BikeCount counts bikes that were not decoded, in count-only mode
+	BikeCount int
+	// LastUpdated is the feed's own last_updated; zero when unknown
+	LastUpdated time.Time
+}
+
+// Struct holding the latest state of every provider, updated by ingestion and
+// read by the API handlers
+type providerStateStore struct {
+	mu        sync.RWMutex
+	providers map[string]ProviderState
+	// Optional backend shared by all instances; reads prefer it when set
+	shared StateBackend
+}
+
+// In-memory state shared by ingestion and the API
+var liveState = &providerStateStore{providers: map[string]ProviderState{}}
+
+// Function to replace a provider's state with a fresh scrape result
+func (s *providerStateStore) update(provider Provider, result ScrapeResult) {
+	state := ProviderState{
+		Provider:    provider,
+		UpdatedAt:   clock.Now().UTC(),
+		Bikes:       result.Bikes,
+		Stations:    result.Stations,
+		BikeCount:   result.BikeCount,
+		LastUpdated: result.LastUpdated,
+	}
+	s.mu.Lock()
+	s.providers[provider.Location] = state
+	s.mu.Unlock()
+
+	if s.shared != nil {
+		if err := s.shared.SaveState(state); err != nil {
+			log.Printf("Error saving shared state for %s: %v", provider.Location, err)
+		}
+	}
+}
+
+// Function to forget a provider that is no longer configured
+func (s *providerStateStore) remove(location string) {
+	s.mu.Lock()
+	delete(s.providers, location)
+	s.mu.Unlock()
+
+	if s.shared != nil {
+		if err := s.shared.RemoveState(location); err != nil {
+			log.Printf("Error removing shared state for %s: %v", location, err)
+		}
+	}
+}
+
+// Function to return the state of one provider. With a shared backend only its
+// entry is read, falling back to the local state on errors.
+func (s *providerStateStore) get(location string) (ProviderState, bool) {
+	if s.shared != nil {
+		state, ok, err := s.shared.LoadState(location)
+		if err == nil {
+			return state, ok
+		}
+		log.Printf("Error loading shared state of %s, serving local state: %v", location, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.providers[location]
+	return state, ok
+}
+
+// Function to return the state of all providers, sorted by location. With a
+// shared backend its contents are returned, falling back to the local state on errors.
+func (s *providerStateStore) all() []ProviderState {
+	var states []ProviderState
+	loaded := false
+	if s.shared != nil {
+		var err error
+		if states, err = s.shared.LoadStates(); err != nil {
+			log.Printf("Error loading shared state, serving local state: %v", err)
+		} else {
+			loaded = true
+		}
+	}
+	if !loaded {
+		s.mu.RLock()
+		states = make([]ProviderState, 0, len(s.providers))
+		for _, state := range s.providers {
+			states = append(states, state)
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Provider.Location < states[j].Provider.Location })
+	return states
+}
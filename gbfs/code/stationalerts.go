@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Conditions a station alert rule can watch for
+const (
+	// No bikes available
+	alertStationEmpty = "empty"
+	// No docks available
+	alertStationFull = "full"
+	// Missing from a successful scrape of its provider
+	alertStationOffline = "offline"
+)
+
+// Struct for a station-scoped alert rule in the config file
+type StationAlertRule struct {
+	Name string `yaml:"name"`
+	// Provider limits the rule to one provider; empty watches every provider
+	Provider string `yaml:"provider,omitempty"`
+	// Stations limits the rule to these station IDs; empty watches every station
+	Stations  []string `yaml:"stations,omitempty"`
+	Condition string   `yaml:"condition"`
+	// For is how long the condition must hold before the alert fires
+	For time.Duration `yaml:"for,omitempty"`
+}
+
+// Function to check a rule
+func (r StationAlertRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("station alert needs a name")
+	}
+	switch r.Condition {
+	case alertStationEmpty, alertStationFull, alertStationOffline:
+	default:
+		return fmt.Errorf("station alert %q: invalid condition %q, expected empty, full or offline", r.Name, r.Condition)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("station alert %q: for cannot be negative", r.Name)
+	}
+	return nil
+}
+
+// Function to report whether a rule watches a provider's station
+func (r StationAlertRule) watches(location, stationID string) bool {
+	if r.Provider != "" && r.Provider != location {
+		return false
+	}
+	return len(r.Stations) == 0 || containsString(r.Stations, stationID)
+}
+
+// Struct for a station alert, pending until its rule's duration passed, then firing
+type StationAlert struct {
+	Rule        string     `json:"rule"`
+	Condition   string     `json:"condition"`
+	Provider    string     `json:"provider"`
+	StationID   string     `json:"station_id"`
+	StationName string     `json:"station_name,omitempty"`
+	Since       time.Time  `json:"since"`
+	Firing      bool       `json:"firing"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Struct for the body POSTed to station alert webhooks when alerts fire or resolve
+type stationAlertPayload struct {
+	Alerts []StationAlert `json:"alerts"`
+}
+
+// Struct evaluating station alert rules against every successful scrape
+type stationAlerter struct {
+	notifiers []*webhookSink
+
+	mu    sync.Mutex
+	rules []StationAlertRule
+	// active alerts by rule, provider and station ID
+	active map[[3]string]*StationAlert
+	// stations seen in the provider's previous scrape, for offline detection
+	seen map[string]map[string]string
+}
+
+// Station alerting, set up from the config's station_alerts
+var stationAlerts = &stationAlerter{active: map[[3]string]*StationAlert{}, seen: map[string]map[string]string{}}
+
+// Gauge for the firing station alerts of each provider and rule
+var stationAlertsFiring = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_station_alerts_firing",
+		Help: "Number of stations for which a station alert rule is firing",
+	},
+	[]string{"location", "rule"},
+)
+
+func init() {
+	prometheus.MustRegister(stationAlertsFiring)
+}
+
+// Function to name a station from station_information, falling back to the scraped name
+func stationName(provider Provider, stationID, scraped string) string {
+	if info, ok := providerStationInformation(provider)[stationID]; ok && info.Name != "" {
+		return string(info.Name)
+	}
+	return scraped
+}
+
+// Function to evaluate the rules against a provider's scraped stations, notifying
+// the webhooks of alerts that started firing or resolved
+func (a *stationAlerter) evaluate(provider Provider, stations []Station, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.rules) == 0 {
+		return
+	}
+
+	current := make(map[string]string, len(stations))
+	for _, station := range stations {
+		current[station.StationID] = station.Name
+	}
+	previous := a.seen[provider.Location]
+	a.seen[provider.Location] = current
+
+	var changed []StationAlert
+	for _, rule := range a.rules {
+		matching := map[string]string{}
+		switch rule.Condition {
+		case alertStationEmpty, alertStationFull:
+			for _, station := range stations {
+				if !rule.watches(provider.Location, station.StationID) {
+					continue
+				}
+				if (rule.Condition == alertStationEmpty && station.BikesAvailable == 0) ||
+					(rule.Condition == alertStationFull && station.DocksAvailable == 0 && !station.IsVirtual) {
+					matching[station.StationID] = station.Name
+				}
+			}
+		case alertStationOffline:
+			for id, name := range previous {
+				if _, ok := current[id]; !ok && rule.watches(provider.Location, id) {
+					matching[id] = name
+				}
+			}
+			// Stations stay offline until they reappear
+			for key, alert := range a.active {
+				if key[0] == rule.Name && key[1] == provider.Location {
+					if _, back := current[key[2]]; !back {
+						matching[key[2]] = alert.StationName
+					}
+				}
+			}
+		}
+
+		firing := 0
+		for id, name := range matching {
+			key := [3]string{rule.Name, provider.Location, id}
+			alert, ok := a.active[key]
+			if !ok {
+				alert = &StationAlert{
+					Rule:        rule.Name,
+					Condition:   rule.Condition,
+					Provider:    provider.Location,
+					StationID:   id,
+					StationName: stationName(provider, id, name),
+					Since:       now,
+				}
+				a.active[key] = alert
+			}
+			if !alert.Firing && now.Sub(alert.Since) >= rule.For {
+				alert.Firing = true
+				changed = append(changed, *alert)
+			}
+			if alert.Firing {
+				firing++
+			}
+		}
+		for key, alert := range a.active {
+			if key[0] != rule.Name || key[1] != provider.Location {
+				continue
+			}
+			if _, still := matching[key[2]]; still {
+				continue
+			}
+			delete(a.active, key)
+			if alert.Firing {
+				resolved := now
+				alert.ResolvedAt = &resolved
+				changed = append(changed, *alert)
+			}
+		}
+		stationAlertsFiring.WithLabelValues(metricLocation(provider), rule.Name).Set(float64(firing))
+	}
+
+	if len(changed) > 0 {
+		for _, alert := range changed {
+			state := "firing"
+			if alert.ResolvedAt != nil {
+				state = "resolved"
+			}
+			log.Printf("Station alert %s %s for %s station %s %q", alert.Rule, state, alert.Provider, alert.StationID, alert.StationName)
+		}
+		go a.notify(changed)
+	}
+}
+
+// Function to POST changed alerts to every station alert webhook
+func (a *stationAlerter) notify(alerts []StationAlert) {
+	body, err := json.Marshal(stationAlertPayload{Alerts: alerts})
+	if err != nil {
+		log.Printf("Error encoding station alerts: %v", err)
+		return
+	}
+	for _, notifier := range a.notifiers {
+		if err := notifier.send(body); err != nil {
+			log.Printf("Error notifying %s of station alerts: %v", notifier.Name(), err)
+		}
+	}
+}
+
+// Function to list the pending and firing alerts, optionally of one provider, oldest first
+func (a *stationAlerter) list(location string) []StationAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alerts := []StationAlert{}
+	for _, alert := range a.active {
+		if location == "" || alert.Provider == location {
+			alerts = append(alerts, *alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Since.Equal(alerts[j].Since) {
+			return alerts[i].Since.Before(alerts[j].Since)
+		}
+		return alerts[i].StationID < alerts[j].StationID
+	})
+	return alerts
+}
+
+// Function to replace the rules, as on a config reload; alerts of rules no longer
+// configured are dropped without notification
+func (a *stationAlerter) setRules(rules []StationAlertRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := map[string]bool{}
+	for _, rule := range rules {
+		kept[rule.Name] = true
+	}
+	for _, rule := range a.rules {
+		if !kept[rule.Name] {
+			stationAlertsFiring.DeletePartialMatch(prometheus.Labels{"rule": rule.Name})
+		}
+	}
+	a.rules = rules
+	for key := range a.active {
+		if !kept[key[0]] {
+			delete(a.active, key)
+		}
+	}
+}
+
+// Function to report whether any rules are configured
+func (a *stationAlerter) configured() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.rules) > 0
+}
+
+// Handler for GET /api/v1/alerts/stations, listing pending and firing station
+// alerts, scoped to the deployment when given
+func stationAlertsHandler(c *gin.Context) {
+	if !stationAlerts.configured() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no station alerts are configured"})
+		return
+	}
+	alerts := stationAlerts.list(c.Query("provider"))
+	if c.Query(deploymentLabel) != "" {
+		providers, err := getProviders()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		own := map[string]bool{}
+		for _, provider := range deploymentProviders(c, providers) {
+			own[provider.Location] = true
+		}
+		scoped := alerts[:0:0]
+		for _, alert := range alerts {
+			if own[alert.Provider] {
+				scoped = append(scoped, alert)
+			}
+		}
+		alerts = scoped
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
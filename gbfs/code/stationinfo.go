@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How long a provider's station_information feed is cached
+const stationInformationRefresh = time.Hour
+
+// Text given as a plain string (GBFS 1.x and 2.x) or as localized strings (GBFS 3.x)
+type gbfsText string
+
+// Function to decode either text form, keeping the first translation
+func (t *gbfsText) UnmarshalJSON(data []byte) error {
+	var plain string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*t = gbfsText(plain)
+		return nil
+	}
+	var localized []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &localized); err != nil {
+		return fmt.Errorf("invalid text %s", data)
+	}
+	if len(localized) > 0 {
+		*t = gbfsText(localized[0].Text)
+	}
+	return nil
+}
+
+// Struct for a station in station_information
+type stationInformation struct {
+	StationID   string   `json:"station_id"`
+	Name        gbfsText `json:"name"`
+	Lat         float64  `json:"lat"`
+	Lon         float64  `json:"lon"`
+	Capacity    int      `json:"capacity"`
+	IsVirtual   gbfsBool `json:"is_virtual_station"`
+	IsCharging  gbfsBool `json:"is_charging_station"`
+	ParkingType string   `json:"parking_type"`
+	// Display fields passed through to the stations API
+	Address       gbfsText `json:"address"`
+	CrossStreet   gbfsText `json:"cross_street"`
+	RentalMethods []string `json:"rental_methods"`
+	// Names holds every translation of a GBFS 3.x localized name; Name keeps the first for metrics
+	Names localizedText `json:"-"`
+}
+
+// Function to decode a station, keeping the translations of its name
+func (s *stationInformation) UnmarshalJSON(data []byte) error {
+	type plain stationInformation
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var names struct {
+		Name localizedText `json:"name"`
+	}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	s.Names = names.Name
+	return nil
+}
+
+// Struct for a station_information feed
+type stationInformationFeed struct {
+	Data struct {
+		Stations []stationInformation `json:"stations"`
+	} `json:"data"`
+}
+
+// Struct for a provider's cached station_information, by station ID
+type cachedStationInformation struct {
+	stations  map[string]stationInformation
+	fetchedAt time.Time
+}
+
+// Cache of station_information per provider location
+var stationInformationCache = struct {
+	sync.Mutex
+	byLocation map[string]cachedStationInformation
+}{byLocation: map[string]cachedStationInformation{}}
+
+// Create Prometheus gauges for the kinds of stations of each provider
+var (
+	stationsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_stations",
+			Help: "Number of stations published by the provider",
+		},
+		[]string{"location"},
+	)
+	virtualStationsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_virtual_stations",
+			Help: "Number of virtual stations, areas without physical docks",
+		},
+		[]string{"location"},
+	)
+	chargingStationsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_charging_stations",
+			Help: "Number of stations that charge e-bikes while docked",
+		},
+		[]string{"location"},
+	)
+	chargingDocksGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_charging_docks_available",
+			Help: "Number of docks available at charging stations",
+		},
+		[]string{"location"},
+	)
+	stationsByParkingType = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_stations_by_parking_type",
+			Help: "Number of stations by GBFS parking_type; stations without one are counted as unknown",
+		},
+		[]string{"location", "parking_type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(stationsGauge, virtualStationsGauge, chargingStationsGauge, chargingDocksGauge, stationsByParkingType)
+}
+
+// Function to return the stations of a provider's station_information, fetched at
+// most hourly; non-GBFS sources and systems without the feed yield none
+func providerStationInformation(provider Provider) map[string]stationInformation {
+	if provider.Source != "" && provider.Source != sourceGBFS {
+		return nil
+	}
+	stationInformationCache.Lock()
+	cached, ok := stationInformationCache.byLocation[provider.Location]
+	stationInformationCache.Unlock()
+	if ok && clock.Now().Sub(cached.fetchedAt) < stationInformationRefresh {
+		return cached.stations
+	}
+
+	stations, listed, err := fetchStationInformation(provider)
+	if err != nil {
+		log.Printf("Error reading station_information of %s: %v", provider.Location, err)
+		// Keep previously known stations through temporary failures
+		stations = cached.stations
+	} else if !listed {
+		// Free-floating systems do not publish station_information
+		stations = nil
+	}
+	stationInformationCache.Lock()
+	stationInformationCache.byLocation[provider.Location] = cachedStationInformation{stations: stations, fetchedAt: clock.Now()}
+	stationInformationCache.Unlock()
+	return stations
+}
+
+// Function to fetch the provider's station_information feed; listed is false when discovery has none
+func fetchStationInformation(provider Provider) (map[string]stationInformation, bool, error) {
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	url, ok := providerFeedURL(provider, body, "station_information")
+	if !ok {
+		return nil, false, nil
+	}
+	body, err = fetchBody(provider, url, nil)
+	if err != nil {
+		return nil, true, err
+	}
+	var feed stationInformationFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, true, failedBodies.keep(provider, url, body, fmt.Errorf("parsing station_information: %w", err))
+	}
+	stations := make(map[string]stationInformation, len(feed.Data.Stations))
+	for _, station := range feed.Data.Stations {
+		stations[station.StationID] = station
+	}
+	return stations, true, nil
+}
+
+// Function to copy the virtual, charging and parking attributes of station_information onto scraped stations
+func annotateStations(provider Provider, stations []Station) {
+	if len(stations) == 0 {
+		return
+	}
+	infos := providerStationInformation(provider)
+	for i, station := range stations {
+		info, ok := infos[station.StationID]
+		if !ok {
+			continue
+		}
+		stations[i].IsVirtual = bool(info.IsVirtual)
+		stations[i].IsCharging = bool(info.IsCharging)
+		stations[i].ParkingType = info.ParkingType
+	}
+}
+
+// Function to export a provider's station kinds, counted from station_information
+// when published and from the scraped stations otherwise
+func updateStationMetrics(provider Provider, stations []Station) {
+	infos := providerStationInformation(provider)
+	if len(infos) == 0 && len(stations) == 0 {
+		return
+	}
+	counted := stations
+	if len(infos) > 0 {
+		counted = make([]Station, 0, len(infos))
+		for _, info := range infos {
+			counted = append(counted, Station{StationID: info.StationID, IsVirtual: bool(info.IsVirtual), IsCharging: bool(info.IsCharging), ParkingType: info.ParkingType})
+		}
+	}
+
+	virtual, charging := 0, 0
+	parking := map[string]int{}
+	for _, station := range counted {
+		if station.IsVirtual {
+			virtual++
+		}
+		if station.IsCharging {
+			charging++
+		}
+		parkingType := station.ParkingType
+		if parkingType == "" {
+			parkingType = "unknown"
+		}
+		parking[parkingType]++
+	}
+	chargingDocks := 0
+	for _, station := range stations {
+		if station.IsCharging {
+			chargingDocks += station.DocksAvailable
+		}
+	}
+
+	location := metricLocation(provider)
+	stationsGauge.WithLabelValues(location).Set(float64(len(counted)))
+	virtualStationsGauge.WithLabelValues(location).Set(float64(virtual))
+	chargingStationsGauge.WithLabelValues(location).Set(float64(charging))
+	chargingDocksGauge.WithLabelValues(location).Set(float64(chargingDocks))
+	// Parking types that disappeared from the feed must not linger
+	stationsByParkingType.DeletePartialMatch(prometheus.Labels{"location": location})
+	parkingTypes := make([]string, 0, len(parking))
+	for parkingType := range parking {
+		parkingTypes = append(parkingTypes, parkingType)
+	}
+	assigned := labelLimits.assign(provider, "parking_type", parkingTypes)
+	exported := map[string]int{}
+	for parkingType, count := range parking {
+		exported[assigned[parkingType]] += count
+	}
+	for parkingType, count := range exported {
+		stationsByParkingType.WithLabelValues(location, parkingType).Set(float64(count))
+	}
+}
+
+// Struct for a station in the API, with its attributes and latest availability
+type StationDetails struct {
+	StationID      string  `json:"station_id"`
+	Name           string  `json:"name"`
+	Lat            float64 `json:"lat"`
+	Lon            float64 `json:"lon"`
+	Capacity       int     `json:"capacity,omitempty"`
+	IsVirtual      bool    `json:"is_virtual_station"`
+	IsCharging     bool    `json:"is_charging_station"`
+	ParkingType    string  `json:"parking_type,omitempty"`
+	BikesAvailable *int    `json:"num_bikes_available,omitempty"`
+	DocksAvailable *int    `json:"num_docks_available,omitempty"`
+	// Display fields passed through from station_information
+	Address       string   `json:"address,omitempty"`
+	CrossStreet   string   `json:"cross_street,omitempty"`
+	RentalMethods []string `json:"rental_methods,omitempty"`
+}
+
+// Handler for GET /api/v1/providers/:name/stations, listing the provider's stations
+// with their station_information attributes and latest availability. Names are
+// translated to the Accept-Language when the feed publishes localized names.
+func providerStationsHandler(c *gin.Context) {
+	provider, found, err := findProvider(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+
+	languages := acceptedLanguages(c.GetHeader("Accept-Language"))
+	c.Header("Vary", "Accept-Language")
+	byID := map[string]*StationDetails{}
+	for id, info := range providerStationInformation(provider) {
+		byID[id] = &StationDetails{
+			StationID:     id,
+			Name:          info.Names.pick(languages, string(info.Name)),
+			Lat:           info.Lat,
+			Lon:           info.Lon,
+			Capacity:      info.Capacity,
+			IsVirtual:     bool(info.IsVirtual),
+			IsCharging:    bool(info.IsCharging),
+			ParkingType:   info.ParkingType,
+			Address:       string(info.Address),
+			CrossStreet:   string(info.CrossStreet),
+			RentalMethods: info.RentalMethods,
+		}
+	}
+	var updatedAt, lastUpdated time.Time
+	var license *DataLicense
+	if state, ok := liveState.get(provider.Location); ok {
+		updatedAt, lastUpdated, license = state.UpdatedAt, state.LastUpdated, state.Provider.License
+		for _, station := range state.Stations {
+			details, ok := byID[station.StationID]
+			if !ok {
+				details = &StationDetails{
+					StationID:   station.StationID,
+					Name:        station.Name,
+					Lat:         station.Lat,
+					Lon:         station.Lon,
+					IsVirtual:   station.IsVirtual,
+					IsCharging:  station.IsCharging,
+					ParkingType: station.ParkingType,
+				}
+				byID[station.StationID] = details
+			}
+			bikes, docks := station.BikesAvailable, station.DocksAvailable
+			details.BikesAvailable, details.DocksAvailable = &bikes, &docks
+		}
+	}
+
+	stations := make([]StationDetails, 0, len(byID))
+	for _, details := range byID {
+		stations = append(stations, *details)
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].StationID < stations[j].StationID })
+	c.JSON(http.StatusOK, gin.H{
+		"provider":     provider.Location,
+		"updated_at":   optionalTime(updatedAt),
+		"last_updated": optionalTime(lastUpdated),
+		"license":      license,
+		"stations":     stations,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for a station in station_status; GBFS 3.x renamed bikes to vehicles
+type stationStatus struct {
+	StationID         string   `json:"station_id"`
+	BikesAvailable    *int     `json:"num_bikes_available"`
+	VehiclesAvailable *int     `json:"num_vehicles_available"`
+	DocksAvailable    int      `json:"num_docks_available"`
+	IsInstalled       gbfsBool `json:"is_installed"`
+	IsRenting         gbfsBool `json:"is_renting"`
+}
+
+// Struct for a station_status feed
+type stationStatusFeed struct {
+	LastUpdated gbfsTime `json:"last_updated"`
+	TTL         int      `json:"ttl"`
+	Data        struct {
+		Stations []stationStatus `json:"stations"`
+	} `json:"data"`
+}
+
+// Create Prometheus gauges for the availability of every station
+var (
+	stationBikesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "station_bikes_available",
+			Help: "Number of bikes available at a docking station",
+		},
+		[]string{"location", "station_id", "name"},
+	)
+	stationDocksGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "station_docks_available",
+			Help: "Number of empty docks at a docking station",
+		},
+		[]string{"location", "station_id", "name"},
+	)
+	stationRentingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "station_is_renting",
+			Help: "Whether a docking station is installed and renting out bikes (1) or not (0)",
+		},
+		[]string{"location", "station_id", "name"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(stationBikesGauge, stationDocksGauge, stationRentingGauge)
+}
+
+// Function to fetch a provider's station_status and merge it with the names and
+// positions of its station_information, returning the stations with the feed's
+// last_updated and ttl
+func fetchStations(provider Provider, stationStatusURL, version string, trace *ScrapeTrace) (ScrapeResult, error) {
+	body, err := fetchBody(provider, stationStatusURL, trace)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	feed, err := parseWithSchemas(provider, "station_status", version, body, trace, stationStatusParsers)
+	if err != nil {
+		return ScrapeResult{}, failedBodies.keep(provider, stationStatusURL, body, err)
+	}
+
+	infos := providerStationInformation(provider)
+	stations := make([]Station, 0, len(feed.Data.Stations))
+	for _, status := range feed.Data.Stations {
+		station := Station{
+			StationID:      status.StationID,
+			DocksAvailable: status.DocksAvailable,
+			IsRenting:      bool(status.IsInstalled) && bool(status.IsRenting),
+		}
+		switch {
+		case status.BikesAvailable != nil:
+			station.BikesAvailable = *status.BikesAvailable
+		case status.VehiclesAvailable != nil:
+			station.BikesAvailable = *status.VehiclesAvailable
+		}
+		if info, ok := infos[status.StationID]; ok {
+			station.Name = string(info.Name)
+			station.Lat, station.Lon = info.Lat, info.Lon
+		}
+		stations = append(stations, station)
+	}
+	trace.recordCount("stations", len(stations))
+	return ScrapeResult{
+		Stations:    stations,
+		LastUpdated: time.Time(feed.LastUpdated),
+		TTL:         time.Duration(feed.TTL) * time.Second,
+	}, nil
+}
+
+// Series exported per provider, so stations that disappear can be removed
+var stationSeries = struct {
+	sync.Mutex
+	byLocation map[string]map[string]string
+}{byLocation: map[string]map[string]string{}}
+
+// Function to export the availability of every station of a provider, removing
+// the series of stations that are gone or were renamed. Stations beyond
+// --max-label-values are summed into one station_id="other" series.
+func updateStationStatusMetrics(provider Provider, stations []Station) {
+	location := metricLocation(provider)
+	ids := make([]string, len(stations))
+	for i, station := range stations {
+		ids[i] = station.StationID
+	}
+	assigned := labelLimits.assign(provider, "station_id", ids)
+
+	current := make(map[string]string, len(stations))
+	overflowed, overflowBikes, overflowDocks, overflowRenting := 0, 0, 0, 0.0
+	for _, station := range stations {
+		renting := 0.0
+		if station.IsRenting {
+			renting = 1
+		}
+		if assigned[station.StationID] == overflowLabelValue {
+			overflowed++
+			overflowBikes += station.BikesAvailable
+			overflowDocks += station.DocksAvailable
+			overflowRenting += renting
+			continue
+		}
+		current[station.StationID] = station.Name
+		stationBikesGauge.WithLabelValues(location, station.StationID, station.Name).Set(float64(station.BikesAvailable))
+		stationDocksGauge.WithLabelValues(location, station.StationID, station.Name).Set(float64(station.DocksAvailable))
+		stationRentingGauge.WithLabelValues(location, station.StationID, station.Name).Set(renting)
+	}
+	if overflowed > 0 {
+		// The overflow series holds the sums, and the number of renting stations
+		current[overflowLabelValue] = overflowLabelValue
+		stationBikesGauge.WithLabelValues(location, overflowLabelValue, overflowLabelValue).Set(float64(overflowBikes))
+		stationDocksGauge.WithLabelValues(location, overflowLabelValue, overflowLabelValue).Set(float64(overflowDocks))
+		stationRentingGauge.WithLabelValues(location, overflowLabelValue, overflowLabelValue).Set(overflowRenting)
+	}
+
+	stationSeries.Lock()
+	defer stationSeries.Unlock()
+	for id, name := range stationSeries.byLocation[location] {
+		if currentName, ok := current[id]; ok && currentName == name {
+			continue
+		}
+		for _, gauge := range []*prometheus.GaugeVec{stationBikesGauge, stationDocksGauge, stationRentingGauge} {
+			gauge.DeleteLabelValues(location, id, name)
+		}
+	}
+	stationSeries.byLocation[location] = current
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of station change events on the stream
+const (
+	// Bikes or docks available changed
+	stationEventAvailability = "availability"
+	// The station stopped renting or is missing from a successful scrape
+	stationEventOffline = "offline"
+	// The station rents again or reappeared
+	stationEventOnline = "online"
+)
+
+// Events buffered per client; a client further behind is disconnected and has to reconnect
+const stationStreamBuffer = 256
+
+// Interval of the comments that keep idle streams open through proxies
+const stationStreamKeepalive = 30 * time.Second
+
+// Struct for a change of one station between two scrapes of its provider
+type StationEvent struct {
+	Type      string    `json:"type"`
+	Provider  string    `json:"provider"`
+	StationID string    `json:"station_id"`
+	Time      time.Time `json:"time"`
+	// Availability after the change; omitted for stations that went missing
+	BikesAvailable *int  `json:"num_bikes_available,omitempty"`
+	DocksAvailable *int  `json:"num_docks_available,omitempty"`
+	IsRenting      *bool `json:"is_renting,omitempty"`
+	// Availability before the change, so clients can tell e.g. 0→N from N→M
+	PreviousBikes *int `json:"previous_num_bikes_available,omitempty"`
+	PreviousDocks *int `json:"previous_num_docks_available,omitempty"`
+
+	deployment string
+}
+
+// Struct for a stream client and the stations it follows
+type stationSubscriber struct {
+	events     chan StationEvent
+	provider   string
+	deployment string
+	// stations followed; empty follows every station
+	stations map[string]bool
+}
+
+// Function to report whether a client follows the station of an event
+func (s *stationSubscriber) follows(event StationEvent) bool {
+	return (s.provider == "" || s.provider == event.Provider) &&
+		(s.deployment == "" || s.deployment == event.deployment) &&
+		(len(s.stations) == 0 || s.stations[event.StationID])
+}
+
+// Struct diffing each successful scrape against the previous one and fanning the
+// station changes out to the stream clients
+type stationEventHub struct {
+	mu          sync.Mutex
+	subscribers map[*stationSubscriber]bool
+	// stations of each provider's previous scrape, by station ID
+	previous map[string]map[string]Station
+}
+
+// Station change events, fed by ingestion and served by /api/v1/stream/stations
+var stationEvents = &stationEventHub{subscribers: map[*stationSubscriber]bool{}, previous: map[string]map[string]Station{}}
+
+// Function to compare a provider's scraped stations with its previous scrape and
+// publish the changes. The provider's first scrape only sets the baseline.
+func (h *stationEventHub) observe(provider Provider, stations []Station, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous, known := h.previous[provider.Location]
+	current := make(map[string]Station, len(stations))
+	for _, station := range stations {
+		current[station.StationID] = station
+	}
+	h.previous[provider.Location] = current
+	if !known || len(h.subscribers) == 0 {
+		return
+	}
+
+	event := func(kind, stationID string) StationEvent {
+		return StationEvent{Type: kind, Provider: provider.Location, StationID: stationID, Time: now, deployment: provider.Deployment}
+	}
+	for _, station := range stations {
+		before, seen := previous[station.StationID]
+		switch {
+		case !seen || (!before.IsRenting && station.IsRenting):
+			h.publish(withAvailability(event(stationEventOnline, station.StationID), station))
+		case before.IsRenting && !station.IsRenting:
+			h.publish(withAvailability(event(stationEventOffline, station.StationID), station))
+		case before.BikesAvailable != station.BikesAvailable || before.DocksAvailable != station.DocksAvailable:
+			change := withAvailability(event(stationEventAvailability, station.StationID), station)
+			bikes, docks := before.BikesAvailable, before.DocksAvailable
+			change.PreviousBikes, change.PreviousDocks = &bikes, &docks
+			h.publish(change)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			h.publish(event(stationEventOffline, id))
+		}
+	}
+}
+
+// Function to fill in the availability of a station after a change
+func withAvailability(event StationEvent, station Station) StationEvent {
+	bikes, docks, renting := station.BikesAvailable, station.DocksAvailable, station.IsRenting
+	event.BikesAvailable, event.DocksAvailable, event.IsRenting = &bikes, &docks, &renting
+	return event
+}
+
+// Function to send an event to the clients following its station, disconnecting
+// clients whose buffer is full rather than blocking ingestion
+func (h *stationEventHub) publish(event StationEvent) {
+	for subscriber := range h.subscribers {
+		if !subscriber.follows(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			delete(h.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// Function to register a stream client
+func (h *stationEventHub) subscribe(subscriber *stationSubscriber) {
+	h.mu.Lock()
+	h.subscribers[subscriber] = true
+	h.mu.Unlock()
+}
+
+// Function to unregister a stream client, unless it was already disconnected
+func (h *stationEventHub) unsubscribe(subscriber *stationSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[subscriber] {
+		delete(h.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// Function to disconnect every client, so open streams do not hold up shutdown
+func (h *stationEventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subscriber := range h.subscribers {
+		delete(h.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// Function to forget a provider's previous stations
+func (h *stationEventHub) forget(location string) {
+	h.mu.Lock()
+	delete(h.previous, location)
+	h.mu.Unlock()
+}
+
+// Function to write one server-sent event
+func writeServerSentEvent(w gin.ResponseWriter, name string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, body); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// Handler for GET /api/v1/stream/stations, a server-sent event stream that starts
+// with a snapshot event of the followed stations and then sends one small event per
+// station change: availability, offline or online. ?provider= and ?station=a,b
+// narrow it; ?deployment= scopes it as elsewhere.
+func stationStreamHandler(c *gin.Context) {
+	subscriber := &stationSubscriber{events: make(chan StationEvent, stationStreamBuffer), deployment: c.Query(deploymentLabel)}
+	if name := c.Query("provider"); name != "" {
+		provider, found, err := findProvider(name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+			return
+		}
+		subscriber.provider = provider.Location
+	}
+	if ids := c.Query("station"); ids != "" {
+		subscriber.stations = map[string]bool{}
+		for _, id := range strings.Split(ids, ",") {
+			subscriber.stations[strings.TrimSpace(id)] = true
+		}
+	}
+
+	// Subscribe before reading the snapshot so no change between the two is lost
+	stationEvents.subscribe(subscriber)
+	defer stationEvents.unsubscribe(subscriber)
+
+	var snapshot []StationEvent
+	for _, state := range liveState.all() {
+		for _, station := range state.Stations {
+			event := withAvailability(StationEvent{
+				Type:       "snapshot",
+				Provider:   state.Provider.Location,
+				StationID:  station.StationID,
+				Time:       state.UpdatedAt,
+				deployment: state.Provider.Deployment,
+			}, station)
+			if subscriber.follows(event) {
+				snapshot = append(snapshot, event)
+			}
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if err := writeServerSentEvent(c.Writer, "snapshot", gin.H{"stations": snapshot}); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(stationStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return
+			}
+			if err := writeServerSentEvent(c.Writer, event.Type, event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether the public status page is served, and its heading, set with serve --status-page
+var (
+	statusPageEnabled bool
+	statusPageTitle   = "GBFS feed status"
+)
+
+// Windows shown as uptime badges on the status page
+var statusPageWindows = []string{"24h", "7d", "30d"}
+
+// States of a provider on the status page
+const (
+	statusOperational = "operational"
+	statusDegraded    = "degraded"
+	statusDown        = "down"
+	statusUnknown     = "unknown"
+)
+
+// Struct for one provider's row on the status page
+type statusRow struct {
+	Name           string
+	State          string
+	Reason         string
+	UpdatedAt      time.Time
+	LastUpdated    time.Time
+	AvailableBikes int
+	Stations       int
+	Uptimes        []statusUptime
+}
+
+// Struct for a provider's uptime over one window
+type statusUptime struct {
+	Window string
+	Ratio  float64
+}
+
+// Function to return a provider's uptime over a window, from the --store history when
+// enabled and otherwise from its down incidents since it was first configured. ok is
+// false without any data.
+func providerUptime(location, window string, now time.Time) (float64, bool) {
+	duration, err := parseSLAWindow(window)
+	if err != nil {
+		return 0, false
+	}
+	from := now.Add(-duration)
+	if reader, found := snapshotStore.(SnapshotReader); found {
+		snapshots, err := reader.QuerySnapshots(SnapshotQuery{Providers: []string{location}, From: from, To: now})
+		if err != nil || len(snapshots) == 0 {
+			return 0, false
+		}
+		report := computeSLA(window, snapshots, from, now)
+		return report.Uptime, report.Scrapes > 0
+	}
+	if _, scraped := providerHealth.score(location); !scraped {
+		return 0, false
+	}
+	if first := registry.firstSeen(location); first.After(from) {
+		from = first
+	}
+	observed := now.Sub(from)
+	if observed <= 0 {
+		return 0, false
+	}
+	var down time.Duration
+	for _, incident := range incidents.query(location, from, now) {
+		if incident.Kind != incidentDown {
+			continue
+		}
+		start, end := incident.Start, now
+		if incident.End != nil && incident.End.Before(now) {
+			end = *incident.End
+		}
+		if start.Before(from) {
+			start = from
+		}
+		down += end.Sub(start)
+	}
+	return max(0, 1-float64(down)/float64(observed)), true
+}
+
+// Function to return a provider's state and, when stale, the age of its data. Scrape
+// errors are not published, as they may reveal details of the upstream endpoints.
+func providerStatusState(location string, now time.Time) (string, string) {
+	state, reason := statusUnknown, ""
+	if _, scraped := providerHealth.score(location); scraped {
+		state = statusOperational
+	}
+	for _, incident := range incidents.query(location, now, time.Time{}) {
+		if incident.End != nil {
+			continue
+		}
+		switch {
+		case incident.Kind == incidentDown:
+			return statusDown, ""
+		case incident.Kind == incidentStale:
+			state, reason = statusDegraded, incident.Reason
+		}
+	}
+	return state, reason
+}
+
+// Function to build the status page rows of the active providers
+func statusRows(providers []Provider, now time.Time) []statusRow {
+	rows := make([]statusRow, 0, len(providers))
+	for _, provider := range providers {
+		row := statusRow{Name: provider.Location}
+		row.State, row.Reason = providerStatusState(provider.Location, now)
+		if state, ok := liveState.get(provider.Location); ok {
+			row.UpdatedAt, row.LastUpdated = state.UpdatedAt, state.LastUpdated
+			row.AvailableBikes = ScrapeResult{Bikes: state.Bikes, Stations: state.Stations, BikeCount: state.BikeCount}.AvailableBikes()
+			row.Stations = len(state.Stations)
+		}
+		for _, window := range statusPageWindows {
+			if ratio, ok := providerUptime(provider.Location, window, now); ok {
+				row.Uptimes = append(row.Uptimes, statusUptime{Window: window, Ratio: ratio})
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Template of the status page; it reloads itself every minute
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago":     statusAgo,
+	"percent": func(ratio float64) string { return fmt.Sprintf("%.2f%%", ratio*100) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; color: #222; padding: 0 1rem; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .5rem; border-bottom: 1px solid #ddd; vertical-align: top; }
+.state { font-weight: 600; text-transform: capitalize; }
+.operational { color: #2e7d32; } .degraded { color: #ef6c00; } .down { color: #c62828; } .unknown { color: #757575; }
+.reason { color: #757575; font-size: .85em; }
+footer { color: #757575; font-size: .85em; margin-top: 1rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>System</th><th>Status</th><th>Last update</th><th>Available</th><th>Uptime</th></tr>
+{{range .Rows}}<tr>
+<td>{{.Name}}</td>
+<td><span class="state {{.State}}">{{.State}}</span>{{if .Reason}}<div class="reason">{{.Reason}}</div>{{end}}</td>
+<td>{{if .UpdatedAt.IsZero}}never{{else}}{{ago .UpdatedAt $.Now}}{{if not .LastUpdated.IsZero}}<div class="reason">feed published {{ago .LastUpdated $.Now}}</div>{{end}}{{end}}</td>
+<td>{{.AvailableBikes}} vehicles{{if .Stations}} at {{.Stations}} stations{{end}}</td>
+<td>{{$name := .Name}}{{range .Uptimes}}<img src="status/badges/{{$name}}?window={{.Window}}" alt="uptime {{.Window}} {{percent .Ratio}}"> {{end}}</td>
+</tr>
+{{end}}</table>
+<footer>Generated {{.Now.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+`))
+
+// Function to describe how long ago t was, to the minute
+func statusAgo(t, now time.Time) string {
+	age := now.Sub(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d min ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d h ago", int(age.Hours()))
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// Handler for the public status page at /status, listing each active provider's
+// state, last update, availability and uptime badges
+func statusPageHandler(c *gin.Context) {
+	if !statusPageEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "status page is not enabled"})
+		return
+	}
+	providers, err := getProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := clock.Now().UTC()
+	var page strings.Builder
+	err = statusPageTemplate.Execute(&page, map[string]any{
+		"Title": statusPageTitle,
+		"Rows":  statusRows(deploymentProviders(c, providers), now),
+		"Now":   now,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=60")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// Handler for /status/badges/:name?window=30d, an SVG badge of a provider's uptime
+// for embedding in city websites
+func statusBadgeHandler(c *gin.Context) {
+	if !statusPageEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "status page is not enabled"})
+		return
+	}
+	window := c.DefaultQuery("window", "30d")
+	if _, err := parseSLAWindow(window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	provider, ok, err := findProvider(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, inactive := registry.inactive(provider.Location); !ok || inactive {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+
+	value, color := "no data", "#9e9e9e"
+	if ratio, ok := providerUptime(provider.Location, window, clock.Now().UTC()); ok {
+		value = fmt.Sprintf("%.2f%%", ratio*100)
+		switch {
+		case ratio >= 0.99:
+			color = "#4caf50"
+		case ratio >= 0.95:
+			color = "#ff9800"
+		default:
+			color = "#e53935"
+		}
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, "image/svg+xml", []byte(uptimeBadge("uptime "+window, value, color)))
+}
+
+// Function to render a flat two-part badge; widths are estimated at 7px per character
+func uptimeBadge(label, value, color string) string {
+	labelWidth, valueWidth := 7*len(label)+10, 7*len(value)+10
+	width := labelWidth + valueWidth
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/>`+
+		`<g fill="#fff" font-family="Verdana,sans-serif" font-size="11" text-anchor="middle">`+
+		`<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`,
+		width, template.HTMLEscapeString(label), template.HTMLEscapeString(value),
+		labelWidth, labelWidth, valueWidth, color,
+		labelWidth/2, template.HTMLEscapeString(label), labelWidth+valueWidth/2, template.HTMLEscapeString(value))
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Station values a subscription can watch
+const (
+	subscriptionBikes = "bikes_available"
+	subscriptionDocks = "docks_available"
+)
+
+// Largest subscription body accepted by the API
+const maxSubscriptionBody = 16 << 10
+
+// Struct for a consumer's webhook, notified when a station's value crosses its threshold
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the deliveries like --webhook-secret; it is never returned by the API
+	Secret    string `json:"secret,omitempty"`
+	Provider  string `json:"provider"`
+	StationID string `json:"station_id"`
+	// Metric is bikes_available or docks_available, compared with Op ">=" or "<=" to Threshold
+	Metric    string `json:"metric"`
+	Op        string `json:"op"`
+	Threshold int    `json:"threshold"`
+	// Once deletes the subscription after its first notification
+	Once      bool      `json:"once,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Met is whether the threshold held at the last scrape; notifications are sent when it becomes true
+	Met bool `json:"met"`
+}
+
+// Function to check a subscription submitted through the API
+func (s Subscription) validate() error {
+	if s.URL == "" || s.Provider == "" || s.StationID == "" {
+		return fmt.Errorf("url, provider and station_id are required")
+	}
+	if err := egress.checkURL(s.URL); err != nil {
+		return err
+	}
+	if s.Metric != subscriptionBikes && s.Metric != subscriptionDocks {
+		return fmt.Errorf("invalid metric %q, expected %s or %s", s.Metric, subscriptionBikes, subscriptionDocks)
+	}
+	if s.Op != ">=" && s.Op != "<=" {
+		return fmt.Errorf("invalid op %q, expected >= or <=", s.Op)
+	}
+	if s.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	return nil
+}
+
+// Function to return the watched value of a station and whether it meets the threshold
+func (s Subscription) evaluate(station Station) (int, bool) {
+	value := station.BikesAvailable
+	if s.Metric == subscriptionDocks {
+		value = station.DocksAvailable
+	}
+	if s.Op == ">=" {
+		return value, value >= s.Threshold
+	}
+	return value, value <= s.Threshold
+}
+
+// Struct for the body POSTed to a subscription's webhook when its threshold is crossed
+type subscriptionPayload struct {
+	Event        string    `json:"event"`
+	Subscription string    `json:"subscription"`
+	Provider     string    `json:"provider"`
+	StationID    string    `json:"station_id"`
+	StationName  string    `json:"station_name,omitempty"`
+	Metric       string    `json:"metric"`
+	Op           string    `json:"op"`
+	Threshold    int       `json:"threshold"`
+	Value        int       `json:"value"`
+	At           time.Time `json:"at"`
+}
+
+// Metrics for consumer subscriptions
+var (
+	subscriptionsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gbfs_subscriptions",
+			Help: "Number of threshold subscriptions registered through /api/v1/subscriptions",
+		},
+	)
+	subscriptionDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_subscription_notifications_total",
+			Help: "Number of threshold crossings POSTed to subscription webhooks, by result (delivered or failed)",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(subscriptionsGauge, subscriptionDeliveries)
+}
+
+// Struct holding the consumers' subscriptions, evaluated against every successful
+// scrape, optionally persisted as a JSON file
+type subscriptionStore struct {
+	// most subscriptions accepted; 0 disables the API
+	max     int
+	retries int
+
+	mu            sync.Mutex
+	subscriptions map[string]*Subscription
+	path          string
+}
+
+// Subscriptions, limited with --max-subscriptions and persisted with --subscriptions-file
+var subscriptions = &subscriptionStore{max: 1000, retries: 3, subscriptions: map[string]*Subscription{}}
+
+// Function to load the subscriptions kept in a JSON file and save every change to it
+func (s *subscriptionStore) persistTo(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored []*Subscription
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, subscription := range stored {
+		s.subscriptions[subscription.ID] = subscription
+	}
+	subscriptionsGauge.Set(float64(len(s.subscriptions)))
+	return nil
+}
+
+// Function to write the subscriptions to the file, if persisted; called with the lock held
+func (s *subscriptionStore) save() {
+	subscriptionsGauge.Set(float64(len(s.subscriptions)))
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err == nil {
+		// Write then rename, so a crash never leaves a truncated file
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving subscriptions %s: %v", s.path, err)
+	}
+}
+
+// Function to return the subscriptions oldest first; called with the lock held
+func (s *subscriptionStore) sorted() []Subscription {
+	list := make([]Subscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		list = append(list, *subscription)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Error for a subscription refused because the store is full
+var errTooManySubscriptions = errors.New("too many subscriptions")
+
+// Function to register a subscription under a new random ID
+func (s *subscriptionStore) add(subscription Subscription, now time.Time) (Subscription, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Subscription{}, err
+	}
+	subscription.ID = hex.EncodeToString(id)
+	subscription.CreatedAt = now.UTC()
+	subscription.Met = false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subscriptions) >= s.max {
+		return Subscription{}, errTooManySubscriptions
+	}
+	s.subscriptions[subscription.ID] = &subscription
+	s.save()
+	return subscription, nil
+}
+
+// Function to delete a subscription, reporting whether it existed
+func (s *subscriptionStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return false
+	}
+	delete(s.subscriptions, id)
+	s.save()
+	return true
+}
+
+// Function to evaluate the subscriptions on a provider's scraped stations, notifying
+// those whose threshold started to hold. Stations missing from the scrape keep
+// their state, so a glitch does not notify twice.
+func (s *subscriptionStore) evaluate(provider Provider, stations []Station, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subscriptions) == 0 {
+		return
+	}
+	byID := make(map[string]Station, len(stations))
+	for _, station := range stations {
+		byID[station.StationID] = station
+	}
+
+	changed := false
+	for id, subscription := range s.subscriptions {
+		if subscription.Provider != provider.Location {
+			continue
+		}
+		station, ok := byID[subscription.StationID]
+		if !ok {
+			continue
+		}
+		value, met := subscription.evaluate(station)
+		if met == subscription.Met {
+			continue
+		}
+		subscription.Met = met
+		changed = true
+		if !met {
+			continue
+		}
+		payload := subscriptionPayload{
+			Event:        "threshold_crossed",
+			Subscription: id,
+			Provider:     provider.Location,
+			StationID:    station.StationID,
+			StationName:  stationName(provider, station.StationID, station.Name),
+			Metric:       subscription.Metric,
+			Op:           subscription.Op,
+			Threshold:    subscription.Threshold,
+			Value:        value,
+			At:           now.UTC(),
+		}
+		go s.notify(*subscription, payload)
+		if subscription.Once {
+			delete(s.subscriptions, id)
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// Function to POST a threshold crossing to a subscription's webhook; the URL came
+// from a consumer, so the delivery goes through the egress policy
+func (s *subscriptionStore) notify(subscription Subscription, payload subscriptionPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding subscription %s notification: %v", subscription.ID, err)
+		return
+	}
+	sink := newWebhookSink(subscription.URL, subscription.Secret, s.retries)
+	sink.client = egress.client()
+	sink.client.Timeout = 15 * time.Second
+	if err := sink.send(body); err != nil {
+		subscriptionDeliveries.WithLabelValues("failed").Inc()
+		log.Printf("Error notifying subscription %s: %v", subscription.ID, err)
+		return
+	}
+	subscriptionDeliveries.WithLabelValues("delivered").Inc()
+}
+
+// Function to hide a subscription's secret from API responses
+func (s Subscription) public() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Handler for POST /api/v1/subscriptions, registering a webhook notified when a
+// station's bikes or docks cross a threshold, e.g. {"url": "...", "provider":
+// "Leuven", "station_id": "42", "metric": "bikes_available", "op": ">=", "threshold": 3}
+func createSubscriptionHandler(c *gin.Context) {
+	if subscriptions.max <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscriptions are disabled"})
+		return
+	}
+	var subscription Subscription
+	dec := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxSubscriptionBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&subscription); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription: " + err.Error()})
+		return
+	}
+	if err := subscription.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, found, err := findProvider(subscription.Provider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "provider not found"})
+		return
+	}
+
+	created, err := subscriptions.add(subscription, clock.Now())
+	if errors.Is(err, errTooManySubscriptions) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("at most %d subscriptions can be registered", subscriptions.max)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created.public())
+}
+
+// Handler for GET /api/v1/subscriptions, listing the subscriptions, optionally of one provider
+func subscriptionsHandler(c *gin.Context) {
+	subscriptions.mu.Lock()
+	all := subscriptions.sorted()
+	subscriptions.mu.Unlock()
+	list := []Subscription{}
+	for _, subscription := range all {
+		if provider := c.Query("provider"); provider == "" || subscription.Provider == provider {
+			list = append(list, subscription.public())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"subscriptions": list})
+}
+
+// Handler for DELETE /api/v1/subscriptions/:id
+func deleteSubscriptionHandler(c *gin.Context) {
+	if !subscriptions.remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How long a provider's system_information is cached
+const systemInformationRefresh = 24 * time.Hour
+
+// Label value sources for --location-label
+const (
+	labelFromLocation = "location"
+	labelFromSystemID = "system_id"
+)
+
+// Source of the "location" label of per-provider metrics, set with serve --location-label
+var locationLabel = labelFromLocation
+
+// Struct for the fields of system_information the exporter uses
+type systemInformationFeed struct {
+	Data struct {
+		SystemID     string   `json:"system_id"`
+		Name         gbfsText `json:"name"`
+		Operator     gbfsText `json:"operator"`
+		Timezone     string   `json:"timezone"`
+		PurchaseURL  string   `json:"purchase_url"`
+		StartRideURL string   `json:"start_ride_url"`
+		RentalApps   map[string]struct {
+			StoreURI     string `json:"store_uri"`
+			DiscoveryURI string `json:"discovery_uri"`
+		} `json:"rental_apps"`
+		// Data license and attribution, since GBFS 2.3 except license_url
+		LicenseURL                  string `json:"license_url"`
+		LicenseID                   string `json:"license_id"`
+		AttributionOrganizationName string `json:"attribution_organization_name"`
+		AttributionURL              string `json:"attribution_url"`
+	} `json:"data"`
+}
+
+// Struct for a cached system_information lookup; failures are cached too so they are not retried every cycle
+type cachedSystemInformation struct {
+	systemInfo
+	fetchedAt time.Time
+}
+
+// Cache of system_information per provider location
+var systemInformations = struct {
+	sync.Mutex
+	byLocation map[string]cachedSystemInformation
+}{byLocation: map[string]cachedSystemInformation{}}
+
+// Struct for the system_information values of one provider
+type systemInfo struct {
+	SystemID string
+	Timezone string
+	// Links are the operator URLs riders are sent to, such as purchase_url and app store pages
+	Links []operatorLink
+	// License is the data license republished with the provider's data
+	License DataLicense
+}
+
+// Struct for the license and attribution a provider publishes its data under,
+// carried along in the API, GeoJSON and archived outputs built from that data
+type DataLicense struct {
+	URL            string `json:"license_url,omitempty"`
+	ID             string `json:"license_id,omitempty"`
+	Attribution    string `json:"attribution_organization_name,omitempty"`
+	AttributionURL string `json:"attribution_url,omitempty"`
+}
+
+// Function to return the provider's system_information, fetched at most once a
+// day; non-GBFS sources and unreachable feeds yield empty values
+func systemInformation(provider Provider) systemInfo {
+	if provider.Source != "" && provider.Source != sourceGBFS {
+		return systemInfo{}
+	}
+
+	systemInformations.Lock()
+	cached, ok := systemInformations.byLocation[provider.Location]
+	systemInformations.Unlock()
+	if ok && clock.Now().Sub(cached.fetchedAt) < systemInformationRefresh {
+		return cached.systemInfo
+	}
+
+	info, err := fetchSystemInformation(provider)
+	if err != nil {
+		log.Printf("Error reading system_information of %s: %v", provider.Location, err)
+		// Keep previously known values through temporary failures
+		info = cached.systemInfo
+	}
+	systemInformations.Lock()
+	systemInformations.byLocation[provider.Location] = cachedSystemInformation{systemInfo: info, fetchedAt: clock.Now()}
+	systemInformations.Unlock()
+	if locationLabel == labelFromSystemID && provider.SystemID == "" && info.SystemID != cached.SystemID {
+		previous := cached.SystemID
+		if previous == "" {
+			previous = provider.Location
+		}
+		retireLocationLabel(previous)
+	}
+	return info
+}
+
+// Function to delete the series that requests made before the system ID was known,
+// such as the system_information fetch itself, labelled with the previous value
+func retireLocationLabel(location string) {
+	for _, vec := range []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{
+		dailyRequestsGauge, dailyBytesGauge, budgetPausedGauge, throttleEvents, throttledUntilGauge,
+		fetchRetriesTotal, feedTimeoutsTotal, injectedFaults, discoveryFailovers, discoveryURLInfo,
+		feedVerificationFailures,
+	} {
+		vec.DeletePartialMatch(prometheus.Labels{"location": location})
+	}
+}
+
+// Function to fetch and parse the provider's system_information feed
+func fetchSystemInformationFeed(provider Provider) (systemInformationFeed, error) {
+	var feed systemInformationFeed
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return feed, err
+	}
+	url, ok := providerFeedURL(provider, body, "system_information")
+	if !ok {
+		return feed, fmt.Errorf("system_information not found in %s", provider.URL)
+	}
+	body, err = fetchBody(provider, url, nil)
+	if err != nil {
+		return feed, err
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return feed, fmt.Errorf("parsing system_information: %w", err)
+	}
+	return feed, nil
+}
+
+// Function to fetch the provider's system_information values
+func fetchSystemInformation(provider Provider) (systemInfo, error) {
+	feed, err := fetchSystemInformationFeed(provider)
+	if err != nil {
+		return systemInfo{}, err
+	}
+	info := systemInfo{SystemID: feed.Data.SystemID, Timezone: feed.Data.Timezone}
+	info.License = DataLicense{
+		URL:            feed.Data.LicenseURL,
+		ID:             feed.Data.LicenseID,
+		Attribution:    feed.Data.AttributionOrganizationName,
+		AttributionURL: feed.Data.AttributionURL,
+	}
+	info.Links = append(info.Links,
+		operatorLink{Kind: "purchase_url", URL: feed.Data.PurchaseURL},
+		operatorLink{Kind: "start_ride_url", URL: feed.Data.StartRideURL})
+	for _, platform := range []string{"android", "ios"} {
+		app := feed.Data.RentalApps[platform]
+		info.Links = append(info.Links,
+			operatorLink{Kind: platform + "_store_uri", URL: app.StoreURI},
+			operatorLink{Kind: platform + "_discovery_uri", URL: app.DiscoveryURI})
+	}
+	info.Links = probeableLinks(info.Links)
+	if _, err := time.LoadLocation(info.Timezone); err != nil || info.Timezone == "" {
+		log.Printf("Error reading timezone of %s: system_information has invalid timezone %q", provider.Location, info.Timezone)
+		info.Timezone = ""
+	}
+	return info, nil
+}
+
+// Function to return the provider's canonical system ID: the configured one, else
+// the one published in system_information, else empty
+func providerSystemID(provider Provider) string {
+	if provider.SystemID != "" {
+		return provider.SystemID
+	}
+	return systemInformation(provider).SystemID
+}
+
+// Function to return the data license published in the provider's
+// system_information, or nil when it publishes none
+func providerLicense(provider Provider) *DataLicense {
+	license := systemInformation(provider).License
+	if license == (DataLicense{}) {
+		return nil
+	}
+	return &license
+}
+
+// Function to return the data license of every provider by location, from the latest state
+func dataLicenses() map[string]*DataLicense {
+	licenses := map[string]*DataLicense{}
+	for _, state := range liveState.all() {
+		if state.Provider.License != nil {
+			licenses[state.Provider.Location] = state.Provider.License
+		}
+	}
+	return licenses
+}
+
+// Function to check a --location-label value
+func validLocationLabel(source string) error {
+	switch source {
+	case labelFromLocation, labelFromSystemID:
+		return nil
+	}
+	return fmt.Errorf("invalid --location-label %q, expected location or system_id", source)
+}
+
+// Function to return the "location" label value of a provider's metrics. With
+// --location-label system_id this is the system ID, so series survive renames in
+// the config; providers without one keep their configured location.
+func metricLocation(provider Provider) string {
+	if locationLabel != labelFromSystemID {
+		return provider.Location
+	}
+	if provider.SystemID != "" {
+		return provider.SystemID
+	}
+	// Only consult the cache here: followers and cleanup paths must not fetch feeds
+	systemInformations.Lock()
+	cached := systemInformations.byLocation[provider.Location]
+	systemInformations.Unlock()
+	if cached.SystemID != "" {
+		return cached.SystemID
+	}
+	return provider.Location
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pause after a 429 without Retry-After, doubled while the provider keeps throttling
+const throttleInitialBackoff = time.Minute
+
+// Longest pause a provider's throttling can impose, including its own Retry-After
+const throttleMaxBackoff = time.Hour
+
+// Error returned for requests to a provider that asked to slow down
+var errThrottled = errors.New("throttled by the operator")
+
+// Metrics for operators asking the exporter to slow down
+var (
+	throttleEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gbfs_throttle_events_total",
+			Help: "Responses asking to slow down: 429, or 503 with Retry-After",
+		},
+		[]string{"location"},
+	)
+	throttledUntilGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_throttled_until_timestamp_seconds",
+			Help: "Unix time until which scrapes of the provider are paused after it throttled them, 0 when not throttled",
+		},
+		[]string{"location"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(throttleEvents, throttledUntilGauge)
+}
+
+// Struct for a provider's throttling: when scrapes may resume and the pause the
+// next 429 without Retry-After gets
+type providerThrottle struct {
+	until   time.Time
+	backoff time.Duration
+}
+
+// Struct pausing providers that respond 429 or send Retry-After, for as long as they
+// ask or with exponential backoff, instead of retrying them every cycle
+type throttleTracker struct {
+	mu        sync.Mutex
+	providers map[string]*providerThrottle
+}
+
+// Throttling of every provider, fed by upstream responses
+var throttles = &throttleTracker{providers: map[string]*providerThrottle{}}
+
+// Function to record a provider's response status, pausing it when the response asks
+// to slow down and resetting the backoff on success
+func (t *throttleTracker) observe(provider Provider, resp *http.Response, now time.Time) {
+	if provider.Location == "" {
+		return
+	}
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	throttled := resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode == http.StatusServiceUnavailable && retryAfter > 0)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	throttle, ok := t.providers[provider.Location]
+	if !throttled {
+		if ok && resp.StatusCode < 300 {
+			delete(t.providers, provider.Location)
+			throttledUntilGauge.WithLabelValues(metricLocation(provider)).Set(0)
+		}
+		return
+	}
+	if !ok {
+		throttle = &providerThrottle{}
+		t.providers[provider.Location] = throttle
+	}
+	wait := retryAfter
+	if wait <= 0 {
+		throttle.backoff = min(max(throttle.backoff*2, throttleInitialBackoff), throttleMaxBackoff)
+		wait = throttle.backoff
+	}
+	wait = min(wait, throttleMaxBackoff)
+	// Parallel requests of one scrape may all be throttled; the latest pause wins only if longer
+	if until := now.Add(wait); until.After(throttle.until) {
+		if !throttle.until.After(now) {
+			log.Printf("Warning: %s responded %s; pausing its scrapes for %s", provider.Location, resp.Status, wait.Round(time.Second))
+		}
+		throttle.until = until
+	}
+	throttleEvents.WithLabelValues(metricLocation(provider)).Inc()
+	throttledUntilGauge.WithLabelValues(metricLocation(provider)).Set(float64(throttle.until.Unix()))
+}
+
+// Function to refuse requests to a provider while it is paused, so a throttled
+// scrape is not retried blindly
+func (t *throttleTracker) allow(provider Provider, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if throttle, ok := t.providers[provider.Location]; ok && now.Before(throttle.until) {
+		return fmt.Errorf("%w: %s asked to wait until %s", errThrottled, provider.Location, throttle.until.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Function to drop the providers that are paused from an ingestion cycle; their
+// latest data stays in place until they may be scraped again
+func (t *throttleTracker) activeProviders(providers []Provider, now time.Time) []Provider {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	active := providers[:0:0]
+	for _, provider := range providers {
+		if throttle, ok := t.providers[provider.Location]; ok && now.Before(throttle.until) {
+			continue
+		}
+		active = append(active, provider)
+	}
+	return active
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of feeds with their own timeout budget
+const (
+	feedKindDiscovery = "discovery"
+	feedKindVehicles  = "vehicles"
+	feedKindStatus    = "status"
+)
+
+// Timeout budgets per feed kind, and for all requests of one provider scrape; zero disables a budget
+var feedTimeouts = struct {
+	discovery, vehicles, status time.Duration
+	provider                    time.Duration
+}{
+	discovery: 10 * time.Second,
+	vehicles:  30 * time.Second,
+	status:    10 * time.Second,
+	provider:  60 * time.Second,
+}
+
+// Counter for feed requests cut off by their timeout budget
+var feedTimeoutsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_feed_timeouts_total",
+		Help: "Number of feed requests that ran out of their feed or provider timeout budget",
+	},
+	[]string{"location", "feed_kind"},
+)
+
+func init() {
+	prometheus.MustRegister(feedTimeoutsTotal)
+}
+
+// Function to classify a feed URL: discovery documents, large vehicle lists, or small status feeds
+func classifyFeed(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return feedKindStatus
+	}
+	// Whole-network APIs and XML exports carry every vehicle
+	if strings.HasSuffix(u.Host, "citybik.es") || strings.HasSuffix(u.Path, ".xml") {
+		return feedKindVehicles
+	}
+	switch strings.TrimSuffix(path.Base(u.Path), ".json") {
+	case "gbfs", "manifest":
+		return feedKindDiscovery
+	case "free_bike_status", "vehicle_status":
+		return feedKindVehicles
+	default:
+		return feedKindStatus
+	}
+}
+
+// Function to return the budget for all requests of one scrape, as configured for the provider or with --provider-timeout
+func (p Provider) scrapeBudget() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return feedTimeouts.provider
+}
+
+// Function to start the provider-wide budget for one scrape, unless one is already running
+func withScrapeBudget(provider Provider) Provider {
+	if budget := provider.scrapeBudget(); budget > 0 && provider.deadline.IsZero() {
+		provider.deadline = time.Now().Add(budget)
+	}
+	return provider
+}
+
+// Function to return a context bounded by the feed's own budget and what is left of the
+// provider's. Timeouts of traced dry runs are not counted.
+func feedContext(provider Provider, url string, trace *ScrapeTrace) (context.Context, context.CancelFunc, string, error) {
+	kind := classifyFeed(url)
+	timeout := map[string]time.Duration{
+		feedKindDiscovery: feedTimeouts.discovery,
+		feedKindVehicles:  feedTimeouts.vehicles,
+		feedKindStatus:    feedTimeouts.status,
+	}[kind]
+
+	// Shutdown cancels requests still in flight
+	ctx, cancel := ingestionCtx, context.CancelFunc(func() {})
+	if !provider.deadline.IsZero() {
+		if time.Until(provider.deadline) <= 0 {
+			if trace == nil {
+				feedTimeoutsTotal.WithLabelValues(metricLocation(provider), kind).Inc()
+			}
+			return nil, nil, kind, fmt.Errorf("scrape budget of %s exhausted before fetching %s", provider.scrapeBudget(), url)
+		}
+		ctx, cancel = context.WithDeadline(ctx, provider.deadline)
+	}
+	if timeout > 0 {
+		parent := cancel
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancel = func() {
+			cancelTimeout()
+			parent()
+		}
+	}
+	return ctx, cancel, kind, nil
+}
+
+// Function to turn a deadline error into a message naming the exhausted budget
+func feedTimeoutError(ctx context.Context, provider Provider, kind string, err error, trace *ScrapeTrace) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if trace == nil {
+		feedTimeoutsTotal.WithLabelValues(metricLocation(provider), kind).Inc()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !provider.deadline.IsZero() && !deadline.Before(provider.deadline) {
+		return fmt.Errorf("scrape budget of %s exhausted", provider.scrapeBudget())
+	}
+	return fmt.Errorf("%s feed timed out", kind)
+}
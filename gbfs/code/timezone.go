@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"time"
+	_ "time/tzdata"
+)
+
+// Function to return the provider's IANA time zone: the configured one, else the
+// one published in system_information, else empty
+func providerTimezone(provider Provider) string {
+	if provider.Timezone != "" {
+		return provider.Timezone
+	}
+	return systemInformation(provider).Timezone
+}
+
+// Function to load a provider's time zone, defaulting to the server's local time
+func providerLocation(provider Provider) *time.Location {
+	return loadTimezone(providerTimezone(provider), time.Local)
+}
+
+// Function to load an IANA zone, returning fallback for empty or unknown names
+func loadTimezone(name string, fallback *time.Location) *time.Location {
+	if name == "" {
+		return fallback
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fallback
+	}
+	return loc
+}
+
+// Struct for one provider's availability over one day in the system's own time zone
+type DailySummary struct {
+	Location string  `json:"location"`
+	Date     string  `json:"date"`
+	Timezone string  `json:"timezone"`
+	Samples  int     `json:"samples"`
+	Min      int     `json:"min_available_bikes"`
+	Max      int     `json:"max_available_bikes"`
+	Mean     float64 `json:"mean_available_bikes"`
+	// Local hour of the day with the highest mean availability
+	PeakHour int `json:"peak_hour"`
+}
+
+// Function to aggregate successful snapshots into per-provider daily summaries. Days
+// follow each snapshot's system time zone; snapshots without one use UTC.
+func dailySummaries(snapshots []ProviderSnapshot) []DailySummary {
+	type key struct{ location, date string }
+	type accumulator struct {
+		summary   DailySummary
+		sum       int
+		hourSum   [24]int
+		hourCount [24]int
+	}
+	days := map[key]*accumulator{}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Error != "" {
+			continue
+		}
+		local := snapshot.ScrapedAt.In(loadTimezone(snapshot.Timezone, time.UTC))
+		k := key{snapshot.Location, local.Format("2006-01-02")}
+		acc, ok := days[k]
+		if !ok {
+			timezone := snapshot.Timezone
+			if timezone == "" {
+				timezone = "UTC"
+			}
+			acc = &accumulator{summary: DailySummary{
+				Location: snapshot.Location,
+				Date:     k.date,
+				Timezone: timezone,
+				Min:      snapshot.AvailableBikes,
+				Max:      snapshot.AvailableBikes,
+			}}
+			days[k] = acc
+		}
+		acc.summary.Samples++
+		acc.sum += snapshot.AvailableBikes
+		acc.summary.Min = min(acc.summary.Min, snapshot.AvailableBikes)
+		acc.summary.Max = max(acc.summary.Max, snapshot.AvailableBikes)
+		acc.hourSum[local.Hour()] += snapshot.AvailableBikes
+		acc.hourCount[local.Hour()]++
+	}
+
+	summaries := make([]DailySummary, 0, len(days))
+	for _, acc := range days {
+		acc.summary.Mean = float64(acc.sum) / float64(acc.summary.Samples)
+		best := -1.0
+		for hour := 0; hour < 24; hour++ {
+			if acc.hourCount[hour] == 0 {
+				continue
+			}
+			if mean := float64(acc.hourSum[hour]) / float64(acc.hourCount[hour]); mean > best {
+				best, acc.summary.PeakHour = mean, hour
+			}
+		}
+		summaries = append(summaries, acc.summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Location != summaries[j].Location {
+			return summaries[i].Location < summaries[j].Location
+		}
+		return summaries[i].Date < summaries[j].Date
+	})
+	return summaries
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct describing a single HTTP request made during a scrape
+type TraceRequest struct {
+	URL        string  `json:"url"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	// Redirects followed before the final response
+	Redirects []TraceRedirect `json:"redirects,omitempty"`
+}
+
+// Struct describing a metric write produced by a scrape
+type MetricUpdate struct {
+	Metric string            `json:"metric"`
+	Labels prometheus.Labels `json:"labels"`
+	Value  float64           `json:"value"`
+
+	gauge *prometheus.GaugeVec
+}
+
+// Function to write the update to its Prometheus gauge
+func (u MetricUpdate) apply() {
+	u.gauge.With(u.Labels).Set(u.Value)
+}
+
+// Struct collecting everything that happened during one scrape of a provider.
+// A nil *ScrapeTrace is valid and records nothing, so the regular ingestion
+// path can share the same code without paying for tracing.
+type ScrapeTrace struct {
+	Provider      string         `json:"provider"`
+	URL           string         `json:"url"`
+	StartedAt     time.Time      `json:"started_at"`
+	DurationMS    float64        `json:"duration_ms"`
+	Requests      []TraceRequest `json:"requests"`
+	ParsedCounts  map[string]int `json:"parsed_counts"`
+	MetricUpdates []MetricUpdate `json:"metric_updates"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// Function to create an empty trace for a provider
+func newScrapeTrace(provider Provider) *ScrapeTrace {
+	return &ScrapeTrace{
+		Provider:      provider.Location,
+		URL:           redactURL(provider.URL),
+		StartedAt:     time.Now(),
+		Requests:      []TraceRequest{},
+		ParsedCounts:  map[string]int{},
+		MetricUpdates: []MetricUpdate{},
+	}
+}
+
+// Function to record an HTTP request in the trace
+func (t *ScrapeTrace) recordRequest(url string, status, bytes int, duration time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	req := TraceRequest{
+		URL:        redactURL(url),
+		Status:     status,
+		Bytes:      bytes,
+		DurationMS: float64(duration.Microseconds()) / 1000,
+	}
+	if err != nil {
+		req.Error = redactText(err.Error())
+	}
+	t.Requests = append(t.Requests, req)
+}
+
+// Function to record a parsed item count in the trace
+func (t *ScrapeTrace) recordCount(name string, count int) {
+	if t == nil {
+		return
+	}
+	t.ParsedCounts[name] = count
+}
+
+// Function to look up a provider by its location, falling back to removed and
+// deactivated ones so their history stays queryable
+func findProvider(name string) (Provider, bool, error) {
+	providers, err := getProviders()
+	if err != nil {
+		return Provider{}, false, err
+	}
+	for _, provider := range providers {
+		if provider.Location == name {
+			return provider, true, nil
+		}
+	}
+	provider, ok := registry.inactive(name)
+	return provider, ok, nil
+}
+
+// Handler for POST /debug/scrape/:provider, running one scrape as a dry run.
+// Metric updates are computed and reported but never applied, and the scrape
+// neither spends the provider's budget nor replaces its stored feeds.
+func debugScrapeHandler(c *gin.Context) {
+	provider, ok, err := findProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider " + c.Param("provider")})
+		return
+	}
+
+	trace := newScrapeTrace(provider)
+	result, err := scrapeProvider(provider, trace)
+	numBikes := result.AvailableBikes()
+	trace.DurationMS = float64(time.Since(trace.StartedAt).Microseconds()) / 1000
+	if err != nil {
+		trace.Error = err.Error()
+	} else {
+		trace.MetricUpdates = providerMetricUpdates(provider, numBikes)
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	if c.Query("trace") != "true" {
+		c.JSON(status, gin.H{
+			"provider":        provider.Location,
+			"available_bikes": numBikes,
+			"error":           trace.Error,
+		})
+		return
+	}
+	c.JSON(status, trace)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gauges for the price of a ride per provider and vehicle category
+var (
+	vehicleUnlockPrice = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_vehicle_unlock_price",
+			Help: "Fixed price of a ride on the category's vehicles, from the default_pricing_plan_id of their vehicle_types in system_pricing_plans",
+		},
+		[]string{"location", "category", "currency"},
+	)
+	vehiclePerMinutePrice = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_vehicle_price_per_minute",
+			Help: "Price per minute at the start of a ride on the category's vehicles, from the per_min_pricing of their default pricing plan",
+		},
+		[]string{"location", "category", "currency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(vehicleUnlockPrice, vehiclePerMinutePrice)
+}
+
+// Struct for the price of a ride on one category of a provider's vehicles
+type CategoryPrice struct {
+	Category string `json:"category"`
+	PlanID   string `json:"plan_id"`
+	Currency string `json:"currency"`
+	// Unlock is the plan's fixed price, PerMinute the rate of its first per-minute segment
+	Unlock    float64 `json:"unlock"`
+	PerMinute float64 `json:"per_minute"`
+}
+
+// Struct for the part of a system_pricing_plans plan used for ride prices
+type pricingPlan struct {
+	PlanID        string `json:"plan_id"`
+	Currency      string `json:"currency"`
+	Price         any    `json:"price"`
+	PerMinPricing []struct {
+		Start    float64 `json:"start"`
+		Rate     float64 `json:"rate"`
+		Interval float64 `json:"interval"`
+	} `json:"per_min_pricing"`
+}
+
+// Function to return a plan's fixed price and the per-minute rate of the segment
+// starting the ride; GBFS 1.x publishes the price as a string
+func (p pricingPlan) rates() (float64, float64, error) {
+	unlock, err := propertyNumber(p.Price)
+	if err != nil {
+		return 0, 0, fmt.Errorf("plan %s price: %w", p.PlanID, err)
+	}
+	perMinute := 0.0
+	segments := p.PerMinPricing
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+	if len(segments) > 0 && segments[0].Start == 0 && segments[0].Interval > 0 {
+		perMinute = segments[0].Rate / segments[0].Interval
+	}
+	return unlock, perMinute, nil
+}
+
+// Struct for a provider's cached ride prices
+type cachedVehiclePrices struct {
+	prices    []CategoryPrice
+	fetchedAt time.Time
+}
+
+// Struct joining vehicle_types with system_pricing_plans into ride prices per
+// category, fetched at most as often as vehicle_types is refreshed
+type vehiclePriceTracker struct {
+	mu         sync.Mutex
+	byLocation map[string]cachedVehiclePrices
+}
+
+// Ride prices of every provider publishing pricing plans for its vehicle types
+var vehiclePrices = &vehiclePriceTracker{byLocation: map[string]cachedVehiclePrices{}}
+
+// Function to refresh a provider's ride prices when due and export them; safe to run concurrently
+func (t *vehiclePriceTracker) observe(provider Provider, now time.Time) {
+	if (provider.Source != "" && provider.Source != sourceGBFS) ||
+		!provider.feedEnabled("vehicle_types") || !provider.feedEnabled("system_pricing_plans") {
+		return
+	}
+	t.mu.Lock()
+	cached, ok := t.byLocation[provider.Location]
+	if ok && now.Sub(cached.fetchedAt) < vehicleTypesRefresh {
+		t.mu.Unlock()
+		return
+	}
+	// Concurrent scrapes of the provider wait for the next refresh
+	t.byLocation[provider.Location] = cachedVehiclePrices{prices: cached.prices, fetchedAt: now}
+	t.mu.Unlock()
+
+	prices, err := fetchVehiclePrices(provider)
+	if err != nil {
+		log.Printf("Error reading the ride prices of %s: %v", provider.Location, err)
+		return
+	}
+	t.mu.Lock()
+	t.byLocation[provider.Location] = cachedVehiclePrices{prices: prices, fetchedAt: now}
+	t.mu.Unlock()
+
+	location := metricLocation(provider)
+	vehicleUnlockPrice.DeletePartialMatch(prometheus.Labels{"location": location})
+	vehiclePerMinutePrice.DeletePartialMatch(prometheus.Labels{"location": location})
+	for _, price := range prices {
+		vehicleUnlockPrice.WithLabelValues(location, price.Category, price.Currency).Set(price.Unlock)
+		vehiclePerMinutePrice.WithLabelValues(location, price.Category, price.Currency).Set(price.PerMinute)
+	}
+}
+
+// Function to return a provider's ride prices by category, if known
+func (t *vehiclePriceTracker) prices(location string) []CategoryPrice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.byLocation[location].prices
+}
+
+// Function to forget a provider's ride prices
+func (t *vehiclePriceTracker) forget(location string) {
+	t.mu.Lock()
+	delete(t.byLocation, location)
+	t.mu.Unlock()
+}
+
+// Function to fetch a provider's vehicle_types and system_pricing_plans and price a
+// ride per category. Where several vehicle types of a category have plans, the
+// cheapest one counts: lowest unlock price, then lowest per-minute rate.
+func fetchVehiclePrices(provider Provider) ([]CategoryPrice, error) {
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	typesURL, ok := providerFeedURL(provider, body, "vehicle_types")
+	if !ok {
+		return nil, nil
+	}
+	plansURL, ok := providerFeedURL(provider, body, "system_pricing_plans")
+	if !ok {
+		return nil, nil
+	}
+
+	body, err = fetchBody(provider, typesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var types vehicleTypesFeed
+	if err := json.Unmarshal(body, &types); err != nil {
+		return nil, fmt.Errorf("parsing vehicle_types: %w", err)
+	}
+	body, err = fetchBody(provider, plansURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var plans struct {
+		Data struct {
+			Plans []pricingPlan `json:"plans"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &plans); err != nil {
+		return nil, failedBodies.keep(provider, plansURL, body, fmt.Errorf("parsing system_pricing_plans: %w", err))
+	}
+	byID := make(map[string]pricingPlan, len(plans.Data.Plans))
+	for _, plan := range plans.Data.Plans {
+		byID[plan.PlanID] = plan
+	}
+
+	cheapest := map[string]CategoryPrice{}
+	for _, vt := range types.Data.VehicleTypes {
+		plan, ok := byID[vt.DefaultPricingPlanID]
+		if !ok {
+			continue
+		}
+		unlock, perMinute, err := plan.rates()
+		if err != nil {
+			return nil, err
+		}
+		category, ok := provider.VehicleTypes[vt.VehicleTypeID]
+		if !ok {
+			category = formFactorCategory(vt.FormFactor, vt.PropulsionType)
+		}
+		price := CategoryPrice{Category: category, PlanID: plan.PlanID, Currency: plan.Currency, Unlock: unlock, PerMinute: perMinute}
+		if known, ok := cheapest[category]; !ok || price.Unlock < known.Unlock ||
+			(price.Unlock == known.Unlock && price.PerMinute < known.PerMinute) {
+			cheapest[category] = price
+		}
+	}
+	prices := make([]CategoryPrice, 0, len(cheapest))
+	for _, price := range cheapest {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Category < prices[j].Category })
+	return prices, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Canonical vehicle categories shared by metrics and API output
+const (
+	categoryBike    = "bike"
+	categoryEbike   = "ebike"
+	categoryScooter = "scooter"
+	categoryCargo   = "cargo"
+	categoryOther   = "other"
+)
+
+// Every canonical category, in the order they are exported
+var vehicleCategories = []string{categoryBike, categoryEbike, categoryScooter, categoryCargo, categoryOther}
+
+// How long a provider's vehicle_types feed is cached
+const vehicleTypesRefresh = time.Hour
+
+// Gauge for the free-floating vehicles of each provider by canonical category
+var vehiclesByCategory = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_available_vehicles",
+		Help: "Number of free-floating vehicles by canonical category (bike, ebike, scooter, cargo, other)",
+	},
+	[]string{"location", "category"},
+)
+
+// Gauge for the average remaining range of a provider's vehicles reporting one, by category
+var vehicleRangeAverage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_vehicle_range_meters_average",
+		Help: "Average current_range_meters of the free-floating vehicles publishing it, by canonical category",
+	},
+	[]string{"location", "category"},
+)
+
+// Gauge for the average charge of a provider's vehicles reporting one, by category
+var vehicleFuelAverage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gbfs_vehicle_fuel_ratio_average",
+		Help: "Average current_fuel_percent (0 to 1) of the free-floating vehicles publishing it, by canonical category",
+	},
+	[]string{"location", "category"},
+)
+
+func init() {
+	prometheus.MustRegister(vehiclesByCategory, vehicleRangeAverage, vehicleFuelAverage)
+}
+
+// Function to check a configured category name
+func validVehicleCategory(category string) error {
+	for _, known := range vehicleCategories {
+		if category == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown vehicle category %q, expected one of %s", category, strings.Join(vehicleCategories, ", "))
+}
+
+// Struct for the part of a vehicle_types feed used for categories and ride prices
+type vehicleTypesFeed struct {
+	Data struct {
+		VehicleTypes []struct {
+			VehicleTypeID        string `json:"vehicle_type_id"`
+			FormFactor           string `json:"form_factor"`
+			PropulsionType       string `json:"propulsion_type"`
+			DefaultPricingPlanID string `json:"default_pricing_plan_id"`
+		} `json:"vehicle_types"`
+	} `json:"data"`
+}
+
+// Struct for a provider's cached vehicle type categories
+type cachedVehicleTypes struct {
+	categories map[string]string
+	fetchedAt  time.Time
+}
+
+// Cache of vehicle_types categories per provider location
+var vehicleTypeCache = struct {
+	sync.Mutex
+	byLocation map[string]cachedVehicleTypes
+}{byLocation: map[string]cachedVehicleTypes{}}
+
+// Function to set the canonical category of every bike. Configured aliases win,
+// then the provider's vehicle_types feed, then hints in the type ID itself;
+// bikes without a type are plain bicycles, as GBFS defines.
+func categorizeVehicles(provider Provider, bikes []Bike) {
+	var feedTypes map[string]string
+	for i, bike := range bikes {
+		if category, ok := provider.VehicleTypes[bike.VehicleTypeID]; ok {
+			bikes[i].Category = category
+			continue
+		}
+		if bike.VehicleTypeID == "" {
+			bikes[i].Category = categoryBike
+			continue
+		}
+		if feedTypes == nil {
+			feedTypes = providerVehicleTypes(provider)
+		}
+		if category, ok := feedTypes[bike.VehicleTypeID]; ok {
+			bikes[i].Category = category
+			continue
+		}
+		bikes[i].Category = guessVehicleCategory(bike.VehicleTypeID)
+	}
+}
+
+// Function to count bikes by category, with every category present
+func countByCategory(bikes []Bike) map[string]int {
+	counts := make(map[string]int, len(vehicleCategories))
+	for _, category := range vehicleCategories {
+		counts[category] = 0
+	}
+	for _, bike := range bikes {
+		category := bike.Category
+		if category == "" {
+			category = categoryOther
+		}
+		counts[category]++
+	}
+	return counts
+}
+
+// Function to export a provider's vehicles and their battery averages by category;
+// categories where no vehicle reports a battery have no average series
+func updateCategoryMetrics(provider Provider, bikes []Bike) {
+	location := metricLocation(provider)
+	for category, count := range countByCategory(bikes) {
+		vehiclesByCategory.WithLabelValues(location, category).Set(float64(count))
+	}
+
+	ranges, fuels := map[string][]float64{}, map[string][]float64{}
+	for _, bike := range bikes {
+		category := bike.Category
+		if category == "" {
+			category = categoryOther
+		}
+		if bike.CurrentRangeMeters != nil {
+			ranges[category] = append(ranges[category], *bike.CurrentRangeMeters)
+		}
+		if bike.CurrentFuelPercent != nil {
+			fuels[category] = append(fuels[category], *bike.CurrentFuelPercent)
+		}
+	}
+	for _, category := range vehicleCategories {
+		setAverage(vehicleRangeAverage, location, category, ranges[category])
+		setAverage(vehicleFuelAverage, location, category, fuels[category])
+	}
+}
+
+// Function to set a gauge to the mean of the values, deleting it when there are none
+func setAverage(gauge *prometheus.GaugeVec, location, category string, values []float64) {
+	if len(values) == 0 {
+		gauge.DeleteLabelValues(location, category)
+		return
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	gauge.WithLabelValues(location, category).Set(sum / float64(len(values)))
+}
+
+// Function to return the categories of a provider's vehicle_types, fetched at most hourly
+func providerVehicleTypes(provider Provider) map[string]string {
+	if provider.Source != "" && provider.Source != sourceGBFS {
+		return map[string]string{}
+	}
+	vehicleTypeCache.Lock()
+	cached, ok := vehicleTypeCache.byLocation[provider.Location]
+	vehicleTypeCache.Unlock()
+	if ok && clock.Now().Sub(cached.fetchedAt) < vehicleTypesRefresh {
+		return cached.categories
+	}
+
+	categories, err := fetchVehicleTypes(provider)
+	if err != nil {
+		log.Printf("Error reading vehicle_types of %s: %v", provider.Location, err)
+		categories = cached.categories
+		if categories == nil {
+			categories = map[string]string{}
+		}
+	}
+	vehicleTypeCache.Lock()
+	vehicleTypeCache.byLocation[provider.Location] = cachedVehicleTypes{categories: categories, fetchedAt: clock.Now()}
+	vehicleTypeCache.Unlock()
+	return categories
+}
+
+// Function to fetch the provider's vehicle_types feed and categorize its types
+func fetchVehicleTypes(provider Provider) (map[string]string, error) {
+	body, err := fetchBody(provider, provider.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	url, ok := providerFeedURL(provider, body, "vehicle_types")
+	if !ok {
+		return nil, fmt.Errorf("vehicle_types not found in %s", provider.URL)
+	}
+	body, err = fetchBody(provider, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var feed vehicleTypesFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("parsing vehicle_types: %w", err)
+	}
+	categories := make(map[string]string, len(feed.Data.VehicleTypes))
+	for _, vt := range feed.Data.VehicleTypes {
+		categories[vt.VehicleTypeID] = formFactorCategory(vt.FormFactor, vt.PropulsionType)
+	}
+	return categories, nil
+}
+
+// Function to map a GBFS form_factor and propulsion_type onto a category
+func formFactorCategory(formFactor, propulsion string) string {
+	switch formFactor {
+	case "bicycle":
+		if propulsion == "" || propulsion == "human" {
+			return categoryBike
+		}
+		return categoryEbike
+	case "cargo_bicycle":
+		return categoryCargo
+	case "scooter", "scooter_standing", "scooter_seated":
+		return categoryScooter
+	}
+	return categoryOther
+}
+
+// Function to guess a category from words in a vehicle type ID such as "ebike_v2"
+func guessVehicleCategory(vehicleTypeID string) string {
+	id := strings.ToLower(vehicleTypeID)
+	switch {
+	case strings.Contains(id, "cargo"):
+		return categoryCargo
+	case strings.Contains(id, "scooter") || strings.Contains(id, "kick"):
+		return categoryScooter
+	case strings.Contains(id, "ebike") || strings.Contains(id, "e-bike") || strings.Contains(id, "pedelec") || strings.Contains(id, "electric"):
+		return categoryEbike
+	case strings.Contains(id, "bike") || strings.Contains(id, "bicycle") || strings.Contains(id, "velo"):
+		return categoryBike
+	}
+	return categoryOther
+}
+
+// Function to describe a category as an MDS vehicle type and propulsion types
+func mdsVehicleKind(category string) (string, []string) {
+	switch category {
+	case categoryEbike:
+		return "bicycle", []string{"electric_assist"}
+	case categoryScooter:
+		return "scooter", []string{"electric"}
+	case categoryCargo:
+		return "cargo_bicycle", []string{"human"}
+	case categoryOther:
+		return "other", []string{"human"}
+	}
+	return "bicycle", []string{"human"}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Struct for the per-provider payload verification settings in the config file.
+// Every configured check must pass before a response is ingested.
+type VerifyConfig struct {
+	// Require a Content-Digest (RFC 9530) or Digest (RFC 3230) SHA-256 header matching the body
+	Digest bool `yaml:"digest,omitempty"`
+	// Fetch a published checksum by appending this suffix to the feed path, e.g. ".sha256"
+	ChecksumSuffix string `yaml:"checksum_suffix,omitempty"`
+	// Header carrying a hex or base64 HMAC-SHA256 of the body, and the shared secret
+	HMACHeader string `yaml:"hmac_header,omitempty"`
+	HMACSecret string `yaml:"hmac_secret,omitempty"`
+	// Header carrying a base64 Ed25519 signature of the body, and the operator's base64 public key
+	SignatureHeader string `yaml:"signature_header,omitempty"`
+	PublicKey       string `yaml:"public_key,omitempty"`
+}
+
+// Counter for feed responses rejected by payload verification
+var feedVerificationFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gbfs_feed_verification_failures_total",
+		Help: "Number of feed responses rejected because their digest or signature did not verify",
+	},
+	[]string{"location", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(feedVerificationFailures)
+}
+
+// Function to check that a verification config is complete
+func (v *VerifyConfig) validate() error {
+	if !v.Digest && v.ChecksumSuffix == "" && v.HMACHeader == "" && v.SignatureHeader == "" {
+		return fmt.Errorf("verify needs at least one of digest, checksum_suffix, hmac_header or signature_header")
+	}
+	if (v.HMACHeader == "") != (v.HMACSecret == "") {
+		return fmt.Errorf("verify needs both hmac_header and hmac_secret")
+	}
+	if (v.SignatureHeader == "") != (v.PublicKey == "") {
+		return fmt.Errorf("verify needs both signature_header and public_key")
+	}
+	if v.PublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(v.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("verify public_key must be a base64 Ed25519 public key")
+		}
+	}
+	return nil
+}
+
+// Function to verify a fetched feed body against the provider's configured checks,
+// counting failures by reason unless the scrape is a traced dry run. A published
+// checksum is fetched within the feed's context.
+func verifyFeed(ctx context.Context, kind string, provider Provider, url string, header http.Header, body []byte, trace *ScrapeTrace) error {
+	v := provider.Verify
+	if v == nil {
+		return nil
+	}
+	fail := func(reason string, err error) error {
+		if trace == nil {
+			feedVerificationFailures.WithLabelValues(metricLocation(provider), reason).Inc()
+		}
+		return fmt.Errorf("verifying %s: %w", url, err)
+	}
+	sum := sha256.Sum256(body)
+
+	if v.Digest {
+		if err := checkDigestHeader(header, sum[:]); err != nil {
+			return fail("digest", err)
+		}
+	}
+	if v.ChecksumSuffix != "" {
+		if err := checkPublishedChecksum(ctx, kind, provider, url, v.ChecksumSuffix, sum[:], trace); err != nil {
+			return fail("checksum", err)
+		}
+	}
+	if v.HMACHeader != "" {
+		secret, err := secrets.expand(v.HMACSecret)
+		if err != nil {
+			return fail("hmac", err)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if !matchesEncoded(header.Get(v.HMACHeader), mac.Sum(nil)) {
+			return fail("hmac", fmt.Errorf("%s header does not match the body", v.HMACHeader))
+		}
+	}
+	if v.SignatureHeader != "" {
+		key, _ := base64.StdEncoding.DecodeString(v.PublicKey)
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header.Get(v.SignatureHeader)))
+		if err != nil || !ed25519.Verify(key, body, signature) {
+			return fail("signature", fmt.Errorf("%s header is not a valid signature of the body", v.SignatureHeader))
+		}
+	}
+	return nil
+}
+
+// Function to check a Content-Digest or Digest header against the body's SHA-256
+func checkDigestHeader(header http.Header, sum []byte) error {
+	// Content-Digest: sha-256=:<base64>:
+	for _, field := range strings.Split(header.Get("Content-Digest"), ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if ok && strings.EqualFold(algorithm, "sha-256") {
+			if matchesEncoded(strings.Trim(value, ":"), sum) {
+				return nil
+			}
+			return fmt.Errorf("Content-Digest does not match the body")
+		}
+	}
+	// Digest: SHA-256=<base64>
+	for _, field := range strings.Split(header.Get("Digest"), ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if ok && strings.EqualFold(algorithm, "sha-256") {
+			if matchesEncoded(value, sum) {
+				return nil
+			}
+			return fmt.Errorf("Digest does not match the body")
+		}
+	}
+	return fmt.Errorf("response has no SHA-256 Content-Digest or Digest header")
+}
+
+// Function to fetch the checksum published next to a feed, as a request of the feed's
+// fetch, and compare it with the body's SHA-256
+func checkPublishedChecksum(ctx context.Context, kind string, provider Provider, url, suffix string, sum []byte, trace *ScrapeTrace) error {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	u.Path += suffix
+	checksumURL := u.String()
+	data, _, err := requestUpstream(ctx, kind, provider, checksumURL, trace)
+	if err != nil {
+		return fmt.Errorf("fetching checksum %s: %w", checksumURL, err)
+	}
+	// sha256sum format: the digest is the first field
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !matchesEncoded(fields[0], sum) {
+		return fmt.Errorf("checksum %s does not match the body", checksumURL)
+	}
+	return nil
+}
+
+// Function to compare a hex or base64 encoded digest with the expected bytes
+func matchesEncoded(value string, expected []byte) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false
+	}
+	if decoded, err := hex.DecodeString(value); err == nil && len(decoded) == len(expected) {
+		return hmac.Equal(decoded, expected)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(value); err == nil {
+			return hmac.Equal(decoded, expected)
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of the warm-up scrape run at startup, before the first regular cycle
+var warmup = struct {
+	// budget of each provider's warm-up scrape, set with --warmup-timeout; 0 disables the warm-up
+	timeout time.Duration
+	// set while the warm-up runs
+	active atomic.Bool
+}{timeout: 5 * time.Second}
+
+// Function to scrape every provider at once, up to --max-concurrency, with the
+// short warm-up budget, so /metrics is populated within seconds of a deploy. Providers
+// that fail are logged and not counted as failures, since they may only be slow; the
+// scrape reports whether all succeeded, in which case it stands in for the first cycle.
+func warmUpIngestion() bool {
+	warmup.active.Store(true)
+	start := time.Now()
+	// Polling schedules start with the regular cycles
+	failed := ingestProviders(false)
+	warmup.active.Store(false)
+	if len(failed) > 0 {
+		log.Printf("Warning: warm-up scrape failed within %s for %s; retrying with the regular timeouts", warmup.timeout, strings.Join(failed, ", "))
+		return false
+	}
+	log.Printf("Warm-up scrape finished in %s", time.Since(start).Round(time.Millisecond))
+	return true
+}
+
+// Function to return the pool of an ingestion cycle; the warm-up scrapes as many
+// providers in parallel as --max-concurrency allows
+func ingestionPool() *scrapePool {
+	if warmup.active.Load() {
+		return &scrapePool{fixed: scrapeWorkers.max}
+	}
+	return scrapeWorkers
+}
+
+// Function to shorten a provider's scrape budget to the warm-up timeout while it runs
+func warmUpBudget(provider Provider) Provider {
+	if warmup.active.Load() && (provider.Timeout <= 0 || provider.Timeout > warmup.timeout) {
+		provider.Timeout = warmup.timeout
+	}
+	return provider
+}
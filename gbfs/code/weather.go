@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Weather APIs supported by --weather-provider
+const (
+	weatherOpenMeteo      = "open-meteo"
+	weatherOpenWeatherMap = "openweathermap"
+)
+
+// Width of the temperature bands of the weather analysis endpoint, in degrees Celsius
+const weatherBandCelsius = 5
+
+// Struct for the weather at a system when it was scraped
+type WeatherObservation struct {
+	TemperatureC    float64   `json:"temperature_celsius"`
+	PrecipitationMM float64   `json:"precipitation_mm"`
+	ObservedAt      time.Time `json:"observed_at"`
+}
+
+// Weather enrichment enabled with serve --weather-provider; nil disables it
+var weather *weatherClient
+
+// Gauges for the current weather at each system
+var (
+	weatherTemperatureGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_weather_temperature_celsius",
+			Help: "Current temperature at the centre of the system",
+		},
+		[]string{"location"},
+	)
+	weatherPrecipitationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gbfs_weather_precipitation_mm",
+			Help: "Precipitation over the last hour at the centre of the system",
+		},
+		[]string{"location"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(weatherTemperatureGauge, weatherPrecipitationGauge)
+}
+
+// Struct for a client of a weather API, caching one observation per provider
+type weatherClient struct {
+	provider string
+	apiKey   string
+	baseURL  string
+	refresh  time.Duration
+	client   *http.Client
+
+	mu       sync.Mutex
+	bySystem map[string]WeatherObservation
+}
+
+// Function to create a weather client; baseURL may be empty to use the provider's public API
+func newWeatherClient(provider, apiKey, baseURL string, refresh time.Duration) (*weatherClient, error) {
+	switch provider {
+	case weatherOpenMeteo:
+		if baseURL == "" {
+			baseURL = "https://api.open-meteo.com/v1/forecast"
+		}
+	case weatherOpenWeatherMap:
+		if apiKey == "" {
+			return nil, fmt.Errorf("--weather-api-key is required for %s", provider)
+		}
+		if baseURL == "" {
+			baseURL = "https://api.openweathermap.org/data/2.5/weather"
+		}
+	default:
+		return nil, fmt.Errorf("unknown --weather-provider %q, expected open-meteo or openweathermap", provider)
+	}
+	if refresh <= 0 {
+		return nil, fmt.Errorf("--weather-interval must be positive")
+	}
+	// OpenWeatherMap's appid parameter is not recognised as sensitive by redactURL
+	registerSecretValue(apiKey)
+	return &weatherClient{
+		provider: provider,
+		apiKey:   apiKey,
+		baseURL:  baseURL,
+		refresh:  refresh,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		bySystem: map[string]WeatherObservation{},
+	}, nil
+}
+
+// Function to return the mean position of a scrape's vehicles and stations
+func systemCentre(result ScrapeResult) (lat, lon float64, ok bool) {
+	n := 0
+	for _, bike := range result.Bikes {
+		if bike.Lat != 0 || bike.Lon != 0 {
+			lat, lon, n = lat+bike.Lat, lon+bike.Lon, n+1
+		}
+	}
+	for _, station := range result.Stations {
+		if station.Lat != 0 || station.Lon != 0 {
+			lat, lon, n = lat+station.Lat, lon+station.Lon, n+1
+		}
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	return lat / float64(n), lon / float64(n), true
+}
+
+// Function to return the weather at a provider's system, fetching it when the
+// cached observation is older than the refresh interval. Returns nil when
+// weather is disabled or unknown.
+func (w *weatherClient) observe(provider Provider, result ScrapeResult, now time.Time) *WeatherObservation {
+	if w == nil {
+		return nil
+	}
+	location := provider.Location
+	w.mu.Lock()
+	cached, ok := w.bySystem[location]
+	w.mu.Unlock()
+	if ok && now.Sub(cached.ObservedAt) < w.refresh {
+		return &cached
+	}
+
+	lat, lon, found := systemCentre(result)
+	if !found {
+		return nil
+	}
+	observation, err := w.fetch(lat, lon)
+	if err != nil {
+		log.Printf("Error fetching weather for %s: %v", location, err)
+		if ok {
+			return &cached
+		}
+		return nil
+	}
+	observation.ObservedAt = now
+	w.mu.Lock()
+	w.bySystem[location] = observation
+	w.mu.Unlock()
+
+	weatherTemperatureGauge.WithLabelValues(metricLocation(provider)).Set(observation.TemperatureC)
+	weatherPrecipitationGauge.WithLabelValues(metricLocation(provider)).Set(observation.PrecipitationMM)
+	return &observation
+}
+
+// Function to request the current weather at a position
+func (w *weatherClient) fetch(lat, lon float64) (WeatherObservation, error) {
+	query := url.Values{}
+	switch w.provider {
+	case weatherOpenMeteo:
+		query.Set("latitude", strconv.FormatFloat(lat, 'f', 4, 64))
+		query.Set("longitude", strconv.FormatFloat(lon, 'f', 4, 64))
+		query.Set("current", "temperature_2m,precipitation")
+		if w.apiKey != "" {
+			query.Set("apikey", w.apiKey)
+		}
+	case weatherOpenWeatherMap:
+		query.Set("lat", strconv.FormatFloat(lat, 'f', 4, 64))
+		query.Set("lon", strconv.FormatFloat(lon, 'f', 4, 64))
+		query.Set("units", "metric")
+		query.Set("appid", w.apiKey)
+	}
+
+	resp, err := w.client.Get(w.baseURL + "?" + query.Encode())
+	if err != nil {
+		return WeatherObservation{}, redactError(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return WeatherObservation{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return WeatherObservation{}, fmt.Errorf("%s returned %s", w.provider, resp.Status)
+	}
+
+	switch w.provider {
+	case weatherOpenMeteo:
+		var data struct {
+			Current struct {
+				Temperature   float64 `json:"temperature_2m"`
+				Precipitation float64 `json:"precipitation"`
+			} `json:"current"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return WeatherObservation{}, fmt.Errorf("parsing %s response: %w", w.provider, err)
+		}
+		return WeatherObservation{TemperatureC: data.Current.Temperature, PrecipitationMM: data.Current.Precipitation}, nil
+	default:
+		var data struct {
+			Main struct {
+				Temp float64 `json:"temp"`
+			} `json:"main"`
+			Rain struct {
+				OneHour float64 `json:"1h"`
+			} `json:"rain"`
+			Snow struct {
+				OneHour float64 `json:"1h"`
+			} `json:"snow"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return WeatherObservation{}, fmt.Errorf("parsing %s response: %w", w.provider, err)
+		}
+		return WeatherObservation{TemperatureC: data.Main.Temp, PrecipitationMM: data.Rain.OneHour + data.Snow.OneHour}, nil
+	}
+}
+
+// Struct for the mean availability of a provider under similar weather
+type WeatherBucket struct {
+	// TemperatureFrom is the lower bound of the temperature band in degrees Celsius
+	TemperatureFrom float64 `json:"temperature_from_celsius"`
+	TemperatureTo   float64 `json:"temperature_to_celsius"`
+	Wet             bool    `json:"wet"`
+	Samples         int     `json:"samples"`
+	MeanAvailable   float64 `json:"mean_available_bikes"`
+}
+
+// Function to group successful snapshots with weather by temperature band and
+// wet or dry conditions
+func weatherBuckets(snapshots []ProviderSnapshot) []WeatherBucket {
+	type key struct {
+		band float64
+		wet  bool
+	}
+	totals := map[key]int{}
+	buckets := map[key]*WeatherBucket{}
+	for _, snapshot := range snapshots {
+		if snapshot.Weather == nil || snapshot.Error != "" || snapshot.Closed {
+			continue
+		}
+		band := math.Floor(snapshot.Weather.TemperatureC/weatherBandCelsius) * weatherBandCelsius
+		k := key{band: band, wet: snapshot.Weather.PrecipitationMM > 0}
+		bucket, ok := buckets[k]
+		if !ok {
+			bucket = &WeatherBucket{TemperatureFrom: band, TemperatureTo: band + weatherBandCelsius, Wet: k.wet}
+			buckets[k] = bucket
+		}
+		bucket.Samples++
+		totals[k] += snapshot.AvailableBikes
+	}
+
+	result := make([]WeatherBucket, 0, len(buckets))
+	for k, bucket := range buckets {
+		bucket.MeanAvailable = float64(totals[k]) / float64(bucket.Samples)
+		result = append(result, *bucket)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TemperatureFrom != result[j].TemperatureFrom {
+			return result[i].TemperatureFrom < result[j].TemperatureFrom
+		}
+		return !result[i].Wet && result[j].Wet
+	})
+	return result
+}
+
+// Handler for a provider's availability grouped by weather, from the snapshot
+// store; from and to are optional RFC 3339 bounds
+func providerWeatherHandler(c *gin.Context) {
+	reader, ok := snapshotStore.(SnapshotReader)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot storage is not enabled"})
+		return
+	}
+	query, err := parseSnapshotQuery([]string{c.Param("name")}, c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	snapshots, err := reader.QuerySnapshots(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider": c.Param("name"),
+		"buckets":  weatherBuckets(snapshots),
+	})
+}
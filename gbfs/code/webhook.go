@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Struct for the body POSTed to webhooks after each cycle
+type webhookPayload struct {
+	CycleAt   time.Time          `json:"cycle_at"`
+	Providers []ProviderSnapshot `json:"providers"`
+}
+
+// Struct for a sink POSTing every cycle's snapshots to an arbitrary URL.
+// When a secret is set, requests carry X-GBFS-Timestamp and an
+// X-GBFS-Signature of "sha256=" + HMAC-SHA256(secret, timestamp + "." + body).
+// With serve --signing-key they also carry the body's detached JWS in X-GBFS-JWS.
+type webhookSink struct {
+	url     string
+	secret  []byte
+	retries int
+	client  *http.Client
+}
+
+// Function to create a webhook sink
+func newWebhookSink(url, secret string, retries int) *webhookSink {
+	return &webhookSink{
+		url:     url,
+		secret:  []byte(secret),
+		retries: retries,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Function to return the sink name used in logs
+func (s *webhookSink) Name() string {
+	return "webhook " + s.url
+}
+
+// Function to sign a payload sent at the given unix timestamp
+func (s *webhookSink) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Function to deliver a cycle
+func (s *webhookSink) Publish(snapshots []ProviderSnapshot) error {
+	body, err := json.Marshal(webhookPayload{CycleAt: clock.Now().UTC(), Providers: snapshots})
+	if err != nil {
+		return err
+	}
+	return s.send(body)
+}
+
+// Function to POST a JSON body, retrying with exponential backoff on network
+// errors, 429 and 5xx responses
+func (s *webhookSink) send(body []byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := s.deliver(body)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= s.retries {
+			return fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Error type for responses that retrying cannot fix
+type permanentError struct {
+	error
+}
+
+// Function to make a single delivery attempt
+func (s *webhookSink) deliver(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gbfs-exporter/"+version)
+	if len(s.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-GBFS-Timestamp", timestamp)
+		req.Header.Set("X-GBFS-Signature", s.sign(timestamp, body))
+	}
+	if signer != nil {
+		req.Header.Set("X-GBFS-JWS", signer.sign(body, true))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+}